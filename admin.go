@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+)
+
+// 启动管理接口（/metrics、/report、/conns、/peers 等），addr为空时不启动
+func startAdminServer(addr string, manager *ServerManager) *http.Server {
+	if addr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/report", reportHandler(manager))
+	mux.Handle("/conns", connsHandler(manager))
+	mux.Handle("/peers", peersHandler(manager))
+	mux.Handle("/servers", serversHandler(manager))
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("admin server failed: %v", err)
+		}
+	}()
+	return server
+}
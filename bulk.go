@@ -0,0 +1,370 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OpResult 批量命令中单个实例的执行结果
+type OpResult struct {
+	Instance string `json:"instance"` // type:address
+	Name     string `json:"name,omitempty"`
+	Action   string `json:"action"`
+	Error    string `json:"error,omitempty"`
+}
+
+type instanceOp struct {
+	typ, address string
+	name         string
+	action       string
+	labels       *Labels // start时设置的标签，nil表示沿用
+}
+
+// 并发执行，结果按实例名排序
+func (m *ServerManager) runOps(ops []instanceOp) []OpResult {
+	results := make([]OpResult, len(ops))
+	var wg sync.WaitGroup
+	for i, op := range ops {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var err error
+			address := op.address
+			switch op.action {
+			case "start":
+				address, err = m.StartLabeled(op.typ, op.address, op.labels)
+			case "stop":
+				err = m.StopServer(op.typ, op.address)
+			case "restart":
+				if err = m.StopServer(op.typ, op.address); err == nil {
+					address, err = m.StartLabeled(op.typ, op.address, op.labels)
+				}
+			}
+			if address == "" {
+				address = op.address
+			}
+			results[i] = OpResult{Instance: op.typ + ":" + address, Name: op.name, Action: op.action}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+	sort.Slice(results, func(i, j int) bool { return results[i].Instance < results[j].Instance })
+	return results
+}
+
+// Bulk 对选择器匹配的实例执行 start/stop/restart。
+// start 选择已停止的实例以及配置文件 labels 中尚未启动的实例，stop 只选择运行中的实例
+func (m *ServerManager) Bulk(action string, selector map[string]string) ([]OpResult, error) {
+	if len(selector) == 0 {
+		return nil, fmt.Errorf("missing selector")
+	}
+	var ops []instanceOp
+	known := make(map[string]bool)
+	for _, s := range m.GetServers() {
+		known[s.Type+":"+s.Address] = true
+		if !s.matches(selector) {
+			continue
+		}
+		switch {
+		case action == "start" && s.Status == "running",
+			action == "stop" && s.Status != "running":
+			continue
+		}
+		ops = append(ops, instanceOp{typ: s.Type, address: s.Address, name: s.Name, action: action})
+	}
+	if action == "start" {
+		for key, labels := range mConfig.Labels {
+			typ, address, ok := strings.Cut(key, ":")
+			if !ok || known[key] {
+				continue
+			}
+			if labels.Name == "" {
+				labels.Name = key
+			}
+			candidate := &Server{Type: typ, Address: address, Labels: labels.clone(), Status: "stopped"}
+			if candidate.matches(selector) {
+				ops = append(ops, instanceOp{typ: typ, address: address, name: labels.Name, action: action, labels: &candidate.Labels})
+			}
+		}
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("no server matches %v", selector)
+	}
+	return m.runOps(ops), nil
+}
+
+// Scale 启动或停止typ类型（指定group时只计该分组）的实例，使运行中的数量为count。
+// 优先重启已停止的实例，仍不足时从basePort起使用未被占用的端口；缩容时先停止端口最大的实例
+func (m *ServerManager) Scale(typ string, count, basePort int, labels Labels) ([]OpResult, error) {
+	if count < 0 {
+		return nil, fmt.Errorf("count must not be negative")
+	}
+	var running, stopped []*Server
+	used := make(map[int]bool)
+	maxPort := 0
+	for _, s := range m.GetServers() {
+		port := addressPort(s.Address)
+		used[port] = true
+		if s.Type != typ {
+			continue
+		}
+		maxPort = max(maxPort, port)
+		if labels.Group != "" && s.Group != labels.Group {
+			continue
+		}
+		if s.Status == "running" {
+			running = append(running, s)
+		} else {
+			stopped = append(stopped, s)
+		}
+	}
+	byPort := func(list []*Server) {
+		sort.Slice(list, func(i, j int) bool { return addressPort(list[i].Address) < addressPort(list[j].Address) })
+	}
+	byPort(running)
+	byPort(stopped)
+
+	var ops []instanceOp
+	switch {
+	case len(running) > count:
+		for _, s := range running[count:] {
+			ops = append(ops, instanceOp{typ: typ, address: s.Address, name: s.Name, action: "stop"})
+		}
+	case len(running) < count:
+		need := count - len(running)
+		for _, s := range stopped {
+			if need == 0 {
+				break
+			}
+			ops = append(ops, instanceOp{typ: typ, address: s.Address, name: s.Name, action: "start"})
+			need--
+		}
+		if need > 0 && basePort <= 0 {
+			if maxPort == 0 {
+				return nil, fmt.Errorf("--base-port is required to start new %s servers", typ)
+			}
+			basePort = maxPort + 1
+		}
+		for port := basePort; need > 0; port++ {
+			if port > 65535 {
+				return nil, fmt.Errorf("no free port left from %d", basePort)
+			}
+			if used[port] {
+				continue
+			}
+			l := labels.clone()
+			ops = append(ops, instanceOp{typ: typ, address: scaleAddress(typ, port), action: "start", labels: &l})
+			need--
+		}
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+	return m.runOps(ops), nil
+}
+
+// 实例地址中的端口，解析失败时为0
+func addressPort(address string) int {
+	_, port, err := net.SplitHostPort(localAddr(address))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+// http类的实例地址为 host:port，其它类型只有端口
+func addressHasHost(typ string) bool {
+	return typ == "http" || typ == "http-proxy"
+}
+
+func scaleAddress(typ string, port int) string {
+	if addressHasHost(typ) {
+		return fmt.Sprintf("127.0.0.1:%d", port)
+	}
+	return strconv.Itoa(port)
+}
+
+// 解析 name=.. group=.. tags=a,b 形式的标签参数
+func parseLabels(args []string) (Labels, error) {
+	var labels Labels
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok {
+			return labels, fmt.Errorf("label must be key=value, got %q", arg)
+		}
+		switch k {
+		case "name":
+			labels.Name = v
+		case "group":
+			labels.Group = v
+		case "tags":
+			labels.Tags = strings.Split(v, ",")
+		default:
+			return labels, fmt.Errorf("unknown label %q, available: name, group, tags", k)
+		}
+	}
+	return labels, nil
+}
+
+// start/stop/restart 的两种形式：<type> <address> [标签...] 或 <选择器...>
+func instanceCommand(action string, args []string, manager *ServerManager) ([]OpResult, error) {
+	if len(args) >= 2 && !strings.Contains(args[0], "=") {
+		typ, address := args[0], args[1]
+		var labels *Labels
+		if len(args) > 2 {
+			if action == "stop" {
+				return nil, fmt.Errorf("stop does not take labels")
+			}
+			l, err := parseLabels(args[2:])
+			if err != nil {
+				return nil, err
+			}
+			labels = &l
+		}
+		return manager.runOps([]instanceOp{{typ: typ, address: address, action: action, labels: labels}}), nil
+	}
+	selector, err := parseSelector(args)
+	if err != nil {
+		return nil, err
+	}
+	return manager.Bulk(action, selector)
+}
+
+// scale <type> <count> [--base-port N] [group=..] [tags=a,b]
+func scaleCommand(args []string, manager *ServerManager) ([]OpResult, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("missing type or count")
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid count %q", args[1])
+	}
+	basePort := 0
+	var labelArgs []string
+	for i := 2; i < len(args); i++ {
+		if args[i] == "--base-port" {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--base-port needs a port")
+			}
+			i++
+			if basePort, err = strconv.Atoi(args[i]); err != nil || basePort <= 0 || basePort > 65535 {
+				return nil, fmt.Errorf("invalid base port %q", args[i])
+			}
+			continue
+		}
+		labelArgs = append(labelArgs, args[i])
+	}
+	labels, err := parseLabels(labelArgs)
+	if err != nil {
+		return nil, err
+	}
+	if labels.Name != "" {
+		return nil, fmt.Errorf("scale does not take a name")
+	}
+	return manager.Scale(args[0], count, basePort, labels)
+}
+
+// 打印每个实例的结果与汇总
+func printResults(w io.Writer, results []OpResult) {
+	failed := 0
+	for _, r := range results {
+		status := "ok"
+		if r.Error != "" {
+			status = "error: " + r.Error
+			failed++
+		}
+		name := r.Instance
+		if r.Name != "" && r.Name != r.Instance {
+			name += " (" + r.Name + ")"
+		}
+		fmt.Fprintf(w, "  %-7s %-36s %s\n", r.Action, name, status)
+	}
+	fmt.Fprintf(w, "%d ok, %d failed\n", len(results)-failed, failed)
+}
+
+// 管理接口 /servers：GET 按选择器列出实例（?group=canary），地址为实际监听的地址；
+// POST ?action=start|stop|restart&<选择器> 批量执行，POST ?action=start&type=tcp&listen=:0|2003-2010[&name=..][&group=..][&tags=..] 启动新实例，
+// POST ?action=scale&type=http&count=8[&base_port=2003][&group=..][&tags=..] 调整数量
+func serversHandler(manager *ServerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		var selectors []string
+		for _, k := range selectorKeys {
+			if v := query.Get(k); v != "" {
+				selectors = append(selectors, k+"="+v)
+			}
+		}
+		switch req.Method {
+		case http.MethodGet:
+			selector, err := parseSelector(selectors)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			type serverInfo struct {
+				Type    string `json:"type"`
+				Address string `json:"address"`
+				Labels
+				Status string `json:"status"`
+			}
+			list := []serverInfo{}
+			for _, s := range manager.GetServers() {
+				if s.matches(selector) {
+					list = append(list, serverInfo{Type: s.Type, Address: s.Address, Labels: s.Labels, Status: s.Status})
+				}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(list)
+		case http.MethodPost:
+			var results []OpResult
+			var err error
+			switch action := query.Get("action"); {
+			case action == "start" && query.Get("listen") != "":
+				args := []string{query.Get("type"), query.Get("listen")}
+				for _, k := range []string{"name", "group", "tags"} {
+					if v := query.Get(k); v != "" {
+						args = append(args, k+"="+v)
+					}
+				}
+				results, err = instanceCommand(action, args, manager)
+			case action == "start", action == "stop", action == "restart":
+				results, err = instanceCommand(action, selectors, manager)
+			case action == "scale":
+				args := []string{query.Get("type"), query.Get("count")}
+				if v := query.Get("base_port"); v != "" {
+					args = append(args, "--base-port", v)
+				}
+				for _, k := range []string{"group", "tags"} {
+					if v := query.Get(k); v != "" {
+						args = append(args, k+"="+v)
+					}
+				}
+				results, err = scaleCommand(args, manager)
+			default:
+				err = fmt.Errorf("unknown action %q, available: start, stop, restart, scale", action)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if results == nil {
+				results = []OpResult{}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(results)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
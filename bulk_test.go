@@ -0,0 +1,203 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 并发的批量启停与状态读取，配合 -race 检查Status的访问
+func TestBulkConcurrentStartStop(t *testing.T) {
+	testConfig(t)
+	m := NewServerManager()
+	defer m.StopAll()
+
+	selector := map[string]string{"group": "race"}
+	var names []string
+	for range 6 {
+		port := freeTestPort(t)
+		if _, err := m.StartLabeled("tcp", port, &Labels{Group: "race"}); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, "tcp:"+port)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	var wg sync.WaitGroup
+	for _, action := range []string{"stop", "start", "restart"} {
+		wg.Go(func() {
+			for time.Now().Before(deadline) {
+				m.Bulk(action, selector)
+			}
+		})
+	}
+	wg.Go(func() {
+		for time.Now().Before(deadline) {
+			for _, name := range names {
+				m.isRunning(name)
+				m.GetConnTable(name)
+				m.GetPeerTable(name)
+			}
+			m.Apply(m.Snapshot(), false)
+			m.GetServers()
+		}
+	})
+	wg.Wait()
+
+	// 最终状态一致：批量启动后全部运行，批量停止后全部停止
+	m.Bulk("start", selector)
+	for _, name := range names {
+		if !m.isRunning(name) {
+			t.Fatalf("%s not running after bulk start", name)
+		}
+		if _, err := m.GetConnTable(name); err != nil {
+			t.Fatalf("conn table of %s: %v", name, err)
+		}
+	}
+	if _, err := m.Bulk("stop", selector); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		if m.isRunning(name) {
+			t.Fatalf("%s still running after bulk stop", name)
+		}
+	}
+}
+
+// 慢的Stop期间状态为stopping，不阻塞状态查询，也不允许重复启动
+func TestStopServerDoesNotHoldLock(t *testing.T) {
+	m := testManager(t)
+	port := freeTestPort(t)
+	if err := m.StartServer("tcp", port); err != nil {
+		t.Fatal(err)
+	}
+	key := "tcp:" + port
+	m.mu.Lock()
+	s := m.servers[key]
+	m.mu.Unlock()
+	release := make(chan struct{})
+	s.mu.Lock()
+	stop := s.Stop
+	s.Stop = func() error {
+		<-release
+		return stop()
+	}
+	s.mu.Unlock()
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- m.StopServer("tcp", port) }()
+	deadline := time.Now().Add(waitTimeout)
+	for s.status() != "stopping" {
+		if time.Now().After(deadline) {
+			t.Fatal("server never entered stopping")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	queried := make(chan struct{})
+	go func() {
+		m.GetServers()
+		m.isRunning(key)
+		m.GetConnTable(key)
+		close(queried)
+	}()
+	select {
+	case <-queried:
+	case <-time.After(waitTimeout):
+		t.Fatal("status queries blocked by a slow stop")
+	}
+	if err := m.StartServer("tcp", port); err == nil {
+		t.Fatal("started a server that is still stopping")
+	}
+	if err := m.StopServer("tcp", port); err != nil {
+		t.Fatalf("second stop: %v", err)
+	}
+
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if got := s.status(); got != "stopped" {
+		t.Fatalf("status = %s, want stopped", got)
+	}
+}
+
+func resultInstances(results []OpResult) []string {
+	var instances []string
+	for _, r := range results {
+		if r.Error != "" {
+			instances = append(instances, r.Instance+" "+r.Error)
+			continue
+		}
+		instances = append(instances, r.Instance)
+	}
+	return instances
+}
+
+// 按名称、分组与标签选择实例，start同时选择配置文件labels中尚未启动的实例
+func TestBulkSelectors(t *testing.T) {
+	m := testManager(t)
+	a, b, c, d, configured := freeTestPort(t), freeTestPort(t), freeTestPort(t), freeTestPort(t), freeTestPort(t)
+	for _, inst := range []struct {
+		typ, port string
+		labels    Labels
+	}{
+		{"tcp", a, Labels{Group: "api", Tags: []string{"blue"}}},
+		{"tcp", b, Labels{Group: "api", Tags: []string{"green"}}},
+		{"udp", c, Labels{Group: "web", Tags: []string{"blue", "canary"}}},
+		{"tcp", d, Labels{Name: "edge"}},
+	} {
+		if _, err := m.StartLabeled(inst.typ, inst.port, &inst.labels); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mConfig.Labels = map[string]Labels{"tcp:" + configured: {Group: "api", Tags: []string{"blue"}}}
+
+	sorted := func(keys ...string) []string {
+		slices.Sort(keys)
+		return keys
+	}
+	for _, tc := range []struct {
+		action   string
+		selector map[string]string
+		want     []string
+	}{
+		{"stop", map[string]string{"tag": "blue"}, sorted("tcp:"+a, "udp:"+c)},
+		// 已停止的实例不再停止
+		{"stop", map[string]string{"tag": "canary"}, nil},
+		// 运行中的b跳过，配置中的实例按配置的标签启动
+		{"start", map[string]string{"group": "api"}, sorted("tcp:"+a, "tcp:"+configured)},
+		{"restart", map[string]string{"name": "edge"}, []string{"tcp:" + d}},
+		{"stop", map[string]string{"group": "api", "tag": "green"}, []string{"tcp:" + b}},
+		{"start", map[string]string{"type": "udp", "group": "web"}, []string{"udp:" + c}},
+		{"stop", map[string]string{"group": "none"}, nil},
+	} {
+		results, err := m.Bulk(tc.action, tc.selector)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%s %v matched %v", tc.action, tc.selector, resultInstances(results))
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s %v: %v", tc.action, tc.selector, err)
+		}
+		if got := resultInstances(results); !slices.Equal(got, tc.want) {
+			t.Errorf("%s %v = %v, want %v", tc.action, tc.selector, got, tc.want)
+		}
+	}
+	for key, running := range map[string]bool{"tcp:" + a: true, "tcp:" + b: false, "udp:" + c: true, "tcp:" + d: true, "tcp:" + configured: true} {
+		if m.isRunning(key) != running {
+			t.Errorf("%s running = %v, want %v", key, !running, running)
+		}
+	}
+	for _, s := range m.GetServers() {
+		if s.Address == configured && (s.Group != "api" || !slices.Equal(s.Tags, []string{"blue"}) || s.Name != "tcp:"+configured) {
+			t.Fatalf("configured instance labels = %+v", s.Labels)
+		}
+	}
+	if _, err := m.Bulk("stop", nil); err == nil {
+		t.Fatal("bulk without a selector")
+	}
+}
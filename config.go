@@ -5,17 +5,41 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
+	"github.com/21Mile/go_downstreamer_server/services/grpc_server"
+	"github.com/21Mile/go_downstreamer_server/services/http_server"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/registry"
+	"github.com/21Mile/go_downstreamer_server/services/tcp_server"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+	"github.com/21Mile/go_downstreamer_server/services/udp_server"
+	"github.com/21Mile/go_downstreamer_server/services/xds_server"
 	"gopkg.in/yaml.v2"
 )
 
 // Config 配置结构体
 type Config struct {
-	Base BaseConfig `yaml:"base"`
-	HTTP HTTPConfig `yaml:"http"`
-	GRPC GRPCConfig `yaml:"grpc"`
-	TCP  TCPConfig  `yaml:"tcp"`
-	Log  LogConfig  `yaml:"log"`
+	Base          BaseConfig           `yaml:"base"`
+	HTTP          HTTPConfig           `yaml:"http"`
+	GRPC          GRPCConfig           `yaml:"grpc"`
+	GRPCMock      GRPCMockConfig       `yaml:"grpc_mock"`
+	TCP           TCPConfig            `yaml:"tcp"`
+	UDP           UDPConfig            `yaml:"udp"`
+	Redis         RedisConfig          `yaml:"redis"`
+	TCPProxy      TCPProxyConfig       `yaml:"tcp_proxy"`
+	HTTPProxy     HTTPProxyConfig      `yaml:"http_proxy"`
+	Zookeeper     ZookeeperConfig      `yaml:"zookeeper"`
+	Etcd          registry.EtcdOptions `yaml:"etcd"`
+	Registrations []RegistrationConfig `yaml:"registrations"`
+	Labels        map[string]Labels    `yaml:"labels"` // 实例的名称、分组与标签，key为 type:address
+	Export        ExportConfig         `yaml:"export"`
+	Xds           XdsConfig            `yaml:"xds"`
+	State         StateConfig          `yaml:"state"`
+	Log           LogConfig            `yaml:"log"`
+	Admin         AdminConfig          `yaml:"admin"`
+	Report        ReportConfig         `yaml:"report"`
+	Trace         tracing.Options      `yaml:"trace"`
 }
 
 // BaseConfig 基础配置
@@ -26,27 +50,250 @@ type BaseConfig struct {
 
 // HTTPConfig HTTP配置
 type HTTPConfig struct {
-	Addrs []string `yaml:"addrs"`
+	Addrs     []string                  `yaml:"addrs"`
+	Options   http_server.ServerOptions `yaml:"options"`   // 所有实例的默认参数
+	Instances []HTTPInstanceConfig      `yaml:"instances"` // 单独配置参数的实例
+}
+
+// HTTPInstanceConfig 单个HTTP实例配置，Options整体替换默认参数
+type HTTPInstanceConfig struct {
+	Addr    string                    `yaml:"addr"`
+	Options http_server.ServerOptions `yaml:"options"`
+}
+
+// 获取地址对应的实例参数，没有单独配置时使用默认参数
+func (c *HTTPConfig) InstanceOptions(addr string) *http_server.ServerOptions {
+	for i := range c.Instances {
+		if c.Instances[i].Addr == addr {
+			return &c.Instances[i].Options
+		}
+	}
+	return &c.Options
 }
 
 // GRPCConfig GRPC配置
 type GRPCConfig struct {
-	StreamingCount int   `yaml"streamingCount"`
-	Ports          []int `yaml:"ports"`
+	StreamingCount int                       `yaml:"streamingCount"`
+	Ports          []int                     `yaml:"ports"`
+	Options        grpc_server.ServerOptions `yaml:"options"`   // 所有实例的默认服务端参数
+	Instances      []GRPCInstanceConfig      `yaml:"instances"` // 单独配置服务端参数的实例
+}
+
+// GRPCInstanceConfig 单个GRPC实例配置，Options整体替换默认参数
+type GRPCInstanceConfig struct {
+	Port    int                       `yaml:"port"`
+	Options grpc_server.ServerOptions `yaml:"options"`
+}
+
+// 获取端口对应的服务端参数，没有单独配置时使用默认参数
+func (c *GRPCConfig) InstanceOptions(port int) *grpc_server.ServerOptions {
+	for i := range c.Instances {
+		if c.Instances[i].Port == port {
+			return &c.Instances[i].Options
+		}
+	}
+	return &c.Options
+}
+
+// GRPCMockConfig 通用gRPC mock配置，根据描述文件响应任意服务
+type GRPCMockConfig struct {
+	Ports                   []int                     `yaml:"ports"`
+	Options                 grpc_server.ServerOptions `yaml:"options"`
+	grpc_server.MockOptions `yaml:",inline"`
 }
 
 // TCPConfig TCP配置
 type TCPConfig struct {
-	Ports []int `yaml:"ports"`
+	Ports     []int                    `yaml:"ports"`
+	Options   tcp_server.ServerOptions `yaml:"options"`   // 所有实例的默认处理模式
+	Instances []TCPInstanceConfig      `yaml:"instances"` // 单独配置处理模式的实例
+}
+
+// TCPInstanceConfig 单个TCP实例配置，Options整体替换默认参数
+type TCPInstanceConfig struct {
+	Port    int                      `yaml:"port"`
+	Options tcp_server.ServerOptions `yaml:"options"`
+}
+
+// 获取端口对应的实例参数，没有单独配置时使用默认参数
+func (c *TCPConfig) InstanceOptions(port int) *tcp_server.ServerOptions {
+	for i := range c.Instances {
+		if c.Instances[i].Port == port {
+			return &c.Instances[i].Options
+		}
+	}
+	return &c.Options
+}
+
+// UDPConfig UDP配置
+type UDPConfig struct {
+	Ports     []int                    `yaml:"ports"`
+	Options   udp_server.ServerOptions `yaml:"options"`   // 所有实例的默认参数
+	Instances []UDPInstanceConfig      `yaml:"instances"` // 单独配置参数的实例
+}
+
+// UDPInstanceConfig 单个UDP实例配置，Options整体替换默认参数
+type UDPInstanceConfig struct {
+	Port    int                      `yaml:"port"`
+	Options udp_server.ServerOptions `yaml:"options"`
+}
+
+// 获取端口对应的实例参数，没有单独配置时使用默认参数
+func (c *UDPConfig) InstanceOptions(port int) *udp_server.ServerOptions {
+	for i := range c.Instances {
+		if c.Instances[i].Port == port {
+			return &c.Instances[i].Options
+		}
+	}
+	return &c.Options
+}
+
+// RedisConfig redis mock配置
+type RedisConfig struct {
+	Ports     []int                   `yaml:"ports"`
+	Options   tcp_server.RedisOptions `yaml:"options"`   // 所有实例的默认参数
+	Instances []RedisInstanceConfig   `yaml:"instances"` // 单独配置参数的实例
+}
+
+// RedisInstanceConfig 单个redis mock实例配置，Options整体替换默认参数
+type RedisInstanceConfig struct {
+	Port    int                     `yaml:"port"`
+	Options tcp_server.RedisOptions `yaml:"options"`
+}
+
+// 获取端口对应的实例参数，没有单独配置时使用默认参数
+func (c *RedisConfig) InstanceOptions(port int) *tcp_server.RedisOptions {
+	for i := range c.Instances {
+		if c.Instances[i].Port == port {
+			return &c.Instances[i].Options
+		}
+	}
+	return &c.Options
+}
+
+// TCPProxyConfig TCP代理配置
+type TCPProxyConfig struct {
+	Ports     []int                   `yaml:"ports"`
+	Options   tcp_server.ProxyOptions `yaml:"options"`   // 所有实例的默认参数
+	Instances []TCPProxyInstance      `yaml:"instances"` // 单独配置参数的实例
+}
+
+// TCPProxyInstance 单个TCP代理实例配置，Options整体替换默认参数
+type TCPProxyInstance struct {
+	Port    int                     `yaml:"port"`
+	Options tcp_server.ProxyOptions `yaml:"options"`
+}
+
+// 获取端口对应的实例参数，没有单独配置时使用默认参数
+func (c *TCPProxyConfig) InstanceOptions(port int) *tcp_server.ProxyOptions {
+	for i := range c.Instances {
+		if c.Instances[i].Port == port {
+			return &c.Instances[i].Options
+		}
+	}
+	return &c.Options
+}
+
+// HTTPProxyConfig HTTP代理配置
+type HTTPProxyConfig struct {
+	Addrs     []string                 `yaml:"addrs"`
+	Options   http_server.ProxyOptions `yaml:"options"`   // 所有实例的默认参数
+	Instances []HTTPProxyInstance      `yaml:"instances"` // 单独配置参数的实例
+}
+
+// HTTPProxyInstance 单个HTTP代理实例配置，Options整体替换默认参数
+type HTTPProxyInstance struct {
+	Addr    string                   `yaml:"addr"`
+	Options http_server.ProxyOptions `yaml:"options"`
+}
+
+// 获取地址对应的实例参数，没有单独配置时使用默认参数
+func (c *HTTPProxyConfig) InstanceOptions(addr string) *http_server.ProxyOptions {
+	for i := range c.Instances {
+		if c.Instances[i].Addr == addr {
+			return &c.Instances[i].Options
+		}
+	}
+	return &c.Options
+}
+
+// ZookeeperConfig 服务发现配置，Hosts为空时不启用
+type ZookeeperConfig struct {
+	Hosts          []string      `yaml:"hosts"`           // zk地址
+	SessionTimeout time.Duration `yaml:"session_timeout"` // 会话超时，默认5s
+	Username       string        `yaml:"username"`        // digest认证，设置后创建的节点只有该用户可写，其他人只读
+	Password       string        `yaml:"password"`
+	DesiredPath    string        `yaml:"desired_path"` // 节点数据为期望运行的服务列表（type:address），manager据此启动/停止服务
+}
+
+// RegistrationConfig 实例启动后注册到注册中心，停止前注销
+type RegistrationConfig struct {
+	Instance string `yaml:"instance"` // 实例名 type:address
+	Backend  string `yaml:"backend"`  // zookeeper/etcd，默认zookeeper
+	Path     string `yaml:"path"`     // 服务路径，实例注册为其子节点
+	Addr     string `yaml:"addr"`     // 注册的地址，默认为实例的本机地址 127.0.0.1:port
+}
+
+// ExportConfig 文件方式服务发现导出配置，Dir为空时不启用
+type ExportConfig struct {
+	Dir       string   `yaml:"dir"`        // 输出目录
+	Formats   []string `yaml:"formats"`    // prometheus/envoy/nginx/srv，为空时全部导出
+	SRVDomain string   `yaml:"srv_domain"` // SRV记录的域名，默认downstream.local
+}
+
+// XdsConfig 内置xDS（ADS）控制面配置，Addr为空时不启动
+type XdsConfig struct {
+	Addr    string             `yaml:"addr"`
+	Options xds_server.Options `yaml:",inline"`
+}
+
+// StateConfig 运行状态持久化配置
+type StateConfig struct {
+	File        string `yaml:"file"`         // 状态文件，实例启动/停止时重写，启动时恢复；为空时不启用
+	SnapshotDir string `yaml:"snapshot_dir"` // save/load 命名快照的目录，默认 ./snapshots
+}
+
+// AdminConfig 管理接口配置，Addr为空时不启动
+type AdminConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+// ReportConfig 负载均衡分布报表配置
+type ReportConfig struct {
+	Window  time.Duration      `yaml:"window"`  // 默认统计窗口
+	Weights map[string]float64 `yaml:"weights"` // 期望权重，key为 type:address 或实例名称，未配置的实例权重为1
 }
 
 // LogConfig 日志配置
 type LogConfig struct {
-	LogLevel      string `yaml:"log_level"`
-	FileWriterOn  bool   `yaml:"file_writer_on"`
-	LogPath       string `yaml:"log_path"`
-	ConsoleWriter bool   `yaml:"console_writer"`
-	Color         bool   `yaml:"color"`
+	LogLevel      string        `yaml:"log_level"`
+	FileWriterOn  bool          `yaml:"file_writer_on"`
+	LogPath       string        `yaml:"log_path"`
+	ConsoleWriter bool          `yaml:"console_writer"`
+	Color         bool          `yaml:"color"`
+	MaxSizeMB     int           `yaml:"max_size_mb"`     // 单个日志文件最大大小，超过后切割
+	RotateEvery   time.Duration `yaml:"rotate_interval"` // 按时间切割的间隔
+	MaxBackups    int           `yaml:"max_backups"`     // 每个实例最多保留的历史文件数
+	MaxAge        time.Duration `yaml:"max_age"`         // 历史文件最长保留时间
+	Compress      bool          `yaml:"compress"`        // 历史文件是否gzip压缩
+}
+
+// 转换为实例日志配置
+func (c *LogConfig) Options() logger.Options {
+	return logger.Options{
+		Level:         c.LogLevel,
+		FileWriterOn:  c.FileWriterOn,
+		LogPath:       c.LogPath,
+		ConsoleWriter: c.ConsoleWriter,
+		Color:         c.Color,
+		Rotate: logger.RotateOptions{
+			MaxSizeMB:  c.MaxSizeMB,
+			Interval:   c.RotateEvery,
+			MaxBackups: c.MaxBackups,
+			MaxAge:     c.MaxAge,
+			Compress:   c.Compress,
+		},
+	}
 }
 
 func ParseConfig(filename string) *Config {
@@ -72,16 +319,71 @@ func ParseConfig(filename string) *Config {
 	for i, addr := range config.HTTP.Addrs {
 		fmt.Printf("  地址%d: %s\n", i+1, addr)
 	}
+	for _, inst := range config.HTTP.Instances {
+		fmt.Printf("  实例: %s %+v\n", inst.Addr, inst.Options)
+	}
 
 	fmt.Printf("\nGRPC配置:\n")
 	for i, port := range config.GRPC.Ports {
 		fmt.Printf("  地址%d: %v\n", i+1, port)
 	}
+	for _, inst := range config.GRPC.Instances {
+		fmt.Printf("  实例: %v %+v\n", inst.Port, inst.Options)
+	}
+
+	fmt.Printf("\nRedis配置:\n")
+	for i, port := range config.Redis.Ports {
+		fmt.Printf("  地址%d: %v\n", i+1, port)
+	}
+	for _, inst := range config.Redis.Instances {
+		fmt.Printf("  实例: %v %+v\n", inst.Port, inst.Options)
+	}
+
+	fmt.Printf("\nTCP代理配置:\n")
+	for i, port := range config.TCPProxy.Ports {
+		fmt.Printf("  地址%d: %v\n", i+1, port)
+	}
+	for _, inst := range config.TCPProxy.Instances {
+		fmt.Printf("  实例: %v %s %+v\n", inst.Port, inst.Options.Balance, inst.Options.Upstreams)
+	}
+
+	fmt.Printf("\nHTTP代理配置:\n")
+	for i, addr := range config.HTTPProxy.Addrs {
+		fmt.Printf("  地址%d: %s\n", i+1, addr)
+	}
+	for _, inst := range config.HTTPProxy.Instances {
+		fmt.Printf("  实例: %s %s %+v\n", inst.Addr, inst.Options.Balance, inst.Options.Upstreams)
+	}
+
+	fmt.Printf("\nGRPC Mock配置:\n")
+	for i, port := range config.GRPCMock.Ports {
+		fmt.Printf("  地址%d: %v\n", i+1, port)
+	}
 
 	fmt.Printf("\nTCP配置:\n")
 	for i, port := range config.TCP.Ports {
 		fmt.Printf("  地址%d: %v\n", i+1, port)
 	}
+	for _, inst := range config.TCP.Instances {
+		fmt.Printf("  实例: %v %s\n", inst.Port, inst.Options.Mode)
+	}
+
+	fmt.Printf("\nUDP配置:\n")
+	for i, port := range config.UDP.Ports {
+		fmt.Printf("  地址%d: %v\n", i+1, port)
+	}
+	for _, inst := range config.UDP.Instances {
+		fmt.Printf("  实例: %v %+v\n", inst.Port, inst.Options)
+	}
+
+	fmt.Printf("\n注册配置:\n")
+	for i := range config.Registrations {
+		rc := &config.Registrations[i]
+		if rc.Backend == "" {
+			rc.Backend = registry.BackendZookeeper
+		}
+		fmt.Printf("  实例: %s -> %s %s\n", rc.Instance, rc.Backend, rc.Path)
+	}
 
 	// fmt.Printf("\n日志配置:\n")
 	// fmt.Printf("  日志级别: %s\n", config.Log.LogLevel)
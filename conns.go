@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/tcp_server"
+	"github.com/21Mile/go_downstreamer_server/services/udp_server"
+	"github.com/chzyer/readline"
+)
+
+// 打印连接表
+func printConns(w io.Writer, name string, conns []tcp_server.ConnInfo) {
+	now := time.Now()
+	fmt.Fprintf(w, "=== connections %s: %d open (press Enter to return) ===\n", name, len(conns))
+	fmt.Fprintf(w, "%-6s %-22s %-22s %10s %10s %9s %9s\n", "ID", "Remote", "Local", "In", "Out", "Age", "Idle")
+	for _, c := range conns {
+		fmt.Fprintf(w, "%-6d %-22s %-22s %10d %10d %9s %9s\n",
+			c.ID, c.Remote, c.Local, c.BytesIn, c.BytesOut,
+			formatUptime(now.Sub(c.StartedAt)), formatUptime(c.Idle))
+	}
+}
+
+// 控制台显示连接表：与日志查看一样暂停状态表刷新，回车返回
+func showConns(args []string, manager *ServerManager) error {
+	if len(args) != 1 {
+		return fmt.Errorf("missing server name")
+	}
+	table, err := manager.GetConnTable(args[0])
+	if err != nil {
+		return err
+	}
+	logViewing.Store(true)
+	printMu.Lock()
+	w := rl.Stdout()
+	readline.ClearScreen(w)
+	printConns(w, args[0], table.Conns())
+	printMu.Unlock()
+	rl.Refresh()
+	return nil
+}
+
+// kill conn <type:address|name> <id|all>
+func killConns(args []string, manager *ServerManager) (int, error) {
+	if len(args) != 3 || args[0] != "conn" {
+		return 0, fmt.Errorf("invalid arguments")
+	}
+	table, err := manager.GetConnTable(args[1])
+	if err != nil {
+		return 0, err
+	}
+	if args[2] == "all" {
+		killed := 0
+		for _, c := range table.Conns() {
+			if table.KillConn(c.ID) == nil {
+				killed++
+			}
+		}
+		return killed, nil
+	}
+	id, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid connection id %q", args[2])
+	}
+	if err := table.KillConn(id); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// 管理接口 /conns?name=tcp:3003 返回连接表；DELETE /conns?name=tcp:3003&id=1 断开连接（id=all断开全部）
+func connsHandler(manager *ServerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		name := query.Get("name")
+		switch req.Method {
+		case http.MethodGet:
+			table, err := manager.GetConnTable(name)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(table.Conns())
+		case http.MethodDelete:
+			killed, err := killConns([]string{"conn", name, query.Get("id")}, manager)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int{"killed": killed})
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// 打印UDP对端统计
+func printPeers(w io.Writer, name string, peers []udp_server.PeerInfo) {
+	now := time.Now()
+	fmt.Fprintf(w, "=== peers %s: %d (press Enter to return) ===\n", name, len(peers))
+	fmt.Fprintf(w, "%-22s %9s %9s %9s %10s %10s %9s\n", "Peer", "PktIn", "PktOut", "Dropped", "In", "Out", "LastSeen")
+	for _, p := range peers {
+		fmt.Fprintf(w, "%-22s %9d %9d %9d %10d %10d %9s\n",
+			p.Peer, p.PacketsIn, p.PacketsOut, p.Dropped, p.BytesIn, p.BytesOut, formatUptime(now.Sub(p.LastSeen)))
+	}
+}
+
+// 控制台显示UDP对端统计
+func showPeers(args []string, manager *ServerManager) error {
+	if len(args) != 1 {
+		return fmt.Errorf("missing server name")
+	}
+	table, err := manager.GetPeerTable(args[0])
+	if err != nil {
+		return err
+	}
+	logViewing.Store(true)
+	printMu.Lock()
+	w := rl.Stdout()
+	readline.ClearScreen(w)
+	printPeers(w, args[0], table.Peers())
+	printMu.Unlock()
+	rl.Refresh()
+	return nil
+}
+
+// 管理接口 /peers?name=udp:5003 返回对端统计
+func peersHandler(manager *ServerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		table, err := manager.GetPeerTable(req.URL.Query().Get("name"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(table.Peers())
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/tcp_server"
+	"github.com/21Mile/go_downstreamer_server/services/udp_server"
+)
+
+// 连接到受管的echo实例并收到一次回显，保证连接已被记录
+func dialManaged(t *testing.T, port string) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+port, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	io.WriteString(conn, "hi\n")
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func serveConns(h http.HandlerFunc, method, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(method, "/conns?"+query, nil))
+	return rec
+}
+
+func TestConnsHandler(t *testing.T) {
+	m := testManager(t)
+	port, udpPort := freeTestPort(t), freeTestPort(t)
+	mConfig.TCP.Options.Mode = tcp_server.ModeEcho
+	if _, err := m.StartLabeled("tcp", port, &Labels{Name: "api-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StartServer("udp", udpPort); err != nil {
+		t.Fatal(err)
+	}
+	a, b := dialManaged(t, port), dialManaged(t, port)
+	h := connsHandler(m)
+
+	// 按 type:address 或实例名称查找
+	for _, name := range []string{"tcp:" + port, "api-1"} {
+		rec := serveConns(h, http.MethodGet, "name="+name)
+		var conns []tcp_server.ConnInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &conns); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d %s", name, rec.Code, rec.Body)
+		}
+		var remotes []string
+		for _, c := range conns {
+			remotes = append(remotes, c.Remote)
+		}
+		if len(conns) != 2 || !slices.Contains(remotes, a.LocalAddr().String()) || !slices.Contains(remotes, b.LocalAddr().String()) {
+			t.Fatalf("conns of %s = %+v", name, conns)
+		}
+	}
+	for _, tc := range []struct {
+		method, query string
+		status        int
+	}{
+		{http.MethodGet, "name=tcp:1", http.StatusNotFound},
+		{http.MethodGet, "name=udp:" + udpPort, http.StatusNotFound}, // 不记录连接
+		{http.MethodDelete, "name=tcp:" + port + "&id=x", http.StatusNotFound},
+		{http.MethodDelete, "name=tcp:" + port + "&id=999", http.StatusNotFound},
+		{http.MethodPost, "name=tcp:" + port, http.StatusMethodNotAllowed},
+	} {
+		if rec := serveConns(h, tc.method, tc.query); rec.Code != tc.status {
+			t.Errorf("%s %s = %d, want %d", tc.method, tc.query, rec.Code, tc.status)
+		}
+	}
+
+	// 按id断开一个连接，再断开全部
+	table, err := m.GetConnTable("api-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id uint64
+	for _, c := range table.Conns() {
+		if c.Remote == a.LocalAddr().String() {
+			id = c.ID
+		}
+	}
+	rec := serveConns(h, http.MethodDelete, "name=api-1&id="+strconv.FormatUint(id, 10))
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"killed":1}` {
+		t.Fatalf("DELETE id=%d = %d %s", id, rec.Code, rec.Body)
+	}
+	expectClosed(t, a)
+	rec = serveConns(h, http.MethodDelete, "name=tcp:"+port+"&id=all")
+	if strings.TrimSpace(rec.Body.String()) != `{"killed":1}` {
+		t.Fatalf("DELETE id=all = %s", rec.Body)
+	}
+	expectClosed(t, b)
+}
+
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	if _, err := io.Copy(io.Discard, conn); err != nil && !strings.Contains(err.Error(), "reset") {
+		t.Fatalf("connection not closed: %v", err)
+	}
+}
+
+func TestKillConnsArgs(t *testing.T) {
+	m := testManager(t)
+	for _, args := range [][]string{{}, {"conn", "tcp:1"}, {"peer", "tcp:1", "all"}, {"conn", "tcp:1", "all"}} {
+		if _, err := killConns(args, m); err == nil {
+			t.Errorf("killConns(%q) accepted", args)
+		}
+	}
+}
+
+func TestPeersHandler(t *testing.T) {
+	m := testManager(t)
+	port := freeTestPort(t)
+	if err := m.StartServer("udp", port); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("udp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	if _, err := conn.Read(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	h := peersHandler(m)
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/peers?name=udp:"+port, nil))
+	var peers []udp_server.PeerInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &peers); err != nil {
+		t.Fatalf("%d %s", rec.Code, rec.Body)
+	}
+	if len(peers) != 1 || peers[0].Peer != conn.LocalAddr().String() || peers[0].PacketsIn != 1 || peers[0].BytesIn != 4 {
+		t.Fatalf("peers = %+v", peers)
+	}
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodGet, "/peers?name=tcp:"+port, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("peers of a missing instance = %d", rec.Code)
+	}
+}
+
+func TestPrintConns(t *testing.T) {
+	var buf bytes.Buffer
+	printConns(&buf, "tcp:3003", []tcp_server.ConnInfo{{
+		ID: 7, Remote: "127.0.0.1:50000", Local: "127.0.0.1:3003", BytesIn: 12, BytesOut: 34,
+		StartedAt: time.Now().Add(-90 * time.Second), Idle: 5 * time.Second,
+	}})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "tcp:3003: 1 open") {
+		t.Fatalf("output:\n%s", buf.String())
+	}
+	if fields := strings.Fields(lines[2]); !slices.Equal(fields, []string{"7", "127.0.0.1:50000", "127.0.0.1:3003", "12", "34", "1m30s", "5s"}) {
+		t.Fatalf("row = %q", fields)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/registry"
+	"gopkg.in/yaml.v2"
+)
+
+// 注册/注销实例的超时
+const registerTimeout = 3 * time.Second
+
+// UpstreamSetter 上游列表可以被服务发现整体替换的代理
+type UpstreamSetter interface {
+	SetUpstreams(addrs []string) error
+}
+
+// ConnectDiscovery 连接配置中的注册中心（zookeeper/etcd），未配置的不启用
+func (m *ServerManager) ConnectDiscovery(cfg *Config) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.mu.Lock()
+	m.zkCtx, m.zkCancel = ctx, cancel
+	m.mu.Unlock()
+	return errors.Join(m.connectZookeeper(cfg.Zookeeper), m.connectEtcd(cfg.Etcd))
+}
+
+func (m *ServerManager) connectZookeeper(cfg ZookeeperConfig) error {
+	if len(cfg.Hosts) == 0 {
+		return nil
+	}
+	lg, err := logger.New(mConfig.Log.Options(), "zookeeper", "zookeeper", strings.Join(cfg.Hosts, ","))
+	if err != nil {
+		return fmt.Errorf("failed to init logger for zookeeper: %w", err)
+	}
+	zkManager := zookeeper.NewZkManager(cfg.Hosts)
+	zkManager.SessionTimeout = cfg.SessionTimeout
+	zkManager.Username = cfg.Username
+	zkManager.Password = cfg.Password
+	zkManager.Logger = lg
+	if err := zkManager.GetConnect(); err != nil {
+		lg.Close()
+		return fmt.Errorf("connect zookeeper %v: %w", cfg.Hosts, err)
+	}
+	m.mu.Lock()
+	m.zk = zkManager
+	m.registries[registry.BackendZookeeper] = registry.NewZookeeper(zkManager)
+	m.registryLoggers = append(m.registryLoggers, lg)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *ServerManager) connectEtcd(cfg registry.EtcdOptions) error {
+	if len(cfg.Endpoints) == 0 {
+		return nil
+	}
+	lg, err := logger.New(mConfig.Log.Options(), "etcd", "etcd", strings.Join(cfg.Endpoints, ","))
+	if err != nil {
+		return fmt.Errorf("failed to init logger for etcd: %w", err)
+	}
+	reg, err := registry.NewEtcd(cfg, lg)
+	if err != nil {
+		lg.Close()
+		return err
+	}
+	m.mu.Lock()
+	m.registries[registry.BackendEtcd] = reg
+	m.registryLoggers = append(m.registryLoggers, lg)
+	m.mu.Unlock()
+	return nil
+}
+
+// FollowDesired 跟随zk节点中的期望服务列表，在配置文件中的服务启动后调用
+func (m *ServerManager) FollowDesired(nodePath string) {
+	m.mu.Lock()
+	zkManager, ctx := m.zk, m.zkCtx
+	m.mu.Unlock()
+	if zkManager == nil || nodePath == "" {
+		return
+	}
+	go zkManager.FollowData(ctx, nodePath, m.reconcile)
+}
+
+// CloseDiscovery 停止所有跟随并断开注册中心，在所有服务停止（注销）后调用
+func (m *ServerManager) CloseDiscovery() {
+	m.mu.Lock()
+	cancel, registries, loggers := m.zkCancel, m.registries, m.registryLoggers
+	m.zk, m.zkCancel, m.registryLoggers = nil, nil, nil
+	m.registries = make(map[string]registry.Registry)
+	m.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	for _, reg := range registries {
+		reg.Close()
+	}
+	for _, lg := range loggers {
+		lg.Close()
+	}
+}
+
+// 服务发现使用的注册中心，backend为空时为zookeeper。调用方持有m.mu
+func (m *ServerManager) discoveryRegistry(backend, service string) (registry.Registry, error) {
+	if err := registry.CheckBackend(backend); err != nil {
+		return nil, err
+	}
+	if backend == "" {
+		backend = registry.BackendZookeeper
+	}
+	reg, ok := m.registries[backend]
+	if !ok {
+		return nil, fmt.Errorf("discovery %q requires %s to be configured", service, backend)
+	}
+	return reg, nil
+}
+
+// 使用服务发现前检查注册中心是否已连接。调用方持有m.mu
+func (m *ServerManager) checkDiscovery(backend, service string) error {
+	if service == "" {
+		return nil
+	}
+	_, err := m.discoveryRegistry(backend, service)
+	return err
+}
+
+// 代理跟随注册中心中的服务实例作为上游，返回的stop先停止跟随再停止代理。调用方持有m.mu
+func (m *ServerManager) followUpstreams(backend, service string, setter UpstreamSetter, lg *logger.Logger, stop func() error) func() error {
+	if service == "" {
+		return stop
+	}
+	reg, _ := m.discoveryRegistry(backend, service)
+	ctx, cancel := context.WithCancel(context.Background())
+	go reg.Watch(ctx, service, func(addrs []string) {
+		if err := setter.SetUpstreams(addrs); err != nil {
+			lg.Error("update upstreams failed", "service", service, "err", err)
+		}
+	})
+	return func() error {
+		cancel()
+		return stop()
+	}
+}
+
+// 本机可连接的地址：只有端口、以冒号开头或监听0.0.0.0时为127.0.0.1，监听[::]时为[::1]
+func localAddr(address string) string {
+	if !strings.Contains(address, ":") {
+		address = ":" + address
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case host == "", ip != nil && ip.Equal(net.IPv4zero):
+		host = "127.0.0.1"
+	case ip != nil && ip.IsUnspecified():
+		host = "::1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// 实例的一个注册项：注册中心与注册的地址
+type registration struct {
+	reg     registry.Registry
+	backend string
+	path    string
+	addr    string
+}
+
+// 实例启动时按配置确定注册项，无法注册的记录日志后跳过。调用方持有m.mu
+func (m *ServerManager) registrationsLocked(key, address string, lg *logger.Logger) []registration {
+	var regs []registration
+	for _, rc := range mConfig.Registrations {
+		if rc.Instance != key {
+			continue
+		}
+		reg, addr, err := m.registration(rc, address)
+		if err != nil {
+			lg.Error("register failed", "path", rc.Path, "err", err)
+			continue
+		}
+		regs = append(regs, registration{reg: reg, backend: rc.Backend, path: rc.Path, addr: addr})
+	}
+	return regs
+}
+
+// 注册项对应的注册中心与注册地址，地址未配置时为实例的本机地址。调用方持有m.mu
+func (m *ServerManager) registration(rc RegistrationConfig, address string) (registry.Registry, string, error) {
+	reg, err := m.discoveryRegistry(rc.Backend, rc.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	if rc.Addr != "" {
+		return reg, rc.Addr, nil
+	}
+	return reg, localAddr(address), nil
+}
+
+// 实例启动后注册到注册中心，调用方不持有m.mu。实例已经开始停止（已注销）时不再注册
+func (s *Server) register() {
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+	if s.deregistered || s.registered {
+		return
+	}
+	s.registered = true
+	for _, r := range s.registrations {
+		ctx, cancel := context.WithTimeout(context.Background(), registerTimeout)
+		if err := r.reg.Register(ctx, r.path, r.addr); err != nil {
+			s.Logger.Error("register failed", "backend", r.backend, "path", r.path, "registered_addr", r.addr, "err", err)
+		} else {
+			s.Logger.Info("registered", "backend", r.backend, "path", r.path, "registered_addr", r.addr)
+		}
+		cancel()
+	}
+}
+
+// 实例停止前从注册中心注销，使上游先摘除再关闭，调用方不持有m.mu。多次调用只注销一次
+func (s *Server) deregister() {
+	s.regMu.Lock()
+	defer s.regMu.Unlock()
+	if s.deregistered {
+		return
+	}
+	s.deregistered = true
+	if !s.registered {
+		return
+	}
+	for _, r := range s.registrations {
+		ctx, cancel := context.WithTimeout(context.Background(), registerTimeout)
+		if err := r.reg.Deregister(ctx, r.path, r.addr); err != nil {
+			s.Logger.Error("deregister failed", "backend", r.backend, "path", r.path, "registered_addr", r.addr, "err", err)
+		}
+		cancel()
+	}
+}
+
+// 按zk节点中的期望服务列表（type:address）启动缺少的服务，停止由列表启动但已被移除的服务。
+// 手动或配置文件启动的服务不会被停止
+func (m *ServerManager) reconcile(data []byte) {
+	var desired []string
+	if err := yaml.Unmarshal(data, &desired); err != nil {
+		log.Printf("invalid desired server list: %v", err)
+		return
+	}
+	want := make(map[string]bool)
+	for _, name := range desired {
+		typ, address, ok := strings.Cut(strings.TrimSpace(name), ":")
+		if !ok || typ == "" || address == "" {
+			log.Printf("invalid desired server %q, expected type:address", name)
+			continue
+		}
+		key := typ + ":" + address
+		want[key] = true
+		if m.isRunning(key) {
+			continue
+		}
+		// 启动前标记，启动通知触发的状态写入不会包含它
+		m.mu.Lock()
+		m.desired[key] = true
+		m.mu.Unlock()
+		if err := m.StartServer(typ, address); err != nil {
+			log.Printf("Failed to start desired server %s: %v", key, err)
+			m.mu.Lock()
+			delete(m.desired, key)
+			m.mu.Unlock()
+		}
+	}
+
+	m.mu.Lock()
+	var remove []string
+	for key := range m.desired {
+		if !want[key] {
+			remove = append(remove, key)
+		}
+	}
+	m.mu.Unlock()
+	// 停止后再取消标记，停止期间的状态写入同样不包含它
+	for _, key := range remove {
+		typ, address, _ := strings.Cut(key, ":")
+		if err := m.StopServer(typ, address); err != nil {
+			log.Printf("Failed to stop server %s: %v", key, err)
+		}
+		m.mu.Lock()
+		delete(m.desired, key)
+		m.mu.Unlock()
+	}
+}
+
+// 当前按期望服务列表启动的实例
+func (m *ServerManager) desiredSet() map[string]bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return maps.Clone(m.desired)
+}
+
+func (m *ServerManager) isRunning(key string) bool {
+	m.mu.Lock()
+	s, ok := m.servers[key]
+	m.mu.Unlock()
+	return ok && s.running()
+}
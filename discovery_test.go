@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper"
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper/zktest"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/registry"
+)
+
+const waitTimeout = 3 * time.Second
+
+// 测试使用的最小配置：不连接注册中心
+func testConfig(t *testing.T) {
+	t.Helper()
+	old := mConfig
+	mConfig = &Config{}
+	t.Cleanup(func() { mConfig = old })
+}
+
+// 连接到独立的进程内zk（zktest），与connectZookeeper的结果一致
+func testManager(t *testing.T) *ServerManager {
+	t.Helper()
+	testConfig(t)
+	m := NewServerManager()
+	if err := m.ConnectDiscovery(mConfig); err != nil {
+		t.Fatal(err)
+	}
+	zkManager := zookeeper.NewZkManager([]string{"zktest"})
+	zkManager.Dial = zktest.NewServer().Dial
+	if err := zkManager.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.zk = zkManager
+	m.registries[registry.BackendZookeeper] = registry.NewZookeeper(zkManager)
+	m.mu.Unlock()
+	t.Cleanup(func() {
+		m.StopAll()
+		m.CloseDiscovery()
+	})
+	return m
+}
+
+// 系统分配一个当前空闲的端口
+func freeTestPort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+type recordingSetter struct {
+	updates chan []string
+}
+
+func (s *recordingSetter) SetUpstreams(addrs []string) error {
+	s.updates <- addrs
+	return nil
+}
+
+func waitUpstreams(t *testing.T, ch <-chan []string, want []string) {
+	t.Helper()
+	deadline := time.After(waitTimeout)
+	for {
+		select {
+		case got := <-ch:
+			if slices.Equal(got, want) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("upstreams never became %v", want)
+		}
+	}
+}
+
+func TestFollowUpstreams(t *testing.T) {
+	m := testManager(t)
+	lg, err := logger.New(logger.Options{Level: "error"}, "test", "tcp-proxy", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lg.Close()
+
+	const service = "/test/follow_upstreams"
+	setter := &recordingSetter{updates: make(chan []string, 16)}
+	var stopped sync.WaitGroup
+	stopped.Add(1)
+	m.mu.Lock()
+	stop := m.followUpstreams("", service, setter, lg, func() error {
+		stopped.Done()
+		return nil
+	})
+	reg := m.registries[registry.BackendZookeeper]
+	m.mu.Unlock()
+
+	ctx := context.Background()
+	waitUpstreams(t, setter.updates, nil)
+	if err := reg.Register(ctx, service, "127.0.0.1:7001"); err != nil {
+		t.Fatal(err)
+	}
+	waitUpstreams(t, setter.updates, []string{"127.0.0.1:7001"})
+	if err := reg.Register(ctx, service, "127.0.0.1:7002"); err != nil {
+		t.Fatal(err)
+	}
+	waitUpstreams(t, setter.updates, []string{"127.0.0.1:7001", "127.0.0.1:7002"})
+	if err := reg.Deregister(ctx, service, "127.0.0.1:7001"); err != nil {
+		t.Fatal(err)
+	}
+	waitUpstreams(t, setter.updates, []string{"127.0.0.1:7002"})
+
+	// stop先停止跟随再停止代理，之后的变化不再替换上游
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	stopped.Wait()
+	time.Sleep(100 * time.Millisecond)
+	for len(setter.updates) > 0 {
+		<-setter.updates
+	}
+	if err := reg.Register(ctx, service, "127.0.0.1:7003"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case got := <-setter.updates:
+		t.Fatalf("upstreams updated after stop: %v", got)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestFollowUpstreamsWithoutService(t *testing.T) {
+	m := NewServerManager()
+	called := false
+	stop := m.followUpstreams("", "", nil, nil, func() error {
+		called = true
+		return nil
+	})
+	stop()
+	if !called {
+		t.Fatal("stop not returned unchanged without service")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	m := testManager(t)
+	manual, first, second := freeTestPort(t), freeTestPort(t), freeTestPort(t)
+	if err := m.StartServer("tcp", manual); err != nil {
+		t.Fatal(err)
+	}
+
+	// 已运行的手动实例不记为期望实例
+	m.reconcile([]byte("- tcp:" + manual + "\n- tcp:" + first + "\n- tcp:" + second + "\n"))
+	for _, port := range []string{manual, first, second} {
+		if !m.isRunning("tcp:" + port) {
+			t.Fatalf("tcp:%s not running after reconcile", port)
+		}
+	}
+	m.mu.Lock()
+	desired := len(m.desired)
+	manualDesired := m.desired["tcp:"+manual]
+	m.mu.Unlock()
+	if desired != 2 || manualDesired {
+		t.Fatalf("desired = %d entries (manual included: %v), want only the two started", desired, manualDesired)
+	}
+
+	// 从列表中移除：只停止由列表启动的实例
+	m.reconcile([]byte("- tcp:" + second + "\n"))
+	if m.isRunning("tcp:" + first) {
+		t.Fatalf("tcp:%s still running after removal", first)
+	}
+	if !m.isRunning("tcp:" + second) {
+		t.Fatalf("tcp:%s stopped although still listed", second)
+	}
+	m.reconcile([]byte("[]"))
+	if m.isRunning("tcp:" + second) {
+		t.Fatalf("tcp:%s still running after removal", second)
+	}
+	if !m.isRunning("tcp:" + manual) {
+		t.Fatal("manually started server was stopped by reconcile")
+	}
+
+	// 无效数据不改变当前状态
+	m.reconcile([]byte("not: [a list"))
+	if !m.isRunning("tcp:" + manual) {
+		t.Fatal("invalid desired list stopped a server")
+	}
+}
+
+func TestRegistrationLifecycle(t *testing.T) {
+	m := testManager(t)
+	first, second := freeTestPort(t), freeTestPort(t)
+	const service = "/test/registrations"
+	mConfig.Registrations = []RegistrationConfig{
+		{Instance: "tcp:" + first, Backend: registry.BackendZookeeper, Path: service},
+		{Instance: "tcp:" + second, Backend: registry.BackendZookeeper, Path: service, Addr: "10.0.0.1:80"},
+	}
+	m.mu.Lock()
+	reg := m.registries[registry.BackendZookeeper]
+	m.mu.Unlock()
+	list := func() []string {
+		t.Helper()
+		addrs, err := reg.List(context.Background(), service)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return addrs
+	}
+
+	for _, port := range []string{first, second} {
+		if err := m.StartServer("tcp", port); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := list(), []string{"10.0.0.1:80", "127.0.0.1:" + first}; !slices.Equal(got, want) {
+		t.Fatalf("registered = %v, want %v", got, want)
+	}
+	if err := m.StopServer("tcp", first); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := list(), []string{"10.0.0.1:80"}; !slices.Equal(got, want) {
+		t.Fatalf("after stop = %v, want %v", got, want)
+	}
+	// 重启后重新注册
+	if err := m.StartServer("tcp", first); err != nil {
+		t.Fatal(err)
+	}
+	if got := list(); len(got) != 2 {
+		t.Fatalf("after restart = %v", got)
+	}
+	m.StopAll()
+	if got := list(); len(got) != 0 {
+		t.Fatalf("after stop all = %v", got)
+	}
+}
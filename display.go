@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/chzyer/readline"
+)
+
+// 完整表格的宽度，终端比它窄时自动使用紧凑模式
+const fullTableWidth = 117
+
+// 状态表的显示设置，由 printMu 保护
+var tableView = struct {
+	sortBy  string
+	desc    bool
+	filters map[string]string
+	compact string // auto/on/off
+}{
+	filters: map[string]string{},
+	compact: "auto",
+}
+
+// 一行数据：服务器信息 + 流量快照
+type serverRow struct {
+	*Server
+	stats  metrics.Snapshot
+	uptime time.Duration
+}
+
+// 可排序的字段
+var sortFields = map[string]func(a, b serverRow) bool{
+	"type":    func(a, b serverRow) bool { return a.Type < b.Type },
+	"address": func(a, b serverRow) bool { return a.Address < b.Address },
+	"status":  func(a, b serverRow) bool { return a.Status < b.Status },
+	"rps":     func(a, b serverRow) bool { return a.stats.RPS < b.stats.RPS },
+	"errors":  func(a, b serverRow) bool { return a.stats.ErrorRate < b.stats.ErrorRate },
+	"p50":     func(a, b serverRow) bool { return a.stats.P50 < b.stats.P50 },
+	"p99":     func(a, b serverRow) bool { return a.stats.P99 < b.stats.P99 },
+	"conns":   func(a, b serverRow) bool { return a.stats.ActiveConns < b.stats.ActiveConns },
+	"uptime":  func(a, b serverRow) bool { return a.uptime < b.uptime },
+}
+
+// 可过滤的字段
+var filterFields = map[string]func(r serverRow) string{
+	"type":    func(r serverRow) string { return r.Type },
+	"address": func(r serverRow) string { return r.Address },
+	"status":  func(r serverRow) string { return r.Status },
+	"name":    func(r serverRow) string { return r.Name },
+	"group":   func(r serverRow) string { return r.Group },
+}
+
+// 处理 sort/filter/compact 命令
+func updateTableView(cmd []string) error {
+	printMu.Lock()
+	defer printMu.Unlock()
+	switch cmd[0] {
+	case "sort":
+		// sort <field> [asc|desc]，不带参数时恢复默认顺序
+		if len(cmd) == 1 {
+			tableView.sortBy = ""
+			return nil
+		}
+		if _, ok := sortFields[cmd[1]]; !ok {
+			return fmt.Errorf("unknown sort field %q, available: type, address, status, rps, errors, p50, p99, conns, uptime", cmd[1])
+		}
+		tableView.sortBy = cmd[1]
+		// 流量相关字段默认从大到小
+		tableView.desc = cmd[1] != "type" && cmd[1] != "address" && cmd[1] != "status"
+		if len(cmd) > 2 {
+			tableView.desc = cmd[2] == "desc"
+		}
+	case "filter":
+		// filter type=http status=running，不带参数时清除所有过滤条件
+		if len(cmd) == 1 {
+			tableView.filters = map[string]string{}
+			return nil
+		}
+		for _, f := range cmd[1:] {
+			k, v, ok := strings.Cut(f, "=")
+			if !ok {
+				return fmt.Errorf("filter must be key=value, got %q", f)
+			}
+			if _, ok := filterFields[k]; !ok {
+				return fmt.Errorf("unknown filter field %q, available: type, address, status, name, group", k)
+			}
+			if v == "" {
+				delete(tableView.filters, k)
+			} else {
+				tableView.filters[k] = v
+			}
+		}
+	case "compact":
+		if len(cmd) != 2 || (cmd[1] != "on" && cmd[1] != "off" && cmd[1] != "auto") {
+			return fmt.Errorf("usage: compact on|off|auto")
+		}
+		tableView.compact = cmd[1]
+	}
+	return nil
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "-"
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dus", d.Microseconds())
+	case d < time.Second:
+		return fmt.Sprintf("%.1fms", float64(d.Microseconds())/1000)
+	default:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+}
+
+func formatUptime(d time.Duration) string {
+	if d <= 0 {
+		return "-"
+	}
+	d = d.Truncate(time.Second)
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd%s", d/(24*time.Hour), (d % (24 * time.Hour)).String())
+	}
+	return d.String()
+}
+
+// 按当前的过滤条件与排序生成表格行，调用方持有 printMu
+func tableRows(servers []*Server, now time.Time) []serverRow {
+	rows := make([]serverRow, 0, len(servers))
+	for _, s := range servers {
+		row := serverRow{Server: s}
+		if s.Status == "running" {
+			row.stats = s.Metrics.Snapshot()
+			row.uptime = now.Sub(s.StartedAt)
+		}
+		matched := true
+		for k, v := range tableView.filters {
+			if filterFields[k](row) != v {
+				matched = false
+			}
+		}
+		if matched {
+			rows = append(rows, row)
+		}
+	}
+	if less, ok := sortFields[tableView.sortBy]; ok {
+		sort.SliceStable(rows, func(i, j int) bool {
+			if tableView.desc {
+				return less(rows[j], rows[i])
+			}
+			return less(rows[i], rows[j])
+		})
+	}
+	return rows
+}
+
+// 将服务器状态输出到 rl.Stdout()
+// 这里保持表格样式（每次 ClearScreen + 重绘），调用方持有 printMu
+func displayServers(servers []*Server, span_time int) {
+	rows := tableRows(servers, time.Now())
+	compact := tableView.compact == "on" ||
+		(tableView.compact == "auto" && readline.GetScreenWidth() > 0 && readline.GetScreenWidth() < fullTableWidth)
+
+	w := rl.Stdout()
+	fmt.Fprintf(w, "The service has been running continuously for %v seconds.\n", span_time)
+	if compact {
+		fmt.Fprintln(w, "┌──────────┬───────────────────┬─────────┬─────────┬─────────┐")
+		fmt.Fprintln(w, "│ Type     │ Address/Port      │ Status  │ RPS     │ p99     │")
+		fmt.Fprintln(w, "├──────────┼───────────────────┼─────────┼─────────┼─────────┤")
+		for _, r := range rows {
+			fmt.Fprintf(w, "│ %-8s │ %-17s │ %-7s │ %7.1f │ %7s │\n",
+				r.Type, r.Address, r.Status, r.stats.RPS, formatDuration(r.stats.P99))
+		}
+		fmt.Fprintln(w, "└──────────┴───────────────────┴─────────┴─────────┴─────────┘")
+	} else {
+		fmt.Fprintln(w, "┌───────────────┬───────────────────┬───────────────┬─────────┬────────┬──────────┬──────────┬────────┬─────────────┐")
+		fmt.Fprintln(w, "│     Type      │   Address/Port    │     Status    │   RPS   │  Err%  │   p50    │   p99    │ Conns  │   Uptime    │")
+		fmt.Fprintln(w, "├───────────────┼───────────────────┼───────────────┼─────────┼────────┼──────────┼──────────┼────────┼─────────────┤")
+		for _, r := range rows {
+			fmt.Fprintf(w, "│ %-13s │ %-17s │ %-13s │ %7.1f │ %5.1f%% │ %8s │ %8s │ %6d │ %11s │\n",
+				r.Type, r.Address, r.Status, r.stats.RPS, r.stats.ErrorRate*100,
+				formatDuration(r.stats.P50), formatDuration(r.stats.P99), r.stats.ActiveConns, formatUptime(r.uptime))
+		}
+		fmt.Fprintln(w, "└───────────────┴───────────────────┴───────────────┴─────────┴────────┴──────────┴──────────┴────────┴─────────────┘")
+	}
+	if len(tableView.filters) > 0 || tableView.sortBy != "" {
+		fmt.Fprintf(w, "filter: %v, sort: %s\n", tableView.filters, tableView.sortBy)
+	}
+	fmt.Fprintln(w, "Enter commands: start|stop|restart [type] [address] or [selector ...], scale [type] [count] [--base-port N]")
+	fmt.Fprintln(w, "                logs [type:address|name] [--follow] [--grep pattern], save|load [name]")
+	fmt.Fprintln(w, "                sort [field] [asc|desc], filter [key=value ...], compact on|off|auto")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+)
+
+// 恢复默认的表格设置
+func resetTableView(t *testing.T) {
+	t.Helper()
+	reset := func() {
+		tableView.sortBy = ""
+		tableView.desc = false
+		tableView.filters = map[string]string{}
+		tableView.compact = "auto"
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func testRows(now time.Time) []*Server {
+	server := func(typ, address, status, group string, conns int, age time.Duration) *Server {
+		s := &Server{Type: typ, Address: address, Status: status, StartedAt: now.Add(-age),
+			Labels:  Labels{Name: typ + ":" + address, Group: group},
+			Metrics: metrics.NewInstance(typ+":"+address, typ, address)}
+		for range conns {
+			s.Metrics.ConnOpened()
+		}
+		return s
+	}
+	return []*Server{
+		server("tcp", "3003", "running", "api", 2, time.Hour),
+		server("http", "2003", "running", "api", 5, time.Minute),
+		server("udp", "5003", "stopped", "", 0, 0),
+		server("tcp", "3004", "running", "", 1, 2*time.Hour),
+	}
+}
+
+func rowKeys(rows []serverRow) []string {
+	keys := make([]string, len(rows))
+	for i, r := range rows {
+		keys[i] = r.Type + ":" + r.Address
+	}
+	return keys
+}
+
+func TestTableSort(t *testing.T) {
+	resetTableView(t)
+	now := time.Now()
+	servers := testRows(now)
+	for _, tc := range []struct {
+		cmd  []string
+		want []string
+	}{
+		// 默认保持原顺序
+		{[]string{"sort"}, []string{"tcp:3003", "http:2003", "udp:5003", "tcp:3004"}},
+		// 流量字段默认从大到小，未运行的实例统计为0
+		{[]string{"sort", "conns"}, []string{"http:2003", "tcp:3003", "tcp:3004", "udp:5003"}},
+		{[]string{"sort", "conns", "asc"}, []string{"udp:5003", "tcp:3004", "tcp:3003", "http:2003"}},
+		{[]string{"sort", "uptime"}, []string{"tcp:3004", "tcp:3003", "http:2003", "udp:5003"}},
+		// 文本字段默认从小到大，相同时保持原顺序
+		{[]string{"sort", "type"}, []string{"http:2003", "tcp:3003", "tcp:3004", "udp:5003"}},
+		{[]string{"sort", "address", "desc"}, []string{"udp:5003", "tcp:3004", "tcp:3003", "http:2003"}},
+		{[]string{"sort", "status"}, []string{"tcp:3003", "http:2003", "tcp:3004", "udp:5003"}},
+	} {
+		if err := updateTableView(tc.cmd); err != nil {
+			t.Fatal(err)
+		}
+		if got := rowKeys(tableRows(servers, now)); !slices.Equal(got, tc.want) {
+			t.Errorf("%v: rows = %v, want %v", tc.cmd, got, tc.want)
+		}
+	}
+	if err := updateTableView([]string{"sort", "latency"}); err == nil {
+		t.Fatal("unknown sort field accepted")
+	}
+}
+
+func TestTableFilter(t *testing.T) {
+	resetTableView(t)
+	now := time.Now()
+	servers := testRows(now)
+	for _, tc := range []struct {
+		cmd  []string
+		want []string
+	}{
+		{[]string{"filter", "type=tcp"}, []string{"tcp:3003", "tcp:3004"}},
+		// 条件累加，同时满足才显示
+		{[]string{"filter", "group=api"}, []string{"tcp:3003"}},
+		// 空值删除单个条件
+		{[]string{"filter", "type="}, []string{"tcp:3003", "http:2003"}},
+		{[]string{"filter", "group=", "status=stopped"}, []string{"udp:5003"}},
+		{[]string{"filter", "status=", "name=tcp:3004"}, []string{"tcp:3004"}},
+		{[]string{"filter", "address=9999"}, []string{}},
+		{[]string{"filter"}, []string{"tcp:3003", "http:2003", "udp:5003", "tcp:3004"}},
+	} {
+		if err := updateTableView(tc.cmd); err != nil {
+			t.Fatal(err)
+		}
+		if got := rowKeys(tableRows(servers, now)); !slices.Equal(got, tc.want) {
+			t.Errorf("%v: rows = %v, want %v", tc.cmd, got, tc.want)
+		}
+	}
+	for _, cmd := range [][]string{{"filter", "type"}, {"filter", "port=1"}} {
+		if err := updateTableView(cmd); err == nil {
+			t.Errorf("%v accepted", cmd)
+		}
+	}
+
+	// 过滤与排序同时生效
+	updateTableView([]string{"filter", "status=running"})
+	updateTableView([]string{"sort", "conns", "asc"})
+	if got, want := rowKeys(tableRows(servers, now)), []string{"tcp:3004", "tcp:3003", "http:2003"}; !slices.Equal(got, want) {
+		t.Fatalf("rows = %v, want %v", got, want)
+	}
+}
+
+func TestCompactCommand(t *testing.T) {
+	resetTableView(t)
+	for _, mode := range []string{"on", "off", "auto"} {
+		if err := updateTableView([]string{"compact", mode}); err != nil || tableView.compact != mode {
+			t.Fatalf("compact %s: %v, mode %q", mode, err, tableView.compact)
+		}
+	}
+	for _, cmd := range [][]string{{"compact"}, {"compact", "yes"}, {"compact", "on", "off"}} {
+		if err := updateTableView(cmd); err == nil {
+			t.Errorf("%v accepted", cmd)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		0:                       "-",
+		250 * time.Microsecond:  "250us",
+		1500 * time.Microsecond: "1.5ms",
+		2500 * time.Millisecond: "2.50s",
+	} {
+		if got := formatDuration(d); got != want {
+			t.Errorf("formatDuration(%v) = %q, want %q", d, got, want)
+		}
+	}
+	for d, want := range map[time.Duration]string{
+		-time.Second:               "-",
+		90*time.Second + 1:         "1m30s",
+		26*time.Hour + time.Minute: "1d2h1m0s",
+		48 * time.Hour:             "2d0s",
+	} {
+		if got := formatUptime(d); got != want {
+			t.Errorf("formatUptime(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
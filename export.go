@@ -0,0 +1,152 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/21Mile/go_downstreamer_server/services/file_sd"
+)
+
+// Exporter 服务状态变化时重新生成文件方式服务发现的配置
+type Exporter struct {
+	writer  *file_sd.Writer
+	manager *ServerManager
+	mu      sync.Mutex // 串行写入
+	done    chan struct{}
+}
+
+// 启动导出，Dir为空时不启用
+func startExporter(cfg ExportConfig, manager *ServerManager) *Exporter {
+	if cfg.Dir == "" {
+		return nil
+	}
+	w, err := file_sd.NewWriter(cfg.Dir, cfg.Formats, cfg.SRVDomain)
+	if err != nil {
+		log.Printf("export disabled: %v", err)
+		return nil
+	}
+	e := &Exporter{writer: w, manager: manager, done: make(chan struct{})}
+	changed := manager.Subscribe()
+	e.write()
+	go func() {
+		for {
+			select {
+			case <-changed:
+				e.write()
+			case <-e.done:
+				return
+			}
+		}
+	}()
+	return e
+}
+
+// Close 停止跟随，并按当前状态最后写一次（退出时所有服务已停止）
+func (e *Exporter) Close() {
+	close(e.done)
+	e.write()
+}
+
+func (e *Exporter) write() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err := e.writer.Write(exportGroups(e.manager.GetServers())); err != nil {
+		log.Printf("export failed: %v", err)
+	}
+}
+
+// 一个受管实例在服务发现中的地址与权重
+type instance struct {
+	Name     string // type:address
+	Label    string // 实例名称
+	Type     string
+	Tags     []string
+	Host     string
+	Port     int
+	Protocol string // tcp/udp
+	Weight   int
+	Running  bool
+}
+
+type instanceGroup struct {
+	Name      string
+	Instances []instance
+}
+
+// 按实例的group分组，未设置group的按类型分组。停止的服务也在分组中，使下游能摘除它。
+// 监听所有地址的实例以本机地址发布
+func groupInstances(servers []*Server) []instanceGroup {
+	index := make(map[string]int)
+	var groups []instanceGroup
+	for _, s := range servers {
+		group := s.Group
+		if group == "" {
+			group = s.Type
+		}
+		i, ok := index[group]
+		if !ok {
+			i = len(groups)
+			index[group] = i
+			groups = append(groups, instanceGroup{Name: group})
+		}
+		host, portStr, err := net.SplitHostPort(localAddr(s.Address))
+		if err != nil {
+			continue
+		}
+		port, _ := strconv.Atoi(portStr)
+		name := s.Type + ":" + s.Address
+		groups[i].Instances = append(groups[i].Instances, instance{
+			Name:     name,
+			Label:    s.Name,
+			Type:     s.Type,
+			Tags:     s.Tags,
+			Host:     host,
+			Port:     port,
+			Protocol: instanceProtocol(s.Type),
+			Weight:   exportWeight(s),
+			Running:  s.Status == "running",
+		})
+	}
+	return groups
+}
+
+// 文件中只包含运行中的实例
+func exportGroups(servers []*Server) []file_sd.Group {
+	var groups []file_sd.Group
+	for _, g := range groupInstances(servers) {
+		fg := file_sd.Group{Name: g.Name}
+		for _, in := range g.Instances {
+			if !in.Running {
+				continue
+			}
+			fg.Endpoints = append(fg.Endpoints, file_sd.Endpoint{
+				Name:     in.Name,
+				Label:    in.Label,
+				Type:     in.Type,
+				Tags:     in.Tags,
+				Host:     in.Host,
+				Port:     in.Port,
+				Protocol: in.Protocol,
+				Weight:   in.Weight,
+			})
+		}
+		groups = append(groups, fg)
+	}
+	return groups
+}
+
+// udp服务为udp，其它类型都基于tcp
+func instanceProtocol(typ string) string {
+	if typ == "udp" {
+		return file_sd.ProtocolUDP
+	}
+	return file_sd.ProtocolTCP
+}
+
+// 权重取 report.weights 中的期望权重，四舍五入且不小于1
+func exportWeight(s *Server) int {
+	return max(1, int(math.Round(instanceWeight(mConfig.Report.Weights, s))))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/21Mile/go_downstreamer_server/services/file_sd"
+)
+
+func TestLocalAddr(t *testing.T) {
+	for address, want := range map[string]string{
+		"8080":           "127.0.0.1:8080",
+		":8080":          "127.0.0.1:8080",
+		"0.0.0.0:8080":   "127.0.0.1:8080",
+		"[::]:8080":      "[::1]:8080",
+		"10.0.0.1:8080":  "10.0.0.1:8080",
+		"localhost:8080": "localhost:8080",
+	} {
+		if got := localAddr(address); got != want {
+			t.Errorf("localAddr(%q) = %q, want %q", address, got, want)
+		}
+	}
+}
+
+func TestExportGroups(t *testing.T) {
+	old := mConfig
+	mConfig = &Config{}
+	t.Cleanup(func() { mConfig = old })
+
+	servers := []*Server{
+		{Type: "http", Address: "0.0.0.0:8080", Status: "running"},
+		{Type: "udp", Address: "5003", Status: "running"},
+		{Type: "udp", Address: "5004", Status: "stopped"},
+	}
+	groups := exportGroups(servers)
+	if len(groups) != 2 {
+		t.Fatalf("groups = %+v", groups)
+	}
+	web := groups[0].Endpoints[0]
+	if web.Host != "127.0.0.1" || web.Port != 8080 || web.Protocol != file_sd.ProtocolTCP {
+		t.Errorf("http endpoint = %+v, want 127.0.0.1:8080 over tcp", web)
+	}
+	// 停止的实例不写入文件
+	if len(groups[1].Endpoints) != 1 || groups[1].Endpoints[0].Protocol != file_sd.ProtocolUDP {
+		t.Errorf("udp group = %+v", groups[1])
+	}
+}
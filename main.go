@@ -1,19 +1,24 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
 	"github.com/chzyer/readline"
-	// 假设这些包存在并且接口和你原来的一样
 )
 
 // ---------- 全局变量和 I/O 控制 ----------
@@ -23,6 +28,10 @@ var (
 
 	// 打印锁，防止 monitor 与命令输出的竞争
 	printMu sync.Mutex
+
+	// 查看日志时暂停状态表刷新；stopFollow 结束 --follow
+	logViewing atomic.Bool
+	stopFollow func()
 )
 
 func main() {
@@ -30,8 +39,13 @@ func main() {
 	mConfig.Log.LogPath, _ = filepath.Abs(mConfig.Log.LogPath)
 	manager := NewServerManager()
 
+	// 链路追踪，需在服务启动前初始化
+	shutdownTracing, err := tracing.Setup(mConfig.Trace)
+	if err != nil {
+		log.Fatalf("tracing init error: %v", err)
+	}
+
 	// readline 初始化
-	var err error
 	rl, err = readline.NewEx(&readline.Config{
 		Prompt:       "> ",
 		HistoryLimit: 1000,
@@ -43,9 +57,24 @@ func main() {
 
 	// 把 log 输出重定向到 rl.Stderr() 避免打断当前输入行
 	log.SetOutput(rl.Stderr())
+	logger.SetConsoleOutput(rl.Stderr())
+
+	// 服务发现需在代理启动前连接
+	if err := manager.ConnectDiscovery(mConfig); err != nil {
+		log.Printf("discovery: %v", err)
+	}
 
 	// 启动配置中的服务器
 	startConfiguredServers(mConfig, manager)
+	// 状态文件中的实例在配置文件之后恢复，以状态文件为准
+	state := startStateKeeper(mConfig.State, manager)
+	// 期望服务列表在恢复完成后再跟随，两者不会同时启动同一实例
+	manager.FollowDesired(mConfig.Zookeeper.DesiredPath)
+	exporter := startExporter(mConfig.Export, manager)
+	xds := startXdsServer(mConfig.Xds, manager)
+
+	// 管理接口
+	admin := startAdminServer(mConfig.Admin.Addr, manager)
 
 	// 处理退出信号
 	quit := make(chan os.Signal, 1)
@@ -62,7 +91,26 @@ func main() {
 	printMu.Lock()
 	fmt.Fprintln(rl.Stdout(), "\nShutting down all servers...")
 	printMu.Unlock()
+	if state != nil {
+		state.Close()
+	}
 	manager.StopAll()
+	manager.CloseDiscovery()
+	if exporter != nil {
+		exporter.Close()
+	}
+	if xds != nil {
+		xds.Close()
+	}
+	if admin != nil {
+		admin.Close()
+	}
+	// 退出前导出剩余的span
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("tracing shutdown error: %v", err)
+	}
+	cancel()
 	printMu.Lock()
 	fmt.Fprintln(rl.Stdout(), "All servers stopped. Exiting...")
 	printMu.Unlock()
@@ -70,23 +118,52 @@ func main() {
 
 // 启动配置中的服务器（示例，保持你原有逻辑）
 func startConfiguredServers(config *Config, manager *ServerManager) {
-	for _, port := range config.GRPC.Ports {
-		addr := strconv.Itoa(port)
-		if err := manager.StartServer("grpc", addr); err != nil {
-			log.Printf("Failed to start GRPC server on %s: %v", addr, err)
+	grpcPort := func(i GRPCInstanceConfig) string { return strconv.Itoa(i.Port) }
+	tcpPort := func(i TCPInstanceConfig) string { return strconv.Itoa(i.Port) }
+	udpPort := func(i UDPInstanceConfig) string { return strconv.Itoa(i.Port) }
+	redisPort := func(i RedisInstanceConfig) string { return strconv.Itoa(i.Port) }
+	tcpProxyPort := func(i TCPProxyInstance) string { return strconv.Itoa(i.Port) }
+	httpAddr := func(i HTTPInstanceConfig) string { return i.Addr }
+	httpProxyAddr := func(i HTTPProxyInstance) string { return i.Addr }
+
+	// 每种类型先启动端口列表中的实例，再启动单独配置参数的实例
+	for _, group := range []struct {
+		typ   string
+		addrs []string
+	}{
+		{"grpc", slices.Concat(portAddrs(config.GRPC.Ports), instanceAddrs(config.GRPC.Instances, grpcPort))},
+		{"grpc-mock", portAddrs(config.GRPCMock.Ports)},
+		{"http", slices.Concat(config.HTTP.Addrs, instanceAddrs(config.HTTP.Instances, httpAddr))},
+		{"tcp", slices.Concat(portAddrs(config.TCP.Ports), instanceAddrs(config.TCP.Instances, tcpPort))},
+		{"udp", slices.Concat(portAddrs(config.UDP.Ports), instanceAddrs(config.UDP.Instances, udpPort))},
+		{"redis", slices.Concat(portAddrs(config.Redis.Ports), instanceAddrs(config.Redis.Instances, redisPort))},
+		{"tcp-proxy", slices.Concat(portAddrs(config.TCPProxy.Ports), instanceAddrs(config.TCPProxy.Instances, tcpProxyPort))},
+		{"http-proxy", slices.Concat(config.HTTPProxy.Addrs, instanceAddrs(config.HTTPProxy.Instances, httpProxyAddr))},
+	} {
+		for _, addr := range group.addrs {
+			if err := manager.StartServer(group.typ, addr); err != nil {
+				log.Printf("Failed to start %s server on %s: %v", group.typ, addr, err)
+			}
 		}
 	}
-	for _, addr := range config.HTTP.Addrs {
-		if err := manager.StartServer("http", addr); err != nil {
-			log.Printf("Failed to start HTTP server on %s: %v", addr, err)
-		}
+}
+
+// 端口列表转为实例地址
+func portAddrs(ports []int) []string {
+	addrs := make([]string, len(ports))
+	for i, port := range ports {
+		addrs[i] = strconv.Itoa(port)
 	}
-	for _, port := range config.TCP.Ports {
-		addr := strconv.Itoa(port)
-		if err := manager.StartServer("tcp", addr); err != nil {
-			log.Printf("Failed to start TCP server on %s: %v", addr, err)
-		}
+	return addrs
+}
+
+// 单独配置参数的实例地址
+func instanceAddrs[T any](instances []T, addr func(T) string) []string {
+	addrs := make([]string, len(instances))
+	for i, inst := range instances {
+		addrs[i] = addr(inst)
 	}
+	return addrs
 }
 
 // 监控循环：持续打印状态并自增 span_time；打印后调用 rl.Refresh() 保持当前输入行不被破坏
@@ -96,6 +173,10 @@ func monitorServers(manager *ServerManager) {
 	span_time := 0
 
 	for range ticker.C {
+		span_time++
+		if logViewing.Load() {
+			continue
+		}
 		// 先构造并打印（加锁）
 		printMu.Lock()
 		// 使用 readline 提供的 ClearScreen 来保持整洁（随后调用 rl.Refresh 恢复 prompt）
@@ -105,29 +186,9 @@ func monitorServers(manager *ServerManager) {
 
 		// 重新绘制 prompt + 当前行（这一步非常关键，可以让用户的输入保持在屏幕底部不会被“丢失”）
 		rl.Refresh()
-
-		span_time++
 	}
 }
 
-// 将服务器状态输出到 rl.Stdout()
-// 这里保持表格样式（每次 ClearScreen + 重绘）
-func displayServers(servers []*Server, span_time int) {
-	w := rl.Stdout()
-	fmt.Fprintf(w, "The service has been running continuously for %v seconds.\n", span_time)
-	fmt.Fprintln(w, "┌───────────────┬───────────────────┬───────────────┐")
-	fmt.Fprintln(w, "│     Type      │   Address/Port    │     Status    │")
-	fmt.Fprintln(w, "├───────────────┼───────────────────┼───────────────┤")
-
-	for _, s := range servers {
-		fmt.Fprintf(w, "│ %-13s │ %-17s │ %-13s │\n",
-			s.Type, s.Address, s.Status)
-	}
-
-	fmt.Fprintln(w, "└───────────────┴───────────────────┴───────────────┘")
-	fmt.Fprintln(w, "Enter commands: start [type] [address], stop [type] [address]")
-}
-
 // 命令处理循环：阻塞读取用户输入并处理
 func handleCommands(manager *ServerManager, quit chan<- os.Signal) {
 	for {
@@ -140,6 +201,8 @@ func handleCommands(manager *ServerManager, quit chan<- os.Signal) {
 			return
 		}
 
+		// 任意输入（包括直接回车）都会退出日志查看，恢复状态表
+		endLogView()
 		cmd := strings.Fields(line)
 		if len(cmd) > 0 {
 			processCommand(cmd, manager, quit)
@@ -150,35 +213,203 @@ func handleCommands(manager *ServerManager, quit chan<- os.Signal) {
 func processCommand(cmd []string, manager *ServerManager, quit chan<- os.Signal) {
 	// 为了避免输出冲突，所有命令回复也用 printMu 锁
 	switch cmd[0] {
-	case "start":
-		if len(cmd) != 3 {
+	case "start", "stop", "restart":
+		results, err := instanceCommand(cmd[0], cmd[1:], manager)
+		printMu.Lock()
+		if err != nil {
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			fmt.Fprintf(rl.Stdout(), "Usage: %s <type> <address|:0|lo-hi> [name=..] [group=..] [tags=a,b] | %s <type=..|group=..|tag=..|name=..|status=..>\n", cmd[0], cmd[0])
+		} else if len(cmd) > 1 && strings.Contains(cmd[1], "=") {
+			// 选择器形式输出每个实例的结果
+			printResults(rl.Stdout(), results)
+		} else if results[0].Error != "" {
+			fmt.Fprintf(rl.Stdout(), "Error: %s\n", results[0].Error)
+		} else if results[0].Instance != cmd[1]+":"+cmd[2] {
+			// :0 或端口范围时显示实际监听的地址
+			fmt.Fprintf(rl.Stdout(), "%s %s\n", cmd[0], results[0].Instance)
+		}
+		printMu.Unlock()
+	case "scale":
+		results, err := scaleCommand(cmd[1:], manager)
+		printMu.Lock()
+		if err != nil {
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			fmt.Fprintln(rl.Stdout(), "Usage: scale <type> <count> [--base-port N] [group=..] [tags=a,b]")
+		} else if len(results) == 0 {
+			fmt.Fprintln(rl.Stdout(), "nothing to do")
+		} else {
+			printResults(rl.Stdout(), results)
+		}
+		printMu.Unlock()
+	case "sort", "filter", "compact":
+		if err := updateTableView(cmd); err != nil {
 			printMu.Lock()
-			fmt.Fprintln(rl.Stdout(), "Usage: start <type> <address>")
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			printMu.Unlock()
+		}
+	case "logs":
+		if err := showLogs(cmd[1:], manager); err != nil {
+			printMu.Lock()
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			fmt.Fprintln(rl.Stdout(), "Usage: logs <type:address|name> [--follow] [--grep pattern] [-n lines]")
+			printMu.Unlock()
+		}
+	case "report":
+		if err := showReport(cmd[1:], manager); err != nil {
+			printMu.Lock()
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			fmt.Fprintln(rl.Stdout(), "Usage: report <type=..|address=..|status=..|name=..|group=..|tag=..> [--window 1m] [--weights name=w,...] [--json file]")
+			printMu.Unlock()
+		}
+	case "conns":
+		if err := showConns(cmd[1:], manager); err != nil {
+			printMu.Lock()
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			fmt.Fprintln(rl.Stdout(), "Usage: conns <type:address|name>")
 			printMu.Unlock()
-		} else {
-			if err := manager.StartServer(cmd[1], cmd[2]); err != nil {
-				printMu.Lock()
-				fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
-				printMu.Unlock()
-			}
 		}
-	case "stop":
-		if len(cmd) != 3 {
+	case "peers":
+		if err := showPeers(cmd[1:], manager); err != nil {
 			printMu.Lock()
-			fmt.Fprintln(rl.Stdout(), "Usage: stop <type> <address>")
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			fmt.Fprintln(rl.Stdout(), "Usage: peers <type:address|name>")
 			printMu.Unlock()
+		}
+	case "kill":
+		killed, err := killConns(cmd[1:], manager)
+		printMu.Lock()
+		if err != nil {
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			fmt.Fprintln(rl.Stdout(), "Usage: kill conn <type:address|name> <id|all>")
 		} else {
-			if err := manager.StopServer(cmd[1], cmd[2]); err != nil {
-				printMu.Lock()
-				fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
-				printMu.Unlock()
-			}
+			fmt.Fprintf(rl.Stdout(), "%d connection(s) killed\n", killed)
+		}
+		printMu.Unlock()
+	case "save":
+		path, err := saveSnapshot(cmd[1:], manager)
+		printMu.Lock()
+		if err != nil {
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			fmt.Fprintln(rl.Stdout(), "Usage: save <name>")
+		} else {
+			fmt.Fprintf(rl.Stdout(), "saved to %s\n", path)
+		}
+		printMu.Unlock()
+	case "load":
+		results, err := loadSnapshot(cmd[1:], manager)
+		printMu.Lock()
+		if err != nil {
+			fmt.Fprintf(rl.Stdout(), "Error: %v\n", err)
+			fmt.Fprintln(rl.Stdout(), "Usage: load <name>")
+		} else if len(results) == 0 {
+			fmt.Fprintln(rl.Stdout(), "nothing to do")
+		} else {
+			printResults(rl.Stdout(), results)
 		}
+		printMu.Unlock()
 	case "exit", "quit":
 		quit <- syscall.SIGTERM
 	default:
 		printMu.Lock()
-		fmt.Fprintln(rl.Stdout(), "Unknown command. Available: start, stop, exit")
+		fmt.Fprintln(rl.Stdout(), "Unknown command. Available: start, stop, restart, scale, save, load, logs, report, conns, peers, kill, sort, filter, compact, exit")
 		printMu.Unlock()
 	}
 }
+
+// 查看实例日志：暂停状态表刷新，打印最近的日志，--follow 时持续输出新日志直到下一次输入
+func showLogs(args []string, manager *ServerManager) error {
+	var name string
+	var follow bool
+	var grep *regexp.Regexp
+	lines := 20
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--follow", "-f":
+			follow = true
+		case "--grep":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--grep needs a pattern")
+			}
+			i++
+			re, err := regexp.Compile(args[i])
+			if err != nil {
+				return fmt.Errorf("invalid pattern: %w", err)
+			}
+			grep = re
+		case "-n":
+			if i+1 >= len(args) {
+				return fmt.Errorf("-n needs a number")
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				return fmt.Errorf("invalid line count: %w", err)
+			}
+			lines = n
+		default:
+			name = args[i]
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("missing server name")
+	}
+	lg, err := manager.GetLogger(name)
+	if err != nil {
+		return err
+	}
+
+	// 从全部缓存中过滤后再取最后n行
+	var matched []string
+	for _, line := range lg.Tail(0) {
+		if line != "" && (grep == nil || grep.MatchString(line)) {
+			matched = append(matched, line)
+		}
+	}
+	if lines > 0 && len(matched) > lines {
+		matched = matched[len(matched)-lines:]
+	}
+
+	logViewing.Store(true)
+	printMu.Lock()
+	w := rl.Stdout()
+	readline.ClearScreen(w)
+	fmt.Fprintf(w, "=== logs %s (press Enter to return) ===\n", name)
+	if path := lg.Path(); path != "" {
+		fmt.Fprintf(w, "file: %s\n", path)
+	}
+	for _, line := range matched {
+		fmt.Fprintln(w, line)
+	}
+	printMu.Unlock()
+	rl.Refresh()
+
+	if follow {
+		ch, cancel := lg.Subscribe()
+		printMu.Lock()
+		stopFollow = cancel
+		printMu.Unlock()
+		go func() {
+			for line := range ch {
+				if grep != nil && !grep.MatchString(line) {
+					continue
+				}
+				printMu.Lock()
+				fmt.Fprintln(rl.Stdout(), line)
+				printMu.Unlock()
+				rl.Refresh()
+			}
+		}()
+	}
+	return nil
+}
+
+// 结束日志查看，恢复状态表刷新
+func endLogView() {
+	printMu.Lock()
+	if stopFollow != nil {
+		stopFollow()
+		stopFollow = nil
+	}
+	printMu.Unlock()
+	logViewing.Store(false)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/21Mile/go_downstreamer_server/services/tcp_server"
+)
+
+func TestStartConfiguredServers(t *testing.T) {
+	m := testManager(t)
+	ports := make([]int, 6)
+	for i := range ports {
+		ports[i], _ = strconv.Atoi(freeTestPort(t))
+	}
+	httpAddr := "127.0.0.1:" + strconv.Itoa(ports[5])
+	mConfig.TCP.Ports = []int{ports[0]}
+	mConfig.TCP.Instances = []TCPInstanceConfig{{Port: ports[1]}}
+	mConfig.UDP.Ports = []int{ports[2]}
+	mConfig.Redis.Instances = []RedisInstanceConfig{{Port: ports[3]}}
+	mConfig.TCPProxy.Ports = []int{ports[4]}
+	mConfig.TCPProxy.Options = tcp_server.ProxyOptions{Upstreams: []tcp_server.Upstream{{Addr: "tcp:" + strconv.Itoa(ports[0])}}}
+	mConfig.HTTP.Instances = []HTTPInstanceConfig{{Addr: httpAddr}}
+	startConfiguredServers(mConfig, m)
+
+	var got []string
+	for _, s := range m.GetServers() {
+		if s.Status != "running" {
+			t.Errorf("%s:%s is %s", s.Type, s.Address, s.Status)
+		}
+		got = append(got, s.Type+":"+s.Address)
+	}
+	want := []string{
+		"tcp:" + strconv.Itoa(ports[0]),
+		"tcp:" + strconv.Itoa(ports[1]),
+		"udp:" + strconv.Itoa(ports[2]),
+		"redis:" + strconv.Itoa(ports[3]),
+		"tcp-proxy:" + strconv.Itoa(ports[4]),
+		"http:" + httpAddr,
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("started %v, want %v", got, want)
+	}
+}
@@ -1,62 +1,234 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/21Mile/go_downstreamer_server/services/grpc_server"
 	"github.com/21Mile/go_downstreamer_server/services/http_server"
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/registry"
 	"github.com/21Mile/go_downstreamer_server/services/tcp_server"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+	"github.com/21Mile/go_downstreamer_server/services/udp_server"
 	"google.golang.org/grpc"
 )
 
 type Server struct {
 	Type    string
 	Address string
-	Status  string
-	Stop    func() error
-	mu      sync.Mutex
+	Labels
+	Status    string
+	StartedAt time.Time
+	Stop      func() error
+	Logger    *logger.Logger
+	Metrics   *metrics.Instance
+	Conns     ConnTable // 支持连接管理的服务（tcp/redis），其它类型为nil
+	Peers     PeerTable // 按对端统计的服务（udp），其它类型为nil
+	mu        sync.Mutex
+
+	registrations []registration // 启动时按配置确定的注册项
+	regMu         sync.Mutex     // 串行化注册与注销，网络调用期间不持有m.mu
+	registered    bool
+	deregistered  bool
+}
+
+// 当前状态。Status由启动/停止流程在s.mu下修改，读取同样需要持有s.mu
+func (s *Server) status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Status
+}
+
+// 是否运行中
+func (s *Server) running() bool {
+	return s.status() == "running"
+}
+
+// 停止运行中的服务。Stop可能要等待连接关闭，期间状态为stopping且不持有s.mu，
+// 慢的关闭不会阻塞状态查询；失败时恢复为running
+func (s *Server) stop() error {
+	s.mu.Lock()
+	if s.Status != "running" || s.Stop == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	s.Status = "stopping"
+	s.mu.Unlock()
+
+	err := s.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.Status = "running"
+		return err
+	}
+	s.Status = "stopped"
+	s.Metrics.SetRunning(false)
+	s.Logger.Info("server stopped")
+	s.Logger.Close()
+	return nil
+}
+
+// Labels 实例的名称、分组与标签，用于选择器与服务发现的分组
+type Labels struct {
+	Name  string   `yaml:"name" json:"name"` // 默认为 type:address
+	Group string   `yaml:"group" json:"group,omitempty"`
+	Tags  []string `yaml:"tags" json:"tags,omitempty"`
+}
+
+func (l Labels) clone() Labels {
+	l.Tags = append([]string(nil), l.Tags...)
+	return l
+}
+
+// ConnTable 可以列出与断开连接的服务
+type ConnTable interface {
+	Conns() []tcp_server.ConnInfo
+	KillConn(id uint64) error
+}
+
+// PeerTable 按对端统计收发的无连接服务
+type PeerTable interface {
+	Peers() []udp_server.PeerInfo
 }
 
 type ServerManager struct {
-	servers map[string]*Server
-	mu      sync.Mutex
+	servers  map[string]*Server
+	mu       sync.Mutex
+	zk       *zookeeper.ZkManager // 期望服务列表，未配置zookeeper时为nil
+	zkCtx    context.Context
+	zkCancel context.CancelFunc
+	desired  map[string]bool // 按zk期望服务列表启动的服务
+
+	registries      map[string]registry.Registry // 已连接的注册中心，按backend
+	registryLoggers []*logger.Logger
+
+	watchers []chan struct{} // 服务启动/停止时通知
 }
 
 func NewServerManager() *ServerManager {
 	return &ServerManager{
-		servers: make(map[string]*Server),
+		servers:    make(map[string]*Server),
+		desired:    make(map[string]bool),
+		registries: make(map[string]registry.Registry),
 	}
 }
 
 // 启动服务器（支持动态添加）
 
 func (m *ServerManager) StartServer(typ, address string) error {
+	_, err := m.StartLabeled(typ, address, nil)
+	return err
+}
+
+// StartLabeled 启动服务器并设置标签，返回实际监听的地址。
+// address可以是固定端口，也可以是 :0（临时端口）或端口范围（2003-2010），此时选择空闲端口。
+// labels为nil时重启沿用之前的标签，新实例使用配置文件 labels 中的标签
+func (m *ServerManager) StartLabeled(typ, address string, labels *Labels) (string, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	address, err := m.startLabeledLocked(typ, address, labels)
+	var server *Server
+	if err == nil {
+		server = m.servers[typ+":"+address]
+	}
+	m.mu.Unlock()
+	// 注册是网络调用，释放m.mu后进行
+	if server != nil {
+		server.register()
+	}
+	return address, err
+}
 
+// 调用方持有m.mu
+func (m *ServerManager) startLabeledLocked(typ, address string, labels *Labels) (string, error) {
+	spec, err := parsePortSpec(address)
+	if err != nil {
+		return "", err
+	}
+	if spec.lo < 0 {
+		if !addressHasHost(typ) {
+			return "", fmt.Errorf("invalid port %q", address)
+		}
+		return address, m.startLocked(typ, address, labels)
+	}
+	// 绑定与命名都使用规范化后的地址，2010 与 127.0.0.1:2010 是同一个http实例
+	spec.normalize(typ)
+	if !spec.dynamic() {
+		address = spec.address(spec.lo)
+		return address, m.startLocked(typ, address, labels)
+	}
+	// 配置文件中按地址写法（如 tcp:0）设置的分组与标签用于每个动态实例，
+	// 名称不沿用，否则多个实例同名，由startLocked设为实际的 type:address
+	if labels == nil {
+		if cfg, ok := mConfig.Labels[typ+":"+address]; ok {
+			cfg = cfg.clone()
+			cfg.Name = ""
+			labels = &cfg
+		}
+	}
+	return m.startDynamic(typ, spec, labels)
+}
+
+// 调用方持有m.mu
+func (m *ServerManager) startLocked(typ, address string, labels *Labels) error {
 	key := fmt.Sprintf("%s:%s", typ, address)
-	if _, exists := m.servers[key]; exists {
-		if m.servers[key].Status == "running" {
+	restart := false
+	var l Labels
+	if old, exists := m.servers[key]; exists {
+		switch old.status() {
+		case "running":
 			return fmt.Errorf("server %s is already running", key)
-		} else {
+		case "stopping":
+			return fmt.Errorf("server %s is stopping", key)
+		default:
 			//否则重启服务：直接删除信息，后后续流程会自动重启服务
+			l = old.Labels
 			delete(m.servers, key)
+			restart = true
 		}
 
+	} else {
+		l = mConfig.Labels[key]
+	}
+	if labels != nil {
+		l = *labels
+	}
+	l = l.clone()
+	if l.Name == "" {
+		l.Name = key
 	}
 
 	var stopFunc func() error
-	var err error
+	var conns ConnTable
+	var peers PeerTable
+	lg, err := logger.New(mConfig.Log.Options(), key, typ, address)
+	if err != nil {
+		return fmt.Errorf("failed to init logger for %s: %w", key, err)
+	}
+	mt := metrics.NewInstance(key, typ, address)
+	tr := tracing.NewInstance(key, typ, address)
 
 	switch typ {
 	case "grpc":
+		port, _ := strconv.Atoi(address)
+		var s *grpc_server.GrpcServer
+		s, err = grpc_server.Run_grpc_server(&port, &mConfig.GRPC.StreamingCount, mConfig.GRPC.InstanceOptions(port), lg, mt, tr)
+		if err == nil {
+			stopFunc = s.Close
+		}
+	case "grpc-mock":
 		port, _ := strconv.Atoi(address)
 		var s *grpc.Server
-		s, err = grpc_server.Run_grpc_server(&port, &mConfig.GRPC.StreamingCount, &mConfig.Log.LogPath)
+		s, err = grpc_server.Run_grpc_mock_server(&port, &mConfig.GRPCMock.MockOptions, &mConfig.GRPCMock.Options, lg, mt, tr)
 		if err == nil {
 			stopFunc = func() error {
 				s.GracefulStop()
@@ -65,63 +237,215 @@ func (m *ServerManager) StartServer(typ, address string) error {
 		}
 	case "http":
 		var s *http_server.RealServer
-		s, err = http_server.Run_http_server(&address, &mConfig.Log.LogPath)
+		s, err = http_server.Run_http_server(&address, mConfig.HTTP.InstanceOptions(address), lg, mt, tr)
 		if err == nil {
 			stopFunc = s.Stop
 		}
+	case "http-proxy":
+		opts := mConfig.HTTPProxy.InstanceOptions(address)
+		if err = m.checkDiscovery(opts.DiscoveryBackend, opts.Discovery); err != nil {
+			break
+		}
+		var s *http_server.ProxyServer
+		s, err = http_server.Run_http_proxy(address, opts, m.ResolveUpstream, lg, mt, tr)
+		if err == nil {
+			stopFunc = m.followUpstreams(opts.DiscoveryBackend, opts.Discovery, s, lg, s.Stop)
+		}
 	case "tcp":
 		port, _ := strconv.Atoi(address)
 		var tcpServer *tcp_server.TcpServer
-		tcpServer, err = tcp_server.Run_tcp_server(port, &mConfig.Log.LogPath)
+		tcpServer, err = tcp_server.Run_tcp_server(port, mConfig.TCP.InstanceOptions(port), lg, mt, tr)
 		if err == nil {
 			stopFunc = func() error {
 				return tcpServer.Close()
 			}
+			conns = tcpServer
+		}
+	case "redis":
+		port, _ := strconv.Atoi(address)
+		var redisServer *tcp_server.TcpServer
+		redisServer, err = tcp_server.Run_redis_server(port, key, mConfig.Redis.InstanceOptions(port), lg, mt, tr)
+		if err == nil {
+			stopFunc = redisServer.Close
+			conns = redisServer
+		}
+	case "tcp-proxy":
+		port, _ := strconv.Atoi(address)
+		opts := mConfig.TCPProxy.InstanceOptions(port)
+		if err = m.checkDiscovery(opts.DiscoveryBackend, opts.Discovery); err != nil {
+			break
+		}
+		var proxyServer *tcp_server.TcpProxy
+		proxyServer, err = tcp_server.Run_tcp_proxy(port, opts, m.ResolveUpstream, lg, mt, tr)
+		if err == nil {
+			stopFunc = m.followUpstreams(opts.DiscoveryBackend, opts.Discovery, proxyServer, lg, proxyServer.Close)
+			conns = proxyServer
+		}
+	case "udp":
+		port, _ := strconv.Atoi(address)
+		var udpServer *udp_server.UdpServer
+		udpServer, err = udp_server.Run_udp_server(port, mConfig.UDP.InstanceOptions(port), lg, mt)
+		if err == nil {
+			stopFunc = udpServer.Close
+			peers = udpServer
 		}
 	default:
-		return fmt.Errorf("unsupported server type: %s", typ)
+		err = fmt.Errorf("unsupported server type: %s", typ)
 	}
 
 	if err != nil {
+		lg.Close()
 		return fmt.Errorf("failed to start %s server: %w", typ, err)
 	}
 
 	server := &Server{
-		Type:    typ,
-		Address: address,
-		Status:  "running",
-		Stop:    stopFunc,
+		Type:      typ,
+		Address:   address,
+		Labels:    l,
+		Status:    "running",
+		StartedAt: time.Now(),
+		Stop:      stopFunc,
+		Logger:    lg,
+		Metrics:   mt,
+		Conns:     conns,
+		Peers:     peers,
 	}
+	server.registrations = m.registrationsLocked(key, address, lg)
 	m.servers[key] = server
+	mt.SetRunning(true)
+	if restart {
+		mt.IncRestarts()
+	}
+	m.notifyLocked()
 	return nil
 }
 
 // 停止服务器
 
+// 停止期间（优雅关闭可能较慢）不持有m.mu，多个实例可以并发停止
 func (m *ServerManager) StopServer(typ, address string) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	key := fmt.Sprintf("%s:%s", typ, address)
+	key := fmt.Sprintf("%s:%s", typ, normalizeAddress(typ, address))
 	server, exists := m.servers[key]
+	m.mu.Unlock()
 	if !exists {
 		return fmt.Errorf("server %s not found", key)
 	}
-
-	if server.Status != "running" {
+	if !server.running() {
 		return nil
 	}
+	// 先从注册中心注销，上游摘除后再关闭
+	server.deregister()
 
-	server.mu.Lock()
-	defer server.mu.Unlock()
-
-	if err := server.Stop(); err != nil {
+	if err := server.stop(); err != nil {
 		return fmt.Errorf("failed to stop server: %w", err)
 	}
-	server.Status = "stopped"
+
+	m.mu.Lock()
+	m.notifyLocked()
+	m.mu.Unlock()
 	return nil
 }
 
+// Subscribe 返回的通道在服务启动或停止后收到通知，多次变化可能合并为一次
+func (m *ServerManager) Subscribe() <-chan struct{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ch := make(chan struct{}, 1)
+	m.watchers = append(m.watchers, ch)
+	return ch
+}
+
+// 通知订阅者，不阻塞。调用方持有m.mu
+func (m *ServerManager) notifyLocked() {
+	for _, ch := range m.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 按 type:address 或实例名称查找，名称对应多个实例时报错。调用方持有m.mu
+func (m *ServerManager) lookupLocked(name string) (*Server, error) {
+	if server, exists := m.servers[name]; exists {
+		return server, nil
+	}
+	var found *Server
+	for _, s := range m.servers {
+		if s.Name != name {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("name %s matches more than one server, use type:address", name)
+		}
+		found = s
+	}
+	if found == nil {
+		return nil, fmt.Errorf("server %s not found", name)
+	}
+	return found, nil
+}
+
+// 获取实例日志，name为 type:address 或实例名称
+func (m *ServerManager) GetLogger(name string) (*logger.Logger, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	server, err := m.lookupLocked(name)
+	if err != nil {
+		return nil, err
+	}
+	return server.Logger, nil
+}
+
+// 获取服务的连接表，服务需在运行中且支持连接管理
+func (m *ServerManager) GetConnTable(name string) (ConnTable, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	server, err := m.lookupLocked(name)
+	if err != nil {
+		return nil, err
+	}
+	if !server.running() {
+		return nil, fmt.Errorf("server %s is not running", name)
+	}
+	if server.Conns == nil {
+		return nil, fmt.Errorf("server %s does not track connections", name)
+	}
+	return server.Conns, nil
+}
+
+// 获取服务的对端统计，服务需在运行中且按对端统计
+func (m *ServerManager) GetPeerTable(name string) (PeerTable, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	server, err := m.lookupLocked(name)
+	if err != nil {
+		return nil, err
+	}
+	if !server.running() {
+		return nil, fmt.Errorf("server %s is not running", name)
+	}
+	if server.Peers == nil {
+		return nil, fmt.Errorf("server %s does not track peers", name)
+	}
+	return server.Peers, nil
+}
+
+// ResolveUpstream 代理上游为受管实例名（type:address）时解析为本机地址，否则原样返回
+func (m *ServerManager) ResolveUpstream(name string) string {
+	m.mu.Lock()
+	s, ok := m.servers[name]
+	m.mu.Unlock()
+	if !ok {
+		return name
+	}
+	return localAddr(s.Address)
+}
+
 // 获取所有服务器状态 ->返回经过排序的拷贝
 func (m *ServerManager) GetServers() []*Server {
 	m.mu.Lock()
@@ -131,9 +455,12 @@ func (m *ServerManager) GetServers() []*Server {
 	for _, s := range m.servers {
 		s.mu.Lock()
 		servers = append(servers, &Server{
-			Type:    s.Type,
-			Address: s.Address,
-			Status:  s.Status,
+			Type:      s.Type,
+			Address:   s.Address,
+			Labels:    s.Labels.clone(),
+			Status:    s.Status,
+			StartedAt: s.StartedAt,
+			Metrics:   s.Metrics,
 		})
 		s.mu.Unlock()
 	}
@@ -164,15 +491,19 @@ func (m *ServerManager) StopAll() {
 	}
 	m.mu.Unlock()
 
+	// 先并发从注册中心注销所有实例，上游摘除后再逐个关闭
+	var wg sync.WaitGroup
 	for _, s := range servers {
-		s.mu.Lock()
-		if s.Status == "running" && s.Stop != nil {
-			if err := s.Stop(); err != nil {
-				log.Printf("server stop err: %s %s, %v", s.Type, s.Address, err)
-			} else {
-				s.Status = "stopped"
-			}
+		wg.Go(s.deregister)
+	}
+	wg.Wait()
+
+	for _, s := range servers {
+		if err := s.stop(); err != nil {
+			log.Printf("server stop err: %s %s, %v", s.Type, s.Address, err)
 		}
-		s.mu.Unlock()
 	}
+	m.mu.Lock()
+	m.notifyLocked()
+	m.mu.Unlock()
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/21Mile/go_downstreamer_server/services/listen"
+)
+
+// 实例地址中的端口部分：固定端口、0（临时端口）或 lo-hi 范围
+type portSpec struct {
+	host     string
+	withHost bool // 原地址带冒号（host:port 或 :port），结果保持同样的形式
+	lo, hi   int  // 不是数字的地址lo为-1
+	isRange  bool
+}
+
+func parsePortSpec(address string) (portSpec, error) {
+	var spec portSpec
+	ports := address
+	if i := strings.LastIndex(address, ":"); i >= 0 {
+		spec.host, ports, spec.withHost = address[:i], address[i+1:], true
+	}
+	lo, hi, isRange := strings.Cut(ports, "-")
+	var err error
+	if spec.lo, err = strconv.Atoi(lo); err != nil || spec.lo < 0 || spec.lo > 65535 {
+		if !isRange {
+			// 不是数字的地址交给各类型自己处理
+			return portSpec{lo: -1}, nil
+		}
+		return spec, fmt.Errorf("invalid port range %q", address)
+	}
+	spec.hi, spec.isRange = spec.lo, isRange
+	if isRange {
+		if spec.hi, err = strconv.Atoi(hi); err != nil || spec.lo == 0 || spec.hi < spec.lo || spec.hi > 65535 {
+			return spec, fmt.Errorf("invalid port range %q", address)
+		}
+	}
+	return spec, nil
+}
+
+// 按类型规范化地址形式：http类的地址带host，不带host时与scale一样使用127.0.0.1；
+// 其它类型的地址只有端口
+func (s *portSpec) normalize(typ string) {
+	if !addressHasHost(typ) {
+		s.host, s.withHost = "", false
+		return
+	}
+	if !s.withHost {
+		s.host, s.withHost = "127.0.0.1", true
+	}
+}
+
+// 固定端口的地址按类型规范化，用于查找已启动的实例；其它地址原样返回
+func normalizeAddress(typ, address string) string {
+	spec, err := parsePortSpec(address)
+	if err != nil || spec.dynamic() || spec.lo < 0 {
+		return address
+	}
+	spec.normalize(typ)
+	return spec.address(spec.lo)
+}
+
+// 是否需要由manager选择端口
+func (s portSpec) dynamic() bool {
+	return s.lo == 0 || s.isRange
+}
+
+func (s portSpec) address(port int) string {
+	if s.withHost {
+		return s.host + ":" + strconv.Itoa(port)
+	}
+	return strconv.Itoa(port)
+}
+
+// 选择空闲端口启动：临时端口由系统分配，范围内按顺序尝试，端口被占用时换下一个。调用方持有m.mu
+func (m *ServerManager) startDynamic(typ string, spec portSpec, labels *Labels) (string, error) {
+	if spec.lo == 0 {
+		// 先绑定系统分配的端口，服务监听时直接使用该监听，期间端口不会被其它进程占用
+		hold := listen.HoldTCP
+		if typ == "udp" {
+			hold = listen.HoldUDP
+		}
+		port, release, err := hold(spec.host)
+		if err != nil {
+			return "", err
+		}
+		defer release()
+		address := spec.address(port)
+		return address, m.startLocked(typ, address, labels)
+	}
+	for port := spec.lo; port <= spec.hi; port++ {
+		address := spec.address(port)
+		// 运行中或正在停止的实例仍占用端口
+		if s, ok := m.servers[typ+":"+address]; ok && s.status() != "stopped" {
+			continue
+		}
+		err := m.startLocked(typ, address, labels)
+		if err == nil || !errors.Is(err, syscall.EADDRINUSE) {
+			return address, err
+		}
+	}
+	return "", fmt.Errorf("no free port in %s", spec.address(spec.lo)+"-"+strconv.Itoa(spec.hi))
+}
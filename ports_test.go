@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParsePortSpec(t *testing.T) {
+	for address, dynamic := range map[string]bool{
+		"3003":            false,
+		"0":               true,
+		":0":              true,
+		"2003-2010":       true,
+		"127.0.0.1:0":     true,
+		"127.0.0.1:2003":  false,
+		"127.0.0.1:20-30": true,
+		"localhost":       false,
+	} {
+		spec, err := parsePortSpec(address)
+		if err != nil {
+			t.Errorf("parsePortSpec(%q): %v", address, err)
+			continue
+		}
+		if spec.dynamic() != dynamic {
+			t.Errorf("parsePortSpec(%q).dynamic() = %v", address, spec.dynamic())
+		}
+	}
+	for _, address := range []string{"10-5", "0-10", "1-70000"} {
+		if _, err := parsePortSpec(address); err == nil {
+			t.Errorf("parsePortSpec(%q) should fail", address)
+		}
+	}
+}
+
+// 动态地址的实例各自以实际地址命名，配置中按地址写法设置的分组对每个实例生效
+func TestDynamicStartNames(t *testing.T) {
+	testConfig(t)
+	mConfig.Labels = map[string]Labels{
+		"tcp:0":            {Name: "shared", Group: "ephemeral"},
+		"udp:0":            {Name: "shared-udp", Group: "ephemeral"},
+		"http:127.0.0.1:0": {Name: "shared-http", Group: "ephemeral"},
+	}
+	m := NewServerManager()
+	defer m.StopAll()
+
+	seen := make(map[string]bool)
+	for _, spec := range []struct{ typ, address string }{
+		{"tcp", "0"}, {"tcp", "0"}, {"udp", "0"}, {"http", "127.0.0.1:0"},
+	} {
+		address, err := m.StartLabeled(spec.typ, spec.address, nil)
+		if err != nil {
+			t.Fatalf("start %s:%s: %v", spec.typ, spec.address, err)
+		}
+		if strings.HasSuffix(address, ":0") || address == "0" {
+			t.Fatalf("start %s:%s returned %q, want the bound address", spec.typ, spec.address, address)
+		}
+		seen[spec.typ+":"+address] = true
+	}
+	if len(seen) != 4 {
+		t.Fatalf("addresses = %v, want 4 distinct", seen)
+	}
+	for _, s := range m.GetServers() {
+		key := s.Type + ":" + s.Address
+		if !seen[key] {
+			t.Errorf("unexpected server %s", key)
+		}
+		if s.Name != key || s.Group != "ephemeral" {
+			t.Errorf("%s labels = %+v, want name %s and group ephemeral", key, s.Labels, key)
+		}
+		if _, err := m.GetLogger(s.Name); err != nil {
+			t.Errorf("lookup %s: %v", s.Name, err)
+		}
+	}
+}
+
+// n个连续的空闲端口中的第一个
+func freeTestRange(t *testing.T, n int) int {
+	t.Helper()
+	for range 20 {
+		base, _ := strconv.Atoi(freeTestPort(t))
+		if base+n > 65535 {
+			continue
+		}
+		free := true
+		for port := base; port < base+n && free; port++ {
+			l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+			if err != nil {
+				free = false
+				continue
+			}
+			l.Close()
+		}
+		if free {
+			return base
+		}
+	}
+	t.Fatalf("no %d consecutive free ports", n)
+	return 0
+}
+
+// 不带host的http地址与带127.0.0.1的写法绑定相同的地址，实例名称也相同
+func TestHostlessHTTPRange(t *testing.T) {
+	m := testManager(t)
+	base := freeTestRange(t, 3)
+	portRange := fmt.Sprintf("%d-%d", base, base+2)
+
+	first, err := m.StartLabeled("http", portRange, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("127.0.0.1:%d", base); first != want {
+		t.Fatalf("host-less range started %q, want %q", first, want)
+	}
+	// 两种写法是同一组实例，已运行的端口被跳过
+	second, err := m.StartLabeled("http", "127.0.0.1:"+portRange, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("127.0.0.1:%d", base+1); second != want {
+		t.Fatalf("range with host started %q, want %q", second, want)
+	}
+	// 固定端口同样规范化
+	third, err := m.StartLabeled("http", strconv.Itoa(base+2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("127.0.0.1:%d", base+2); third != want {
+		t.Fatalf("fixed host-less port started %q, want %q", third, want)
+	}
+	for _, address := range []string{first, second, third} {
+		resp, err := http.Get("http://" + address + "/")
+		if err != nil {
+			t.Fatalf("%s not reachable on 127.0.0.1: %v", address, err)
+		}
+		resp.Body.Close()
+		if _, err := m.GetLogger("http:" + address); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.StartLabeled("http", portRange, nil); err == nil {
+		t.Fatal("started a fourth instance in a three port range")
+	}
+	// 停止时两种写法都能找到实例
+	if err := m.StopServer("http", strconv.Itoa(base)); err != nil || m.isRunning("http:"+first) {
+		t.Fatalf("stop by host-less port: %v", err)
+	}
+	if err := m.StopServer("http", strconv.Itoa(base+9)); err == nil {
+		t.Fatal("stopped an instance that was never started")
+	}
+}
+
+// 范围内的端口被其它进程占用时尝试下一个
+func TestRangeRetriesOnAddrInUse(t *testing.T) {
+	m := testManager(t)
+	base := freeTestRange(t, 3)
+	for _, network := range []string{"tcp", "http"} {
+		t.Run(network, func(t *testing.T) {
+			busy, err := net.Listen("tcp", fmt.Sprintf(":%d", base))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer busy.Close()
+			address, err := m.StartLabeled(network, fmt.Sprintf("%d-%d", base, base+1), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.StopServer(network, address)
+			if !strings.HasSuffix(address, strconv.Itoa(base+1)) {
+				t.Fatalf("started %q, want port %d after %d was in use", address, base+1, base)
+			}
+			// 范围内没有空闲端口
+			if _, err := m.StartLabeled(network, fmt.Sprintf("%d-%d", base, base+1), nil); err == nil || !strings.Contains(err.Error(), "no free port") {
+				t.Fatalf("full range: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/tcp_server"
+)
+
+func TestResolveUpstream(t *testing.T) {
+	m := testManager(t)
+	port := freeTestPort(t)
+	if err := m.StartServer("tcp", port); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"tcp:" + port:   "127.0.0.1:" + port, // 受管实例解析为本机地址
+		"redis:" + port: "redis:" + port,     // 类型不同不是同一实例
+		"tcp:1":         "tcp:1",
+		"10.0.0.1:80":   "10.0.0.1:80",
+	} {
+		if got := m.ResolveUpstream(name); got != want {
+			t.Errorf("ResolveUpstream(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// 上游按连接时解析，代理先于受管实例启动也能转发到它
+func TestTCPProxyToManagedInstance(t *testing.T) {
+	m := testManager(t)
+	upstream, proxy := freeTestPort(t), freeTestPort(t)
+	mConfig.TCPProxy.Options = tcp_server.ProxyOptions{Upstreams: []tcp_server.Upstream{{Addr: "tcp:" + upstream}}}
+	if err := m.StartServer("tcp-proxy", proxy); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StartServer("tcp", upstream); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialTimeout("tcp", "127.0.0.1:"+proxy, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "tcpHandler\n" {
+		t.Fatalf("read %q, %v through the proxy, want the banner of tcp:%s", line, err, upstream)
+	}
+}
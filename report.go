@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+)
+
+// 默认统计最近一分钟
+const defaultReportWindow = time.Minute
+
+// ReportInstance 单个实例在窗口内的请求分布
+type ReportInstance struct {
+	Name          string  `json:"name"`
+	Type          string  `json:"type"`
+	Address       string  `json:"address"`
+	Status        string  `json:"status"`
+	Weight        float64 `json:"weight"`
+	Requests      uint64  `json:"requests"`
+	Errors        uint64  `json:"errors"`
+	Share         float64 `json:"share"`          // 实际占比
+	ExpectedShare float64 `json:"expected_share"` // 按权重的期望占比
+	Deviation     float64 `json:"deviation"`      // 实际占比-期望占比
+	RelDeviation  float64 `json:"rel_deviation"`  // 相对期望占比的偏差
+}
+
+// Report 负载均衡分布报表
+type Report struct {
+	Selector     map[string]string `json:"selector"`
+	Window       string            `json:"window"`
+	GeneratedAt  time.Time         `json:"generated_at"`
+	Total        uint64            `json:"total"`
+	Instances    []ReportInstance  `json:"instances"`
+	ChiSquare    float64           `json:"chi_square"`
+	DOF          int               `json:"dof"`
+	PValue       float64           `json:"p_value"`       // 分布与期望权重一致的概率，越小偏差越显著
+	MaxDeviation float64           `json:"max_deviation"` // 绝对偏差最大值
+}
+
+// 选择器可用的字段，tag匹配实例的任意一个标签
+var selectorKeys = []string{"type", "address", "status", "name", "group", "tag"}
+
+// 解析 key=value 选择器
+func parseSelector(args []string) (map[string]string, error) {
+	selector := map[string]string{}
+	for _, arg := range args {
+		k, v, ok := strings.Cut(arg, "=")
+		if !ok || v == "" {
+			return nil, fmt.Errorf("selector must be key=value, got %q", arg)
+		}
+		if !slices.Contains(selectorKeys, k) {
+			return nil, fmt.Errorf("unknown selector %q, available: %s", k, strings.Join(selectorKeys, ", "))
+		}
+		selector[k] = v
+	}
+	return selector, nil
+}
+
+// 实例是否匹配选择器
+func (s *Server) matches(selector map[string]string) bool {
+	for k, v := range selector {
+		var actual string
+		switch k {
+		case "type":
+			actual = s.Type
+		case "address":
+			actual = s.Address
+		case "status":
+			actual = s.Status
+		case "name":
+			actual = s.Name
+		case "group":
+			actual = s.Group
+		case "tag":
+			if slices.Contains(s.Tags, v) {
+				continue
+			}
+		}
+		if actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 解析 a=1,b=2 形式的权重
+func parseWeights(s string) (map[string]float64, error) {
+	weights := map[string]float64{}
+	for _, item := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("weight must be name=value, got %q", item)
+		}
+		w, err := strconv.ParseFloat(v, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight %q", item)
+		}
+		weights[k] = w
+	}
+	return weights, nil
+}
+
+// 实例的期望权重：先按 type:address 查找，再按实例名称，都没有配置时为1。
+// 地址为:0的实例启动后才确定端口，只能按名称配置权重
+func instanceWeight(weights map[string]float64, s *Server) float64 {
+	if w, ok := weights[s.Type+":"+s.Address]; ok {
+		return w
+	}
+	if w, ok := weights[s.Name]; ok && s.Name != "" {
+		return w
+	}
+	return 1
+}
+
+// BuildReport 统计选中实例在窗口内的请求分布，并与期望权重比较
+func (m *ServerManager) BuildReport(selector map[string]string, window time.Duration, weights map[string]float64) (*Report, error) {
+	if window <= 0 {
+		window = defaultReportWindow
+	}
+	report := &Report{
+		Selector:    selector,
+		Window:      window.String(),
+		GeneratedAt: time.Now(),
+	}
+	var totalWeight float64
+	for _, s := range m.GetServers() {
+		if !s.matches(selector) {
+			continue
+		}
+		name := fmt.Sprintf("%s:%s", s.Type, s.Address)
+		weight := instanceWeight(weights, s)
+		requests, errors := s.Metrics.Window(window)
+		report.Instances = append(report.Instances, ReportInstance{
+			Name:     name,
+			Type:     s.Type,
+			Address:  s.Address,
+			Status:   s.Status,
+			Weight:   weight,
+			Requests: requests,
+			Errors:   errors,
+		})
+		report.Total += requests
+		totalWeight += weight
+	}
+	if len(report.Instances) == 0 {
+		return nil, fmt.Errorf("no server matches %v", selector)
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("total weight is 0")
+	}
+
+	k := 0
+	for i := range report.Instances {
+		inst := &report.Instances[i]
+		inst.ExpectedShare = inst.Weight / totalWeight
+		if report.Total > 0 {
+			inst.Share = float64(inst.Requests) / float64(report.Total)
+		}
+		inst.Deviation = inst.Share - inst.ExpectedShare
+		if inst.ExpectedShare > 0 {
+			inst.RelDeviation = inst.Deviation / inst.ExpectedShare
+			expected := inst.ExpectedShare * float64(report.Total)
+			if expected > 0 {
+				diff := float64(inst.Requests) - expected
+				report.ChiSquare += diff * diff / expected
+			}
+			k++
+		}
+		report.MaxDeviation = math.Max(report.MaxDeviation, math.Abs(inst.Deviation))
+	}
+	report.DOF = k - 1
+	report.PValue = 1
+	if report.DOF > 0 && report.Total > 0 {
+		report.PValue = chiSquarePValue(report.ChiSquare, report.DOF)
+	}
+	sort.Slice(report.Instances, func(i, j int) bool { return report.Instances[i].Name < report.Instances[j].Name })
+	return report, nil
+}
+
+// 解析 report 命令参数：report type=http [--window 1m] [--weights a=1,b=2] [--json file]
+func parseReportArgs(args []string) (selector map[string]string, window time.Duration, weights map[string]float64, jsonPath string, err error) {
+	window = mConfig.Report.Window
+	weights = mConfig.Report.Weights
+	var selectors []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--window", "-w":
+			if i+1 >= len(args) {
+				return nil, 0, nil, "", fmt.Errorf("--window needs a duration")
+			}
+			i++
+			if window, err = time.ParseDuration(args[i]); err != nil {
+				return nil, 0, nil, "", fmt.Errorf("invalid window: %w", err)
+			}
+		case "--weights":
+			if i+1 >= len(args) {
+				return nil, 0, nil, "", fmt.Errorf("--weights needs name=weight pairs")
+			}
+			i++
+			if weights, err = parseWeights(args[i]); err != nil {
+				return nil, 0, nil, "", err
+			}
+		case "--json":
+			if i+1 >= len(args) {
+				return nil, 0, nil, "", fmt.Errorf("--json needs a file path")
+			}
+			i++
+			jsonPath = args[i]
+		default:
+			selectors = append(selectors, args[i])
+		}
+	}
+	if len(selectors) == 0 {
+		return nil, 0, nil, "", fmt.Errorf("missing selector")
+	}
+	selector, err = parseSelector(selectors)
+	return selector, window, weights, jsonPath, err
+}
+
+// 控制台显示报表：与日志查看一样暂停状态表刷新，回车返回
+func showReport(args []string, manager *ServerManager) error {
+	selector, window, weights, jsonPath, err := parseReportArgs(args)
+	if err != nil {
+		return err
+	}
+	report, err := manager.BuildReport(selector, window, weights)
+	if err != nil {
+		return err
+	}
+	if jsonPath != "" {
+		if err := report.WriteJSON(jsonPath); err != nil {
+			return fmt.Errorf("write report: %w", err)
+		}
+	}
+
+	logViewing.Store(true)
+	printMu.Lock()
+	w := rl.Stdout()
+	readline.ClearScreen(w)
+	report.Print(w)
+	if jsonPath != "" {
+		fmt.Fprintf(w, "saved to %s\n", jsonPath)
+	}
+	fmt.Fprintln(w, "(press Enter to return)")
+	printMu.Unlock()
+	rl.Refresh()
+	return nil
+}
+
+// 管理接口 /report?type=http&window=1m&weights=name=1,name=2，返回JSON
+func reportHandler(manager *ServerManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		var selectors []string
+		for _, k := range selectorKeys {
+			if v := query.Get(k); v != "" {
+				selectors = append(selectors, k+"="+v)
+			}
+		}
+		selector, err := parseSelector(selectors)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		window := mConfig.Report.Window
+		if v := query.Get("window"); v != "" {
+			if window, err = time.ParseDuration(v); err != nil {
+				http.Error(w, "invalid window: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		weights := mConfig.Report.Weights
+		if v := query.Get("weights"); v != "" {
+			if weights, err = parseWeights(v); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		report, err := manager.BuildReport(selector, window, weights)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	}
+}
+
+// 输出为表格
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "Distribution report %v over last %s, total %d requests\n", r.Selector, r.Window, r.Total)
+	fmt.Fprintf(w, "%-28s %-8s %8s %10s %8s %9s %9s %9s\n", "Name", "Status", "Weight", "Requests", "Errors", "Share", "Expected", "Dev")
+	for _, inst := range r.Instances {
+		fmt.Fprintf(w, "%-28s %-8s %8.2f %10d %8d %8.2f%% %8.2f%% %+8.2f%%\n",
+			inst.Name, inst.Status, inst.Weight, inst.Requests, inst.Errors,
+			inst.Share*100, inst.ExpectedShare*100, inst.Deviation*100)
+	}
+	fmt.Fprintf(w, "chi-square %.3f, dof %d, p-value %.4f, max deviation %.2f%%\n", r.ChiSquare, r.DOF, r.PValue, r.MaxDeviation*100)
+}
+
+// 导出为JSON文件
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// 卡方分布的上尾概率 Q(dof/2, x/2)
+func chiSquarePValue(x float64, dof int) float64 {
+	if x <= 0 {
+		return 1
+	}
+	return regularizedGammaQ(float64(dof)/2, x/2)
+}
+
+// 正则化上不完全伽马函数，x < a+1 时用级数展开，否则用连分式
+func regularizedGammaQ(a, x float64) float64 {
+	const (
+		maxIter = 200
+		eps     = 1e-12
+	)
+	lgamma, _ := math.Lgamma(a)
+	if x < a+1 {
+		sum, term := 1/a, 1/a
+		for n := 1; n < maxIter; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*eps {
+				break
+			}
+		}
+		return 1 - sum*math.Exp(-x+a*math.Log(x)-lgamma)
+	}
+	// Lentz算法
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for n := 1; n < maxIter; n++ {
+		an := -float64(n) * (float64(n) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < eps {
+			break
+		}
+	}
+	return math.Exp(-x+a*math.Log(x)-lgamma) * h
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseSelector(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want map[string]string
+		ok   bool
+	}{
+		{[]string{"type=http"}, map[string]string{"type": "http"}, true},
+		{[]string{"group=api", "tag=blue", "status=running"}, map[string]string{"group": "api", "tag": "blue", "status": "running"}, true},
+		{[]string{"name=web=1"}, map[string]string{"name": "web=1"}, true}, // 只按第一个=拆分
+		{nil, map[string]string{}, true},
+		{[]string{"type"}, nil, false},
+		{[]string{"type="}, nil, false},
+		{[]string{"port=80"}, nil, false},
+	} {
+		got, err := parseSelector(tc.args)
+		if (err == nil) != tc.ok {
+			t.Errorf("parseSelector(%q) error = %v", tc.args, err)
+			continue
+		}
+		if tc.ok && !mapsEqual(got, tc.want) {
+			t.Errorf("parseSelector(%q) = %v, want %v", tc.args, got, tc.want)
+		}
+	}
+}
+
+func mapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestServerMatches(t *testing.T) {
+	s := &Server{Type: "http", Address: "127.0.0.1:2003", Status: "running",
+		Labels: Labels{Name: "api-1", Group: "api", Tags: []string{"blue", "canary"}}}
+	for _, tc := range []struct {
+		selector map[string]string
+		want     bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"type": "http"}, true},
+		{map[string]string{"type": "tcp"}, false},
+		{map[string]string{"address": "127.0.0.1:2003", "status": "running"}, true},
+		{map[string]string{"status": "stopped"}, false},
+		{map[string]string{"name": "api-1"}, true},
+		{map[string]string{"name": "http:127.0.0.1:2003"}, false},
+		{map[string]string{"group": "api"}, true},
+		{map[string]string{"group": "web"}, false},
+		{map[string]string{"tag": "canary"}, true},
+		{map[string]string{"tag": "green"}, false},
+		{map[string]string{"tag": "blue", "group": "api", "type": "http"}, true},
+		{map[string]string{"tag": "blue", "group": "web"}, false},
+	} {
+		if got := s.matches(tc.selector); got != tc.want {
+			t.Errorf("matches(%v) = %v, want %v", tc.selector, got, tc.want)
+		}
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	got, err := parseWeights("http:127.0.0.1:2003=2,api-1=0.5,tcp:0=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got["http:127.0.0.1:2003"] != 2 || got["api-1"] != 0.5 || got["tcp:0"] != 0 {
+		t.Fatalf("weights = %v", got)
+	}
+	for _, s := range []string{"a", "a=x", "a=-1", "a=1,b"} {
+		if _, err := parseWeights(s); err == nil {
+			t.Errorf("parseWeights(%q) accepted", s)
+		}
+	}
+}
+
+func TestInstanceWeight(t *testing.T) {
+	weights := map[string]float64{"tcp:3003": 3, "api-1": 2, "http:127.0.0.1:2003": 4}
+	for _, tc := range []struct {
+		server *Server
+		want   float64
+	}{
+		{&Server{Type: "tcp", Address: "3003"}, 3},
+		// :0启动的实例按名称
+		{&Server{Type: "tcp", Address: "41234", Labels: Labels{Name: "api-1"}}, 2},
+		// 两种key都有时以 type:address 为准
+		{&Server{Type: "http", Address: "127.0.0.1:2003", Labels: Labels{Name: "api-1"}}, 4},
+		{&Server{Type: "udp", Address: "3003"}, 1},
+		{&Server{Type: "tcp", Address: "3004"}, 1},
+	} {
+		if got := instanceWeight(weights, tc.server); got != tc.want {
+			t.Errorf("weight of %s:%s (%q) = %v, want %v", tc.server.Type, tc.server.Address, tc.server.Name, got, tc.want)
+		}
+	}
+}
+
+func TestChiSquarePValue(t *testing.T) {
+	// 卡方分布表中的临界值
+	for _, tc := range []struct {
+		x    float64
+		dof  int
+		want float64
+	}{
+		{0, 1, 1},
+		{-1, 3, 1},
+		{3.841, 1, 0.05},
+		{6.635, 1, 0.01},
+		{10.828, 1, 0.001},
+		{5.991, 2, 0.05},
+		{0.352, 3, 0.95},
+		{7.815, 3, 0.05},
+		{18.307, 10, 0.05},
+		{2.558, 10, 0.99},
+		{43.773, 30, 0.05},
+	} {
+		got := chiSquarePValue(tc.x, tc.dof)
+		if math.Abs(got-tc.want) > tc.want*0.01+1e-6 {
+			t.Errorf("chiSquarePValue(%v, %d) = %.6f, want %.6f", tc.x, tc.dof, got, tc.want)
+		}
+	}
+}
+
+// 向实例的统计中记录请求，与实际收到请求时一致
+func observe(t *testing.T, m *ServerManager, key string, ok, failed int) {
+	t.Helper()
+	m.mu.Lock()
+	s := m.servers[key]
+	m.mu.Unlock()
+	if s == nil {
+		t.Fatalf("%s not started", key)
+	}
+	for range ok {
+		s.Metrics.ObserveRequest("ok", time.Millisecond)
+	}
+	for range failed {
+		s.Metrics.ObserveRequest("error", time.Millisecond)
+	}
+}
+
+func TestBuildReport(t *testing.T) {
+	m := testManager(t)
+	a, b := freeTestPort(t), freeTestPort(t)
+	if err := m.StartServer("tcp", a); err != nil {
+		t.Fatal(err)
+	}
+	dynamic, err := m.StartLabeled("tcp", "0", &Labels{Name: "tcp-dynamic"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.StartServer("udp", b); err != nil {
+		t.Fatal(err)
+	}
+	observe(t, m, "tcp:"+a, 60, 0)
+	observe(t, m, "tcp:"+dynamic, 25, 5)
+	observe(t, m, "udp:"+b, 100, 0)
+	// 统计完整的秒，等到当前秒结束
+	time.Sleep(time.Until(time.Now().Truncate(time.Second).Add(time.Second + 10*time.Millisecond)))
+
+	weights := map[string]float64{"tcp:" + a: 2, "tcp-dynamic": 1}
+	report, err := m.BuildReport(map[string]string{"type": "tcp"}, time.Minute, weights)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total != 90 || len(report.Instances) != 2 || report.DOF != 1 {
+		t.Fatalf("report total %d, %d instances, dof %d", report.Total, len(report.Instances), report.DOF)
+	}
+	byName := map[string]ReportInstance{}
+	for _, inst := range report.Instances {
+		byName[inst.Name] = inst
+	}
+	fixed, dyn := byName["tcp:"+a], byName["tcp:"+dynamic]
+	if fixed.Weight != 2 || dyn.Weight != 1 {
+		t.Fatalf("weights = %v, %v; the dynamic instance should be weighted by name", fixed.Weight, dyn.Weight)
+	}
+	if fixed.Requests != 60 || dyn.Requests != 30 || dyn.Errors != 5 {
+		t.Fatalf("requests = %d, %d (errors %d)", fixed.Requests, dyn.Requests, dyn.Errors)
+	}
+	// 实际分布与权重2:1一致
+	if math.Abs(fixed.Share-2.0/3) > 1e-9 || math.Abs(fixed.ExpectedShare-2.0/3) > 1e-9 || report.ChiSquare > 1e-9 || report.PValue != 1 {
+		t.Fatalf("report = %+v", report)
+	}
+
+	// 相同权重时偏离：60:30的期望为45:45
+	report, err = m.BuildReport(map[string]string{"type": "tcp"}, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(report.ChiSquare-10) > 1e-9 || math.Abs(report.MaxDeviation-(2.0/3-0.5)) > 1e-9 {
+		t.Fatalf("chi-square %v, max deviation %v", report.ChiSquare, report.MaxDeviation)
+	}
+	if report.PValue > 0.01 {
+		t.Fatalf("p-value %v for a 2:1 split with equal weights", report.PValue)
+	}
+
+	if _, err := m.BuildReport(map[string]string{"type": "redis"}, time.Minute, nil); err == nil {
+		t.Fatal("report without matching instances")
+	}
+	if _, err := m.BuildReport(map[string]string{"type": "tcp"}, time.Minute, map[string]float64{"tcp:" + a: 0, "tcp-dynamic": 0}); err == nil {
+		t.Fatal("report with total weight 0")
+	}
+}
+
+func TestReportHandler(t *testing.T) {
+	m := testManager(t)
+	port := freeTestPort(t)
+	if err := m.StartServer("tcp", port); err != nil {
+		t.Fatal(err)
+	}
+	h := reportHandler(m)
+	for _, tc := range []struct {
+		query  string
+		status int
+	}{
+		{"type=tcp", http.StatusOK},
+		{"type=tcp&window=10s&weights=tcp:" + port + "=2", http.StatusOK},
+		{"type=tcp&window=x", http.StatusBadRequest},
+		{"type=tcp&weights=a", http.StatusBadRequest},
+		{"type=redis", http.StatusNotFound},
+	} {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/report?"+tc.query, nil))
+		if rec.Code != tc.status {
+			t.Errorf("/report?%s = %d, want %d", tc.query, rec.Code, tc.status)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		var report Report
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || len(report.Instances) != 1 {
+			t.Errorf("/report?%s body = %s", tc.query, rec.Body)
+		}
+	}
+}
+
+func TestParseReportArgs(t *testing.T) {
+	testConfig(t)
+	mConfig.Report.Window = 30 * time.Second
+	mConfig.Report.Weights = map[string]float64{"a": 1}
+	selector, window, weights, jsonPath, err := parseReportArgs([]string{"type=http", "--json", "out.json"})
+	if err != nil || selector["type"] != "http" || window != 30*time.Second || weights["a"] != 1 || jsonPath != "out.json" {
+		t.Fatalf("defaults: %v %v %v %q %v", selector, window, weights, jsonPath, err)
+	}
+	_, window, weights, _, err = parseReportArgs([]string{"-w", "5m", "--weights", "b=2", "group=api"})
+	if err != nil || window != 5*time.Minute || len(weights) != 1 || weights["b"] != 2 {
+		t.Fatalf("flags: %v %v %v", window, weights, err)
+	}
+	for _, args := range [][]string{{}, {"--window"}, {"type=http", "--window", "x"}, {"type=http", "--weights"}, {"type=http", "--json"}, {"bogus"}} {
+		if _, _, _, _, err := parseReportArgs(args); err == nil {
+			t.Errorf("parseReportArgs(%q) accepted", args)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package file_sd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 导出格式
+const (
+	FormatPrometheus = "prometheus" // Prometheus file_sd：<dir>/prometheus.json
+	FormatEnvoy      = "envoy"      // Envoy文件方式EDS：每组一个 <dir>/envoy/<group>.json
+	FormatNginx      = "nginx"      // nginx upstream片段：<dir>/nginx/upstreams.conf，可在http或stream中include
+	FormatSRV        = "srv"        // DNS SRV记录（zone文件片段）：<dir>/srv/records.zone，可被bind/CoreDNS file插件加载
+)
+
+var allFormats = []string{FormatPrometheus, FormatEnvoy, FormatNginx, FormatSRV}
+
+// 实例的传输协议
+const (
+	ProtocolTCP = "tcp"
+	ProtocolUDP = "udp"
+)
+
+const (
+	DefaultSRVDomain = "downstream.local" // 未配置域名时SRV记录使用的域名
+	srvTTL           = 30
+	srvPriority      = 10
+)
+
+// Endpoint 一个运行中的实例
+type Endpoint struct {
+	Name     string // 实例名 type:address
+	Label    string // 实例的名称，默认与Name相同
+	Type     string
+	Tags     []string
+	Host     string
+	Port     int
+	Protocol string // tcp/udp，为空时为tcp
+	Weight   int    // 不小于1
+}
+
+func (e Endpoint) udp() bool {
+	return e.Protocol == ProtocolUDP
+}
+
+// Group 同一分组的实例，对应Prometheus的group标签、Envoy的cluster和nginx的upstream。
+// Endpoints为空表示该组当前没有运行中的实例
+type Group struct {
+	Name      string
+	Endpoints []Endpoint
+}
+
+// CheckFormats 检查导出格式，为空时返回全部格式
+func CheckFormats(formats []string) ([]string, error) {
+	if len(formats) == 0 {
+		return allFormats, nil
+	}
+	for _, f := range formats {
+		switch f {
+		case FormatPrometheus, FormatEnvoy, FormatNginx, FormatSRV:
+		default:
+			return nil, fmt.Errorf("unknown export format %q, available: %s", f, strings.Join(allFormats, ", "))
+		}
+	}
+	return formats, nil
+}
+
+// Writer 把实例列表写为文件方式服务发现的配置，内容不变时不重写
+type Writer struct {
+	dir       string
+	formats   []string
+	srvDomain string
+	written   map[string][]byte // 路径 -> 上次写入的内容
+}
+
+// srvDomain为SRV记录所在的域名，为空时为DefaultSRVDomain
+func NewWriter(dir string, formats []string, srvDomain string) (*Writer, error) {
+	formats, err := CheckFormats(formats)
+	if err != nil {
+		return nil, err
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("export dir is empty")
+	}
+	srvDomain = strings.Trim(srvDomain, ".")
+	if srvDomain == "" {
+		srvDomain = DefaultSRVDomain
+	}
+	return &Writer{dir: dir, formats: formats, srvDomain: srvDomain, written: make(map[string][]byte)}, nil
+}
+
+// Write 按配置的格式写出所有分组，分组按名称排序
+func (w *Writer) Write(groups []Group) error {
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	for _, f := range w.formats {
+		var err error
+		switch f {
+		case FormatPrometheus:
+			err = w.writeFile(filepath.Join(w.dir, "prometheus.json"), renderPrometheus(groups))
+		case FormatEnvoy:
+			err = w.writeEnvoy(groups)
+		case FormatNginx:
+			err = w.writeFile(filepath.Join(w.dir, "nginx", "upstreams.conf"), renderNginx(groups))
+		case FormatSRV:
+			err = w.writeFile(filepath.Join(w.dir, "srv", "records.zone"), renderSRV(groups, w.srvDomain))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Envoy的每个cluster跟随一个文件 envoy/<分组>.json，文件名中字母数字、下划线与连字符以外的字符替换为下划线。
+// 组内没有实例时写空列表而不是删除文件；分组消失（包括上次运行留下的）时删除其文件
+func (w *Writer) writeEnvoy(groups []Group) error {
+	dir := filepath.Join(w.dir, "envoy")
+	current := make(map[string]string) // 路径 -> 分组名
+	for _, g := range groups {
+		path := filepath.Join(dir, nginxName(g.Name)+".json")
+		if other, ok := current[path]; ok {
+			return fmt.Errorf("groups %q and %q map to the same envoy file %s", other, g.Name, path)
+		}
+		current[path] = g.Name
+		if err := w.writeFile(path, renderEnvoy(g)); err != nil {
+			return err
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		if _, ok := current[path]; ok {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(w.written, path)
+	}
+	return nil
+}
+
+// 先写同目录下的临时文件再rename，读取方不会读到写了一半的内容
+func (w *Writer) writeFile(path string, data []byte) error {
+	if old, ok := w.written[path]; ok && bytes.Equal(old, data) {
+		return nil
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	w.written[path] = data
+	return nil
+}
+
+type prometheusGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// 每个实例一个target组，标签中带上分组与实例名
+func renderPrometheus(groups []Group) []byte {
+	out := []prometheusGroup{}
+	for _, g := range groups {
+		for _, e := range g.Endpoints {
+			out = append(out, prometheusGroup{
+				Targets: []string{fmt.Sprintf("%s:%d", e.Host, e.Port)},
+				Labels:  prometheusLabels(g.Name, e),
+			})
+		}
+	}
+	data, _ := json.MarshalIndent(out, "", "  ")
+	return append(data, '\n')
+}
+
+// 标签中的每个tag为 tag_<名称>="true"，便于relabel按标签过滤
+func prometheusLabels(group string, e Endpoint) map[string]string {
+	labels := map[string]string{
+		"group":    group,
+		"instance": e.Name,
+		"name":     e.Label,
+		"type":     e.Type,
+		"protocol": ProtocolTCP,
+	}
+	if e.udp() {
+		labels["protocol"] = ProtocolUDP
+	}
+	for _, tag := range e.Tags {
+		labels["tag_"+labelName(tag)] = "true"
+	}
+	return labels
+}
+
+// Envoy path_config_source 读取的是 DiscoveryResponse，资源为 ClusterLoadAssignment
+func renderEnvoy(g Group) []byte {
+	lbEndpoints := []any{}
+	for _, e := range g.Endpoints {
+		socketAddress := map[string]any{"address": e.Host, "port_value": e.Port}
+		if e.udp() {
+			socketAddress["protocol"] = "UDP"
+		}
+		lbEndpoints = append(lbEndpoints, map[string]any{
+			"endpoint": map[string]any{
+				"address": map[string]any{
+					"socket_address": socketAddress,
+				},
+				"hostname": e.Name,
+			},
+			"health_status":         "HEALTHY",
+			"load_balancing_weight": e.Weight,
+		})
+	}
+	resp := map[string]any{
+		"resources": []any{
+			map[string]any{
+				"@type":        "type.googleapis.com/envoy.config.endpoint.v3.ClusterLoadAssignment",
+				"cluster_name": g.Name,
+				"endpoints":    []any{map[string]any{"lb_endpoints": lbEndpoints}},
+			},
+		},
+	}
+	data, _ := json.MarshalIndent(resp, "", "  ")
+	return append(data, '\n')
+}
+
+// nginx不允许空的upstream，没有实例的分组不输出。
+// udp实例单独输出为 <分组>_udp，供stream中 listen ... udp 的server使用
+func renderNginx(groups []Group) []byte {
+	var b bytes.Buffer
+	b.WriteString("# generated by go_downstreamer_server, do not edit\n")
+	for _, g := range groups {
+		var tcp, udp []Endpoint
+		for _, e := range g.Endpoints {
+			if e.udp() {
+				udp = append(udp, e)
+			} else {
+				tcp = append(tcp, e)
+			}
+		}
+		writeUpstream(&b, nginxName(g.Name), tcp)
+		writeUpstream(&b, nginxName(g.Name)+"_udp", udp)
+	}
+	return b.Bytes()
+}
+
+func writeUpstream(b *bytes.Buffer, name string, endpoints []Endpoint) {
+	if len(endpoints) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\nupstream %s {\n", name)
+	for _, e := range endpoints {
+		fmt.Fprintf(b, "    server %s:%d weight=%d; # %s\n", hostPort(e), e.Port, e.Weight, e.Name)
+	}
+	b.WriteString("}\n")
+}
+
+// IPv6地址需要加方括号
+func hostPort(e Endpoint) string {
+	if strings.Contains(e.Host, ":") {
+		return "[" + e.Host + "]"
+	}
+	return e.Host
+}
+
+// 每个分组为 _<分组>._tcp.<域名>（udp实例为_udp）的SRV记录，目标为实例的主机记录 <实例名>.<域名>。
+// 实例的Host为IP时同时输出A/AAAA记录，为主机名时SRV直接指向该主机名
+func renderSRV(groups []Group, domain string) []byte {
+	var b bytes.Buffer
+	b.WriteString("; generated by go_downstreamer_server, do not edit\n")
+	var hosts []string
+	for _, g := range groups {
+		for _, e := range g.Endpoints {
+			proto := ProtocolTCP
+			if e.udp() {
+				proto = ProtocolUDP
+			}
+			target := strings.TrimSuffix(e.Host, ".") + "."
+			if ip := net.ParseIP(e.Host); ip != nil {
+				target = dnsLabel(e.Name) + "." + domain + "."
+				rtype := "A"
+				if ip.To4() == nil {
+					rtype = "AAAA"
+				}
+				hosts = append(hosts, fmt.Sprintf("%s %d IN %s %s\n", target, srvTTL, rtype, e.Host))
+			}
+			fmt.Fprintf(&b, "_%s._%s.%s. %d IN SRV %d %d %d %s\n",
+				dnsLabel(g.Name), proto, domain, srvTTL, srvPriority, e.Weight, e.Port, target)
+		}
+	}
+	if len(hosts) > 0 {
+		b.WriteString("\n")
+		for _, h := range hosts {
+			b.WriteString(h)
+		}
+	}
+	return b.Bytes()
+}
+
+// DNS标签只保留小写字母、数字与连字符，其它连续的字符合并为一个连字符，最长63个字符
+func dnsLabel(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	label := b.String()
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	if label == "" {
+		label = "x"
+	}
+	return label
+}
+
+// Prometheus标签名只允许字母数字与下划线
+func labelName(name string) string {
+	return strings.ReplaceAll(nginxName(name), "-", "_")
+}
+
+// upstream名称只保留字母数字、下划线与连字符
+func nginxName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,155 @@
+package file_sd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func envoyFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, "envoy", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		files[i] = filepath.Base(f)
+	}
+	return files
+}
+
+func TestEnvoyFileNames(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, []string{FormatEnvoy}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 分组名不能让文件写到envoy目录之外
+	groups := []Group{{Name: "../x"}, {Name: "a/b"}, {Name: "web"}}
+	if err := w.Write(groups); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(envoyFiles(t, dir), ","); got != "___x.json,a_b.json,web.json" {
+		t.Fatalf("envoy files = %s", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x.json")); !os.IsNotExist(err) {
+		t.Fatal("group name escaped the envoy dir")
+	}
+	if !strings.Contains(readFile(t, filepath.Join(dir, "envoy", "a_b.json")), `"cluster_name": "a/b"`) {
+		t.Fatal("cluster name should keep the group name")
+	}
+
+	if err := w.Write([]Group{{Name: "a_b"}, {Name: "a/b"}}); err == nil {
+		t.Fatal("expected an error for groups sharing a file")
+	}
+}
+
+func TestEnvoyRemovesStaleGroups(t *testing.T) {
+	dir := t.TempDir()
+	// 上次运行留下的分组文件
+	if err := os.MkdirAll(filepath.Join(dir, "envoy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "envoy", "old.json"), []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWriter(dir, []string{FormatEnvoy}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]Group{{Name: "a"}, {Name: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(envoyFiles(t, dir), ","); got != "a.json,b.json" {
+		t.Fatalf("envoy files = %s", got)
+	}
+	if err := w.Write([]Group{{Name: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(envoyFiles(t, dir), ","); got != "b.json" {
+		t.Fatalf("envoy files after group removed = %s", got)
+	}
+	// 分组重新出现时重新写入
+	if err := w.Write([]Group{{Name: "a"}, {Name: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(envoyFiles(t, dir), ","); got != "a.json,b.json" {
+		t.Fatalf("envoy files after group is back = %s", got)
+	}
+}
+
+func TestUDPEndpoints(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, nil, "test.local.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := []Group{{Name: "mixed", Endpoints: []Endpoint{
+		{Name: "tcp:3003", Type: "tcp", Host: "127.0.0.1", Port: 3003, Weight: 1},
+		{Name: "udp:5003", Type: "udp", Host: "127.0.0.1", Port: 5003, Protocol: ProtocolUDP, Weight: 2},
+	}}}
+	if err := w.Write(groups); err != nil {
+		t.Fatal(err)
+	}
+
+	nginx := readFile(t, filepath.Join(dir, "nginx", "upstreams.conf"))
+	for _, want := range []string{
+		"upstream mixed {\n    server 127.0.0.1:3003 weight=1; # tcp:3003\n}",
+		"upstream mixed_udp {\n    server 127.0.0.1:5003 weight=2; # udp:5003\n}",
+	} {
+		if !strings.Contains(nginx, want) {
+			t.Errorf("nginx missing %q:\n%s", want, nginx)
+		}
+	}
+
+	envoy := readFile(t, filepath.Join(dir, "envoy", "mixed.json"))
+	if strings.Count(envoy, `"protocol": "UDP"`) != 1 {
+		t.Errorf("envoy should mark only the udp endpoint:\n%s", envoy)
+	}
+
+	prometheus := readFile(t, filepath.Join(dir, "prometheus.json"))
+	if !strings.Contains(prometheus, `"protocol": "udp"`) || !strings.Contains(prometheus, `"protocol": "tcp"`) {
+		t.Errorf("prometheus labels missing protocol:\n%s", prometheus)
+	}
+
+	srv := readFile(t, filepath.Join(dir, "srv", "records.zone"))
+	for _, want := range []string{
+		"_mixed._tcp.test.local. 30 IN SRV 10 1 3003 tcp-3003.test.local.\n",
+		"_mixed._udp.test.local. 30 IN SRV 10 2 5003 udp-5003.test.local.\n",
+		"tcp-3003.test.local. 30 IN A 127.0.0.1\n",
+		"udp-5003.test.local. 30 IN A 127.0.0.1\n",
+	} {
+		if !strings.Contains(srv, want) {
+			t.Errorf("srv missing %q:\n%s", want, srv)
+		}
+	}
+}
+
+func TestSRVTargets(t *testing.T) {
+	srv := string(renderSRV([]Group{{Name: "Web_API", Endpoints: []Endpoint{
+		{Name: "http:[::1]:8080", Host: "::1", Port: 8080, Weight: 1},
+		{Name: "http:backend:80", Host: "backend.example.com", Port: 80, Weight: 1},
+	}}}, DefaultSRVDomain))
+	for _, want := range []string{
+		"_web-api._tcp.downstream.local. 30 IN SRV 10 1 8080 http-1-8080.downstream.local.\n",
+		"http-1-8080.downstream.local. 30 IN AAAA ::1\n",
+		"_web-api._tcp.downstream.local. 30 IN SRV 10 1 80 backend.example.com.\n",
+	} {
+		if !strings.Contains(srv, want) {
+			t.Errorf("srv missing %q:\n%s", want, srv)
+		}
+	}
+	if strings.Contains(srv, "IN A backend") {
+		t.Errorf("host names should not get address records:\n%s", srv)
+	}
+}
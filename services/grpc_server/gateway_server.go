@@ -5,32 +5,31 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"net"
-	"time"
+	"net/http"
 
 	pb "github.com/21Mile/go_downstreamer_server/services/grpc_server/proto" //定义了服务接口和消息结构
+	"github.com/21Mile/go_downstreamer_server/services/listen"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 )
 
 // server需要实现EchoServer的接口
 type server struct {
 	pb.UnimplementedEchoServer
+	streamingCount int
+	logger         *logger.Logger
 }
 
-var (
-	streamingCount = 10
-)
-
 func (s *server) ServiceStreamingEcho(in *pb.EchoRequest, stream pb.Echo_ServiceStreamingEchoServer) error {
-	grpcLogger.Printf("--- ServerStreamingEcho ---\n")
-	grpcLogger.Printf("request received: %v\n", in)
+	s.logger.Debug("ServerStreamingEcho request received", "request", in)
 
 	// Read requests and send responses.
-	for i := 0; i < streamingCount; i++ {
-		grpcLogger.Printf("echo message %v\n", in.Message)
+	for i := 0; i < s.streamingCount; i++ {
+		s.logger.Trace("echo message", "message", in.Message)
 		err := stream.Send(&pb.EchoResponse{Message: in.Message})
 		if err != nil {
 			return err
@@ -40,28 +39,28 @@ func (s *server) ServiceStreamingEcho(in *pb.EchoRequest, stream pb.Echo_Service
 }
 
 func (s *server) ClientStreamingEcho(stream pb.Echo_ClientStreamingEchoServer) error {
-	grpcLogger.Printf("--- ClientStreamingEcho ---\n")
+	s.logger.Debug("ClientStreamingEcho started")
 	// Read requests and send responses.
 	var message string
 	for {
 		in, err := stream.Recv() //流式接受
 		if err == io.EOF {
 			//持续接收客户端的消息
-			grpcLogger.Printf("echo last received message\n")
+			s.logger.Debug("echo last received message", "message", message)
 			return stream.SendAndClose(&pb.EchoResponse{Message: message})
 		}
-		message = in.Message
-		// 保存当前接收到的消息
-		grpcLogger.Printf("request received: %v, building echo\n", in)
 		if err != nil {
 			return err
 		}
+		// 保存当前接收到的消息
+		message = in.Message
+		s.logger.Trace("request received, building echo", "request", in)
 	}
 }
 
 // 双向流式
 func (s *server) BidirectionalStreamingEcho(stream pb.Echo_BidirectionalStreamingEchoServer) error {
-	grpcLogger.Printf("--- BidirectionalStreamingEcho ---\n")
+	s.logger.Debug("BidirectionalStreamingEcho started")
 	// Read requests and send responses.
 	for {
 		in, err := stream.Recv()
@@ -71,7 +70,7 @@ func (s *server) BidirectionalStreamingEcho(stream pb.Echo_BidirectionalStreamin
 		if err != nil {
 			return err
 		}
-		grpcLogger.Printf("request received %v, sending echo\n", in)
+		s.logger.Trace("request received, sending echo", "request", in)
 		if err := stream.Send(&pb.EchoResponse{Message: in.Message}); err != nil {
 			return err
 		}
@@ -79,55 +78,91 @@ func (s *server) BidirectionalStreamingEcho(stream pb.Echo_BidirectionalStreamin
 }
 
 func (s *server) UnaryEcho(ctx context.Context, in *pb.EchoRequest) (*pb.EchoResponse, error) {
-	grpcLogger.Printf("--- UnaryEcho ---\n")
 	// --- 测试header头 ---
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		grpcLogger.Println("miss metadata from context")
+		s.logger.Warn("miss metadata from context")
 	}
 	//这一步需要先在dashboard中添加对应的header值
 	testHeaderValue := md.Get("my_test_header_key")
 	if len(testHeaderValue) > 0 {
-		grpcLogger.Println(testHeaderValue)
+		s.logger.Info("test header received", "my_test_header_key", testHeaderValue)
 	}
 	// --- 测试结束 ---
-	fmt.Println("md", md)
-	grpcLogger.Printf("request received: %v, sending echo\n", in)
+	s.logger.Debug("UnaryEcho request received, sending echo", "request", in, "metadata", md)
 	return &pb.EchoResponse{Message: in.Message}, nil
 }
 
-func Run_grpc_server(port, configStreamingCount *int, logPath *string) (*grpc.Server, error) {
-	// 初始化日志
-	err := initGrpcLogger(*logPath)
-	if err != nil {
-		grpcLogger.Fatalf("初始化日志失败: %v", err)
+// GrpcServer gRPC服务句柄，开启web时同一端口由http服务分发各协议
+type GrpcServer struct {
+	*grpc.Server
+	web *http.Server
+}
+
+// 关闭服务，等待进行中的请求完成
+func (s *GrpcServer) Close() error {
+	if s.web != nil {
+		// 停止接受新连接，等待进行中的请求完成，超时后强制断开
+		ctx, cancel := context.WithTimeout(context.Background(), webShutdownTimeout)
+		defer cancel()
+		err := s.web.Shutdown(ctx)
+		if err != nil {
+			err = s.web.Close()
+		}
+		s.Server.Stop()
+		return err
 	}
-	defer closeGrpcLogger()
+	s.GracefulStop()
+	return nil
+}
 
+func Run_grpc_server(port, configStreamingCount *int, opts *ServerOptions, lg *logger.Logger, mt *metrics.Instance, tr *tracing.Instance) (*GrpcServer, error) {
 	// 记录服务器启动日志
-	grpcLogger.Printf("开始启动gRPC服务器，端口: %d, 配置路径: %s, 日志路径: %s\n", *port, *logPath, logPath)
+	lg.Info("开始启动gRPC服务器", "port", *port)
+	if err := opts.check(); err != nil {
+		return nil, err
+	}
 
-	streamingCount = *configStreamingCount
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", *port)) //创建 TCP 监听器 lis。
+	lis, err := listen.TCP(fmt.Sprintf(":%d", *port)) //创建 TCP 监听器 lis。
 	if err != nil {
-		grpcLogger.Fatalf("failed to listen: %v", err)
+		lg.Error("failed to listen", "err", err)
+		return nil, err
 	}
-	grpcLogger.Printf("grpc server listening at %v\n", lis.Addr())
-	s := grpc.NewServer(
-		grpc.NumStreamWorkers(32),         // 工作线程数 (默认1)
-		grpc.MaxConcurrentStreams(100000), // 最大并发流 (默认100)
-		grpc.KeepaliveParams(keepalive.ServerParameters{
-			MaxConnectionIdle: 5 * time.Minute,
-			Timeout:           10 * time.Second,
-		}),
-	) //创建 gRPC 服务器实例。
+	lg.Info("grpc server listening", "listen", lis.Addr().String())
+	s := grpc.NewServer(append(opts.serverOptions(), //创建 gRPC 服务器实例。
+		grpc.StatsHandler(metrics.GRPCStatsHandler(mt)),
+		grpc.StatsHandler(tr.GRPCStatsHandler()),
+	)...)
 	// 一个 gRPC 服务器可以注册多个服务
-	pb.RegisterEchoServer(s, &server{}) //注册 Echo 服务到 gRPC 服务器。
+	echo := &server{streamingCount: *configStreamingCount, logger: lg}
+	pb.RegisterEchoServer(s, echo) //注册 Echo 服务到 gRPC 服务器。
+	gs := &GrpcServer{Server: s}
+	if opts != nil && opts.Web {
+		// 原生gRPC、gRPC-Web与Connect共用端口，需要同时支持HTTP/1.1与h2c
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		gs.web = &http.Server{
+			Handler:   newWebHandler(s, echo, mt, tr),
+			Protocols: protocols,
+			ConnState: metrics.HTTPConnState(mt), // ServeHTTP方式下grpc不上报连接事件
+		}
+		for _, name := range opts.applyWeb(gs.web) {
+			lg.Warn("option is not supported with web enabled, ignored", "option", name)
+		}
+		lg.Info("grpc-web and connect enabled", "listen", lis.Addr().String())
+		go func() {
+			if err := gs.web.Serve(lis); err != nil && err != http.ErrServerClosed {
+				lg.Error("gRPC web server failed", "err", err)
+			}
+		}()
+		return gs, nil
+	}
 	// 协程启动监听，返回server句柄
 	go func() {
 		if err := s.Serve(lis); err != nil && err != grpc.ErrServerStopped {
-			grpcLogger.Printf("gRPC server failed: %v", err)
+			lg.Error("gRPC server failed", "err", err)
 		}
 	}()
-	return s, nil
+	return gs, nil
 }
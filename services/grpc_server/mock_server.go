@@ -0,0 +1,236 @@
+package grpc_server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/listen"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// MockResponse 单个方法的mock响应，消息体均为JSON，按方法的输出类型解析
+type MockResponse struct {
+	Body    string        `yaml:"body"`    // 一元/客户端流式返回的消息
+	Stream  []string      `yaml:"stream"`  // 服务端流式/双向流式依次返回的消息，为空时使用Body
+	Code    uint32        `yaml:"code"`    // 非0时直接返回对应的gRPC状态码
+	Message string        `yaml:"message"` // 状态码对应的错误信息
+	Delay   time.Duration `yaml:"delay"`   // 每条响应发送前的延迟
+}
+
+// MockOptions mock服务的描述文件与响应配置
+type MockOptions struct {
+	DescriptorSets []string                `yaml:"descriptor_sets"` // protoc --descriptor_set_out 生成的文件
+	ProtoFiles     []string                `yaml:"proto_files"`     // 启动时编译的.proto文件
+	ImportPaths    []string                `yaml:"import_paths"`    // 编译.proto时的import搜索路径
+	Responses      map[string]MockResponse `yaml:"responses"`       // key为完整方法名，如 /echo.Echo/UnaryEcho
+}
+
+// mockServer 根据描述文件处理所有未注册服务的请求
+type mockServer struct {
+	methods   map[string]protoreflect.MethodDescriptor
+	responses map[string]MockResponse
+	logger    *logger.Logger
+}
+
+// 加载描述文件，返回 完整方法名 -> 方法描述
+func loadMockMethods(opts *MockOptions) (map[string]protoreflect.MethodDescriptor, error) {
+	var files []protoreflect.FileDescriptor
+	for _, path := range opts.DescriptorSets {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取描述文件失败: %w", err)
+		}
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &set); err != nil {
+			return nil, fmt.Errorf("解析描述文件失败 %s: %w", path, err)
+		}
+		registry, err := protodesc.NewFiles(&set)
+		if err != nil {
+			return nil, fmt.Errorf("加载描述文件失败 %s: %w", path, err)
+		}
+		registry.RangeFiles(func(fd protoreflect.FileDescriptor) bool {
+			files = append(files, fd)
+			return true
+		})
+	}
+	if len(opts.ProtoFiles) > 0 {
+		compiler := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+				ImportPaths: opts.ImportPaths,
+			}),
+		}
+		compiled, err := compiler.Compile(context.Background(), opts.ProtoFiles...)
+		if err != nil {
+			return nil, fmt.Errorf("编译proto文件失败: %w", err)
+		}
+		for _, fd := range compiled {
+			files = append(files, fd)
+		}
+	}
+
+	methods := make(map[string]protoreflect.MethodDescriptor)
+	for _, fd := range files {
+		services := fd.Services()
+		for i := 0; i < services.Len(); i++ {
+			sd := services.Get(i)
+			for j := 0; j < sd.Methods().Len(); j++ {
+				md := sd.Methods().Get(j)
+				methods[fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())] = md
+			}
+		}
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("描述文件中没有任何服务")
+	}
+	return methods, nil
+}
+
+// 将配置中的JSON转换为方法输出类型的动态消息
+func newMockMessage(md protoreflect.MethodDescriptor, body string) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(md.Output())
+	if body == "" {
+		return msg, nil
+	}
+	if err := protojson.Unmarshal([]byte(body), msg); err != nil {
+		return nil, status.Errorf(codes.Internal, "mock response for %s is invalid: %v", md.FullName(), err)
+	}
+	return msg, nil
+}
+
+// 发送一组配置的响应
+func (m *mockServer) sendAll(stream grpc.ServerStream, md protoreflect.MethodDescriptor, resp MockResponse) error {
+	bodies := resp.Stream
+	if len(bodies) == 0 {
+		bodies = []string{resp.Body}
+	}
+	for _, body := range bodies {
+		msg, err := newMockMessage(md, body)
+		if err != nil {
+			return err
+		}
+		if resp.Delay > 0 {
+			tracing.InjectedLatency(stream.Context(), resp.Delay)
+			time.Sleep(resp.Delay)
+		}
+		if err := stream.SendMsg(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleStream 作为 UnknownServiceHandler 处理所有方法
+func (m *mockServer) handleStream(_ any, stream grpc.ServerStream) error {
+	fullMethod, ok := grpc.MethodFromServerStream(stream)
+	if !ok {
+		return status.Error(codes.Internal, "method missing from stream")
+	}
+	md, ok := m.methods[fullMethod]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "method %s not found in descriptors", fullMethod)
+	}
+	m.logger.Debug("mock request", "method", fullMethod)
+	resp := m.responses[fullMethod]
+	if resp.Code != 0 {
+		if resp.Delay > 0 {
+			tracing.InjectedLatency(stream.Context(), resp.Delay)
+			time.Sleep(resp.Delay)
+		}
+		tracing.InjectedFault(stream.Context(), fmt.Sprintf("%s: %s", codes.Code(resp.Code), resp.Message))
+		return status.Error(codes.Code(resp.Code), resp.Message)
+	}
+
+	switch {
+	case md.IsStreamingClient() && md.IsStreamingServer():
+		// 双向流式：每收到一条请求就返回一组响应
+		for {
+			in := dynamicpb.NewMessage(md.Input())
+			err := stream.RecvMsg(in)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			m.logger.Trace("request received, sending mock", "request", in)
+			if err := m.sendAll(stream, md, resp); err != nil {
+				return err
+			}
+		}
+	case md.IsStreamingClient():
+		// 客户端流式：读完所有请求后返回一条响应
+		for {
+			in := dynamicpb.NewMessage(md.Input())
+			err := stream.RecvMsg(in)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			m.logger.Trace("request received", "request", in)
+		}
+		resp.Stream = nil
+		return m.sendAll(stream, md, resp)
+	default:
+		in := dynamicpb.NewMessage(md.Input())
+		if err := stream.RecvMsg(in); err != nil {
+			return err
+		}
+		m.logger.Trace("request received, sending mock", "request", in)
+		if !md.IsStreamingServer() {
+			resp.Stream = nil
+		}
+		return m.sendAll(stream, md, resp)
+	}
+}
+
+func Run_grpc_mock_server(port *int, opts *MockOptions, serverOpts *ServerOptions, lg *logger.Logger, mt *metrics.Instance, tr *tracing.Instance) (*grpc.Server, error) {
+	lg.Info("开始启动gRPC mock服务器", "port", *port)
+	if err := serverOpts.check(); err != nil {
+		return nil, err
+	}
+
+	methods, err := loadMockMethods(opts)
+	if err != nil {
+		return nil, err
+	}
+	for name := range opts.Responses {
+		if _, ok := methods[name]; !ok {
+			return nil, fmt.Errorf("mock response configured for unknown method %s", name)
+		}
+	}
+	mock := &mockServer{methods: methods, responses: opts.Responses, logger: lg}
+
+	lis, err := listen.TCP(fmt.Sprintf(":%d", *port))
+	if err != nil {
+		return nil, err
+	}
+	lg.Info("grpc mock server listening", "listen", lis.Addr().String(), "methods", len(methods))
+	s := grpc.NewServer(append(serverOpts.serverOptions(),
+		grpc.UnknownServiceHandler(mock.handleStream),
+		grpc.StatsHandler(metrics.GRPCStatsHandler(mt)),
+		grpc.StatsHandler(tr.GRPCStatsHandler()),
+	)...)
+	go func() {
+		if err := s.Serve(lis); err != nil && err != grpc.ErrServerStopped {
+			lg.Error("gRPC mock server failed", "err", err)
+		}
+	}()
+	return s, nil
+}
@@ -0,0 +1,126 @@
+package grpc_server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/21Mile/go_downstreamer_server/services/grpc_server/proto"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// 用仓库中的echo.proto启动mock服务，返回客户端
+func startMock(t *testing.T, responses map[string]MockResponse) pb.EchoClient {
+	t.Helper()
+	opts := &MockOptions{
+		ProtoFiles:  []string{"echo.proto"},
+		ImportPaths: []string{"proto"},
+		Responses:   responses,
+	}
+	methods, err := loadMockMethods(opts)
+	if err != nil {
+		t.Fatalf("loadMockMethods: %v", err)
+	}
+	lg, err := logger.New(logger.Options{Level: "error"}, "test", "grpc-mock", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockServer{methods: methods, responses: responses, logger: lg}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(grpc.UnknownServiceHandler(mock.handleStream))
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewEchoClient(conn)
+}
+
+func TestLoadMockMethods(t *testing.T) {
+	methods, err := loadMockMethods(&MockOptions{ProtoFiles: []string{"echo.proto"}, ImportPaths: []string{"proto"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/Echo/UnaryEcho", "/Echo/ServiceStreamingEcho", "/Echo/ClientStreamingEcho", "/Echo/BidirectionalStreamingEcho"} {
+		if _, ok := methods[name]; !ok {
+			t.Errorf("method %s not loaded", name)
+		}
+	}
+	if _, err := loadMockMethods(&MockOptions{}); err == nil {
+		t.Error("expected an error without descriptors")
+	}
+}
+
+func TestMockUnaryAndStream(t *testing.T) {
+	client := startMock(t, map[string]MockResponse{
+		"/Echo/UnaryEcho":            {Body: `{"message": "mocked unary"}`},
+		"/Echo/ServiceStreamingEcho": {Stream: []string{`{"message": "1"}`, `{"message": "2"}`}},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.UnaryEcho(ctx, &pb.EchoRequest{Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message != "mocked unary" {
+		t.Errorf("unary message = %q", resp.Message)
+	}
+
+	stream, err := client.ServiceStreamingEcho(ctx, &pb.EchoRequest{Message: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		msg, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.Message)
+	}
+	if len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Errorf("stream messages = %v", got)
+	}
+
+	// 未配置响应的方法返回空消息
+	cs, err := client.ClientStreamingEcho(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs.Send(&pb.EchoRequest{Message: "a"})
+	resp, err = cs.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message != "" {
+		t.Errorf("client streaming message = %q", resp.Message)
+	}
+}
+
+func TestMockStatusCode(t *testing.T) {
+	client := startMock(t, map[string]MockResponse{
+		"/Echo/UnaryEcho": {Code: uint32(codes.Unavailable), Message: "down"},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := client.UnaryEcho(ctx, &pb.EchoRequest{})
+	if st := status.Convert(err); st.Code() != codes.Unavailable || st.Message() != "down" {
+		t.Errorf("status = %v", st)
+	}
+}
@@ -0,0 +1,167 @@
+package grpc_server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip" // 注册gzip压缩器，客户端发送的gzip请求都能被解压
+	"google.golang.org/grpc/keepalive"
+)
+
+// 未配置时使用的默认参数（与原先写死的值一致）
+const (
+	defaultNumStreamWorkers     = 32     // 工作线程数 (grpc默认1)
+	defaultMaxConcurrentStreams = 100000 // 最大并发流 (grpc默认100)
+	defaultMaxConnectionIdle    = 5 * time.Minute
+	defaultKeepaliveTimeout     = 10 * time.Second
+	webShutdownTimeout          = 10 * time.Second // web模式关闭时等待进行中请求的时间
+)
+
+// KeepaliveOptions 服务端keepalive参数，对应 keepalive.ServerParameters
+type KeepaliveOptions struct {
+	MaxConnectionIdle     time.Duration `yaml:"max_connection_idle"`
+	MaxConnectionAge      time.Duration `yaml:"max_connection_age"`       // 到期后发送GOAWAY
+	MaxConnectionAgeGrace time.Duration `yaml:"max_connection_age_grace"` // GOAWAY后等待进行中请求的时间
+	Time                  time.Duration `yaml:"time"`
+	Timeout               time.Duration `yaml:"timeout"`
+}
+
+// EnforcementPolicy 客户端keepalive约束，对应 keepalive.EnforcementPolicy
+type EnforcementPolicy struct {
+	MinTime             time.Duration `yaml:"min_time"`
+	PermitWithoutStream bool          `yaml:"permit_without_stream"`
+}
+
+// ServerOptions 单个gRPC实例的服务端参数，零值表示使用默认值
+type ServerOptions struct {
+	NumStreamWorkers     uint32             `yaml:"num_stream_workers"`
+	MaxConcurrentStreams uint32             `yaml:"max_concurrent_streams"`
+	MaxRecvMsgSize       int                `yaml:"max_recv_msg_size"`
+	MaxSendMsgSize       int                `yaml:"max_send_msg_size"`
+	WriteBufferSize      int                `yaml:"write_buffer_size"`
+	ReadBufferSize       int                `yaml:"read_buffer_size"`
+	Compression          string             `yaml:"compression"` // "gzip"：所有响应都使用gzip压缩
+	Keepalive            KeepaliveOptions   `yaml:"keepalive"`
+	EnforcementPolicy    *EnforcementPolicy `yaml:"enforcement_policy"`
+	Web                  bool               `yaml:"web"` // 同一端口额外提供gRPC-Web(二进制/文本)与Connect协议
+}
+
+// 检查取值，配置错误在启动时返回
+func (o *ServerOptions) check() error {
+	if o == nil {
+		return nil
+	}
+	switch o.Compression {
+	case "", gzip.Name:
+	default:
+		return fmt.Errorf("unknown grpc compression %q, available: gzip", o.Compression)
+	}
+	return nil
+}
+
+// 转换为grpc.ServerOption
+func (o *ServerOptions) serverOptions() []grpc.ServerOption {
+	if o == nil {
+		o = &ServerOptions{}
+	}
+	workers := o.NumStreamWorkers
+	if workers == 0 {
+		workers = defaultNumStreamWorkers
+	}
+	opts := []grpc.ServerOption{
+		grpc.NumStreamWorkers(workers),
+		grpc.MaxConcurrentStreams(o.maxConcurrentStreams()),
+		grpc.KeepaliveParams(o.keepaliveParams()),
+	}
+	if o.MaxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(o.MaxRecvMsgSize))
+	}
+	if o.MaxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(o.MaxSendMsgSize))
+	}
+	if o.WriteBufferSize != 0 {
+		opts = append(opts, grpc.WriteBufferSize(o.WriteBufferSize))
+	}
+	if o.ReadBufferSize != 0 {
+		opts = append(opts, grpc.ReadBufferSize(o.ReadBufferSize))
+	}
+	if o.EnforcementPolicy != nil {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             o.EnforcementPolicy.MinTime,
+			PermitWithoutStream: o.EnforcementPolicy.PermitWithoutStream,
+		}))
+	}
+	if o.Compression == gzip.Name {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(gzipUnaryInterceptor),
+			grpc.ChainStreamInterceptor(gzipStreamInterceptor),
+		)
+	}
+	return opts
+}
+
+func (o *ServerOptions) maxConcurrentStreams() uint32 {
+	if o.MaxConcurrentStreams == 0 {
+		return defaultMaxConcurrentStreams
+	}
+	return o.MaxConcurrentStreams
+}
+
+func (o *ServerOptions) keepaliveParams() keepalive.ServerParameters {
+	params := keepalive.ServerParameters{
+		MaxConnectionIdle:     o.Keepalive.MaxConnectionIdle,
+		MaxConnectionAge:      o.Keepalive.MaxConnectionAge,
+		MaxConnectionAgeGrace: o.Keepalive.MaxConnectionAgeGrace,
+		Time:                  o.Keepalive.Time,
+		Timeout:               o.Keepalive.Timeout,
+	}
+	if params.MaxConnectionIdle == 0 {
+		params.MaxConnectionIdle = defaultMaxConnectionIdle
+	}
+	if params.Timeout == 0 {
+		params.Timeout = defaultKeepaliveTimeout
+	}
+	return params
+}
+
+// web模式下连接由http.Server处理，grpc的连接级参数不生效：
+// 空闲超时、keepalive ping与最大并发流映射到http.Server与HTTP2Config，无法对应的参数返回其名称
+func (o *ServerOptions) applyWeb(srv *http.Server) (ignored []string) {
+	params := o.keepaliveParams()
+	srv.IdleTimeout = params.MaxConnectionIdle
+	srv.HTTP2 = &http.HTTP2Config{
+		MaxConcurrentStreams: int(o.maxConcurrentStreams()),
+		SendPingTimeout:      params.Time, // 0为不主动ping
+		PingTimeout:          params.Timeout,
+	}
+	if o.Keepalive.MaxConnectionAge != 0 || o.Keepalive.MaxConnectionAgeGrace != 0 {
+		ignored = append(ignored, "keepalive.max_connection_age")
+	}
+	if o.NumStreamWorkers != 0 {
+		ignored = append(ignored, "num_stream_workers")
+	}
+	if o.ReadBufferSize != 0 {
+		ignored = append(ignored, "read_buffer_size")
+	}
+	if o.WriteBufferSize != 0 {
+		ignored = append(ignored, "write_buffer_size")
+	}
+	if o.EnforcementPolicy != nil {
+		ignored = append(ignored, "enforcement_policy")
+	}
+	return ignored
+}
+
+// 强制使用gzip压缩响应
+func gzipUnaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	grpc.SetSendCompressor(ctx, gzip.Name)
+	return handler(ctx, req)
+}
+
+func gzipStreamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	grpc.SetSendCompressor(ss.Context(), gzip.Name)
+	return handler(srv, ss)
+}
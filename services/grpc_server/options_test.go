@@ -0,0 +1,299 @@
+package grpc_server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/21Mile/go_downstreamer_server/services/grpc_server/proto"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+const waitTimeout = 3 * time.Second
+
+func testLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+	lg, err := logger.New(logger.Options{Level: "error"}, "test", "grpc", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lg.Close() })
+	return lg
+}
+
+func dialEcho(t *testing.T, addr string, opts ...grpc.DialOption) pb.EchoClient {
+	t.Helper()
+	conn, err := grpc.NewClient(addr, append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewEchoClient(conn)
+}
+
+// 按参数启动echo服务，返回客户端
+func startEcho(t *testing.T, opts *ServerOptions, dialOpts ...grpc.DialOption) pb.EchoClient {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer(opts.serverOptions()...)
+	pb.RegisterEchoServer(s, &server{streamingCount: 1, logger: testLogger(t)})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+	return dialEcho(t, lis.Addr().String(), dialOpts...)
+}
+
+// 记录客户端收到的响应头中的压缩方式（grpc-encoding不出现在metadata中）
+type compressionRecorder struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+func (r *compressionRecorder) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (r *compressionRecorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *compressionRecorder) HandleConn(context.Context, stats.ConnStats) {}
+
+func (r *compressionRecorder) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok {
+		r.mu.Lock()
+		r.seen = append(r.seen, h.Compression)
+		r.mu.Unlock()
+	}
+}
+
+func TestKeepaliveParams(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts ServerOptions
+		want keepalive.ServerParameters
+	}{
+		{"defaults", ServerOptions{}, keepalive.ServerParameters{MaxConnectionIdle: defaultMaxConnectionIdle, Timeout: defaultKeepaliveTimeout}},
+		{"configured", ServerOptions{Keepalive: KeepaliveOptions{
+			MaxConnectionIdle:     time.Minute,
+			MaxConnectionAge:      time.Hour,
+			MaxConnectionAgeGrace: 5 * time.Second,
+			Time:                  30 * time.Second,
+			Timeout:               time.Second,
+		}}, keepalive.ServerParameters{
+			MaxConnectionIdle:     time.Minute,
+			MaxConnectionAge:      time.Hour,
+			MaxConnectionAgeGrace: 5 * time.Second,
+			Time:                  30 * time.Second,
+			Timeout:               time.Second,
+		}},
+	} {
+		if got := tc.opts.keepaliveParams(); got != tc.want {
+			t.Errorf("%s: keepalive = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+	if got := (&ServerOptions{}).maxConcurrentStreams(); got != defaultMaxConcurrentStreams {
+		t.Errorf("default max_concurrent_streams = %d", got)
+	}
+	if got := (&ServerOptions{MaxConcurrentStreams: 10}).maxConcurrentStreams(); got != 10 {
+		t.Errorf("max_concurrent_streams = %d, want 10", got)
+	}
+}
+
+func TestServerOptionsCount(t *testing.T) {
+	// 固定的workers/并发流/keepalive三项，其余参数配置时各追加一项，gzip追加两个拦截器
+	base := len((*ServerOptions)(nil).serverOptions())
+	if base != 3 {
+		t.Fatalf("%d options for nil, want 3", base)
+	}
+	for _, tc := range []struct {
+		name string
+		opts ServerOptions
+		want int
+	}{
+		{"message sizes", ServerOptions{MaxRecvMsgSize: 1, MaxSendMsgSize: 1}, base + 2},
+		{"buffers", ServerOptions{WriteBufferSize: 1, ReadBufferSize: -1}, base + 2},
+		{"enforcement policy", ServerOptions{EnforcementPolicy: &EnforcementPolicy{MinTime: time.Second}}, base + 1},
+		{"gzip", ServerOptions{Compression: "gzip"}, base + 2},
+	} {
+		if got := len(tc.opts.serverOptions()); got != tc.want {
+			t.Errorf("%s: %d options, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCompressionCheck(t *testing.T) {
+	for _, tc := range []struct {
+		opts *ServerOptions
+		ok   bool
+	}{
+		{nil, true},
+		{&ServerOptions{}, true},
+		{&ServerOptions{Compression: "gzip"}, true},
+		{&ServerOptions{Compression: "snappy"}, false},
+		{&ServerOptions{Compression: "GZIP"}, false},
+	} {
+		if err := tc.opts.check(); (err == nil) != tc.ok {
+			t.Errorf("check(%+v) = %v", tc.opts, err)
+		}
+	}
+	// 启动时报告错误，不监听端口
+	port, streams := 0, 1
+	if s, err := Run_grpc_server(&port, &streams, &ServerOptions{Compression: "zstd"}, testLogger(t), nil, nil); err == nil {
+		s.Close()
+		t.Fatal("unknown compression accepted")
+	}
+	if s, err := Run_grpc_mock_server(&port, &MockOptions{}, &ServerOptions{Compression: "zstd"}, testLogger(t), nil, nil); err == nil {
+		s.Stop()
+		t.Fatal("unknown compression accepted by the mock server")
+	}
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	client := startEcho(t, &ServerOptions{MaxRecvMsgSize: 1024, MaxSendMsgSize: 512})
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	for _, tc := range []struct {
+		size int
+		code codes.Code
+	}{
+		{100, codes.OK},
+		{600, codes.ResourceExhausted},  // 回复超过max_send_msg_size
+		{2000, codes.ResourceExhausted}, // 请求超过max_recv_msg_size
+	} {
+		_, err := client.UnaryEcho(ctx, &pb.EchoRequest{Message: strings.Repeat("x", tc.size)})
+		if got := status.Code(err); got != tc.code {
+			t.Errorf("message of %d bytes: %v, want %v", tc.size, err, tc.code)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	for _, tc := range []struct {
+		compression, encoding string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+	} {
+		rec := &compressionRecorder{}
+		client := startEcho(t, &ServerOptions{Compression: tc.compression}, grpc.WithStatsHandler(rec))
+		ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+		if _, err := client.UnaryEcho(ctx, &pb.EchoRequest{Message: "hi"}); err != nil {
+			t.Fatal(err)
+		}
+		stream, err := client.ServiceStreamingEcho(ctx, &pb.EchoRequest{Message: "hi"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := stream.Recv(); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		rec.mu.Lock()
+		if want := []string{tc.encoding, tc.encoding}; !slices.Equal(rec.seen, want) {
+			t.Errorf("compression %q: responses encoded with %q, want %q", tc.compression, rec.seen, want)
+		}
+		rec.mu.Unlock()
+	}
+}
+
+func TestApplyWeb(t *testing.T) {
+	opts := &ServerOptions{
+		NumStreamWorkers:     4,
+		MaxConcurrentStreams: 50,
+		WriteBufferSize:      1,
+		ReadBufferSize:       1,
+		Keepalive:            KeepaliveOptions{MaxConnectionIdle: time.Minute, MaxConnectionAge: time.Hour, Time: 20 * time.Second},
+		EnforcementPolicy:    &EnforcementPolicy{},
+	}
+	srv := &http.Server{}
+	ignored := opts.applyWeb(srv)
+	if srv.IdleTimeout != time.Minute {
+		t.Errorf("idle timeout = %v", srv.IdleTimeout)
+	}
+	if srv.HTTP2.MaxConcurrentStreams != 50 || srv.HTTP2.SendPingTimeout != 20*time.Second || srv.HTTP2.PingTimeout != defaultKeepaliveTimeout {
+		t.Errorf("http2 config = %+v", srv.HTTP2)
+	}
+	want := []string{"keepalive.max_connection_age", "num_stream_workers", "read_buffer_size", "write_buffer_size", "enforcement_policy"}
+	if !slices.Equal(ignored, want) {
+		t.Errorf("ignored = %v, want %v", ignored, want)
+	}
+	if ignored := (&ServerOptions{}).applyWeb(&http.Server{}); len(ignored) != 0 {
+		t.Errorf("defaults ignored %v", ignored)
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// web模式关闭时等待进行中的流结束，不直接断开
+func TestWebCloseGraceful(t *testing.T) {
+	port, streams := freePort(t), 1
+	s, err := Run_grpc_server(&port, &streams, &ServerOptions{Web: true}, testLogger(t), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := dialEcho(t, net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	stream, err := client.BidirectionalStreamingEcho(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange := func(msg string) {
+		t.Helper()
+		if err := stream.Send(&pb.EchoRequest{Message: msg}); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil || resp.Message != msg {
+			t.Fatalf("echo = %v, %v", resp, err)
+		}
+	}
+	exchange("before close")
+
+	closed := make(chan error, 1)
+	go func() { closed <- s.Close() }()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned %v with a stream in progress", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	exchange("during close")
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("stream ended with %v", err)
+	}
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(waitTimeout):
+		t.Fatal("Close did not return after the stream finished")
+	}
+}
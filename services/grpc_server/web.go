@@ -0,0 +1,235 @@
+package grpc_server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	pb "github.com/21Mile/go_downstreamer_server/services/grpc_server/proto"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	grpcWebTextContentType = "application/grpc-web-text"
+	grpcWebContentType     = "application/grpc-web"
+)
+
+// 将http请求头转换为gRPC的incoming metadata，保持与原生gRPC一致的header测试
+func incomingContext(ctx context.Context, header http.Header) context.Context {
+	md := metadata.MD{}
+	for k, v := range header {
+		md.Append(strings.ToLower(k), v...)
+	}
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+// connectStream 将connect的流适配为grpc.ServerStream，复用原有的Echo实现
+type connectStream struct {
+	ctx     context.Context
+	recv    func() (*pb.EchoRequest, error)
+	send    func(*pb.EchoResponse) error
+	header  http.Header
+	trailer http.Header
+}
+
+func (s *connectStream) SetHeader(md metadata.MD) error {
+	for k, v := range md {
+		for _, vv := range v {
+			s.header.Add(k, vv)
+		}
+	}
+	return nil
+}
+
+func (s *connectStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *connectStream) SetTrailer(md metadata.MD) {
+	if s.trailer == nil {
+		return
+	}
+	for k, v := range md {
+		for _, vv := range v {
+			s.trailer.Add(k, vv)
+		}
+	}
+}
+
+func (s *connectStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *connectStream) SendMsg(m any) error {
+	return s.send(m.(*pb.EchoResponse))
+}
+
+func (s *connectStream) RecvMsg(m any) error {
+	in, err := s.recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return err
+	}
+	proto.Reset(m.(proto.Message))
+	proto.Merge(m.(proto.Message), in)
+	return nil
+}
+
+// 为Echo服务注册connect handler，同时支持Connect协议与gRPC-Web(二进制)
+func newConnectMux(echo *server) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(pb.Echo_UnaryEcho_FullMethodName, connect.NewUnaryHandler(pb.Echo_UnaryEcho_FullMethodName,
+		func(ctx context.Context, req *connect.Request[pb.EchoRequest]) (*connect.Response[pb.EchoResponse], error) {
+			resp, err := echo.UnaryEcho(incomingContext(ctx, req.Header()), req.Msg)
+			if err != nil {
+				return nil, err
+			}
+			return connect.NewResponse(resp), nil
+		}))
+	mux.Handle(pb.Echo_ServiceStreamingEcho_FullMethodName, connect.NewServerStreamHandler(pb.Echo_ServiceStreamingEcho_FullMethodName,
+		func(ctx context.Context, req *connect.Request[pb.EchoRequest], stream *connect.ServerStream[pb.EchoResponse]) error {
+			ss := &connectStream{
+				ctx:     incomingContext(ctx, req.Header()),
+				send:    stream.Send,
+				header:  stream.ResponseHeader(),
+				trailer: stream.ResponseTrailer(),
+			}
+			return echo.ServiceStreamingEcho(req.Msg, &grpc.GenericServerStream[pb.EchoRequest, pb.EchoResponse]{ServerStream: ss})
+		}))
+	mux.Handle(pb.Echo_ClientStreamingEcho_FullMethodName, connect.NewClientStreamHandler(pb.Echo_ClientStreamingEcho_FullMethodName,
+		func(ctx context.Context, stream *connect.ClientStream[pb.EchoRequest]) (*connect.Response[pb.EchoResponse], error) {
+			var resp *pb.EchoResponse
+			ss := &connectStream{
+				ctx: incomingContext(ctx, stream.RequestHeader()),
+				recv: func() (*pb.EchoRequest, error) {
+					if !stream.Receive() {
+						if err := stream.Err(); err != nil {
+							return nil, err
+						}
+						return nil, io.EOF
+					}
+					return stream.Msg(), nil
+				},
+				send: func(m *pb.EchoResponse) error {
+					resp = m
+					return nil
+				},
+				header: http.Header{},
+			}
+			if err := echo.ClientStreamingEcho(&grpc.GenericServerStream[pb.EchoRequest, pb.EchoResponse]{ServerStream: ss}); err != nil {
+				return nil, err
+			}
+			res := connect.NewResponse(resp)
+			for k, v := range ss.header {
+				res.Header()[k] = v
+			}
+			return res, nil
+		}))
+	mux.Handle(pb.Echo_BidirectionalStreamingEcho_FullMethodName, connect.NewBidiStreamHandler(pb.Echo_BidirectionalStreamingEcho_FullMethodName,
+		func(ctx context.Context, stream *connect.BidiStream[pb.EchoRequest, pb.EchoResponse]) error {
+			ss := &connectStream{
+				ctx:     incomingContext(ctx, stream.RequestHeader()),
+				recv:    stream.Receive,
+				send:    stream.Send,
+				header:  stream.ResponseHeader(),
+				trailer: stream.ResponseTrailer(),
+			}
+			return echo.BidirectionalStreamingEcho(&grpc.GenericServerStream[pb.EchoRequest, pb.EchoResponse]{ServerStream: ss})
+		}))
+	return mux
+}
+
+// base64Writer gRPC-Web文本格式：响应体base64编码，按3字节对齐编码避免中途出现填充
+type base64Writer struct {
+	http.ResponseWriter
+	pending     []byte
+	wroteHeader bool
+}
+
+func (w *base64Writer) WriteHeader(code int) {
+	w.wroteHeader = true
+	ct := w.Header().Get("Content-Type")
+	w.Header().Set("Content-Type", strings.Replace(ct, grpcWebContentType, grpcWebTextContentType, 1))
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *base64Writer) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.pending = append(w.pending, p...)
+	n := len(w.pending) / 3 * 3
+	if n > 0 {
+		if _, err := io.WriteString(w.ResponseWriter, base64.StdEncoding.EncodeToString(w.pending[:n])); err != nil {
+			return 0, err
+		}
+		w.pending = append(w.pending[:0], w.pending[n:]...)
+	}
+	return len(p), nil
+}
+
+// Flush 编码剩余数据（带填充）后下发
+func (w *base64Writer) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if len(w.pending) > 0 {
+		io.WriteString(w.ResponseWriter, base64.StdEncoding.EncodeToString(w.pending))
+		w.pending = w.pending[:0]
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// 将gRPC-Web文本请求转换为二进制请求交给connect处理
+func grpcWebTextHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(body)))
+		if err != nil {
+			http.Error(w, "invalid grpc-web-text body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(decoded))
+		r.ContentLength = int64(len(decoded))
+		ct := r.Header.Get("Content-Type")
+		r.Header.Set("Content-Type", strings.Replace(ct, grpcWebTextContentType, grpcWebContentType, 1))
+		bw := &base64Writer{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+		bw.Flush()
+	})
+}
+
+// 同一端口按Content-Type分发：原生gRPC交给grpc.Server，gRPC-Web与Connect交给connect
+func newWebHandler(s *grpc.Server, echo *server, mt *metrics.Instance, tr *tracing.Instance) http.Handler {
+	// 原生gRPC由stats handler统计与追踪，gRPC-Web与Connect按HTTP请求统计与追踪
+	connectMux := metrics.HTTPMiddleware(mt, tr.HTTPMiddleware(newConnectMux(echo)))
+	textHandler := grpcWebTextHandler(connectMux)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct := r.Header.Get("Content-Type")
+		switch {
+		case strings.HasPrefix(ct, grpcWebTextContentType):
+			textHandler.ServeHTTP(w, r)
+		case r.ProtoMajor == 2 && strings.HasPrefix(ct, "application/grpc") && !strings.HasPrefix(ct, grpcWebContentType):
+			s.ServeHTTP(w, r)
+		default:
+			connectMux.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,325 @@
+package http_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/listen"
+	"github.com/21Mile/go_downstreamer_server/services/load_balance"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/proxy_protocol"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+)
+
+// 转发时Host头的取值
+const (
+	HostUpstream = "upstream" // 使用上游地址（默认）
+	HostPreserve = "preserve" // 保留客户端请求的Host
+)
+
+// Upstream 代理的上游，Addr为 host:port、完整URL（如 https://www.baidu.com）或受管实例名（如 http:127.0.0.1:2003）
+type Upstream struct {
+	Addr   string `yaml:"addr"`
+	Weight int    `yaml:"weight"` // weight-round-robin、least-conn与consistent-hash使用，默认1
+}
+
+// ProxyOptions 单个HTTP代理实例的参数
+type ProxyOptions struct {
+	Balance          string        `yaml:"balance"` // random/round-robin/weight-round-robin/least-conn/consistent-hash，默认round-robin
+	Upstreams        []Upstream    `yaml:"upstreams"`
+	Discovery        string        `yaml:"discovery"`         // 跟随注册中心中该服务路径下的实例（host:port）作为上游，设置后替换upstreams
+	DiscoveryBackend string        `yaml:"discovery_backend"` // 服务发现使用的注册中心：zookeeper/etcd，默认zookeeper
+	HashOn           string        `yaml:"hash_on"`           // consistent-hash的key：client-ip（默认）/header:<名称>/cookie:<名称>
+	Host             string        `yaml:"host"`              // 转发的Host：upstream（默认）/preserve/其它为固定值
+	MaxFails         int           `yaml:"max_fails"`         // 连续失败（连接错误或502/503/504）多少次后摘除上游，0为不摘除
+	FailTimeout      time.Duration `yaml:"fail_timeout"`      // 摘除时长，默认10s
+	Timeout          time.Duration `yaml:"timeout"`           // 等待上游响应头的超时，0为不限制
+	ProxyProtocol    string        `yaml:"proxy_protocol"`
+}
+
+// Resolver 把上游名称解析为可连接的地址，名称不是受管实例时原样返回
+type Resolver func(name string) string
+
+type upstreamKey struct{}
+
+func Run_http_proxy(addr string, opts *ProxyOptions, resolve Resolver, lg *logger.Logger, mt *metrics.Instance, tr *tracing.Instance) (*ProxyServer, error) {
+	// 记录服务器启动日志
+	lg.Info("开始启动http代理", "balance", opts.Balance, "upstreams", len(opts.Upstreams))
+	p, err := newProxyServer(addr, opts, resolve, lg, mt, tr)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := p.Listen()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := p.Serve(ln); err != nil && err != http.ErrServerClosed {
+			lg.Error("HTTP proxy failed", "err", err)
+		}
+	}()
+	return p, nil
+}
+
+// ProxyServer HTTP负载均衡反向代理
+type ProxyServer struct {
+	Addr          string
+	ProxyProtocol string
+	Logger        *logger.Logger
+	Metrics       *metrics.Instance
+	Tracer        *tracing.Instance
+
+	lb       *load_balance.Dynamic
+	ejector  *load_balance.Ejector
+	resolve  Resolver
+	hashOn   string // 为空表示按客户端IP
+	hashName string
+	host     string
+	proxy    *httputil.ReverseProxy
+	server   *http.Server
+}
+
+func newProxyServer(addr string, opts *ProxyOptions, resolve Resolver, lg *logger.Logger, mt *metrics.Instance, tr *tracing.Instance) (*ProxyServer, error) {
+	if len(opts.Upstreams) == 0 && opts.Discovery == "" {
+		return nil, fmt.Errorf("http proxy needs at least one upstream or a discovery path")
+	}
+	if err := proxy_protocol.CheckMode(opts.ProxyProtocol); err != nil {
+		return nil, err
+	}
+	typ, err := load_balance.ParseType(opts.Balance)
+	if err != nil {
+		return nil, err
+	}
+	lb := load_balance.NewDynamic(typ)
+	for _, u := range opts.Upstreams {
+		weight := ""
+		if u.Weight != 0 {
+			weight = strconv.Itoa(u.Weight)
+		}
+		if err := lb.Add(u.Addr, weight); err != nil {
+			return nil, err
+		}
+	}
+	if resolve == nil {
+		resolve = func(name string) string { return name }
+	}
+	p := &ProxyServer{
+		Addr:          addr,
+		ProxyProtocol: opts.ProxyProtocol,
+		Logger:        lg,
+		Metrics:       mt,
+		Tracer:        tr,
+		lb:            lb,
+		ejector:       load_balance.NewEjector(opts.MaxFails, opts.FailTimeout),
+		resolve:       resolve,
+		host:          opts.Host,
+	}
+	switch kind, name, _ := strings.Cut(opts.HashOn, ":"); kind {
+	case "", "client-ip":
+	case "header", "cookie":
+		if name == "" {
+			return nil, fmt.Errorf("hash_on %q is missing a name", opts.HashOn)
+		}
+		p.hashOn, p.hashName = kind, name
+	default:
+		return nil, fmt.Errorf("unknown hash_on %q, available: client-ip, header:<name>, cookie:<name>", opts.HashOn)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = opts.Timeout
+	p.proxy = &httputil.ReverseProxy{
+		Rewrite:        p.rewrite,
+		Transport:      tr.HTTPTransport(transport),
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+	}
+	return p, nil
+}
+
+// SetUpstreams 整体替换上游列表（服务发现回调）
+func (p *ProxyServer) SetUpstreams(addrs []string) error {
+	nodes := make([]load_balance.Node, len(addrs))
+	for i, addr := range addrs {
+		nodes[i] = load_balance.Node{Addr: addr}
+	}
+	if err := p.lb.Update(nodes); err != nil {
+		return err
+	}
+	p.Logger.Info("upstreams updated", "upstreams", addrs)
+	return nil
+}
+
+func (p *ProxyServer) Run() error {
+	ln, err := p.Listen()
+	if err != nil {
+		return err
+	}
+	return p.Serve(ln)
+}
+
+// Listen 创建http.Server并监听Addr
+func (p *ProxyServer) Listen() (net.Listener, error) {
+	p.Logger.Info("Starting http proxy")
+	p.server = &http.Server{
+		Addr:      p.Addr,
+		Handler:   metrics.HTTPMiddleware(p.Metrics, p.Tracer.HTTPMiddleware(p)),
+		ConnState: metrics.HTTPConnState(p.Metrics),
+	}
+	ln, err := listen.TCP(p.Addr)
+	if err != nil {
+		p.Logger.Error("HTTP listen failed", "err", err)
+		return nil, err
+	}
+	return proxy_protocol.Wrap(ln, p.ProxyProtocol, 0), nil
+}
+
+func (p *ProxyServer) Serve(ln net.Listener) error {
+	if err := p.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+		p.Logger.Error("HTTP serve failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+func (p *ProxyServer) Stop() error {
+	if err := p.server.Close(); err != nil {
+		p.Logger.Error("proxy stop failed", "err", err)
+		return err
+	}
+	return nil
+}
+
+func clientIP(req *http.Request) string {
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// 一致性哈希的key，指定的请求头/cookie不存在时按客户端IP
+func (p *ProxyServer) hashKey(req *http.Request) string {
+	switch p.hashOn {
+	case "header":
+		if v := req.Header.Get(p.hashName); v != "" {
+			return v
+		}
+	case "cookie":
+		if c, err := req.Cookie(p.hashName); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	return clientIP(req)
+}
+
+// 选择上游，跳过被摘除的节点；全部被摘除时仍使用第一次选中的节点，避免整体不可用
+func (p *ProxyServer) pick(key string) (string, error) {
+	first := ""
+	for i := 0; i < max(p.lb.Len(), 1); i++ {
+		// 一致性哈希对同一个key总是返回同一节点，重试时换一个key
+		k := key
+		if i > 0 {
+			k = key + "#" + strconv.Itoa(i)
+		}
+		name, err := p.lb.Get(k)
+		if err != nil {
+			return "", err
+		}
+		if !p.ejector.Ejected(name) {
+			if first != "" {
+				p.lb.Done(first)
+			}
+			return name, nil
+		}
+		if first == "" {
+			first = name
+		} else {
+			p.lb.Done(name)
+		}
+	}
+	p.Logger.Warn("all upstreams ejected, using ejected upstream", "upstream", first)
+	return first, nil
+}
+
+// 上游地址：完整URL原样使用，否则解析受管实例名后按http访问
+func (p *ProxyServer) target(name string) (*url.URL, error) {
+	if strings.Contains(name, "://") {
+		return url.Parse(name)
+	}
+	return url.Parse("http://" + p.resolve(name))
+}
+
+func (p *ProxyServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name, err := p.pick(p.hashKey(req))
+	if err != nil {
+		p.Logger.Error("no upstream available", "err", err)
+		http.Error(w, "no upstream available", http.StatusBadGateway)
+		return
+	}
+	defer p.lb.Done(name)
+	target, err := p.target(name)
+	if err != nil {
+		p.Logger.Error("invalid upstream", "upstream", name, "err", err)
+		http.Error(w, "invalid upstream", http.StatusBadGateway)
+		return
+	}
+	p.Logger.Trace("proxy request", "remote", req.RemoteAddr, "path", req.URL.Path, "upstream", name, "target", target.String())
+	ctx := context.WithValue(req.Context(), upstreamKey{}, name)
+	p.proxy.ServeHTTP(w, req.WithContext(ctx))
+}
+
+func (p *ProxyServer) rewrite(pr *httputil.ProxyRequest) {
+	name, _ := pr.In.Context().Value(upstreamKey{}).(string)
+	target, _ := p.target(name)
+	pr.SetURL(target)
+	// 保留客户端带来的X-Forwarded-For，并在其后追加客户端IP
+	pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+	pr.SetXForwarded()
+	pr.Out.Header.Set("X-Real-Ip", clientIP(pr.In))
+	switch p.host {
+	case "", HostUpstream:
+		// SetURL已改为上游地址
+	case HostPreserve:
+		pr.Out.Host = pr.In.Host
+	default:
+		pr.Out.Host = p.host
+	}
+}
+
+func (p *ProxyServer) report(req *http.Request, ok bool) {
+	name, _ := req.Context().Value(upstreamKey{}).(string)
+	if p.ejector.Report(name, ok) {
+		p.Logger.Warn("upstream ejected", "upstream", name)
+	}
+}
+
+func (p *ProxyServer) modifyResponse(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		p.report(resp.Request, false)
+	default:
+		p.report(resp.Request, true)
+	}
+	return nil
+}
+
+func (p *ProxyServer) errorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	name, _ := req.Context().Value(upstreamKey{}).(string)
+	// 客户端自己取消的请求不计入上游失败
+	if errors.Is(err, context.Canceled) {
+		p.Logger.Debug("client canceled request", "upstream", name)
+		w.WriteHeader(499)
+		return
+	}
+	p.Logger.Warn("upstream request failed", "upstream", name, "err", err)
+	p.report(req, false)
+	w.WriteHeader(http.StatusBadGateway)
+}
@@ -0,0 +1,365 @@
+package http_server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+)
+
+const waitTimeout = 3 * time.Second
+
+func testLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+	lg, err := logger.New(logger.Options{Level: "error"}, "test", "http-proxy", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lg.Close() })
+	return lg
+}
+
+// backend 测试上游：响应体为名称，status不为0时返回该状态码，/slow请求等待release
+type backend struct {
+	name    string
+	status  int
+	entered chan struct{}
+	release chan struct{}
+	srv     *httptest.Server
+}
+
+func startBackend(t *testing.T, name string, status int) *backend {
+	t.Helper()
+	b := &backend{name: name, status: status, entered: make(chan struct{}, 16), release: make(chan struct{})}
+	b.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			b.entered <- struct{}{}
+			<-b.release
+		}
+		w.Header().Set("Got-Host", r.Host)
+		w.Header().Set("Got-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("Got-Real-Ip", r.Header.Get("X-Real-Ip"))
+		if b.status != 0 {
+			w.WriteHeader(b.status)
+		}
+		io.WriteString(w, b.name)
+	}))
+	t.Cleanup(b.srv.Close)
+	t.Cleanup(func() { close(b.release) })
+	return b
+}
+
+func (b *backend) addr() string {
+	return b.srv.Listener.Addr().String()
+}
+
+func startProxyForTest(t *testing.T, opts *ProxyOptions, resolve Resolver) string {
+	t.Helper()
+	p, err := newProxyServer("127.0.0.1:0", opts, resolve, testLogger(t), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := p.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go p.Serve(ln)
+	t.Cleanup(func() { p.Stop() })
+	return ln.Addr().String()
+}
+
+func upstreams(addrs ...string) []Upstream {
+	us := make([]Upstream, len(addrs))
+	for i, a := range addrs {
+		us[i] = Upstream{Addr: a}
+	}
+	return us
+}
+
+// 发送请求，返回状态码、响应体与响应头
+func get(t *testing.T, url string, header http.Header) (int, string, http.Header) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	client := &http.Client{Timeout: waitTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body), resp.Header
+}
+
+func TestProxyRoundRobinAndHeaders(t *testing.T) {
+	a, b := startBackend(t, "a", 0), startBackend(t, "b", 0)
+	proxy := startProxyForTest(t, &ProxyOptions{Upstreams: upstreams(a.addr(), b.addr())}, nil)
+	counts := map[string]int{}
+	for range 6 {
+		status, body, header := get(t, "http://"+proxy+"/", http.Header{"X-Forwarded-For": {"203.0.113.9"}})
+		if status != http.StatusOK {
+			t.Fatalf("status %d", status)
+		}
+		counts[body]++
+		// 保留客户端带来的X-Forwarded-For并追加客户端IP，Host默认为上游地址
+		if got := header.Get("Got-Forwarded-For"); got != "203.0.113.9, 127.0.0.1" {
+			t.Fatalf("X-Forwarded-For = %q", got)
+		}
+		if got := header.Get("Got-Real-Ip"); got != "127.0.0.1" {
+			t.Fatalf("X-Real-Ip = %q", got)
+		}
+		if got := header.Get("Got-Host"); got != a.addr() && got != b.addr() {
+			t.Fatalf("Host = %q, want the upstream address", got)
+		}
+	}
+	if counts["a"] != 3 || counts["b"] != 3 {
+		t.Fatalf("distribution = %v, want 3/3", counts)
+	}
+}
+
+func TestProxyHostOption(t *testing.T) {
+	a := startBackend(t, "a", 0)
+	for _, tc := range []struct {
+		host, want string
+	}{
+		{HostPreserve, "example.test"},
+		{"fixed.test", "fixed.test"},
+	} {
+		proxy := startProxyForTest(t, &ProxyOptions{Upstreams: upstreams(a.addr()), Host: tc.host}, nil)
+		req, _ := http.NewRequest(http.MethodGet, "http://"+proxy+"/", nil)
+		req.Host = "example.test"
+		resp, err := (&http.Client{Timeout: waitTimeout}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Got-Host"); got != tc.want {
+			t.Errorf("host %q: upstream saw Host %q, want %q", tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestProxyLeastConn(t *testing.T) {
+	a, b := startBackend(t, "a", 0), startBackend(t, "b", 0)
+	proxy := startProxyForTest(t, &ProxyOptions{Balance: "least-conn", Upstreams: upstreams(a.addr(), b.addr())}, nil)
+
+	done := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + proxy + "/slow")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		done <- string(body)
+	}()
+	var busy, idle *backend
+	select {
+	case <-a.entered:
+		busy, idle = a, b
+	case <-b.entered:
+		busy, idle = b, a
+	case <-time.After(waitTimeout):
+		t.Fatal("slow request not received")
+	}
+	// 一个上游有进行中的请求，之后的请求都到另一个上游
+	for range 4 {
+		if _, body, _ := get(t, "http://"+proxy+"/", nil); body != idle.name {
+			t.Fatalf("request sent to %s while %s was busy", body, busy.name)
+		}
+	}
+	busy.release <- struct{}{}
+	if body := <-done; body != busy.name {
+		t.Fatalf("slow request answered by %q", body)
+	}
+	counts := map[string]int{}
+	for range 4 {
+		_, body, _ := get(t, "http://"+proxy+"/", nil)
+		counts[body]++
+	}
+	if counts["a"] != 2 || counts["b"] != 2 {
+		t.Fatalf("idle distribution = %v, want 2/2", counts)
+	}
+}
+
+// 连续失败max_fails次后摘除，fail_timeout后恢复
+func TestProxyEjection(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		failed func(t *testing.T) string
+		status int
+	}{
+		{"503", func(t *testing.T) string { return startBackend(t, "bad", http.StatusServiceUnavailable).addr() }, http.StatusServiceUnavailable},
+		{"dial error", func(t *testing.T) string {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ln.Close()
+			return ln.Addr().String()
+		}, http.StatusBadGateway},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			good := startBackend(t, "good", 0)
+			proxy := startProxyForTest(t, &ProxyOptions{
+				Upstreams:   upstreams(good.addr(), tc.failed(t)),
+				MaxFails:    2,
+				FailTimeout: 300 * time.Millisecond,
+			}, nil)
+			failures := 0
+			for range 4 {
+				status, _, _ := get(t, "http://"+proxy+"/", nil)
+				if status == tc.status {
+					failures++
+				}
+			}
+			if failures != 2 {
+				t.Fatalf("%d failed requests before ejection, want 2", failures)
+			}
+			for range 6 {
+				if status, body, _ := get(t, "http://"+proxy+"/", nil); status != http.StatusOK || body != "good" {
+					t.Fatalf("request after ejection: %d %q", status, body)
+				}
+			}
+			time.Sleep(400 * time.Millisecond)
+			failures = 0
+			for range 2 {
+				if status, _, _ := get(t, "http://"+proxy+"/", nil); status == tc.status {
+					failures++
+				}
+			}
+			if failures != 1 {
+				t.Fatalf("ejected upstream not retried after fail_timeout")
+			}
+		})
+	}
+}
+
+// 所有上游都被摘除时仍然转发，不整体不可用
+func TestProxyAllEjected(t *testing.T) {
+	bad := startBackend(t, "bad", http.StatusBadGateway)
+	proxy := startProxyForTest(t, &ProxyOptions{Upstreams: upstreams(bad.addr()), MaxFails: 1}, nil)
+	for range 3 {
+		if status, body, _ := get(t, "http://"+proxy+"/", nil); status != http.StatusBadGateway || body != "bad" {
+			t.Fatalf("request = %d %q", status, body)
+		}
+	}
+}
+
+// 通过原始连接发送PROXY协议头与请求，返回响应体
+func getWithProxyHeader(t *testing.T, proxy, src string, port int) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", proxy, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(waitTimeout))
+	fmt.Fprintf(conn, "PROXY TCP4 %s 192.0.2.1 %d 80\r\nGET / HTTP/1.1\r\nHost: proxy\r\nConnection: close\r\n\r\n", src, port)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if got := resp.Header.Get("Got-Real-Ip"); got != src {
+		t.Fatalf("X-Real-Ip = %q, want the PROXY source %s", got, src)
+	}
+	return string(body)
+}
+
+func TestProxyConsistentHashByProxySource(t *testing.T) {
+	a, b, c := startBackend(t, "a", 0), startBackend(t, "b", 0), startBackend(t, "c", 0)
+	proxy := startProxyForTest(t, &ProxyOptions{
+		Balance:       "consistent-hash",
+		ProxyProtocol: "required",
+		Upstreams:     upstreams(a.addr(), b.addr(), c.addr()),
+	}, nil)
+	counts := map[string]int{}
+	for i := 1; i <= 30; i++ {
+		src := fmt.Sprintf("10.1.%d.%d", i, i*11%256)
+		first := getWithProxyHeader(t, proxy, src, 1000)
+		counts[first]++
+		for port := 1001; port < 1003; port++ {
+			if got := getWithProxyHeader(t, proxy, src, port); got != first {
+				t.Fatalf("client %s sent to %s and %s", src, first, got)
+			}
+		}
+	}
+	if len(counts) != 3 {
+		t.Fatalf("30 clients spread over %v, want all three upstreams", counts)
+	}
+}
+
+func TestProxyConsistentHashOnHeader(t *testing.T) {
+	a, b := startBackend(t, "a", 0), startBackend(t, "b", 0)
+	proxy := startProxyForTest(t, &ProxyOptions{
+		Balance:   "consistent-hash",
+		HashOn:    "header:X-User",
+		Upstreams: upstreams(a.addr(), b.addr()),
+	}, nil)
+	counts := map[string]int{}
+	for i := range 20 {
+		user := http.Header{"X-User": {fmt.Sprintf("user-%d", i)}}
+		_, first, _ := get(t, "http://"+proxy+"/", user)
+		counts[first]++
+		if _, again, _ := get(t, "http://"+proxy+"/", user); again != first {
+			t.Fatalf("user %d sent to %s and %s", i, first, again)
+		}
+	}
+	if len(counts) != 2 {
+		t.Fatalf("20 users spread over %v", counts)
+	}
+}
+
+func TestProxyResolvesInstanceName(t *testing.T) {
+	a := startBackend(t, "managed", 0)
+	resolve := func(name string) string {
+		if name == "http:127.0.0.1:2003" {
+			return a.addr()
+		}
+		return name
+	}
+	proxy := startProxyForTest(t, &ProxyOptions{Upstreams: upstreams("http:127.0.0.1:2003")}, resolve)
+	if status, body, _ := get(t, "http://"+proxy+"/", nil); status != http.StatusOK || body != "managed" {
+		t.Fatalf("request = %d %q", status, body)
+	}
+	// 完整URL不经过解析
+	proxy = startProxyForTest(t, &ProxyOptions{Upstreams: upstreams(a.srv.URL)}, func(name string) string {
+		t.Errorf("resolver called for %q", name)
+		return name
+	})
+	if _, body, _ := get(t, "http://"+proxy+"/", nil); body != "managed" {
+		t.Fatalf("request to URL upstream = %q", body)
+	}
+}
+
+func TestProxyOptionsValidation(t *testing.T) {
+	lg := testLogger(t)
+	for _, opts := range []ProxyOptions{
+		{},
+		{Upstreams: upstreams("a:1"), Balance: "fastest"},
+		{Upstreams: upstreams("a:1"), HashOn: "header"},
+		{Upstreams: upstreams("a:1"), HashOn: "query:x"},
+		{Upstreams: upstreams("a:1"), ProxyProtocol: "sometimes"},
+	} {
+		if _, err := newProxyServer("127.0.0.1:0", &opts, nil, lg, nil, nil); err == nil {
+			t.Errorf("options %+v accepted", opts)
+		}
+	}
+}
@@ -1,27 +1,43 @@
 package http_server
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/listen"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/proxy_protocol"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
 )
 
-func Run_http_server(addr, logPath *string) (*RealServer, error) {
-	// 初始化日志
-	err := initGrpcLogger(*logPath)
-	if err != nil {
-		httpLogger.Fatalf("初始化日志失败: %v", err)
-	}
-	defer closeGrpcLogger()
+// ServerOptions 单个HTTP实例的参数
+type ServerOptions struct {
+	ProxyProtocol string `yaml:"proxy_protocol"` // PROXY协议头：off/optional/required，默认off
+}
+
+type proxyConnKey struct{}
 
+func Run_http_server(addr *string, opts *ServerOptions, lg *logger.Logger, mt *metrics.Instance, tr *tracing.Instance) (*RealServer, error) {
 	// 记录服务器启动日志
-	httpLogger.Printf("开始启动http服务器，addr: %v, 日志路径: %s\n", *addr, *logPath)
-	rs1 := &RealServer{Addr: *addr}
+	lg.Info("开始启动http服务器")
+	if err := proxy_protocol.CheckMode(opts.ProxyProtocol); err != nil {
+		return nil, err
+	}
+	rs1 := &RealServer{Addr: *addr, ProxyProtocol: opts.ProxyProtocol, Logger: lg, Metrics: mt, Tracer: tr}
+	// 同步监听，端口被占用时直接返回错误
+	ln, err := rs1.Listen()
+	if err != nil {
+		return nil, err
+	}
 	// 协程处理
 	go func() {
-		if err := rs1.Run(); err != nil && err != http.ErrServerClosed {
-			httpLogger.Println("HTTP server failed:%v", rs1.Addr)
+		if err := rs1.Serve(ln); err != nil && err != http.ErrServerClosed {
+			lg.Error("HTTP server failed", "err", err)
 		}
 	}()
 
@@ -30,12 +46,25 @@ func Run_http_server(addr, logPath *string) (*RealServer, error) {
 }
 
 type RealServer struct {
-	Addr   string
-	server *http.Server
+	Addr          string
+	ProxyProtocol string
+	Logger        *logger.Logger
+	Metrics       *metrics.Instance
+	Tracer        *tracing.Instance
+	server        *http.Server
 }
 
 func (r *RealServer) Run() error {
-	httpLogger.Println("Starting httpserver at " + r.Addr)
+	ln, err := r.Listen()
+	if err != nil {
+		return err
+	}
+	return r.Serve(ln)
+}
+
+// Listen 创建http.Server并监听Addr
+func (r *RealServer) Listen() (net.Listener, error) {
+	r.Logger.Info("Starting httpserver")
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", r.HelloHandler) //没有匹配的路径会默认匹配到这里
 	mux.HandleFunc("/base/error", r.ErrorHandler)
@@ -43,7 +72,15 @@ func (r *RealServer) Run() error {
 	r.server = &http.Server{
 		Addr:         r.Addr,
 		WriteTimeout: time.Second * 3,
-		Handler:      mux,
+		Handler:      metrics.HTTPMiddleware(r.Metrics, r.Tracer.HTTPMiddleware(mux)),
+		ConnState:    metrics.HTTPConnState(r.Metrics),
+		// 保存PROXY协议连接，供处理器读取头部（此处不能读取，会阻塞accept循环）
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			if pc, ok := c.(*proxy_protocol.Conn); ok {
+				return context.WithValue(ctx, proxyConnKey{}, pc)
+			}
+			return ctx
+		},
 	}
 	// 暂时不用zkp节点
 	// go func() {
@@ -62,8 +99,17 @@ func (r *RealServer) Run() error {
 	// 	httpLogger.Println(zlist)
 	// 	httpLogger.Fatal(server.ListenAndServe())
 	// }()
-	if err := r.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		httpLogger.Printf("HTTP serve failed : %v", r.Addr)
+	ln, err := listen.TCP(r.Addr)
+	if err != nil {
+		r.Logger.Error("HTTP listen failed", "err", err)
+		return nil, err
+	}
+	return proxy_protocol.Wrap(ln, r.ProxyProtocol, 0), nil
+}
+
+func (r *RealServer) Serve(ln net.Listener) error {
+	if err := r.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+		r.Logger.Error("HTTP serve failed", "err", err)
 		return err
 	}
 	return nil
@@ -71,7 +117,7 @@ func (r *RealServer) Run() error {
 
 func (r *RealServer) Stop() error {
 	if err := r.server.Close(); err != nil {
-		httpLogger.Printf("server stop failed:%v\n", r.Addr)
+		r.Logger.Error("server stop failed", "err", err)
 		return err
 	}
 	return nil
@@ -84,6 +130,11 @@ func (r *RealServer) HelloHandler(w http.ResponseWriter, req *http.Request) {
 	io.WriteString(w, "hello! this is real server.\n")
 	io.WriteString(w, upath)
 	io.WriteString(w, realIP)
+	// 经过PROXY协议时 RemoteAddr 已经是原始客户端地址，这里输出完整头部
+	if pc, ok := req.Context().Value(proxyConnKey{}).(*proxy_protocol.Conn); ok {
+		h, _ := pc.Header()
+		io.WriteString(w, fmt.Sprintf("ProxyProtocol=%s,Peer=%s\n", h, pc.NetConn().RemoteAddr()))
+	}
 	io.WriteString(w, header)
 	// 写入一句hello
 
@@ -91,13 +142,16 @@ func (r *RealServer) HelloHandler(w http.ResponseWriter, req *http.Request) {
 
 func (r *RealServer) ErrorHandler(w http.ResponseWriter, req *http.Request) {
 	upath := "error handler"
+	tracing.InjectedFault(req.Context(), upath)
 	w.WriteHeader(500)
 	io.WriteString(w, upath)
 }
 
 func (r *RealServer) TimeoutHandler(w http.ResponseWriter, req *http.Request) {
 	upath := "timeout handler"
-	time.Sleep(6 * time.Second) //超时时间(经过这段时间后返回)
-	w.WriteHeader(200)          //返回状态码
+	delay := 6 * time.Second
+	tracing.InjectedLatency(req.Context(), delay)
+	time.Sleep(delay)  //超时时间(经过这段时间后返回)
+	w.WriteHeader(200) //返回状态码
 	io.WriteString(w, upath)
 }
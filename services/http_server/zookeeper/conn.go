@@ -0,0 +1,18 @@
+package zookeeper
+
+import "github.com/samuel/go-zookeeper/zk"
+
+// Conn ZkManager使用的zk操作，*zk.Conn 与测试中的内存实现（zktest）都满足该接口
+type Conn interface {
+	Exists(path string) (bool, *zk.Stat, error)
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Delete(path string, version int32) error
+	Get(path string) ([]byte, *zk.Stat, error)
+	GetW(path string) ([]byte, *zk.Stat, <-chan zk.Event, error)
+	Set(path string, data []byte, version int32) (*zk.Stat, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	ChildrenW(path string) ([]string, *zk.Stat, <-chan zk.Event, error)
+	Close()
+}
+
+var _ Conn = (*zk.Conn)(nil)
@@ -0,0 +1,114 @@
+package zookeeper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// 监听出错后的重试间隔
+const followRetryInterval = time.Second
+
+func (z *ZkManager) reportError(err error) {
+	z.logWarn("zookeeper watch error", "err", err)
+}
+
+// 等待watch事件或ctx取消，返回false表示ctx已取消
+func waitEvent(ctx context.Context, events <-chan zk.Event) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-events:
+		return true
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// FollowChildren 持续跟随节点的子节点列表，列表变化时调用fn，直到ctx取消。
+// 节点不存在时按空列表处理；连接错误时保持上一次的列表并重试
+func (z *ZkManager) FollowChildren(ctx context.Context, nodePath string, fn func([]string)) {
+	z.followChildren(ctx, nodePath, fn, z.reportError)
+}
+
+func (z *ZkManager) followChildren(ctx context.Context, nodePath string, fn func([]string), onErr func(error)) {
+	var last []string
+	first := true
+	for ctx.Err() == nil {
+		children, _, events, err := z.conn.ChildrenW(nodePath)
+		if errors.Is(err, zk.ErrNoNode) {
+			children, err = nil, nil
+		}
+		if err != nil {
+			onErr(err)
+			if !sleepCtx(ctx, followRetryInterval) {
+				return
+			}
+			continue
+		}
+		slices.Sort(children)
+		if first || !slices.Equal(children, last) {
+			fn(children)
+			last, first = children, false
+		}
+		// 节点不存在时没有watch，定期重试
+		if events == nil {
+			if !sleepCtx(ctx, followRetryInterval) {
+				return
+			}
+			continue
+		}
+		if !waitEvent(ctx, events) {
+			return
+		}
+	}
+}
+
+// FollowData 持续跟随节点数据，数据变化时调用fn，直到ctx取消。节点不存在时按空数据处理
+func (z *ZkManager) FollowData(ctx context.Context, nodePath string, fn func([]byte)) {
+	z.followData(ctx, nodePath, fn, z.reportError)
+}
+
+func (z *ZkManager) followData(ctx context.Context, nodePath string, fn func([]byte), onErr func(error)) {
+	var last []byte
+	first := true
+	for ctx.Err() == nil {
+		data, _, events, err := z.conn.GetW(nodePath)
+		if errors.Is(err, zk.ErrNoNode) {
+			data, err = nil, nil
+		}
+		if err != nil {
+			onErr(err)
+			if !sleepCtx(ctx, followRetryInterval) {
+				return
+			}
+			continue
+		}
+		if first || !bytes.Equal(data, last) {
+			fn(data)
+			last, first = data, false
+		}
+		if events == nil {
+			if !sleepCtx(ctx, followRetryInterval) {
+				return
+			}
+			continue
+		}
+		if !waitEvent(ctx, events) {
+			return
+		}
+	}
+}
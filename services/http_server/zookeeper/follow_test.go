@@ -0,0 +1,230 @@
+package zookeeper_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper"
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper/zktest"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+const waitTimeout = 3 * time.Second
+
+// 使用独立的内存zk，返回manager、manager使用的会话与另一个用于修改节点的会话
+func newMemoryManager(t *testing.T) (*zookeeper.ZkManager, *zktest.Conn, *zktest.Conn) {
+	t.Helper()
+	server := zktest.NewServer()
+	z := zookeeper.NewZkManager([]string{"zktest"})
+	var own *zktest.Conn
+	z.Dial = func(hosts []string, timeout time.Duration) (zookeeper.Conn, <-chan zk.Event, error) {
+		own = server.Connect()
+		return own, own.Events(), nil
+	}
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	other := server.Connect()
+	t.Cleanup(func() {
+		other.Close()
+		z.Close()
+	})
+	return z, own, other
+}
+
+func mustCreate(t *testing.T, c *zktest.Conn, p string, data string, flags int32) {
+	t.Helper()
+	if _, err := c.Create(p, []byte(data), flags, zk.WorldACL(zk.PermAll)); err != nil {
+		t.Fatalf("create %s: %v", p, err)
+	}
+}
+
+func next[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(waitTimeout):
+		t.Fatal("timed out waiting for update")
+	}
+	var zero T
+	return zero
+}
+
+// 在goroutine中运行follow，返回ctx取消后关闭的done通道
+func runFollow(ctx context.Context, follow func(ctx context.Context)) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		follow(ctx)
+	}()
+	return done
+}
+
+func waitDone(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(waitTimeout):
+		t.Fatal("follow did not stop after cancel")
+	}
+}
+
+func TestFollowChildren(t *testing.T) {
+	z, _, other := newMemoryManager(t)
+	mustCreate(t, other, "/svc", "", 0)
+
+	updates := make(chan []string, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runFollow(ctx, func(ctx context.Context) {
+		z.FollowChildren(ctx, "/svc", func(list []string) { updates <- list })
+	})
+
+	if got := next(t, updates); len(got) != 0 {
+		t.Fatalf("initial list = %v, want empty", got)
+	}
+	mustCreate(t, other, "/svc/b", "", zk.FlagEphemeral)
+	if got := next(t, updates); !slices.Equal(got, []string{"b"}) {
+		t.Fatalf("after add = %v", got)
+	}
+	mustCreate(t, other, "/svc/a", "", zk.FlagEphemeral)
+	if got := next(t, updates); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("after second add = %v, want sorted [a b]", got)
+	}
+	if err := other.Delete("/svc/b", -1); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(t, updates); !slices.Equal(got, []string{"a"}) {
+		t.Fatalf("after remove = %v", got)
+	}
+	// 会话关闭时临时节点被删除
+	other.Close()
+	if got := next(t, updates); len(got) != 0 {
+		t.Fatalf("after session close = %v, want empty", got)
+	}
+
+	cancel()
+	waitDone(t, done)
+}
+
+func TestFollowChildrenMissingNode(t *testing.T) {
+	z, _, other := newMemoryManager(t)
+
+	updates := make(chan []string, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runFollow(ctx, func(ctx context.Context) {
+		z.FollowChildren(ctx, "/missing/svc", func(list []string) { updates <- list })
+	})
+
+	// 节点不存在时按空列表处理，创建后（重试间隔内）跟上
+	if got := next(t, updates); got != nil {
+		t.Fatalf("missing node = %v, want nil", got)
+	}
+	mustCreate(t, other, "/missing", "", 0)
+	mustCreate(t, other, "/missing/svc", "", 0)
+	mustCreate(t, other, "/missing/svc/x", "", 0)
+	if got := next(t, updates); !slices.Equal(got, []string{"x"}) {
+		t.Fatalf("after create = %v", got)
+	}
+
+	cancel()
+	waitDone(t, done)
+}
+
+func TestFollowData(t *testing.T) {
+	z, _, other := newMemoryManager(t)
+
+	updates := make(chan string, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := runFollow(ctx, func(ctx context.Context) {
+		z.FollowData(ctx, "/desired", func(data []byte) { updates <- string(data) })
+	})
+
+	if got := next(t, updates); got != "" {
+		t.Fatalf("missing node = %q, want empty", got)
+	}
+	mustCreate(t, other, "/desired", "v1", 0)
+	if got := next(t, updates); got != "v1" {
+		t.Fatalf("after create = %q", got)
+	}
+	if _, err := other.Set("/desired", []byte("v2"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(t, updates); got != "v2" {
+		t.Fatalf("after set = %q", got)
+	}
+	// 数据不变时不回调
+	if _, err := other.Set("/desired", []byte("v2"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Delete("/desired", -1); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(t, updates); got != "" {
+		t.Fatalf("after delete = %q, want empty", got)
+	}
+
+	cancel()
+	waitDone(t, done)
+	select {
+	case got := <-updates:
+		t.Fatalf("unexpected update %q", got)
+	default:
+	}
+}
+
+func TestWatchServerListByPath(t *testing.T) {
+	z, _, other := newMemoryManager(t)
+	mustCreate(t, other, "/list", "", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	snapshots, errs := z.WatchServerListByPath(ctx, "/list")
+	if got := next(t, snapshots); len(got) != 0 {
+		t.Fatalf("initial list = %v", got)
+	}
+
+	// 不消费期间的变化只保留最新的一个快照
+	for _, name := range []string{"a", "b", "c"} {
+		mustCreate(t, other, "/list/"+name, "", 0)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got := next(t, snapshots); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("latest snapshot = %v", got)
+	}
+	select {
+	case got := <-snapshots:
+		t.Fatalf("stale snapshot %v was kept", got)
+	default:
+	}
+
+	cancel()
+	for _, closed := range []func() bool{
+		func() bool { _, ok := <-snapshots; return !ok },
+		func() bool { _, ok := <-errs; return !ok },
+	} {
+		result := make(chan bool, 1)
+		go func() { result <- closed() }()
+		select {
+		case ok := <-result:
+			if !ok {
+				t.Fatal("channel delivered a value after cancel")
+			}
+		case <-time.After(waitTimeout):
+			t.Fatal("channel not closed after cancel")
+		}
+	}
+}
+
+func TestWatchServerListByPathReportsErrors(t *testing.T) {
+	z, own, _ := newMemoryManager(t)
+	own.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, errs := z.WatchServerListByPath(ctx, "/list")
+	if err := next(t, errs); err != zk.ErrClosing {
+		t.Fatalf("err = %v, want %v", err, zk.ErrClosing)
+	}
+}
@@ -1,92 +1,317 @@
 package zookeeper
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/21Mile/go_downstreamer_server/services/logger"
 	"github.com/samuel/go-zookeeper/zk"
 )
 
+const defaultSessionTimeout = 5 * time.Second
+
 type ZkManager struct {
 	hosts      []string
-	conn       *zk.Conn
+	conn       Conn
 	pathPrefix string
+
+	SessionTimeout time.Duration  // 会话超时，默认5s
+	Username       string         // digest认证用户，设置后创建的节点只有该用户可写，其他人只读
+	Password       string         // digest认证密码
+	Logger         *logger.Logger // 为空时不输出日志
+	// Dial 建立连接并返回会话事件，为空时使用zk.Connect；测试中替换为zktest的内存实现
+	Dial func(hosts []string, timeout time.Duration) (Conn, <-chan zk.Event, error)
+
+	mu      sync.Mutex
+	state   zk.State
+	session int64
+	owned   map[string][]byte // 创建过的临时节点，会话过期重建后重新创建
+	cancel  context.CancelFunc
+}
+
+// 能返回会话ID的连接，*zk.Conn 与zktest的内存实现都满足
+type sessionConn interface {
+	SessionID() int64
 }
 
 func NewZkManager(hosts []string) *ZkManager {
-	return &ZkManager{hosts: hosts, pathPrefix: "/gateway_servers_"}
+	return &ZkManager{hosts: hosts, pathPrefix: "/gateway_servers_", state: zk.StateDisconnected, owned: make(map[string][]byte)}
+}
+
+// zkLogger 把zk库的日志转到实例日志
+type zkLogger struct{ z *ZkManager }
+
+func (l zkLogger) Printf(format string, args ...interface{}) {
+	if l.z.Logger != nil {
+		l.z.Logger.Debug(fmt.Sprintf(format, args...))
+	}
+}
+
+func (z *ZkManager) logWarn(msg string, args ...any) {
+	if z.Logger != nil {
+		z.Logger.Warn(msg, args...)
+	}
 }
 
-// 连接zk服务器
+func (z *ZkManager) logInfo(msg string, args ...any) {
+	if z.Logger != nil {
+		z.Logger.Info(msg, args...)
+	}
+}
+
+// 连接zk服务器并等待会话建立
 func (z *ZkManager) GetConnect() error {
-	conn, _, err := zk.Connect(z.hosts, 5*time.Second)
+	timeout := z.SessionTimeout
+	if timeout <= 0 {
+		timeout = defaultSessionTimeout
+	}
+	dial := z.Dial
+	if dial == nil {
+		dial = z.dial
+	}
+	conn, events, err := dial(z.hosts, timeout)
 	if err != nil {
 		return err
 	}
 	z.conn = conn
+
+	ctx, cancel := context.WithCancel(context.Background())
+	z.cancel = cancel
+	ready := make(chan struct{})
+	go z.watchSession(ctx, events, ready)
+	select {
+	case <-ready:
+	case <-time.After(timeout):
+		z.Close()
+		return fmt.Errorf("zookeeper %v: no session established within %v", z.hosts, timeout)
+	}
+
+	if z.Username != "" {
+		if c, ok := z.conn.(*zk.Conn); ok {
+			// 认证信息由zk库在重连后自动重新提交
+			if err := c.AddAuth("digest", []byte(z.Username+":"+z.Password)); err != nil {
+				z.Close()
+				return fmt.Errorf("zookeeper auth: %w", err)
+			}
+		}
+	}
 	return nil
 }
 
+func (z *ZkManager) dial(hosts []string, timeout time.Duration) (Conn, <-chan zk.Event, error) {
+	conn, events, err := zk.Connect(hosts, timeout, zk.WithLogger(zkLogger{z}))
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, events, nil
+}
+
+// 跟踪会话状态：会话过期后zk库会建立新会话，此时重新创建所有临时节点
+func (z *ZkManager) watchSession(ctx context.Context, events <-chan zk.Event, ready chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Type != zk.EventSession {
+				continue
+			}
+			z.mu.Lock()
+			z.state = ev.State
+			z.mu.Unlock()
+			switch ev.State {
+			case zk.StateHasSession:
+				z.onSession()
+				if ready != nil {
+					close(ready)
+					ready = nil
+				}
+			case zk.StateExpired:
+				z.logWarn("zookeeper session expired", "hosts", z.hosts)
+			case zk.StateDisconnected:
+				z.logWarn("zookeeper disconnected", "hosts", z.hosts)
+			case zk.StateAuthFailed:
+				z.logWarn("zookeeper auth failed", "hosts", z.hosts, "user", z.Username)
+			}
+		}
+	}
+}
+
+func (z *ZkManager) onSession() {
+	sc, ok := z.conn.(sessionConn)
+	if !ok {
+		return
+	}
+	id := sc.SessionID()
+	z.mu.Lock()
+	prev := z.session
+	z.session = id
+	z.mu.Unlock()
+	if prev == id {
+		z.logInfo("zookeeper reconnected", "session", fmt.Sprintf("0x%x", id))
+		return
+	}
+	z.logInfo("zookeeper session established", "session", fmt.Sprintf("0x%x", id))
+	if prev != 0 {
+		// 新会话，之前的临时节点已随旧会话删除
+		go z.reregister()
+	}
+}
+
+// 重新创建所有临时节点
+func (z *ZkManager) reregister() {
+	z.mu.Lock()
+	owned := make(map[string][]byte, len(z.owned))
+	for p, data := range z.owned {
+		owned[p] = data
+	}
+	z.mu.Unlock()
+	for p, data := range owned {
+		if err := z.createEphemeral(p, data); err != nil {
+			z.logWarn("re-register ephemeral node failed", "path", p, "err", err)
+			continue
+		}
+		z.logInfo("ephemeral node re-registered", "path", p)
+	}
+}
+
+// State 当前会话状态
+func (z *ZkManager) State() zk.State {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.state
+}
+
 // 关闭服务
 func (z *ZkManager) Close() {
-	z.conn.Close()
-	return
+	if z.cancel != nil {
+		z.cancel()
+	}
+	if z.conn != nil {
+		z.conn.Close()
+	}
 }
 
-// 获取配置
-func (z *ZkManager) GetPathData(nodePath string) ([]byte, *zk.Stat, error) {
-	return z.conn.Get(nodePath)
+// 创建节点使用的ACL
+func (z *ZkManager) acl() []zk.ACL {
+	if z.Username == "" {
+		return zk.WorldACL(zk.PermAll)
+	}
+	return append(zk.DigestACL(zk.PermAll, z.Username, z.Password), zk.WorldACL(zk.PermRead)...)
 }
 
-// 更新配置
-func (z *ZkManager) SetPathData(nodePath string, config []byte, version int32) (err error) {
-	ex, _, _ := z.conn.Exists(nodePath)
-	if !ex {
-		z.conn.Create(nodePath, config, 0, zk.WorldACL(zk.PermAll))
+// CreatePath 逐级创建持久节点，已存在时忽略
+func (z *ZkManager) CreatePath(nodePath string) error {
+	if nodePath == "/" || nodePath == "" {
 		return nil
 	}
-	_, dStat, err := z.GetPathData(nodePath)
-	if err != nil {
-		return
-	}
-	_, err = z.conn.Set(nodePath, config, dStat.Version)
-	if err != nil {
-		// fmt.Println("Update node error", err)
-		return err
+	cur := ""
+	for _, part := range strings.Split(strings.Trim(nodePath, "/"), "/") {
+		cur += "/" + part
+		ex, _, err := z.conn.Exists(cur)
+		if err != nil {
+			return err
+		}
+		if ex {
+			continue
+		}
+		if _, err := z.conn.Create(cur, nil, 0, z.acl()); err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return fmt.Errorf("create %s: %w", cur, err)
+		}
 	}
-	// fmt.Println("SetData ok")
-	return
+	return nil
 }
 
-// 创建临时节点
-func (z *ZkManager) RegistServerPath(nodePath, host string) (err error) {
+// 获取配置
+func (z *ZkManager) GetPathData(nodePath string) ([]byte, *zk.Stat, error) {
+	return z.conn.Get(nodePath)
+}
+
+// 更新配置，节点不存在时连同父节点一起创建；version为-1时不检查版本
+func (z *ZkManager) SetPathData(nodePath string, config []byte, version int32) (err error) {
 	ex, _, err := z.conn.Exists(nodePath)
 	if err != nil {
-		// fmt.Println("Exists error", nodePath)
 		return err
 	}
 	if !ex {
-		//持久化节点，思考题：如果不是持久化节点会怎么样？
-		_, err = z.conn.Create(nodePath, nil, 0, zk.WorldACL(zk.PermAll))
-		if err != nil {
-			// fmt.Println("Create error", nodePath)
+		if err := z.CreatePath(path.Dir(nodePath)); err != nil {
 			return err
 		}
+		_, err = z.conn.Create(nodePath, config, 0, z.acl())
+		if !errors.Is(err, zk.ErrNodeExists) {
+			return err
+		}
+		// 并发创建，继续按更新处理
 	}
-	//临时节点
-	subNodePath := nodePath + "/" + host
-	ex, _, err = z.conn.Exists(subNodePath)
+	_, err = z.conn.Set(nodePath, config, version)
+	return err
+}
+
+// 创建临时节点，已存在且属于其它（旧）会话时删除后重建
+func (z *ZkManager) createEphemeral(nodePath string, data []byte) error {
+	if err := z.CreatePath(path.Dir(nodePath)); err != nil {
+		return err
+	}
+	_, err := z.conn.Create(nodePath, data, zk.FlagEphemeral, z.acl())
+	if !errors.Is(err, zk.ErrNodeExists) {
+		return err
+	}
+	_, stat, err := z.conn.Exists(nodePath)
 	if err != nil {
-		// fmt.Println("Exists error", subNodePath)
 		return err
 	}
-	if !ex {
-		_, err = z.conn.Create(subNodePath, nil, zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
-		if err != nil {
-			// fmt.Println("Create error", subNodePath)
-			return err
-		}
+	z.mu.Lock()
+	session := z.session
+	z.mu.Unlock()
+	if stat != nil && stat.EphemeralOwner == session {
+		_, err = z.conn.Set(nodePath, data, -1)
+		return err
 	}
-	return
+	if err := z.conn.Delete(nodePath, -1); err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return err
+	}
+	_, err = z.conn.Create(nodePath, data, zk.FlagEphemeral, z.acl())
+	return err
+}
+
+// RegisterEphemeral 创建临时节点并记录，会话过期重建后自动重新创建
+func (z *ZkManager) RegisterEphemeral(nodePath string, data []byte) error {
+	if err := z.createEphemeral(nodePath, data); err != nil {
+		return err
+	}
+	z.mu.Lock()
+	z.owned[nodePath] = append([]byte(nil), data...)
+	z.mu.Unlock()
+	return nil
+}
+
+// Unregister 删除临时节点，不再自动重新创建
+func (z *ZkManager) Unregister(nodePath string) error {
+	z.mu.Lock()
+	delete(z.owned, nodePath)
+	z.mu.Unlock()
+	if err := z.conn.Delete(nodePath, -1); err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return err
+	}
+	return nil
+}
+
+// 创建临时节点，父路径不存在时逐级创建持久节点
+func (z *ZkManager) RegistServerPath(nodePath, host string) (err error) {
+	//持久化节点，思考题：如果不是持久化节点会怎么样？
+	if err := z.CreatePath(nodePath); err != nil {
+		return err
+	}
+	//临时节点
+	return z.RegisterEphemeral(nodePath+"/"+host, nil)
 }
 
 // 获取服务列表
@@ -95,54 +320,52 @@ func (z *ZkManager) GetServerListByPath(path string) (list []string, err error)
 	return
 }
 
-// watch机制，服务器有断开或者重连，收到消息
-func (z *ZkManager) WatchServerListByPath(path string) (chan []string, chan error) {
-	conn := z.conn
-	snapshots := make(chan []string)
-	errors := make(chan error)
+// 只保留最新的一个值，消费者慢时丢弃旧快照
+func sendLatest[T any](ch chan T, v T) {
+	for {
+		select {
+		case ch <- v:
+			return
+		default:
+		}
+		select {
+		case <-ch:
+		default:
+		}
+	}
+}
+
+// watch机制，服务器有断开或者重连，收到消息。
+// 快照通道只保留最新的列表；出错后自动重试，错误通道满时丢弃；ctx取消后两个通道关闭
+func (z *ZkManager) WatchServerListByPath(ctx context.Context, path string) (<-chan []string, <-chan error) {
+	snapshots := make(chan []string, 1)
+	errs := make(chan error, 1)
 	go func() {
-		for {
-			snapshot, _, events, err := conn.ChildrenW(path)
-			if err != nil {
-				errors <- err
-			}
-			snapshots <- snapshot
+		defer close(snapshots)
+		defer close(errs)
+		z.followChildren(ctx, path, func(list []string) { sendLatest(snapshots, list) }, func(err error) {
 			select {
-			case evt := <-events:
-				if evt.Err != nil {
-					errors <- evt.Err
-				}
-				// fmt.Printf("ChildrenW Event Path:%v, Type:%v\n", evt.Path, evt.Type)
+			case errs <- err:
+			default:
 			}
-		}
+		})
 	}()
-
-	return snapshots, errors
+	return snapshots, errs
 }
 
-// watch机制，监听节点值变化
-func (z *ZkManager) WatchPathData(nodePath string) (chan []byte, chan error) {
-	conn := z.conn
-	snapshots := make(chan []byte)
-	errors := make(chan error)
-
+// watch机制，监听节点值变化，通道语义同 WatchServerListByPath
+func (z *ZkManager) WatchPathData(ctx context.Context, nodePath string) (<-chan []byte, <-chan error) {
+	snapshots := make(chan []byte, 1)
+	errs := make(chan error, 1)
 	go func() {
-		for {
-			dataBuf, _, events, err := conn.GetW(nodePath)
-			if err != nil {
-				errors <- err
-				return
-			}
-			snapshots <- dataBuf
+		defer close(snapshots)
+		defer close(errs)
+		z.followData(ctx, nodePath, func(data []byte) { sendLatest(snapshots, data) }, func(err error) {
 			select {
-			case evt := <-events:
-				if evt.Err != nil {
-					errors <- evt.Err
-					return
-				}
-				// fmt.Printf("GetW Event Path:%v, Type:%v\n", evt.Path, evt.Type)
+			case errs <- err:
+			default:
 			}
-		}
+		})
 	}()
-	return snapshots, errors
+	return snapshots, errs
 }
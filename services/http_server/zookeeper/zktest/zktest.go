@@ -0,0 +1,338 @@
+// Package zktest 测试用的进程内zk实现，通过 ZkManager.Dial 接入，不连接真实zk
+package zktest
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Server 进程内的zk兼容实现：节点树、临时节点与一次性watch
+type Server struct {
+	mu       sync.Mutex
+	nodes    map[string]*node
+	sessions int64
+	zxid     int64
+}
+
+type node struct {
+	data         []byte
+	stat         zk.Stat
+	children     map[string]struct{}
+	dataWatches  []chan zk.Event
+	childWatches []chan zk.Event
+}
+
+// NewServer 新建一个空的节点树，每个测试使用独立的实例
+func NewServer() *Server {
+	return &Server{nodes: map[string]*node{
+		"/": {children: make(map[string]struct{})},
+	}}
+}
+
+// Connect 新建一个会话，会话关闭时删除其创建的临时节点
+func (s *Server) Connect() *Conn {
+	s.mu.Lock()
+	s.sessions++
+	c := &Conn{server: s, session: s.sessions, events: make(chan zk.Event, 16)}
+	s.mu.Unlock()
+	c.sessionEvent(zk.StateHasSession)
+	return c
+}
+
+// Dial 可赋值给 ZkManager.Dial，每次调用新建一个会话
+func (s *Server) Dial(hosts []string, timeout time.Duration) (zookeeper.Conn, <-chan zk.Event, error) {
+	c := s.Connect()
+	return c, c.Events(), nil
+}
+
+// Conn 内存实现的一个会话
+type Conn struct {
+	server  *Server
+	events  chan zk.Event
+	mu      sync.Mutex
+	session int64
+	closed  bool
+}
+
+// Events 会话事件，与 zk.Connect 返回的通道一致
+func (c *Conn) Events() <-chan zk.Event {
+	return c.events
+}
+
+func (c *Conn) SessionID() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session
+}
+
+// 事件通道满时丢弃，与zk库一致
+func (c *Conn) sessionEvent(state zk.State) {
+	select {
+	case c.events <- zk.Event{Type: zk.EventSession, State: state}:
+	default:
+	}
+}
+
+// Expire 模拟会话过期：删除临时节点后以新会话重新连上
+func (c *Conn) Expire() {
+	if c.check("/") != nil {
+		return
+	}
+	s := c.server
+	s.mu.Lock()
+	s.deleteEphemerals(c.SessionID())
+	s.sessions++
+	session := s.sessions
+	s.mu.Unlock()
+	c.sessionEvent(zk.StateExpired)
+	c.mu.Lock()
+	c.session = session
+	c.mu.Unlock()
+	c.sessionEvent(zk.StateHasSession)
+}
+
+// 删除会话的临时节点，调用方持有锁
+func (s *Server) deleteEphemerals(session int64) {
+	for p, n := range s.nodes {
+		if n.stat.EphemeralOwner == session {
+			s.delete(p, -1)
+		}
+	}
+}
+
+var _ zookeeper.Conn = (*Conn)(nil)
+
+func checkPath(p string) error {
+	if p == "" || p[0] != '/' || (len(p) > 1 && strings.HasSuffix(p, "/")) || path.Clean(p) != p {
+		return zk.ErrInvalidPath
+	}
+	return nil
+}
+
+func (c *Conn) check(p string) error {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return zk.ErrClosing
+	}
+	return checkPath(p)
+}
+
+// 触发并清空watch，调用方持有锁
+func fire(watches []chan zk.Event, typ zk.EventType, p string) {
+	for _, ch := range watches {
+		ch <- zk.Event{Type: typ, State: zk.StateHasSession, Path: p}
+		close(ch)
+	}
+}
+
+func (c *Conn) Exists(p string) (bool, *zk.Stat, error) {
+	if err := c.check(p); err != nil {
+		return false, nil, err
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[p]
+	if !ok {
+		return false, nil, nil
+	}
+	stat := n.stat
+	return true, &stat, nil
+}
+
+func (c *Conn) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	if err := c.check(p); err != nil {
+		return "", err
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	parentPath := path.Dir(p)
+	parent, ok := s.nodes[parentPath]
+	if !ok {
+		return "", zk.ErrNoNode
+	}
+	if parent.stat.EphemeralOwner != 0 {
+		return "", zk.ErrNoChildrenForEphemerals
+	}
+	if flags&zk.FlagSequence != 0 {
+		p = fmt.Sprintf("%s%010d", p, parent.stat.Cversion)
+	}
+	if _, exists := s.nodes[p]; exists {
+		return "", zk.ErrNodeExists
+	}
+	s.zxid++
+	now := time.Now().UnixMilli()
+	n := &node{
+		data:     append([]byte(nil), data...),
+		children: make(map[string]struct{}),
+		stat: zk.Stat{
+			Czxid: s.zxid, Mzxid: s.zxid, Pzxid: s.zxid,
+			Ctime: now, Mtime: now,
+			DataLength: int32(len(data)),
+		},
+	}
+	if flags&zk.FlagEphemeral != 0 {
+		n.stat.EphemeralOwner = c.SessionID()
+	}
+	s.nodes[p] = n
+	parent.children[path.Base(p)] = struct{}{}
+	parent.stat.Cversion++
+	parent.stat.NumChildren = int32(len(parent.children))
+	parent.stat.Pzxid = s.zxid
+	fire(parent.childWatches, zk.EventNodeChildrenChanged, parentPath)
+	parent.childWatches = nil
+	return p, nil
+}
+
+func (c *Conn) Delete(p string, version int32) error {
+	if err := c.check(p); err != nil {
+		return err
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.delete(p, version)
+}
+
+// 删除节点，调用方持有锁
+func (s *Server) delete(p string, version int32) error {
+	n, ok := s.nodes[p]
+	if !ok || p == "/" {
+		return zk.ErrNoNode
+	}
+	if version != -1 && version != n.stat.Version {
+		return zk.ErrBadVersion
+	}
+	if len(n.children) > 0 {
+		return zk.ErrNotEmpty
+	}
+	s.zxid++
+	delete(s.nodes, p)
+	fire(n.dataWatches, zk.EventNodeDeleted, p)
+	fire(n.childWatches, zk.EventNodeDeleted, p)
+	parentPath := path.Dir(p)
+	parent := s.nodes[parentPath]
+	delete(parent.children, path.Base(p))
+	parent.stat.Cversion++
+	parent.stat.NumChildren = int32(len(parent.children))
+	parent.stat.Pzxid = s.zxid
+	fire(parent.childWatches, zk.EventNodeChildrenChanged, parentPath)
+	parent.childWatches = nil
+	return nil
+}
+
+func (c *Conn) get(p string, watch bool) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	if err := c.check(p); err != nil {
+		return nil, nil, nil, err
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	var ch chan zk.Event
+	if watch {
+		ch = make(chan zk.Event, 1)
+		n.dataWatches = append(n.dataWatches, ch)
+	}
+	stat := n.stat
+	return append([]byte(nil), n.data...), &stat, ch, nil
+}
+
+func (c *Conn) Get(p string) ([]byte, *zk.Stat, error) {
+	data, stat, _, err := c.get(p, false)
+	return data, stat, err
+}
+
+func (c *Conn) GetW(p string) ([]byte, *zk.Stat, <-chan zk.Event, error) {
+	return c.get(p, true)
+}
+
+func (c *Conn) Set(p string, data []byte, version int32) (*zk.Stat, error) {
+	if err := c.check(p); err != nil {
+		return nil, err
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, zk.ErrNoNode
+	}
+	if version != -1 && version != n.stat.Version {
+		return nil, zk.ErrBadVersion
+	}
+	s.zxid++
+	n.data = append([]byte(nil), data...)
+	n.stat.Version++
+	n.stat.Mzxid = s.zxid
+	n.stat.Mtime = time.Now().UnixMilli()
+	n.stat.DataLength = int32(len(data))
+	fire(n.dataWatches, zk.EventNodeDataChanged, p)
+	n.dataWatches = nil
+	stat := n.stat
+	return &stat, nil
+}
+
+func (c *Conn) children(p string, watch bool) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	if err := c.check(p); err != nil {
+		return nil, nil, nil, err
+	}
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, nil, nil, zk.ErrNoNode
+	}
+	list := make([]string, 0, len(n.children))
+	for name := range n.children {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	var ch chan zk.Event
+	if watch {
+		ch = make(chan zk.Event, 1)
+		n.childWatches = append(n.childWatches, ch)
+	}
+	stat := n.stat
+	return list, &stat, ch, nil
+}
+
+func (c *Conn) Children(p string) ([]string, *zk.Stat, error) {
+	list, stat, _, err := c.children(p, false)
+	return list, stat, err
+}
+
+func (c *Conn) ChildrenW(p string) ([]string, *zk.Stat, <-chan zk.Event, error) {
+	return c.children(p, true)
+}
+
+// Close 关闭会话并删除其临时节点
+func (c *Conn) Close() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	c.mu.Unlock()
+
+	s := c.server
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteEphemerals(c.SessionID())
+	close(c.events)
+}
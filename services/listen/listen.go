@@ -0,0 +1,87 @@
+package listen
+
+import (
+	"net"
+	"strconv"
+	"sync"
+)
+
+// 临时端口（:0）由调用方先绑定并保留，服务监听同一端口时直接取走保留的监听，
+// 选出端口与服务开始监听之间端口不会被其它进程占用
+var (
+	mu  sync.Mutex
+	tcp = make(map[int]net.Listener)
+	udp = make(map[int]*net.UDPConn)
+)
+
+// HoldTCP 在host上绑定系统分配的tcp端口并保留，返回端口与释放函数。
+// 释放时服务没有取走的监听被关闭
+func HoldTCP(host string) (int, func(), error) {
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, nil, err
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	mu.Lock()
+	tcp[port] = ln
+	mu.Unlock()
+	return port, func() {
+		mu.Lock()
+		ln, ok := tcp[port]
+		delete(tcp, port)
+		mu.Unlock()
+		if ok {
+			ln.Close()
+		}
+	}, nil
+}
+
+// HoldUDP 与HoldTCP相同，保留udp端口
+func HoldUDP(host string) (int, func(), error) {
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, nil, err
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	mu.Lock()
+	udp[port] = conn.(*net.UDPConn)
+	mu.Unlock()
+	return port, func() {
+		mu.Lock()
+		conn, ok := udp[port]
+		delete(udp, port)
+		mu.Unlock()
+		if ok {
+			conn.Close()
+		}
+	}, nil
+}
+
+// TCP 监听addr，端口已被保留时返回保留的监听
+func TCP(addr string) (net.Listener, error) {
+	if _, p, err := net.SplitHostPort(addr); err == nil {
+		port, _ := strconv.Atoi(p)
+		mu.Lock()
+		ln, ok := tcp[port]
+		delete(tcp, port)
+		mu.Unlock()
+		if ok {
+			return ln, nil
+		}
+	}
+	return net.Listen("tcp", addr)
+}
+
+// UDP 监听addr，端口已被保留时返回保留的连接
+func UDP(addr *net.UDPAddr) (*net.UDPConn, error) {
+	if addr != nil {
+		mu.Lock()
+		conn, ok := udp[addr.Port]
+		delete(udp, addr.Port)
+		mu.Unlock()
+		if ok {
+			return conn, nil
+		}
+	}
+	return net.ListenUDP("udp", addr)
+}
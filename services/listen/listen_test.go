@@ -0,0 +1,63 @@
+package listen
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestHoldTCP(t *testing.T) {
+	port, release, err := HoldTCP("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 保留期间端口不能被其它监听占用
+	if ln, err := net.Listen("tcp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+		ln.Close()
+		t.Fatal("held port could be bound again")
+	}
+	ln, err := TCP("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if got := ln.Addr().(*net.TCPAddr).Port; got != port {
+		t.Fatalf("listener port = %d, want %d", got, port)
+	}
+	// 已被取走的监听不会在释放时关闭
+	release()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("taken listener closed by release: %v", err)
+	}
+	conn.Close()
+}
+
+func TestHoldTCPReleaseUnclaimed(t *testing.T) {
+	port, release, err := HoldTCP("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	ln, err := TCP("127.0.0.1:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("port not freed after release: %v", err)
+	}
+	ln.Close()
+}
+
+func TestHoldUDP(t *testing.T) {
+	port, release, err := HoldUDP("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	conn, err := UDP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if got := conn.LocalAddr().(*net.UDPAddr).Port; got != port {
+		t.Fatalf("conn port = %d, want %d", got, port)
+	}
+}
@@ -0,0 +1,214 @@
+package load_balance
+
+import (
+	"hash/crc32"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// RandomBalance 随机
+type RandomBalance struct {
+	mu    sync.Mutex
+	nodes []string
+}
+
+func (r *RandomBalance) Add(params ...string) error {
+	addr, _, err := parseParams(params)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.nodes = append(r.nodes, addr)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *RandomBalance) Get(string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.nodes) == 0 {
+		return "", ErrNoNodes
+	}
+	return r.nodes[rand.Intn(len(r.nodes))], nil
+}
+
+// RoundRobinBalance 轮询
+type RoundRobinBalance struct {
+	mu    sync.Mutex
+	nodes []string
+	next  int
+}
+
+func (r *RoundRobinBalance) Add(params ...string) error {
+	addr, _, err := parseParams(params)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.nodes = append(r.nodes, addr)
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *RoundRobinBalance) Get(string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.nodes) == 0 {
+		return "", ErrNoNodes
+	}
+	addr := r.nodes[r.next%len(r.nodes)]
+	r.next = (r.next + 1) % len(r.nodes)
+	return addr, nil
+}
+
+type weightNode struct {
+	addr          string
+	weight        int
+	currentWeight int
+}
+
+// WeightRoundRobinBalance 平滑加权轮询（与nginx一致）：
+// 每次所有节点的当前权重加上各自权重，选出当前权重最大的节点，再减去总权重
+type WeightRoundRobinBalance struct {
+	mu    sync.Mutex
+	nodes []*weightNode
+}
+
+func (r *WeightRoundRobinBalance) Add(params ...string) error {
+	addr, weight, err := parseParams(params)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.nodes = append(r.nodes, &weightNode{addr: addr, weight: weight})
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *WeightRoundRobinBalance) Get(string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.nodes) == 0 {
+		return "", ErrNoNodes
+	}
+	total := 0
+	var best *weightNode
+	for _, n := range r.nodes {
+		total += n.weight
+		n.currentWeight += n.weight
+		if best == nil || n.currentWeight > best.currentWeight {
+			best = n
+		}
+	}
+	best.currentWeight -= total
+	return best.addr, nil
+}
+
+const defaultReplicas = 100
+
+// ConsistentHashBalance 一致性哈希，每个节点在环上放置 replicas*权重 个虚拟节点
+type ConsistentHashBalance struct {
+	mu       sync.RWMutex
+	hash     func([]byte) uint32
+	replicas int
+	keys     []uint32 // 已排序的虚拟节点哈希
+	ring     map[uint32]string
+}
+
+// NewConsistentHashBalance hash为nil时使用crc32
+func NewConsistentHashBalance(replicas int, hash func([]byte) uint32) *ConsistentHashBalance {
+	if hash == nil {
+		hash = crc32.ChecksumIEEE
+	}
+	if replicas <= 0 {
+		replicas = defaultReplicas
+	}
+	return &ConsistentHashBalance{hash: hash, replicas: replicas, ring: make(map[uint32]string)}
+}
+
+func (c *ConsistentHashBalance) Add(params ...string) error {
+	addr, weight, err := parseParams(params)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := 0; i < c.replicas*weight; i++ {
+		h := c.hash([]byte(strconv.Itoa(i) + addr))
+		c.keys = append(c.keys, h)
+		c.ring[h] = addr
+	}
+	sort.Slice(c.keys, func(i, j int) bool { return c.keys[i] < c.keys[j] })
+	return nil
+}
+
+// Get 顺时针找到第一个不小于key哈希的虚拟节点
+func (c *ConsistentHashBalance) Get(key string) (string, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.keys) == 0 {
+		return "", ErrNoNodes
+	}
+	h := c.hash([]byte(key))
+	i := sort.Search(len(c.keys), func(i int) bool { return c.keys[i] >= h })
+	if i == len(c.keys) {
+		i = 0
+	}
+	return c.ring[c.keys[i]], nil
+}
+
+type connNode struct {
+	addr   string
+	weight int
+	active int
+}
+
+// LeastConnBalance 最少连接：选择 进行中请求数/权重 最小的节点，相同时轮流选择
+type LeastConnBalance struct {
+	mu    sync.Mutex
+	nodes []*connNode
+	next  int
+}
+
+func (l *LeastConnBalance) Add(params ...string) error {
+	addr, weight, err := parseParams(params)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.nodes = append(l.nodes, &connNode{addr: addr, weight: weight})
+	l.mu.Unlock()
+	return nil
+}
+
+// Get 选中的节点进行中请求数加一，调用方结束后必须调用Done
+func (l *LeastConnBalance) Get(string) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.nodes) == 0 {
+		return "", ErrNoNodes
+	}
+	var best *connNode
+	for i := range l.nodes {
+		n := l.nodes[(l.next+i)%len(l.nodes)]
+		if best == nil || n.active*best.weight < best.active*n.weight {
+			best = n
+		}
+	}
+	l.next = (l.next + 1) % len(l.nodes)
+	best.active++
+	return best.addr, nil
+}
+
+func (l *LeastConnBalance) Done(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, n := range l.nodes {
+		if n.addr == addr && n.active > 0 {
+			n.active--
+			return
+		}
+	}
+}
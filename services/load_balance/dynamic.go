@@ -0,0 +1,87 @@
+package load_balance
+
+import (
+	"strconv"
+	"sync"
+)
+
+// Node 负载均衡节点，Weight为0时按1处理
+type Node struct {
+	Addr   string
+	Weight int
+}
+
+// Dynamic 节点列表可以整体替换的负载均衡器（如跟随服务发现），替换时按新列表重建内部的负载均衡器
+type Dynamic struct {
+	typ   LbType
+	mu    sync.RWMutex
+	lb    LoadBalance
+	nodes []Node
+}
+
+func NewDynamic(typ LbType) *Dynamic {
+	return &Dynamic{typ: typ, lb: LoadBalanceFactory(typ)}
+}
+
+func (d *Dynamic) Add(params ...string) error {
+	addr, weight, err := parseParams(params)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.lb.Add(params...); err != nil {
+		return err
+	}
+	d.nodes = append(d.nodes, Node{Addr: addr, Weight: weight})
+	return nil
+}
+
+func (d *Dynamic) Get(key string) (string, error) {
+	d.mu.RLock()
+	lb := d.lb
+	d.mu.RUnlock()
+	return lb.Get(key)
+}
+
+// Done 转发给内部的最少连接负载均衡器，替换前选中的节点不再计数
+func (d *Dynamic) Done(addr string) {
+	d.mu.RLock()
+	lb := d.lb
+	d.mu.RUnlock()
+	if r, ok := lb.(Releaser); ok {
+		r.Done(addr)
+	}
+}
+
+// Update 用新的节点列表替换当前节点
+func (d *Dynamic) Update(nodes []Node) error {
+	lb := LoadBalanceFactory(d.typ)
+	for _, n := range nodes {
+		weight := ""
+		if n.Weight != 0 {
+			weight = strconv.Itoa(n.Weight)
+		}
+		if err := lb.Add(n.Addr, weight); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	d.lb = lb
+	d.nodes = append([]Node(nil), nodes...)
+	d.mu.Unlock()
+	return nil
+}
+
+// Nodes 当前节点列表
+func (d *Dynamic) Nodes() []Node {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]Node(nil), d.nodes...)
+}
+
+func (d *Dynamic) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.nodes)
+}
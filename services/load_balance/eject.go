@@ -0,0 +1,66 @@
+package load_balance
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultEjectTime = 10 * time.Second
+
+// Ejector 被动健康检查：节点连续失败 maxFails 次后摘除 ejectTime，到期后自动恢复
+type Ejector struct {
+	maxFails  int
+	ejectTime time.Duration
+
+	mu    sync.Mutex
+	nodes map[string]*nodeHealth
+}
+
+type nodeHealth struct {
+	fails        int
+	ejectedUntil time.Time
+}
+
+// NewEjector maxFails<=0时不摘除节点
+func NewEjector(maxFails int, ejectTime time.Duration) *Ejector {
+	if ejectTime <= 0 {
+		ejectTime = defaultEjectTime
+	}
+	return &Ejector{maxFails: maxFails, ejectTime: ejectTime, nodes: make(map[string]*nodeHealth)}
+}
+
+// Ejected 节点当前是否处于摘除状态
+func (e *Ejector) Ejected(addr string) bool {
+	if e == nil || e.maxFails <= 0 {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n, ok := e.nodes[addr]
+	return ok && time.Now().Before(n.ejectedUntil)
+}
+
+// Report 记录一次请求结果，返回true表示该节点因本次失败被摘除
+func (e *Ejector) Report(addr string, ok bool) bool {
+	if e == nil || e.maxFails <= 0 {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	n, exists := e.nodes[addr]
+	if !exists {
+		n = &nodeHealth{}
+		e.nodes[addr] = n
+	}
+	if ok {
+		n.fails = 0
+		return false
+	}
+	n.fails++
+	if n.fails < e.maxFails {
+		return false
+	}
+	n.fails = 0
+	n.ejectedUntil = time.Now().Add(e.ejectTime)
+	return true
+}
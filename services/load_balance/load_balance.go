@@ -0,0 +1,81 @@
+package load_balance
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type LbType int
+
+const (
+	LbRandom LbType = iota
+	LbRoundRobin
+	LbWeightRoundRobin
+	LbConsistentHash
+	LbLeastConn
+)
+
+var lbNames = map[string]LbType{
+	"random":             LbRandom,
+	"round-robin":        LbRoundRobin,
+	"weight-round-robin": LbWeightRoundRobin,
+	"consistent-hash":    LbConsistentHash,
+	"least-conn":         LbLeastConn,
+}
+
+var ErrNoNodes = errors.New("load balance: no available nodes")
+
+// LoadBalance 负载均衡器，Add的参数为 地址[, 权重]
+type LoadBalance interface {
+	Add(params ...string) error
+	Get(key string) (string, error) // key仅一致性哈希使用
+}
+
+// Releaser 按进行中请求数选择节点的负载均衡器实现该接口，请求结束后调用Done
+type Releaser interface {
+	Done(addr string)
+}
+
+// ParseType 按名称解析负载均衡类型，空字符串为round-robin
+func ParseType(name string) (LbType, error) {
+	if name == "" {
+		return LbRoundRobin, nil
+	}
+	t, ok := lbNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown balance %q, available: random, round-robin, weight-round-robin, consistent-hash, least-conn", name)
+	}
+	return t, nil
+}
+
+func LoadBalanceFactory(lbType LbType) LoadBalance {
+	switch lbType {
+	case LbRandom:
+		return &RandomBalance{}
+	case LbWeightRoundRobin:
+		return &WeightRoundRobinBalance{}
+	case LbConsistentHash:
+		return NewConsistentHashBalance(defaultReplicas, nil)
+	case LbLeastConn:
+		return &LeastConnBalance{}
+	default:
+		return &RoundRobinBalance{}
+	}
+}
+
+// 解析Add的参数，权重缺省为1
+func parseParams(params []string) (addr string, weight int, err error) {
+	if len(params) == 0 || params[0] == "" {
+		return "", 0, fmt.Errorf("load balance: missing address")
+	}
+	weight = 1
+	if len(params) > 1 && params[1] != "" {
+		weight, err = strconv.Atoi(params[1])
+		if err != nil || weight <= 0 {
+			return "", 0, fmt.Errorf("load balance: invalid weight %q for %s", params[1], params[0])
+		}
+	}
+	return params[0], weight, nil
+}
@@ -0,0 +1,280 @@
+package load_balance
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newBalance(t *testing.T, typ LbType, nodes ...string) LoadBalance {
+	t.Helper()
+	lb := LoadBalanceFactory(typ)
+	for _, n := range nodes {
+		// "addr*weight" 拆为地址与权重
+		addr, weight, _ := strings.Cut(n, "*")
+		if err := lb.Add(addr, weight); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lb
+}
+
+func pickN(t *testing.T, lb LoadBalance, n int, key func(i int) string) map[string]int {
+	t.Helper()
+	counts := make(map[string]int)
+	for i := range n {
+		addr, err := lb.Get(key(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[addr]++
+	}
+	return counts
+}
+
+func noKey(int) string { return "" }
+
+func TestParseType(t *testing.T) {
+	for name, want := range map[string]LbType{
+		"":                   LbRoundRobin,
+		"random":             LbRandom,
+		"Round-Robin":        LbRoundRobin,
+		"weight-round-robin": LbWeightRoundRobin,
+		"consistent-hash":    LbConsistentHash,
+		"least-conn":         LbLeastConn,
+	} {
+		if got, err := ParseType(name); err != nil || got != want {
+			t.Errorf("ParseType(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseType("fastest"); err == nil {
+		t.Error("unknown balance accepted")
+	}
+}
+
+func TestAddValidation(t *testing.T) {
+	for _, typ := range []LbType{LbRandom, LbRoundRobin, LbWeightRoundRobin, LbConsistentHash, LbLeastConn} {
+		lb := LoadBalanceFactory(typ)
+		if _, err := lb.Get("k"); err != ErrNoNodes {
+			t.Errorf("type %d: empty Get = %v, want ErrNoNodes", typ, err)
+		}
+		for _, params := range [][]string{nil, {""}, {"a:1", "0"}, {"a:1", "-1"}, {"a:1", "x"}} {
+			if err := lb.Add(params...); err == nil {
+				t.Errorf("type %d: Add(%q) accepted", typ, params)
+			}
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	lb := newBalance(t, LbRoundRobin, "a", "b", "c")
+	var got []string
+	for range 6 {
+		addr, _ := lb.Get("")
+		got = append(got, addr)
+	}
+	if want := []string{"a", "b", "c", "a", "b", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("sequence = %v, want %v", got, want)
+	}
+}
+
+func TestRandomDistribution(t *testing.T) {
+	counts := pickN(t, newBalance(t, LbRandom, "a", "b", "c"), 3000, noKey)
+	for _, addr := range []string{"a", "b", "c"} {
+		if counts[addr] < 800 {
+			t.Errorf("random picked %s %d times out of 3000: %v", addr, counts[addr], counts)
+		}
+	}
+}
+
+func TestWeightRoundRobin(t *testing.T) {
+	// 平滑加权轮询：权重5/1/1时与nginx的序列一致，低权重节点不会连续被跳过
+	lb := newBalance(t, LbWeightRoundRobin, "a*5", "b*1", "c*1")
+	var got []string
+	for range 7 {
+		addr, _ := lb.Get("")
+		got = append(got, addr)
+	}
+	if want := []string{"a", "a", "b", "a", "c", "a", "a"}; !slices.Equal(got, want) {
+		t.Fatalf("sequence = %v, want %v", got, want)
+	}
+	counts := pickN(t, lb, 700, noKey)
+	if counts["a"] != 500 || counts["b"] != 100 || counts["c"] != 100 {
+		t.Fatalf("distribution = %v, want 500/100/100", counts)
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	lb := newBalance(t, LbConsistentHash, "10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80").(*ConsistentHashBalance)
+	key := func(i int) string { return "192.168.0." + strconv.Itoa(i%256) + "/" + strconv.Itoa(i) }
+
+	// 同一个key总是得到同一节点
+	before := make(map[string]string)
+	for i := range 3000 {
+		addr, _ := lb.Get(key(i))
+		if again, _ := lb.Get(key(i)); again != addr {
+			t.Fatalf("key %s mapped to %s then %s", key(i), addr, again)
+		}
+		before[key(i)] = addr
+	}
+	counts := make(map[string]int)
+	for _, addr := range before {
+		counts[addr]++
+	}
+	for addr, n := range counts {
+		if n < 600 {
+			t.Errorf("%s got %d of 3000 keys: %v", addr, n, counts)
+		}
+	}
+
+	// 加入节点后，变化的key只会移到新节点，且约占1/4
+	if err := lb.Add("10.0.0.4:80"); err != nil {
+		t.Fatal(err)
+	}
+	moved := 0
+	for k, old := range before {
+		addr, _ := lb.Get(k)
+		if addr == old {
+			continue
+		}
+		if addr != "10.0.0.4:80" {
+			t.Fatalf("key %s moved from %s to %s, not to the new node", k, old, addr)
+		}
+		moved++
+	}
+	if moved < 400 || moved > 1200 {
+		t.Fatalf("%d of 3000 keys moved after adding a fourth node", moved)
+	}
+}
+
+func TestConsistentHashWeight(t *testing.T) {
+	lb := newBalance(t, LbConsistentHash, "a*3", "b*1")
+	counts := pickN(t, lb, 4000, func(i int) string { return "key" + strconv.Itoa(i) })
+	if counts["a"] < 2*counts["b"] {
+		t.Fatalf("weight 3 node got %d keys, weight 1 node %d", counts["a"], counts["b"])
+	}
+}
+
+func TestLeastConn(t *testing.T) {
+	lb := newBalance(t, LbLeastConn, "a", "b").(*LeastConnBalance)
+	// a上有一个进行中的请求，之后的请求都选择b，直到两者相同
+	first, _ := lb.Get("")
+	other := "b"
+	if first == "b" {
+		other = "a"
+	}
+	second, _ := lb.Get("")
+	if second != other {
+		t.Fatalf("second pick = %s, want the idle node %s", second, other)
+	}
+	lb.Done(first)
+	for range 3 {
+		addr, _ := lb.Get("")
+		if addr != first {
+			t.Fatalf("picked %s with active counts %s=0, %s=1", addr, first, other)
+		}
+		lb.Done(addr)
+	}
+	// 相同时轮流选择
+	lb.Done(second)
+	counts := map[string]int{}
+	for range 10 {
+		addr, _ := lb.Get("")
+		counts[addr]++
+		lb.Done(addr)
+	}
+	if counts["a"] != 5 || counts["b"] != 5 {
+		t.Fatalf("idle picks = %v, want an even split", counts)
+	}
+	// 未知节点与多余的Done不影响计数
+	lb.Done("unknown")
+	lb.Done("a")
+	for _, n := range lb.nodes {
+		if n.active != 0 {
+			t.Fatalf("%s active = %d after all requests finished", n.addr, n.active)
+		}
+	}
+}
+
+func TestLeastConnWeight(t *testing.T) {
+	lb := newBalance(t, LbLeastConn, "a*2", "b*1").(*LeastConnBalance)
+	// 请求都未结束时，进行中请求数按权重分配
+	counts := pickN(t, lb, 6, noKey)
+	if counts["a"] != 4 || counts["b"] != 2 {
+		t.Fatalf("active = %v, want a=4 b=2", counts)
+	}
+}
+
+func TestDynamic(t *testing.T) {
+	d := NewDynamic(LbLeastConn)
+	if err := d.Add("a", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if addr, _ := d.Get(""); addr != "a" || d.Len() != 1 {
+		t.Fatalf("Get = %s, Len = %d", addr, d.Len())
+	}
+	if err := d.Update([]Node{{Addr: "b"}, {Addr: "c", Weight: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	// 替换前选中的节点在新的均衡器中不存在，Done不影响计数
+	d.Done("a")
+	if want := []Node{{Addr: "b"}, {Addr: "c", Weight: 3}}; !slices.Equal(d.Nodes(), want) {
+		t.Fatalf("nodes = %v, want %v", d.Nodes(), want)
+	}
+	counts := pickN(t, d, 4, noKey)
+	if counts["c"] != 3 || counts["b"] != 1 {
+		t.Fatalf("active after update = %v", counts)
+	}
+	// 无效的列表不替换当前节点
+	if err := d.Update([]Node{{Addr: "d", Weight: -1}}); err == nil {
+		t.Fatal("invalid weight accepted")
+	}
+	if d.Len() != 2 {
+		t.Fatalf("nodes replaced by an invalid list: %v", d.Nodes())
+	}
+	if err := d.Update(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(""); err != ErrNoNodes {
+		t.Fatalf("Get on empty list = %v", err)
+	}
+}
+
+func TestEjector(t *testing.T) {
+	e := NewEjector(2, 100*time.Millisecond)
+	if e.Report("a", false) || e.Ejected("a") {
+		t.Fatal("ejected after one failure")
+	}
+	// 成功清零连续失败数
+	e.Report("a", true)
+	if e.Report("a", false) {
+		t.Fatal("failures not reset by a success")
+	}
+	if !e.Report("a", false) || !e.Ejected("a") {
+		t.Fatal("not ejected after two consecutive failures")
+	}
+	if e.Ejected("b") {
+		t.Fatal("unrelated node ejected")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if e.Ejected("a") {
+		t.Fatal("still ejected after fail_timeout")
+	}
+	// 恢复后重新计数
+	if e.Report("a", false) {
+		t.Fatal("ejected on the first failure after recovery")
+	}
+
+	for _, disabled := range []*Ejector{nil, NewEjector(0, 0)} {
+		for range 5 {
+			if disabled.Report("a", false) || disabled.Ejected("a") {
+				t.Fatal("disabled ejector ejected a node")
+			}
+		}
+	}
+	if NewEjector(1, 0).ejectTime != defaultEjectTime {
+		t.Fatal("default fail_timeout not applied")
+	}
+}
@@ -0,0 +1,223 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// LevelTrace 比Debug更低的日志级别
+const LevelTrace = slog.Level(-8)
+
+// Options 日志配置
+type Options struct {
+	Level         string // trace/debug/info/warn/error
+	FileWriterOn  bool   // 是否写入文件
+	LogPath       string // 日志目录
+	ConsoleWriter bool   // 是否输出到控制台
+	Color         bool   // 控制台是否彩色输出
+	Rotate        RotateOptions
+}
+
+var (
+	consoleMu  sync.Mutex
+	consoleOut io.Writer = os.Stderr
+)
+
+// SetConsoleOutput 设置控制台输出（如readline的Stderr，避免打断当前输入行）
+func SetConsoleOutput(w io.Writer) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	consoleOut = w
+}
+
+// 控制台输出统一加锁，多个实例共用
+type consoleWriter struct{}
+
+func (consoleWriter) Write(p []byte) (int, error) {
+	consoleMu.Lock()
+	defer consoleMu.Unlock()
+	return consoleOut.Write(p)
+}
+
+// Logger 单个服务实例的日志，实例名/类型/地址作为固定字段
+type Logger struct {
+	*slog.Logger
+	file *rotateWriter
+	ring *ringBuffer
+	path string
+}
+
+// ParseLevel 解析日志级别，未知级别按info处理
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "trace":
+		return LevelTrace
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// 文件名中不能出现地址里的冒号等字符
+func fileName(typ, addr string) string {
+	r := strings.NewReplacer(":", "_", "/", "_", "\\", "_")
+	return fmt.Sprintf("%s_server_%s.log", typ, strings.Trim(r.Replace(addr), "_"))
+}
+
+// New 为一个服务实例创建日志，同一实例重启时追加写入同一个文件
+func New(opts Options, name, typ, addr string) (*Logger, error) {
+	level := ParseLevel(opts.Level)
+	handlerOpts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: replaceLevel,
+	}
+	// 内存中始终保留最近的日志，供控制台查看
+	l := &Logger{ring: newRingBuffer()}
+	handlers := []slog.Handler{slog.NewTextHandler(l.ring, handlerOpts)}
+	if opts.FileWriterOn {
+		// 确保日志目录存在
+		if err := os.MkdirAll(opts.LogPath, 0755); err != nil {
+			return nil, fmt.Errorf("创建日志目录失败: %v", err)
+		}
+		l.path = filepath.Join(opts.LogPath, fileName(typ, addr))
+		file, err := newRotateWriter(l.path, opts.Rotate)
+		if err != nil {
+			return nil, err
+		}
+		l.file = file
+		handlers = append(handlers, slog.NewTextHandler(file, handlerOpts))
+	}
+	if opts.ConsoleWriter {
+		var w io.Writer = consoleWriter{}
+		if opts.Color {
+			w = colorWriter{w}
+		}
+		handlers = append(handlers, slog.NewTextHandler(w, handlerOpts))
+	}
+
+	var h slog.Handler = teeHandler(handlers)
+	if len(handlers) == 1 {
+		h = handlers[0]
+	}
+	l.Logger = slog.New(h).With("name", name, "type", typ, "addr", addr)
+	return l, nil
+}
+
+// Trace 输出trace级别日志
+func (l *Logger) Trace(msg string, args ...any) {
+	l.Log(context.Background(), LevelTrace, msg, args...)
+}
+
+// Path 日志文件路径，未写文件时为空
+func (l *Logger) Path() string {
+	return l.path
+}
+
+// Tail 最近n行日志（n<=0时返回内存中保留的全部）
+func (l *Logger) Tail(n int) []string {
+	return l.ring.tail(n)
+}
+
+// Subscribe 订阅新日志行，调用返回的函数取消订阅
+func (l *Logger) Subscribe() (<-chan string, func()) {
+	return l.ring.subscribe()
+}
+
+// Close 关闭日志文件
+func (l *Logger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// 自定义级别显示为TRACE
+func replaceLevel(_ []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey {
+		if level, ok := a.Value.Any().(slog.Level); ok && level <= LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// teeHandler 同时输出到多个handler
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			if e := h.Handle(ctx, r.Clone()); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
+
+// colorWriter 按日志级别给整行着色
+type colorWriter struct {
+	w io.Writer
+}
+
+var levelColors = []struct {
+	level []byte
+	color string
+}{
+	{[]byte("level=ERROR"), "\033[31m"},
+	{[]byte("level=WARN"), "\033[33m"},
+	{[]byte("level=INFO"), "\033[32m"},
+	{[]byte("level=DEBUG"), "\033[36m"},
+	{[]byte("level=TRACE"), "\033[90m"},
+}
+
+func (c colorWriter) Write(p []byte) (int, error) {
+	for _, lc := range levelColors {
+		if bytes.Contains(p, lc.level) {
+			line := bytes.TrimSuffix(p, []byte("\n"))
+			if _, err := fmt.Fprintf(c.w, "%s%s\033[0m\n", lc.color, line); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+	}
+	return c.w.Write(p)
+}
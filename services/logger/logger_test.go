@@ -0,0 +1,151 @@
+package logger
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]slog.Level{
+		"trace":   LevelTrace,
+		"DEBUG":   slog.LevelDebug,
+		"info":    slog.LevelInfo,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+		"":        slog.LevelInfo,
+		"verbose": slog.LevelInfo,
+	} {
+		if got := ParseLevel(name); got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestFileName(t *testing.T) {
+	for _, tc := range []struct{ typ, addr, want string }{
+		{"tcp", "3003", "tcp_server_3003.log"},
+		{"http", "127.0.0.1:2003", "http_server_127.0.0.1_2003.log"},
+		{"http", ":2003", "http_server_2003.log"},
+		{"unix", "/tmp/a.sock", "unix_server_tmp_a.sock.log"},
+	} {
+		if got := fileName(tc.typ, tc.addr); got != tc.want {
+			t.Errorf("fileName(%q, %q) = %q, want %q", tc.typ, tc.addr, got, tc.want)
+		}
+	}
+}
+
+// 每个实例写自己的文件，记录带实例字段，级别按实例配置过滤
+func TestInstanceLoggers(t *testing.T) {
+	dir := t.TempDir()
+	a, err := New(Options{Level: "debug", FileWriterOn: true, LogPath: dir}, "api-1", "http", "127.0.0.1:2003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(Options{Level: "warn", FileWriterOn: true, LogPath: dir}, "tcp:3003", "tcp", "3003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Debug("debug from a", "k", 1)
+	a.Trace("trace from a")
+	b.Info("info from b")
+	b.Warn("warn from b")
+	a.Close()
+	b.Close()
+
+	dataA, _ := os.ReadFile(filepath.Join(dir, "http_server_127.0.0.1_2003.log"))
+	dataB, _ := os.ReadFile(filepath.Join(dir, "tcp_server_3003.log"))
+	if !strings.Contains(string(dataA), `level=DEBUG msg="debug from a" name=api-1 type=http addr=127.0.0.1:2003 k=1`) {
+		t.Fatalf("log of a:\n%s", dataA)
+	}
+	if strings.Contains(string(dataA), "trace from a") || strings.Contains(string(dataA), "from b") {
+		t.Fatalf("unexpected records in the log of a:\n%s", dataA)
+	}
+	if strings.Contains(string(dataB), "info from b") || !strings.Contains(string(dataB), "warn from b") {
+		t.Fatalf("log of b at warn level:\n%s", dataB)
+	}
+	if a.Path() != filepath.Join(dir, "http_server_127.0.0.1_2003.log") {
+		t.Fatalf("Path() = %q", a.Path())
+	}
+
+	// 重启后追加写入同一个文件
+	again, err := New(Options{Level: "debug", FileWriterOn: true, LogPath: dir}, "api-1", "http", "127.0.0.1:2003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again.Info("restarted")
+	again.Close()
+	data, _ := os.ReadFile(again.Path())
+	if !strings.Contains(string(data), "debug from a") || !strings.Contains(string(data), "restarted") {
+		t.Fatalf("log after restart:\n%s", data)
+	}
+}
+
+func TestTraceLevel(t *testing.T) {
+	l, err := New(Options{Level: "trace"}, "udp:3003", "udp", "3003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Trace("packet", "bytes", 12)
+	lines := l.Tail(0)
+	if len(lines) != 1 || !strings.Contains(lines[0], `level=TRACE msg=packet name=udp:3003`) {
+		t.Fatalf("lines = %q", lines)
+	}
+	if l.Path() != "" || l.Close() != nil {
+		t.Fatal("logger without a file")
+	}
+}
+
+func TestTailAndSubscribe(t *testing.T) {
+	l, err := New(Options{Level: "info"}, "tcp:3003", "tcp", "3003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range ringSize + 5 {
+		l.Info("line", "i", i)
+	}
+	// 只保留最近的ringSize行
+	all := l.Tail(0)
+	if len(all) != ringSize || !strings.HasSuffix(all[0], "i=5") || !strings.HasSuffix(all[ringSize-1], "i=1004") {
+		t.Fatalf("kept %d lines, first %q", len(all), all[0])
+	}
+	last := l.Tail(2)
+	if len(last) != 2 || !strings.HasSuffix(last[0], "i=1003") {
+		t.Fatalf("Tail(2) = %q", last)
+	}
+
+	ch, cancel := l.Subscribe()
+	l.Warn("live")
+	select {
+	case line := <-ch:
+		if !strings.Contains(line, "msg=live") {
+			t.Fatalf("subscriber got %q", line)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("subscriber got nothing")
+	}
+	cancel()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("channel open after cancel")
+	}
+	l.Info("after cancel")
+}
+
+func TestConsoleColor(t *testing.T) {
+	var buf bytes.Buffer
+	SetConsoleOutput(&buf)
+	t.Cleanup(func() { SetConsoleOutput(os.Stderr) })
+	l, err := New(Options{Level: "info", ConsoleWriter: true, Color: true}, "tcp:3003", "tcp", "3003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Error("failed")
+	if out := buf.String(); !strings.HasPrefix(out, "\033[31m") || !strings.HasSuffix(out, "\033[0m\n") {
+		t.Fatalf("console output %q", out)
+	}
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"strings"
+	"sync"
+)
+
+// 每个实例在内存中保留的最近日志行数
+const ringSize = 1000
+
+// ringBuffer 保存最近的日志行，并推送给订阅者（控制台logs命令）
+type ringBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+	subs  map[chan string]struct{}
+}
+
+func newRingBuffer() *ringBuffer {
+	return &ringBuffer{
+		lines: make([]string, ringSize),
+		subs:  make(map[chan string]struct{}),
+	}
+}
+
+// Write slog每条记录调用一次Write
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	line := strings.TrimRight(string(p), "\n")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	for ch := range r.subs {
+		select {
+		case ch <- line:
+		default: // 订阅者处理不过来时丢弃，不阻塞服务
+		}
+	}
+	return len(p), nil
+}
+
+// 最近n行，按时间顺序
+func (r *ringBuffer) tail(n int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var all []string
+	if r.full {
+		all = append(all, r.lines[r.next:]...)
+	}
+	all = append(all, r.lines[:r.next]...)
+	if n > 0 && len(all) > n {
+		all = all[len(all)-n:]
+	}
+	return all
+}
+
+func (r *ringBuffer) subscribe() (<-chan string, func()) {
+	ch := make(chan string, 256)
+	r.mu.Lock()
+	r.subs[ch] = struct{}{}
+	r.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.subs, ch)
+			r.mu.Unlock()
+			close(ch)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const backupTimeFormat = "20060102T150405.000"
+
+// 旧版本每次启动生成的日志文件，如 grpc_server_20250812_132725.log
+var legacyLogName = regexp.MustCompile(`^[a-z-]+_server_\d{8}_\d{6}\.log$`)
+
+// RotateOptions 日志切割与保留策略，零值表示不切割、不清理
+type RotateOptions struct {
+	MaxSizeMB  int           // 单个文件最大大小
+	Interval   time.Duration // 按时间切割的间隔
+	MaxBackups int           // 最多保留的历史文件数
+	MaxAge     time.Duration // 历史文件最长保留时间
+	Compress   bool          // 历史文件是否gzip压缩
+}
+
+// rotateWriter 按大小/时间切割的日志文件
+type rotateWriter struct {
+	path string
+	opts RotateOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func newRotateWriter(path string, opts RotateOptions) (*rotateWriter, error) {
+	w := &rotateWriter{path: path, opts: opts}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	go w.cleanup()
+	return w, nil
+}
+
+func (w *rotateWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return fmt.Errorf("创建日志文件失败: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	w.openedAt = time.Now()
+	return nil
+}
+
+func (w *rotateWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.shouldRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *rotateWriter) shouldRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.opts.MaxSizeMB > 0 && w.size+int64(n) > int64(w.opts.MaxSizeMB)<<20 {
+		return true
+	}
+	return w.opts.Interval > 0 && time.Since(w.openedAt) >= w.opts.Interval
+}
+
+// 当前文件改名为带时间戳的历史文件，再重新打开
+func (w *rotateWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	ext := filepath.Ext(w.path)
+	base := fmt.Sprintf("%s-%s", strings.TrimSuffix(w.path, ext), time.Now().Format(backupTimeFormat))
+	backup := base + ext
+	// 同一毫秒内多次切分时加序号，避免覆盖之前的历史文件
+	for i := 1; fileExists(backup) || fileExists(backup+".gz"); i++ {
+		backup = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	if err := os.Rename(w.path, backup); err != nil {
+		return err
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	go func() {
+		if w.opts.Compress {
+			compressFile(backup)
+		}
+		w.cleanup()
+	}()
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func (w *rotateWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// 压缩历史文件，成功后删除原文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		gz.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return os.Remove(path)
+}
+
+// 按保留数量与时间清理历史文件（包括旧版本按启动时间生成的文件）
+func (w *rotateWriter) cleanup() {
+	if w.opts.MaxBackups <= 0 && w.opts.MaxAge <= 0 {
+		return
+	}
+	dir := filepath.Dir(w.path)
+	ext := filepath.Ext(w.path)
+	prefix := strings.TrimSuffix(filepath.Base(w.path), ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path    string
+		modTime time.Time
+	}
+	var backups, legacy []backup
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		b := backup{filepath.Join(dir, e.Name()), info.ModTime()}
+		switch {
+		case strings.HasPrefix(e.Name(), prefix):
+			backups = append(backups, b)
+		case legacyLogName.MatchString(e.Name()):
+			legacy = append(legacy, b)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].modTime.After(backups[j].modTime) })
+	for i, b := range backups {
+		expired := w.opts.MaxAge > 0 && time.Since(b.modTime) > w.opts.MaxAge
+		if expired || (w.opts.MaxBackups > 0 && i >= w.opts.MaxBackups) {
+			os.Remove(b.path)
+		}
+	}
+	// 旧版本文件不属于任何实例，只按保留时间清理
+	for _, b := range legacy {
+		if w.opts.MaxAge > 0 && time.Since(b.modTime) > w.opts.MaxAge {
+			os.Remove(b.path)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const waitTimeout = 3 * time.Second
+
+// 切割后的压缩与清理在后台进行，等待目录达到期望状态
+func waitFiles(t *testing.T, dir string, ok func(names []string) bool) []string {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		names := listFiles(t, dir)
+		if ok(names) {
+			return names
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("files in %s: %v", dir, names)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func countSuffix(names []string, suffix string) int {
+	n := 0
+	for _, name := range names {
+		if strings.HasSuffix(name, suffix) {
+			n++
+		}
+	}
+	return n
+}
+
+func newTestWriter(t *testing.T, opts RotateOptions) (*rotateWriter, string) {
+	t.Helper()
+	dir := t.TempDir()
+	w, err := newRotateWriter(filepath.Join(dir, "tcp_server_3003.log"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w, dir
+}
+
+func TestRotateBySize(t *testing.T) {
+	w, dir := newTestWriter(t, RotateOptions{MaxSizeMB: 1})
+	line := strings.Repeat("x", 1023) + "\n"
+	// 写入2.5MB，切出两个1MB的历史文件
+	for range 2560 {
+		if _, err := io.WriteString(w, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := listFiles(t, dir)
+	if len(names) != 3 || countSuffix(names, ".log") != 3 {
+		t.Fatalf("files = %v", names)
+	}
+	var total int64
+	for _, name := range names {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() > 1<<20 {
+			t.Fatalf("%s is %d bytes, over MaxSizeMB", name, info.Size())
+		}
+		total += info.Size()
+	}
+	if total != 2560*1024 {
+		t.Fatalf("%d bytes across files, want %d", total, 2560*1024)
+	}
+	// 单条超过上限的记录写入空文件，不会无限切割
+	w.mu.Lock()
+	w.rotate()
+	w.mu.Unlock()
+	if _, err := io.WriteString(w, strings.Repeat("y", 2<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(listFiles(t, dir)); n != 4 {
+		t.Fatalf("%d files after an oversized record", n)
+	}
+}
+
+func TestRotateByInterval(t *testing.T) {
+	w, dir := newTestWriter(t, RotateOptions{Interval: 50 * time.Millisecond})
+	io.WriteString(w, "first\n")
+	io.WriteString(w, "second\n")
+	if n := len(listFiles(t, dir)); n != 1 {
+		t.Fatalf("rotated before the interval: %d files", n)
+	}
+	time.Sleep(60 * time.Millisecond)
+	io.WriteString(w, "third\n")
+	names := listFiles(t, dir)
+	if len(names) != 2 {
+		t.Fatalf("files = %v", names)
+	}
+	backup, _ := os.ReadFile(filepath.Join(dir, names[0]))
+	current, _ := os.ReadFile(w.path)
+	if string(backup) != "first\nsecond\n" || string(current) != "third\n" {
+		t.Fatalf("backup %q, current %q", backup, current)
+	}
+}
+
+// 同一毫秒内多次切割不覆盖之前的历史文件
+func TestRotateUniqueBackups(t *testing.T) {
+	w, dir := newTestWriter(t, RotateOptions{})
+	for i := range 5 {
+		io.WriteString(w, strings.Repeat("z", i+1))
+		w.mu.Lock()
+		err := w.rotate()
+		w.mu.Unlock()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := listFiles(t, dir)
+	if len(names) != 6 {
+		t.Fatalf("files = %v", names)
+	}
+	sizes := map[int64]bool{}
+	for _, name := range names {
+		info, _ := os.Stat(filepath.Join(dir, name))
+		sizes[info.Size()] = true
+	}
+	for size := int64(1); size <= 5; size++ {
+		if !sizes[size] {
+			t.Fatalf("backup of %d bytes lost: %v", size, names)
+		}
+	}
+}
+
+func TestRotateCompressAndMaxBackups(t *testing.T) {
+	w, dir := newTestWriter(t, RotateOptions{Interval: time.Millisecond, MaxBackups: 2, Compress: true})
+	// 间隔已过，每次写入先把上一条记录切为历史文件，等待压缩与清理完成
+	for i := range 5 {
+		io.WriteString(w, "record "+string(rune('a'+i))+"\n")
+		want := min(i, 2)
+		waitFiles(t, dir, func(names []string) bool {
+			return countSuffix(names, ".gz") == want && len(names) == want+1
+		})
+		time.Sleep(2 * time.Millisecond)
+	}
+	names := listFiles(t, dir)
+	// 保留最近的两个：c与d，e在当前文件中
+	var contents []string
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".gz") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(gz)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	sort.Strings(contents)
+	if len(contents) != 2 || contents[0] != "record c\n" || contents[1] != "record d\n" {
+		t.Fatalf("kept backups %q", contents)
+	}
+}
+
+// 按时间清理历史文件与旧版本日志，其它实例和无关的文件不受影响
+func TestCleanupMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-48 * time.Hour)
+	files := map[string]bool{
+		"tcp_server_3003-20240101T000000.000.log":    false,
+		"tcp_server_3003-20240102T000000.000.log.gz": false,
+		"tcp_server_3003-20991231T000000.000.log":    true,
+		"tcp_server_20240101_120000.log":             false, // 旧版本
+		"udp_server_3003-20240101T000000.000.log":    true,  // 其它实例
+		"notes.txt": true,
+	}
+	for name := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(name, "2099") {
+			os.Chtimes(path, old, old)
+		}
+	}
+	w, err := newRotateWriter(filepath.Join(dir, "tcp_server_3003.log"), RotateOptions{MaxAge: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	waitFiles(t, dir, func(names []string) bool { return len(names) == 4 })
+	for name, kept := range files {
+		if fileExists(filepath.Join(dir, name)) != kept {
+			t.Errorf("%s kept = %v, want %v", name, !kept, kept)
+		}
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	w, _ := newTestWriter(t, RotateOptions{})
+	w.Close()
+	if _, err := io.WriteString(w, "late\n"); err != os.ErrClosed {
+		t.Fatalf("write after close = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// statusRecorder 记录HTTP响应状态码与写出的字节数
+type statusRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += n
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// HTTPMiddleware 统计HTTP请求数、耗时与收发字节
+func HTTPMiddleware(inst *Instance, next http.Handler) http.Handler {
+	if inst == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rec, req)
+		if req.ContentLength > 0 {
+			inst.AddBytesIn(int(req.ContentLength))
+		}
+		inst.AddBytesOut(rec.bytes)
+		inst.ObserveRequest(strconv.Itoa(rec.code), time.Since(start))
+	})
+}
+
+// HTTPConnState 作为 http.Server.ConnState 统计活跃连接
+func HTTPConnState(inst *Instance) func(net.Conn, http.ConnState) {
+	return func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			inst.ConnOpened()
+		case http.StateClosed, http.StateHijacked:
+			inst.ConnClosed()
+		}
+	}
+}
+
+// countingConn 统计TCP连接收发字节
+type countingConn struct {
+	net.Conn
+	inst *Instance
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.inst.AddBytesIn(n)
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.inst.AddBytesOut(n)
+	return n, err
+}
+
+// CountingConn 包装连接以统计收发字节
+func CountingConn(inst *Instance, c net.Conn) net.Conn {
+	if inst == nil {
+		return c
+	}
+	return &countingConn{Conn: c, inst: inst}
+}
+
+type rpcMethodKey struct{}
+
+// grpcStatsHandler 通过gRPC stats接口统计连接、字节与每个方法的调用
+type grpcStatsHandler struct {
+	inst *Instance
+}
+
+// GRPCStatsHandler 用于 grpc.StatsHandler
+func GRPCStatsHandler(inst *Instance) stats.Handler {
+	return &grpcStatsHandler{inst: inst}
+}
+
+func (h *grpcStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, rpcMethodKey{}, info.FullMethodName)
+}
+
+func (h *grpcStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	switch st := s.(type) {
+	case *stats.InPayload:
+		h.inst.AddBytesIn(st.WireLength)
+	case *stats.OutPayload:
+		h.inst.AddBytesOut(st.WireLength)
+	case *stats.End:
+		method, _ := ctx.Value(rpcMethodKey{}).(string)
+		h.inst.ObserveRPC(method, status.Code(st.Error).String(), st.EndTime.Sub(st.BeginTime))
+	}
+}
+
+func (h *grpcStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *grpcStatsHandler) HandleConn(_ context.Context, s stats.ConnStats) {
+	switch s.(type) {
+	case *stats.ConnBegin:
+		h.inst.ConnOpened()
+	case *stats.ConnEnd:
+		h.inst.ConnClosed()
+	}
+}
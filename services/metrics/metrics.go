@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "downstream"
+
+// 所有指标都带有实例标签
+var instanceLabels = []string{"name", "type", "address"}
+
+var (
+	registry = prometheus.NewRegistry()
+
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Requests handled by each instance, by status/code. TCP counts one request per connection.",
+	}, append(instanceLabels, "code"))
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Request latency of each instance.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, instanceLabels)
+
+	activeConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_connections",
+		Help:      "Currently open connections of each instance.",
+	}, instanceLabels)
+
+	bytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_total",
+		Help:      "Bytes received (in) and sent (out) by each instance.",
+	}, append(instanceLabels, "direction"))
+
+	grpcRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "grpc_requests_total",
+		Help:      "gRPC requests by method and status code.",
+	}, append(instanceLabels, "method", "code"))
+
+	grpcRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_request_duration_seconds",
+		Help:      "gRPC request latency by method.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, append(instanceLabels, "method"))
+
+	serverState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "server_running",
+		Help:      "1 if the instance is running, 0 if it is stopped.",
+	}, instanceLabels)
+
+	serverRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "server_restarts_total",
+		Help:      "Times the instance was started again after being stopped.",
+	}, instanceLabels)
+
+	framesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "frames_total",
+		Help:      "Length-prefixed frames received (in) and sent (out) by framed TCP instances.",
+	}, append(instanceLabels, "direction"))
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal, requestDuration, activeConnections, bytesTotal,
+		grpcRequestsTotal, grpcRequestDuration, serverState, serverRestarts,
+		framesTotal,
+	)
+}
+
+// Handler /metrics 接口
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Instance 单个服务实例的指标，方法对nil安全，未开启统计的服务可以直接传nil
+type Instance struct {
+	labels prometheus.Labels
+
+	requestDuration prometheus.Observer
+	active          prometheus.Gauge
+	bytesIn         prometheus.Counter
+	bytesOut        prometheus.Counter
+
+	stats *liveStats
+}
+
+// NewInstance 创建实例指标
+func NewInstance(name, typ, address string) *Instance {
+	labels := prometheus.Labels{"name": name, "type": typ, "address": address}
+	return &Instance{
+		labels:          labels,
+		requestDuration: requestDuration.With(labels),
+		active:          activeConnections.With(labels),
+		bytesIn:         bytesTotal.MustCurryWith(labels).WithLabelValues("in"),
+		bytesOut:        bytesTotal.MustCurryWith(labels).WithLabelValues("out"),
+		stats:           &liveStats{},
+	}
+}
+
+// ObserveRequest 记录一次请求（HTTP状态码/gRPC状态码/TCP连接结果）
+func (i *Instance) ObserveRequest(code string, d time.Duration) {
+	if i == nil {
+		return
+	}
+	requestsTotal.MustCurryWith(i.labels).WithLabelValues(code).Inc()
+	i.requestDuration.Observe(d.Seconds())
+	i.stats.observe(code, d, time.Now())
+}
+
+// ObserveRPC 记录一次gRPC调用，同时计入总请求数
+func (i *Instance) ObserveRPC(method, code string, d time.Duration) {
+	if i == nil {
+		return
+	}
+	grpcRequestsTotal.MustCurryWith(i.labels).WithLabelValues(method, code).Inc()
+	grpcRequestDuration.MustCurryWith(i.labels).WithLabelValues(method).Observe(d.Seconds())
+	i.ObserveRequest(code, d)
+}
+
+// ConnOpened 新建连接
+func (i *Instance) ConnOpened() {
+	if i == nil {
+		return
+	}
+	i.active.Inc()
+	i.stats.addActive(1)
+}
+
+// ConnClosed 连接关闭
+func (i *Instance) ConnClosed() {
+	if i == nil {
+		return
+	}
+	i.active.Dec()
+	i.stats.addActive(-1)
+}
+
+// AddBytesIn 接收字节数
+func (i *Instance) AddBytesIn(n int) {
+	if i == nil || n <= 0 {
+		return
+	}
+	i.bytesIn.Add(float64(n))
+}
+
+// AddBytesOut 发送字节数
+func (i *Instance) AddBytesOut(n int) {
+	if i == nil || n <= 0 {
+		return
+	}
+	i.bytesOut.Add(float64(n))
+}
+
+// AddFrames 统计收发的帧数，direction 为 in/out
+func (i *Instance) AddFrames(direction string, n int) {
+	if i == nil || n <= 0 {
+		return
+	}
+	framesTotal.MustCurryWith(i.labels).WithLabelValues(direction).Add(float64(n))
+}
+
+// SetRunning 更新实例运行状态
+func (i *Instance) SetRunning(running bool) {
+	if i == nil {
+		return
+	}
+	v := 0.0
+	if running {
+		v = 1
+	}
+	serverState.With(i.labels).Set(v)
+}
+
+// IncRestarts 实例被重新启动
+func (i *Instance) IncRestarts() {
+	if i == nil {
+		return
+	}
+	serverRestarts.With(i.labels).Inc()
+}
@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// 抓取 /metrics，按行返回
+func scrape(t *testing.T) map[string]bool {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics = %d", rec.Code)
+	}
+	lines := map[string]bool{}
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		lines[line] = true
+	}
+	return lines
+}
+
+// 一条时间序列，标签按名称排序，与抓取结果的格式一致
+func series(metric string, value float64, labels prometheus.Labels) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return metric + "{" + strings.Join(pairs, ",") + "} " + strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// 实例标签加上额外的标签
+func with(base prometheus.Labels, kv ...string) prometheus.Labels {
+	l := prometheus.Labels{}
+	for k, v := range base {
+		l[k] = v
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		l[kv[i]] = kv[i+1]
+	}
+	return l
+}
+
+func expectSeries(t *testing.T, lines map[string]bool, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		if !lines[w] {
+			t.Errorf("missing series %s", w)
+		}
+	}
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	inst := NewInstance("web-1", "http", "127.0.0.1:2003")
+	h := HTTPMiddleware(inst, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		io.Copy(io.Discard, req.Body)
+		if req.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		io.WriteString(w, "hello")
+	}))
+	for _, path := range []string{"/", "/", "/down"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, strings.NewReader("ping")))
+	}
+	// 标签为实例的名称/类型/地址，状态码未显式写出时为200
+	labels := prometheus.Labels{"name": "web-1", "type": "http", "address": "127.0.0.1:2003"}
+	expectSeries(t, scrape(t),
+		series("downstream_requests_total", 2, with(labels, "code", "200")),
+		series("downstream_requests_total", 1, with(labels, "code", "503")),
+		series("downstream_bytes_total", 12, with(labels, "direction", "in")),
+		series("downstream_bytes_total", 15, with(labels, "direction", "out")),
+		series("downstream_request_duration_seconds_count", 3, labels),
+	)
+	if snap := inst.Snapshot(); snap.Requests != 3 || snap.Errors != 1 {
+		t.Fatalf("snapshot = %+v", snap)
+	}
+}
+
+func TestConnAndStateMetrics(t *testing.T) {
+	inst := NewInstance("tcp:3003", "tcp", "3003")
+	inst.ConnOpened()
+	inst.ConnOpened()
+	inst.ConnClosed()
+	inst.SetRunning(true)
+	inst.IncRestarts()
+	inst.AddFrames("in", 3)
+	inst.AddFrames("out", 0)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := CountingConn(inst, server)
+	defer conn.Close()
+	go func() {
+		client.Write([]byte("abcdef"))
+		io.ReadFull(client, make([]byte, 2))
+	}()
+	io.ReadFull(conn, make([]byte, 6))
+	conn.Write([]byte("ok"))
+
+	labels := prometheus.Labels{"name": "tcp:3003", "type": "tcp", "address": "3003"}
+	expectSeries(t, scrape(t),
+		series("downstream_active_connections", 1, labels),
+		series("downstream_server_running", 1, labels),
+		series("downstream_server_restarts_total", 1, labels),
+		series("downstream_frames_total", 3, with(labels, "direction", "in")),
+		series("downstream_bytes_total", 6, with(labels, "direction", "in")),
+		series("downstream_bytes_total", 2, with(labels, "direction", "out")),
+	)
+	if inst.Snapshot().ActiveConns != 1 {
+		t.Fatalf("active = %d", inst.Snapshot().ActiveConns)
+	}
+	inst.SetRunning(false)
+	expectSeries(t, scrape(t), series("downstream_server_running", 0, labels))
+}
+
+func TestGRPCStatsHandler(t *testing.T) {
+	inst := NewInstance("grpc:3005", "grpc", "3005")
+	h := GRPCStatsHandler(inst)
+	h.HandleConn(context.Background(), &stats.ConnBegin{})
+	begin := time.Now()
+	for _, err := range []error{nil, status.Error(codes.Unavailable, "down"), errors.New("plain")} {
+		ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/echo.Echo/Say"})
+		h.HandleRPC(ctx, &stats.InPayload{WireLength: 10})
+		h.HandleRPC(ctx, &stats.OutPayload{WireLength: 20})
+		h.HandleRPC(ctx, &stats.End{BeginTime: begin, EndTime: begin.Add(time.Millisecond), Error: err})
+	}
+	instLabels := prometheus.Labels{"name": "grpc:3005", "type": "grpc", "address": "3005"}
+	labels := with(instLabels, "method", "/echo.Echo/Say")
+	expectSeries(t, scrape(t),
+		series("downstream_grpc_requests_total", 1, with(labels, "code", "OK")),
+		series("downstream_grpc_requests_total", 1, with(labels, "code", "Unavailable")),
+		series("downstream_grpc_requests_total", 1, with(labels, "code", "Unknown")),
+		series("downstream_grpc_request_duration_seconds_count", 3, labels),
+		// gRPC调用同时计入总请求数
+		series("downstream_requests_total", 1, with(instLabels, "code", "OK")),
+		series("downstream_active_connections", 1, instLabels),
+		series("downstream_bytes_total", 30, with(instLabels, "direction", "in")),
+		series("downstream_bytes_total", 60, with(instLabels, "direction", "out")),
+	)
+	h.HandleConn(context.Background(), &stats.ConnEnd{})
+	expectSeries(t, scrape(t), series("downstream_active_connections", 0, instLabels))
+}
+
+// 未开启统计的服务传nil
+func TestNilInstance(t *testing.T) {
+	var inst *Instance
+	inst.ObserveRequest("200", time.Millisecond)
+	inst.ObserveRPC("/a", "OK", time.Millisecond)
+	inst.ConnOpened()
+	inst.ConnClosed()
+	inst.AddBytesIn(1)
+	inst.AddBytesOut(1)
+	inst.AddFrames("in", 1)
+	inst.SetRunning(true)
+	inst.IncRestarts()
+	if snap := inst.Snapshot(); snap != (Snapshot{}) {
+		t.Fatalf("snapshot = %+v", snap)
+	}
+	if total, errs := inst.Window(time.Minute); total != 0 || errs != 0 {
+		t.Fatal("window of nil instance")
+	}
+	next := http.NotFoundHandler()
+	if h := HTTPMiddleware(nil, next); h == nil {
+		t.Fatal("nil handler")
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if CountingConn(nil, server) != server {
+		t.Fatal("connection wrapped without an instance")
+	}
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// 按秒保存最近一小时的请求数，用于RPS与时间窗口统计
+	historySeconds = 3600
+	// 计算延迟分位数时保留的最近样本数
+	latencySamples = 1024
+	// 状态表中RPS/错误率的统计窗口
+	rateWindow = 5 * time.Second
+)
+
+type secondBucket struct {
+	sec    int64
+	total  uint64
+	errors uint64
+}
+
+// liveStats 进程内的实时统计，供控制台与报表使用（Prometheus指标不便于直接读取）
+type liveStats struct {
+	mu        sync.Mutex
+	buckets   [historySeconds]secondBucket
+	latencies [latencySamples]time.Duration
+	nextLat   int
+	numLat    int
+	total     uint64
+	errors    uint64
+	active    int64
+}
+
+// Snapshot 实例当前的流量概况
+type Snapshot struct {
+	Requests    uint64        // 累计请求数
+	Errors      uint64        // 累计错误数
+	RPS         float64       // 最近几秒的每秒请求数
+	ErrorRate   float64       // 最近几秒的错误比例(0-1)
+	P50         time.Duration // 最近请求的延迟中位数
+	P99         time.Duration
+	ActiveConns int64
+}
+
+// 判断状态码是否为错误：HTTP 5xx、gRPC非OK、TCP连接异常
+func isError(code string) bool {
+	if n, err := strconv.Atoi(code); err == nil {
+		return n >= 500
+	}
+	return code != "OK" && code != "ok"
+}
+
+func (s *liveStats) observe(code string, d time.Duration, now time.Time) {
+	failed := isError(code)
+	sec := now.Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := &s.buckets[sec%historySeconds]
+	if b.sec != sec {
+		*b = secondBucket{sec: sec}
+	}
+	b.total++
+	s.total++
+	if failed {
+		b.errors++
+		s.errors++
+	}
+	s.latencies[s.nextLat] = d
+	s.nextLat = (s.nextLat + 1) % latencySamples
+	if s.numLat < latencySamples {
+		s.numLat++
+	}
+}
+
+// 统计 [now-window, now) 内完整秒的请求数与错误数
+func (s *liveStats) window(window time.Duration, now time.Time) (total, errors uint64) {
+	secs := int64(window / time.Second)
+	if secs > historySeconds-1 {
+		secs = historySeconds - 1
+	}
+	end := now.Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sec := end - secs; sec < end; sec++ {
+		b := s.buckets[sec%historySeconds]
+		if b.sec == sec {
+			total += b.total
+			errors += b.errors
+		}
+	}
+	return
+}
+
+func (s *liveStats) snapshot(now time.Time) Snapshot {
+	total, errors := s.window(rateWindow, now)
+	s.mu.Lock()
+	snap := Snapshot{
+		Requests:    s.total,
+		Errors:      s.errors,
+		ActiveConns: s.active,
+		RPS:         float64(total) / rateWindow.Seconds(),
+	}
+	lat := make([]time.Duration, s.numLat)
+	copy(lat, s.latencies[:s.numLat])
+	s.mu.Unlock()
+
+	if total > 0 {
+		snap.ErrorRate = float64(errors) / float64(total)
+	}
+	if len(lat) > 0 {
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		snap.P50 = lat[len(lat)*50/100]
+		snap.P99 = lat[len(lat)*99/100]
+	}
+	return snap
+}
+
+func (s *liveStats) addActive(n int64) {
+	s.mu.Lock()
+	s.active += n
+	s.mu.Unlock()
+}
+
+// Snapshot 实例当前的流量概况
+func (i *Instance) Snapshot() Snapshot {
+	if i == nil {
+		return Snapshot{}
+	}
+	return i.stats.snapshot(time.Now())
+}
+
+// Window 最近一段时间内的请求数与错误数（最长一小时）
+func (i *Instance) Window(window time.Duration) (total, errors uint64) {
+	if i == nil {
+		return 0, 0
+	}
+	return i.stats.window(window, time.Now())
+}
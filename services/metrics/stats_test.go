@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLiveStatsWindow(t *testing.T) {
+	base := time.Unix(1_700_000_000, 0)
+	s := &liveStats{}
+	// 第i秒记录 i+1 个成功请求，偶数秒另有一个错误：每秒总数为2,2,4,4,...,10,10
+	for i := range 10 {
+		now := base.Add(time.Duration(i)*time.Second + 500*time.Millisecond)
+		for range i + 1 {
+			s.observe("200", time.Millisecond, now)
+		}
+		if i%2 == 0 {
+			s.observe("503", time.Millisecond, now)
+		}
+	}
+	at := func(sec int) time.Time { return base.Add(time.Duration(sec) * time.Second) }
+	for _, tc := range []struct {
+		name          string
+		window        time.Duration
+		now           time.Time
+		total, errors uint64
+	}{
+		// 当前秒未结束，不计入：第6、7、8秒
+		{"current second excluded", 3 * time.Second, at(9).Add(900 * time.Millisecond), 8 + 8 + 10, 2},
+		{"all seconds", time.Minute, at(10), 60, 5},
+		{"partial seconds truncated", 2500 * time.Millisecond, at(10), 10 + 10, 1},
+		{"window shorter than a second", 500 * time.Millisecond, at(10), 0, 0},
+		{"before any request", time.Minute, at(0), 0, 0},
+		// 超过一小时的窗口按一小时统计，只剩第6-9秒未过期
+		{"expired", 2 * time.Hour, at(historySeconds + 5), 8 + 8 + 10 + 10, 2},
+	} {
+		total, errors := s.window(tc.window, tc.now)
+		if total != tc.total || errors != tc.errors {
+			t.Errorf("%s: window = %d/%d, want %d/%d", tc.name, total, errors, tc.total, tc.errors)
+		}
+	}
+}
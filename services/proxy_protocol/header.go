@@ -0,0 +1,295 @@
+package proxy_protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	ErrNoHeader      = errors.New("proxy protocol: no header")
+	ErrInvalidHeader = errors.New("proxy protocol: invalid header")
+)
+
+const (
+	v1MaxLength = 107 // 含结尾\r\n
+	v2HeaderLen = 16
+)
+
+// v2 TLV类型
+const (
+	TLVTypeALPN      = 0x01
+	TLVTypeAuthority = 0x02
+	TLVTypeCRC32C    = 0x03
+	TLVTypeNoop      = 0x04
+	TLVTypeUniqueID  = 0x05
+	TLVTypeSSL       = 0x20
+	TLVTypeNetNS     = 0x30
+)
+
+var tlvNames = map[byte]string{
+	TLVTypeALPN:      "alpn",
+	TLVTypeAuthority: "authority",
+	TLVTypeCRC32C:    "crc32c",
+	TLVTypeNoop:      "noop",
+	TLVTypeUniqueID:  "unique_id",
+	TLVTypeSSL:       "ssl",
+	TLVTypeNetNS:     "netns",
+}
+
+// TLV v2头部中的扩展字段
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Name TLV类型名，未知类型显示为十六进制
+func (t TLV) Name() string {
+	if name, ok := tlvNames[t.Type]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", t.Type)
+}
+
+func (t TLV) String() string {
+	switch t.Type {
+	case TLVTypeALPN, TLVTypeAuthority, TLVTypeUniqueID, TLVTypeNetNS:
+		return fmt.Sprintf("%s=%s", t.Name(), t.Value)
+	}
+	return fmt.Sprintf("%s=%x", t.Name(), t.Value)
+}
+
+// Header 解析后的PROXY协议头
+type Header struct {
+	Version     int      // 1或2
+	Command     string   // PROXY/LOCAL，v1固定为PROXY
+	Transport   string   // TCP4/TCP6/UDP4/UDP6/UNIX/UNKNOWN
+	Source      net.Addr // 原始客户端地址，LOCAL/UNKNOWN时为nil
+	Destination net.Addr // 代理收到连接时的目的地址
+	TLVs        []TLV
+}
+
+// TLV 查找指定类型的TLV
+func (h *Header) TLV(typ byte) (TLV, bool) {
+	for _, t := range h.TLVs {
+		if t.Type == typ {
+			return t, true
+		}
+	}
+	return TLV{}, false
+}
+
+func (h *Header) String() string {
+	if h == nil {
+		return "none"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "v%d %s %s", h.Version, h.Command, h.Transport)
+	if h.Source != nil {
+		fmt.Fprintf(&b, " src=%s dst=%s", h.Source, h.Destination)
+	}
+	for _, t := range h.TLVs {
+		fmt.Fprintf(&b, " %s", t)
+	}
+	return b.String()
+}
+
+// 逐字节比较前缀，一旦不匹配就返回，避免等待不会到来的数据
+func hasPrefix(r *bufio.Reader, prefix []byte) (bool, error) {
+	for i := 1; i <= len(prefix); i++ {
+		p, err := r.Peek(i)
+		if err != nil {
+			return false, err
+		}
+		if p[i-1] != prefix[i-1] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Read 从连接开头读取PROXY协议头，没有头部时返回ErrNoHeader且不消耗数据
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case v1Prefix[0]:
+		ok, err := hasPrefix(r, v1Prefix)
+		if err != nil || !ok {
+			return nil, noHeader(err)
+		}
+		return readV1(r)
+	case v2Signature[0]:
+		ok, err := hasPrefix(r, v2Signature)
+		if err != nil || !ok {
+			return nil, noHeader(err)
+		}
+		return readV2(r)
+	}
+	return nil, ErrNoHeader
+}
+
+func noHeader(err error) error {
+	if err != nil {
+		return err
+	}
+	return ErrNoHeader
+}
+
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 line too long or not terminated", ErrInvalidHeader)
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	h := &Header{Version: 1, Command: "PROXY"}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+	h.Transport = fields[1]
+	switch h.Transport {
+	case "UNKNOWN":
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, fmt.Errorf("%w: unknown v1 protocol %q", ErrInvalidHeader, h.Transport)
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+	src, err := v1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := v1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func v1Addr(ip, port string) (net.Addr, error) {
+	addr := net.ParseIP(ip)
+	p, err := strconv.ParseUint(port, 10, 16)
+	if addr == nil || err != nil {
+		return nil, fmt.Errorf("%w: bad address %s:%s", ErrInvalidHeader, ip, port)
+	}
+	return &net.TCPAddr{IP: addr, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, v2HeaderLen)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	verCmd, famProto := fixed[12], fixed[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, verCmd>>4)
+	}
+	h := &Header{Version: 2}
+	switch verCmd & 0x0f {
+	case 0:
+		h.Command = "LOCAL"
+	case 1:
+		h.Command = "PROXY"
+	default:
+		return nil, fmt.Errorf("%w: unknown command %d", ErrInvalidHeader, verCmd&0x0f)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	var addrLen int
+	stream := famProto&0x0f == 1
+	switch famProto >> 4 {
+	case 0:
+		h.Transport = "UNKNOWN"
+	case 1:
+		h.Transport, addrLen = transport("TCP4", "UDP4", stream), 12
+	case 2:
+		h.Transport, addrLen = transport("TCP6", "UDP6", stream), 36
+	case 3:
+		h.Transport, addrLen = "UNIX", 216
+	default:
+		return nil, fmt.Errorf("%w: unknown address family %d", ErrInvalidHeader, famProto>>4)
+	}
+	if len(payload) < addrLen {
+		return nil, fmt.Errorf("%w: address block too short", ErrInvalidHeader)
+	}
+	// LOCAL命令（如健康检查）忽略地址
+	if h.Command == "PROXY" {
+		addrs := payload[:addrLen]
+		switch famProto >> 4 {
+		case 1:
+			h.Source = inetAddr(addrs[0:4], addrs[8:10], stream)
+			h.Destination = inetAddr(addrs[4:8], addrs[10:12], stream)
+		case 2:
+			h.Source = inetAddr(addrs[0:16], addrs[32:34], stream)
+			h.Destination = inetAddr(addrs[16:32], addrs[34:36], stream)
+		case 3:
+			h.Source = &net.UnixAddr{Name: cString(addrs[:108]), Net: "unix"}
+			h.Destination = &net.UnixAddr{Name: cString(addrs[108:]), Net: "unix"}
+		}
+	}
+
+	tlvs := payload[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalidHeader)
+		}
+		n := int(binary.BigEndian.Uint16(tlvs[1:3]))
+		if len(tlvs) < 3+n {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalidHeader)
+		}
+		h.TLVs = append(h.TLVs, TLV{Type: tlvs[0], Value: tlvs[3 : 3+n]})
+		tlvs = tlvs[3+n:]
+	}
+	return h, nil
+}
+
+func transport(streamName, dgramName string, stream bool) string {
+	if stream {
+		return streamName
+	}
+	return dgramName
+}
+
+func inetAddr(ip, port []byte, stream bool) net.Addr {
+	p := int(binary.BigEndian.Uint16(port))
+	addr := append(net.IP(nil), ip...)
+	if stream {
+		return &net.TCPAddr{IP: addr, Port: p}
+	}
+	return &net.UDPAddr{IP: addr, Port: p}
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
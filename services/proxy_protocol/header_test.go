@@ -0,0 +1,189 @@
+package proxy_protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+// 构造v2头部：verCmd为版本与命令，famProto为地址族与协议
+func v2Header(verCmd, famProto byte, payload []byte) []byte {
+	b := append([]byte{}, v2Signature...)
+	b = append(b, verCmd, famProto)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func tlv(typ byte, value string) []byte {
+	b := []byte{typ}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(value)))
+	return append(b, value...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// 192.168.0.1:56324 -> 192.168.0.11:443
+var tcp4Addrs = []byte{192, 168, 0, 1, 192, 168, 0, 11, 0xdc, 0x04, 0x01, 0xbb}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []byte
+		version   int
+		command   string
+		transport string
+		source    string
+		tlvs      []string
+	}{
+		{
+			name:      "v1 tcp4",
+			input:     []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n"),
+			version:   1,
+			command:   "PROXY",
+			transport: "TCP4",
+			source:    "192.168.0.1:56324",
+		},
+		{
+			name:      "v1 tcp6",
+			input:     []byte("PROXY TCP6 2001:db8::1 2001:db8::2 1000 443\r\n"),
+			version:   1,
+			command:   "PROXY",
+			transport: "TCP6",
+			source:    "[2001:db8::1]:1000",
+		},
+		{
+			name:      "v1 unknown",
+			input:     []byte("PROXY UNKNOWN\r\n"),
+			version:   1,
+			command:   "PROXY",
+			transport: "UNKNOWN",
+		},
+		{
+			name:      "v2 tcp4 with tlvs",
+			input:     v2Header(0x21, 0x11, concat(tcp4Addrs, tlv(TLVTypeALPN, "h2"), tlv(TLVTypeAuthority, "example.com"))),
+			version:   2,
+			command:   "PROXY",
+			transport: "TCP4",
+			source:    "192.168.0.1:56324",
+			tlvs:      []string{"alpn=h2", "authority=example.com"},
+		},
+		{
+			name:      "v2 udp4",
+			input:     v2Header(0x21, 0x12, tcp4Addrs),
+			version:   2,
+			command:   "PROXY",
+			transport: "UDP4",
+			source:    "192.168.0.1:56324",
+		},
+		{
+			name:      "v2 local ignores addresses",
+			input:     v2Header(0x20, 0x11, tcp4Addrs),
+			version:   2,
+			command:   "LOCAL",
+			transport: "TCP4",
+		},
+		{
+			name:      "v2 local unspec",
+			input:     v2Header(0x20, 0x00, nil),
+			version:   2,
+			command:   "LOCAL",
+			transport: "UNKNOWN",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(append(tt.input, "payload"...)))
+			h, err := Read(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Version != tt.version || h.Command != tt.command || h.Transport != tt.transport {
+				t.Fatalf("header = %s", h)
+			}
+			source := ""
+			if h.Source != nil {
+				source = h.Source.String()
+			}
+			if source != tt.source {
+				t.Fatalf("source = %q, want %q", source, tt.source)
+			}
+			var tlvs []string
+			for _, v := range h.TLVs {
+				tlvs = append(tlvs, v.String())
+			}
+			if strings.Join(tlvs, ",") != strings.Join(tt.tlvs, ",") {
+				t.Fatalf("tlvs = %v, want %v", tlvs, tt.tlvs)
+			}
+			// 头部之后的数据原样保留
+			if rest, _ := io.ReadAll(r); string(rest) != "payload" {
+				t.Fatalf("remaining data = %q", rest)
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+		want  error
+	}{
+		{"plain data", []byte("GET / HTTP/1.1\r\n"), ErrNoHeader},
+		{"prefix mismatch", []byte("PROXIMITY\r\n"), ErrNoHeader},
+		{"v1 truncated", []byte("PROXY TCP4 192.168.0.1"), io.EOF},
+		{"v1 not terminated", []byte("PROXY TCP4 " + strings.Repeat("1", v1MaxLength) + "\r\n"), ErrInvalidHeader},
+		{"v1 bad protocol", []byte("PROXY SCTP 1.1.1.1 2.2.2.2 1 2\r\n"), ErrInvalidHeader},
+		{"v1 bad address", []byte("PROXY TCP4 1.1.1 2.2.2.2 1 2\r\n"), ErrInvalidHeader},
+		{"v1 bad port", []byte("PROXY TCP4 1.1.1.1 2.2.2.2 1 70000\r\n"), ErrInvalidHeader},
+		{"v1 missing fields", []byte("PROXY TCP4 1.1.1.1\r\n"), ErrInvalidHeader},
+		{"v2 truncated signature", v2Signature[:8], io.EOF},
+		{"v2 truncated payload", v2Header(0x21, 0x11, tcp4Addrs)[:20], io.ErrUnexpectedEOF},
+		{"v2 bad version", v2Header(0x11, 0x11, tcp4Addrs), ErrInvalidHeader},
+		{"v2 bad command", v2Header(0x22, 0x11, tcp4Addrs), ErrInvalidHeader},
+		{"v2 bad family", v2Header(0x21, 0x41, tcp4Addrs), ErrInvalidHeader},
+		{"v2 short address block", v2Header(0x21, 0x21, tcp4Addrs), ErrInvalidHeader},
+		{"v2 truncated tlv", v2Header(0x21, 0x11, concat(tcp4Addrs, tlv(TLVTypeALPN, "h2")[:4])), ErrInvalidHeader},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(tt.input))
+			_, err := Read(r)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			// 没有头部时不消耗数据
+			if tt.want == ErrNoHeader {
+				if rest, _ := io.ReadAll(r); !bytes.Equal(rest, tt.input) {
+					t.Fatalf("data consumed: %q", rest)
+				}
+			}
+		})
+	}
+}
+
+func TestHeaderTLVLookup(t *testing.T) {
+	r := bufio.NewReader(bytes.NewReader(v2Header(0x21, 0x11, concat(tcp4Addrs, tlv(TLVTypeUniqueID, "abc"), tlv(0xe0, "\x01")))))
+	h, err := Read(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := h.TLV(TLVTypeUniqueID); !ok || string(v.Value) != "abc" {
+		t.Fatalf("unique_id = %v, %v", v, ok)
+	}
+	if _, ok := h.TLV(TLVTypeSSL); ok {
+		t.Fatal("found absent ssl tlv")
+	}
+	if got := h.TLVs[1].String(); got != "0xe0=01" {
+		t.Fatalf("unknown tlv = %q", got)
+	}
+	if _, ok := h.Destination.(*net.TCPAddr); !ok {
+		t.Fatalf("destination = %T", h.Destination)
+	}
+}
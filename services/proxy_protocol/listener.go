@@ -0,0 +1,143 @@
+package proxy_protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// 监听模式
+const (
+	ModeOff      = "off"      // 不解析
+	ModeOptional = "optional" // 有头部时解析，没有时按普通连接处理
+	ModeRequired = "required" // 必须有头部，否则断开
+)
+
+// 默认等待头部的时间
+const DefaultHeaderTimeout = 3 * time.Second
+
+// CheckMode 校验模式配置
+func CheckMode(mode string) error {
+	switch mode {
+	case "", ModeOff, ModeOptional, ModeRequired:
+		return nil
+	}
+	return fmt.Errorf("unknown proxy_protocol mode %q, available: off, optional, required", mode)
+}
+
+// Listener 包装监听器，Accept返回的连接在第一次读取时解析PROXY协议头
+type Listener struct {
+	net.Listener
+	Mode          string
+	HeaderTimeout time.Duration
+}
+
+// Wrap 按模式包装监听器，off时原样返回
+func Wrap(l net.Listener, mode string, timeout time.Duration) net.Listener {
+	if mode == "" || mode == ModeOff {
+		return l
+	}
+	if timeout <= 0 {
+		timeout = DefaultHeaderTimeout
+	}
+	return &Listener{Listener: l, Mode: mode, HeaderTimeout: timeout}
+}
+
+// Accept 不在这里读取头部，避免慢客户端阻塞accept循环
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{Conn: c, r: bufio.NewReader(c), mode: l.Mode, timeout: l.HeaderTimeout}, nil
+}
+
+// Conn 带PROXY协议头的连接，RemoteAddr返回原始客户端地址
+type Conn struct {
+	net.Conn
+	r       *bufio.Reader
+	mode    string
+	timeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+
+	mu           sync.Mutex
+	readDeadline time.Time // 调用方设置的读超时，读取头部后恢复
+	inHeader     bool
+}
+
+// 读取头部期间使用头部超时与调用方超时中较早的一个，结束后恢复调用方的超时
+func (c *Conn) readHeader() {
+	c.mu.Lock()
+	deadline := time.Now().Add(c.timeout)
+	if !c.readDeadline.IsZero() && c.readDeadline.Before(deadline) {
+		deadline = c.readDeadline
+	}
+	c.inHeader = true
+	c.Conn.SetReadDeadline(deadline)
+	c.mu.Unlock()
+
+	c.header, c.err = Read(c.r)
+
+	c.mu.Lock()
+	c.inHeader = false
+	c.Conn.SetReadDeadline(c.readDeadline)
+	c.mu.Unlock()
+	if c.err == nil || c.mode == ModeRequired {
+		return
+	}
+	// optional：没有头部或在超时内没有收到数据，都按普通连接处理
+	var ne net.Error
+	if errors.Is(c.err, ErrNoHeader) || (errors.As(c.err, &ne) && ne.Timeout()) {
+		c.err = nil
+	}
+}
+
+// Header 解析（只进行一次）并返回头部，optional模式下没有头部时返回nil
+func (c *Conn) Header() (*Header, error) {
+	c.once.Do(c.readHeader)
+	return c.header, c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	if _, err := c.Header(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// SetReadDeadline 读取头部期间只记录，头部读取结束后生效
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	if c.inHeader {
+		return nil
+	}
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetWriteDeadline(t); err != nil {
+		return err
+	}
+	return c.SetReadDeadline(t)
+}
+
+// RemoteAddr 有头部时返回原始客户端地址，否则返回对端（代理）地址
+func (c *Conn) RemoteAddr() net.Addr {
+	if h, err := c.Header(); err == nil && h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// NetConn 被包装的连接
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,171 @@
+package proxy_protocol
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// 在回环地址上建立一对连接，服务端连接经过Wrap包装
+func wrappedPair(t *testing.T, mode string, timeout time.Duration) (server, client net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	l := Wrap(ln, mode, timeout)
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func readString(t *testing.T, c net.Conn, n int) string {
+	t.Helper()
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func TestCheckMode(t *testing.T) {
+	for _, mode := range []string{"", ModeOff, ModeOptional, ModeRequired} {
+		if err := CheckMode(mode); err != nil {
+			t.Errorf("CheckMode(%q) = %v", mode, err)
+		}
+	}
+	if err := CheckMode("on"); err == nil {
+		t.Error("unknown mode accepted")
+	}
+}
+
+func TestWrapOff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if Wrap(ln, ModeOff, 0) != ln {
+		t.Fatal("off mode wrapped the listener")
+	}
+}
+
+func TestRequired(t *testing.T) {
+	server, client := wrappedPair(t, ModeRequired, time.Second)
+	client.Write([]byte("PROXY TCP4 10.1.2.3 10.0.0.1 4000 80\r\nhello"))
+	if got := readString(t, server, 5); got != "hello" {
+		t.Fatalf("data = %q", got)
+	}
+	if got := server.RemoteAddr().String(); got != "10.1.2.3:4000" {
+		t.Fatalf("remote addr = %s", got)
+	}
+}
+
+func TestRequiredRejectsPlainConnection(t *testing.T) {
+	server, client := wrappedPair(t, ModeRequired, time.Second)
+	client.Write([]byte("hello"))
+	if _, err := server.Read(make([]byte, 5)); !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("err = %v, want %v", err, ErrNoHeader)
+	}
+	if _, err := server.(*Conn).Header(); !errors.Is(err, ErrNoHeader) {
+		t.Fatalf("header err = %v", err)
+	}
+}
+
+func TestOptionalPlainConnection(t *testing.T) {
+	server, client := wrappedPair(t, ModeOptional, time.Second)
+	client.Write([]byte("hello"))
+	if got := readString(t, server, 5); got != "hello" {
+		t.Fatalf("data = %q", got)
+	}
+	if h, err := server.(*Conn).Header(); h != nil || err != nil {
+		t.Fatalf("header = %v, %v, want none", h, err)
+	}
+	if got, want := server.RemoteAddr().String(), client.LocalAddr().String(); got != want {
+		t.Fatalf("remote addr = %s, want peer %s", got, want)
+	}
+}
+
+func TestOptionalFallsBackAfterTimeout(t *testing.T) {
+	server, client := wrappedPair(t, ModeOptional, 50*time.Millisecond)
+	// 客户端先等待对端说话（如smtp/redis），头部超时后按普通连接处理
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		client.Write([]byte("late"))
+	}()
+	if got := readString(t, server, 4); got != "late" {
+		t.Fatalf("data = %q", got)
+	}
+	if h, _ := server.(*Conn).Header(); h != nil {
+		t.Fatalf("header = %s, want none", h)
+	}
+}
+
+func TestRequiredTimesOut(t *testing.T) {
+	server, _ := wrappedPair(t, ModeRequired, 50*time.Millisecond)
+	if _, err := server.Read(make([]byte, 1)); !isTimeout(err) {
+		t.Fatalf("err = %v, want timeout", err)
+	}
+}
+
+// 头部读取后恢复调用方的读超时，而不是清除它
+func TestCallerDeadlineKept(t *testing.T) {
+	server, client := wrappedPair(t, ModeRequired, 5*time.Second)
+	server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	client.Write([]byte("PROXY TCP4 10.1.2.3 10.0.0.1 4000 80\r\n"))
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 1))
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		if !isTimeout(err) {
+			t.Fatalf("err = %v, want timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("read after header ignored the caller's deadline")
+	}
+	if got := server.RemoteAddr().String(); got != "10.1.2.3:4000" {
+		t.Fatalf("remote addr = %s", got)
+	}
+
+	// 清除超时后可以继续读取
+	server.SetDeadline(time.Time{})
+	client.Write([]byte("x"))
+	if got := readString(t, server, 1); got != "x" {
+		t.Fatalf("data = %q", got)
+	}
+}
+
+// 调用方的超时早于头部超时时，读取头部也受它限制
+func TestCallerDeadlineBoundsHeaderRead(t *testing.T) {
+	server, _ := wrappedPair(t, ModeRequired, 5*time.Second)
+	server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	if _, err := server.Read(make([]byte, 1)); !isTimeout(err) {
+		t.Fatalf("err = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("header read took %s", elapsed)
+	}
+}
@@ -0,0 +1,279 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultLeaseTTL    = 10 * time.Second
+	defaultDialTimeout = 5 * time.Second
+	retryInterval      = time.Second
+	maxRetryInterval   = 30 * time.Second
+)
+
+// EtcdOptions etcd v3 注册中心参数
+type EtcdOptions struct {
+	Endpoints   []string      `yaml:"endpoints"`
+	DialTimeout time.Duration `yaml:"dial_timeout"` // 默认5s
+	LeaseTTL    time.Duration `yaml:"lease_ttl"`    // 租约时长，进程退出后实例在该时间内被删除，默认10s
+	Username    string        `yaml:"username"`
+	Password    string        `yaml:"password"`
+}
+
+// Etcd 基于etcd v3的注册中心：实例为绑定租约的key（service/addr），
+// 租约由keepalive保持，租约丢失（如etcd长时间不可达）后申请新租约并重新写入所有实例
+type Etcd struct {
+	client *clientv3.Client
+	ttl    time.Duration
+	logger *logger.Logger
+
+	mu     sync.Mutex
+	lease  clientv3.LeaseID
+	owned  map[string]string // key -> value
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewEtcd(opts EtcdOptions, lg *logger.Logger) (*Etcd, error) {
+	if len(opts.Endpoints) == 0 {
+		return nil, fmt.Errorf("etcd registry needs at least one endpoint")
+	}
+	dialTimeout := opts.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   opts.Endpoints,
+		DialTimeout: dialTimeout,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		Logger:      zap.NewNop(), // 错误通过返回值记录到实例日志
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Etcd{
+		client: client,
+		ttl:    opts.LeaseTTL,
+		logger: lg,
+		owned:  make(map[string]string),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if r.ttl < time.Second {
+		r.ttl = defaultLeaseTTL
+	}
+	// 启动时检查连通性
+	sctx, scancel := context.WithTimeout(ctx, dialTimeout)
+	defer scancel()
+	if _, err := client.Status(sctx, opts.Endpoints[0]); err != nil {
+		client.Close()
+		cancel()
+		return nil, fmt.Errorf("etcd %v: %w", opts.Endpoints, err)
+	}
+	return r, nil
+}
+
+func etcdKey(service, addr string) string {
+	return strings.TrimSuffix(service, "/") + "/" + addr
+}
+
+func etcdPrefix(service string) string {
+	return strings.TrimSuffix(service, "/") + "/"
+}
+
+// 取得当前租约，没有时申请新租约并启动keepalive。调用方持有r.mu
+func (r *Etcd) leaseLocked(ctx context.Context) (clientv3.LeaseID, error) {
+	if r.lease != 0 {
+		return r.lease, nil
+	}
+	resp, err := r.client.Grant(ctx, int64(r.ttl/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	ch, err := r.client.KeepAlive(r.ctx, resp.ID)
+	if err != nil {
+		return 0, err
+	}
+	r.lease = resp.ID
+	go r.keepAlive(resp.ID, ch)
+	return r.lease, nil
+}
+
+// 消费keepalive应答，通道关闭表示租约丢失，重新申请租约并写入所有实例
+func (r *Etcd) keepAlive(id clientv3.LeaseID, ch <-chan *clientv3.LeaseKeepAliveResponse) {
+	for range ch {
+	}
+	if r.ctx.Err() != nil {
+		return
+	}
+	r.logger.Warn("etcd lease lost, re-registering", "lease", fmt.Sprintf("%x", id))
+	r.mu.Lock()
+	if r.lease == id {
+		r.lease = 0
+	}
+	r.mu.Unlock()
+	for r.ctx.Err() == nil {
+		err := r.reregister()
+		if err == nil {
+			return
+		}
+		r.logger.Warn("etcd re-register failed", "err", err)
+		select {
+		case <-r.ctx.Done():
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+func (r *Etcd) reregister() error {
+	ctx, cancel := context.WithTimeout(r.ctx, r.ttl)
+	defer cancel()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lease, err := r.leaseLocked(ctx)
+	if err != nil {
+		return err
+	}
+	for key, value := range r.owned {
+		if _, err := r.client.Put(ctx, key, value, clientv3.WithLease(lease)); err != nil {
+			return err
+		}
+		r.logger.Info("etcd key re-registered", "key", key)
+	}
+	return nil
+}
+
+func (r *Etcd) Register(ctx context.Context, service, addr string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	lease, err := r.leaseLocked(ctx)
+	if err != nil {
+		return err
+	}
+	key := etcdKey(service, addr)
+	if _, err := r.client.Put(ctx, key, addr, clientv3.WithLease(lease)); err != nil {
+		return err
+	}
+	r.owned[key] = addr
+	return nil
+}
+
+func (r *Etcd) Deregister(ctx context.Context, service, addr string) error {
+	key := etcdKey(service, addr)
+	r.mu.Lock()
+	delete(r.owned, key)
+	r.mu.Unlock()
+	_, err := r.client.Delete(ctx, key)
+	return err
+}
+
+// 返回实例列表与读取时的revision
+func (r *Etcd) list(ctx context.Context, service string) ([]string, int64, error) {
+	prefix := etcdPrefix(service)
+	resp, err := r.client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
+	if err != nil {
+		return nil, 0, err
+	}
+	list := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		name := strings.TrimPrefix(string(kv.Key), prefix)
+		// 只取直接子节点，与zk的子节点语义一致
+		if name != "" && !strings.Contains(name, "/") {
+			list = append(list, name)
+		}
+	}
+	slices.Sort(list)
+	return list, resp.Header.Revision, nil
+}
+
+func (r *Etcd) List(ctx context.Context, service string) ([]string, error) {
+	list, _, err := r.list(ctx, service)
+	return list, err
+}
+
+// 重试间隔从retryInterval开始翻倍，最长maxRetryInterval
+func nextBackoff(d time.Duration) time.Duration {
+	if d <= 0 {
+		return retryInterval
+	}
+	return min(2*d, maxRetryInterval)
+}
+
+// Watch 先读取列表，再从下一个revision开始监听前缀，有变化时重新读取。
+// 出错或watch没有事件就结束（如失去leader）时按指数退避重试，收到事件后重置
+func (r *Etcd) Watch(ctx context.Context, service string, fn func([]string)) {
+	var last []string
+	first := true
+	var backoff time.Duration
+	wait := func() {
+		backoff = nextBackoff(backoff)
+		t := time.NewTimer(backoff)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+		case <-t.C:
+		}
+	}
+	for ctx.Err() == nil {
+		list, rev, err := r.list(ctx, service)
+		if err != nil {
+			if ctx.Err() == nil {
+				r.logger.Warn("etcd list failed", "service", service, "err", err, "retry", nextBackoff(backoff))
+			}
+			wait()
+			continue
+		}
+		if first || !slices.Equal(list, last) {
+			fn(list)
+			last, first = list, false
+		}
+		wctx, wcancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+		wch := r.client.Watch(wctx, etcdPrefix(service), clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+		changed := false
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				r.logger.Warn("etcd watch failed", "service", service, "err", err)
+				break
+			}
+			// 有事件时退出内层循环重新读取完整列表
+			if len(resp.Events) > 0 {
+				changed = true
+				break
+			}
+		}
+		wcancel()
+		if changed {
+			backoff = 0
+			continue
+		}
+		if ctx.Err() == nil {
+			r.logger.Debug("etcd watch ended without events", "service", service, "retry", nextBackoff(backoff))
+			wait()
+		}
+	}
+}
+
+func (r *Etcd) Close() error {
+	r.cancel()
+	// 主动撤销租约，实例立即下线
+	r.mu.Lock()
+	lease := r.lease
+	r.mu.Unlock()
+	if lease != 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		r.client.Revoke(ctx, lease)
+		cancel()
+	}
+	return r.client.Close()
+}
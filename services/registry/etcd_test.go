@@ -0,0 +1,139 @@
+package registry
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: "127.0.0.1:" + strconv.Itoa(l.Addr().(*net.TCPAddr).Port)}
+}
+
+// 在临时目录中启动单节点etcd，返回客户端地址
+func startEtcd(t *testing.T) string {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	client, peer := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{client}, []url.URL{client}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peer}, []url.URL{peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(e.Close)
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		t.Fatal("etcd not ready")
+	}
+	return client.String()
+}
+
+func newTestEtcd(t *testing.T, endpoint string) *Etcd {
+	t.Helper()
+	reg, err := NewEtcd(EtcdOptions{Endpoints: []string{endpoint}, LeaseTTL: 2 * time.Second}, testLogger(t, "etcd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { reg.Close() })
+	return reg
+}
+
+func TestEtcdRegistry(t *testing.T) {
+	testRegistryContract(t, newTestEtcd(t, startEtcd(t)), "/registry_test/etcd")
+}
+
+func TestEtcdNestedKeysIgnored(t *testing.T) {
+	reg := newTestEtcd(t, startEtcd(t))
+	ctx := context.Background()
+	if err := reg.Register(ctx, "/svc", "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Register(ctx, "/svc/sub", "127.0.0.1:8002"); err != nil {
+		t.Fatal(err)
+	}
+	// 只返回直接子节点，与zk一致
+	mustList(t, reg, "/svc", []string{"127.0.0.1:8001"})
+}
+
+func TestEtcdReregisterAfterLeaseRevoked(t *testing.T) {
+	endpoint := startEtcd(t)
+	reg := newTestEtcd(t, endpoint)
+	observer := newTestEtcd(t, endpoint)
+	ctx := context.Background()
+	const service = "/registry_test/etcd_revoke"
+	if err := reg.Register(ctx, service, "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := make(chan []string, 16)
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go observer.Watch(wctx, service, func(list []string) { updates <- list })
+	waitList(t, updates, []string{"127.0.0.1:8001"})
+
+	// 租约被撤销后key立即删除，keepalive结束后申请新租约重新写入
+	reg.mu.Lock()
+	lease := reg.lease
+	reg.mu.Unlock()
+	if _, err := observer.client.Revoke(ctx, lease); err != nil {
+		t.Fatal(err)
+	}
+	waitList(t, updates, nil)
+	waitList(t, updates, []string{"127.0.0.1:8001"})
+	reg.mu.Lock()
+	renewed := reg.lease
+	reg.mu.Unlock()
+	if renewed == lease || renewed == 0 {
+		t.Fatalf("lease not renewed: old %x, new %x", lease, renewed)
+	}
+
+	// 已注销的实例不会被重新写入
+	if err := reg.Deregister(ctx, service, "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := observer.client.Revoke(ctx, renewed); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	mustList(t, observer, service, nil)
+
+	// Close撤销租约，实例立即下线
+	if err := reg.Register(ctx, service, "127.0.0.1:8002"); err != nil {
+		t.Fatal(err)
+	}
+	waitList(t, updates, []string{"127.0.0.1:8002"})
+	reg.Close()
+	waitList(t, updates, nil)
+	mustList(t, observer, service, nil)
+}
+
+func TestNextBackoff(t *testing.T) {
+	var got []time.Duration
+	d := time.Duration(0)
+	for range 8 {
+		d = nextBackoff(d)
+		got = append(got, d)
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 30 * time.Second, 30 * time.Second, 30 * time.Second}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("backoff sequence = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+)
+
+// 注册中心类型
+const (
+	BackendZookeeper = "zookeeper"
+	BackendEtcd      = "etcd"
+)
+
+// Registry 服务注册与发现。service为服务路径（如 /real_server），
+// 实例以 service/addr 的形式注册，注册中心会话失效后由实现负责重新注册
+type Registry interface {
+	Register(ctx context.Context, service, addr string) error
+	Deregister(ctx context.Context, service, addr string) error
+	List(ctx context.Context, service string) ([]string, error)
+	// Watch 持续跟随服务的实例列表，列表变化时调用fn，直到ctx取消
+	Watch(ctx context.Context, service string, fn func([]string))
+	Close() error
+}
+
+// CheckBackend 检查注册中心类型，空字符串为zookeeper
+func CheckBackend(backend string) error {
+	switch backend {
+	case "", BackendZookeeper, BackendEtcd:
+		return nil
+	}
+	return fmt.Errorf("unknown registry backend %q, available: zookeeper, etcd", backend)
+}
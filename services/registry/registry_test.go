@@ -0,0 +1,101 @@
+package registry
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+)
+
+const waitTimeout = 5 * time.Second
+
+func testLogger(t *testing.T, typ string) *logger.Logger {
+	t.Helper()
+	lg, err := logger.New(logger.Options{Level: "error"}, "test", typ, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lg.Close() })
+	return lg
+}
+
+// 等待列表变为want，期间的中间状态忽略
+func waitList(t *testing.T, ch <-chan []string, want []string) {
+	t.Helper()
+	deadline := time.After(waitTimeout)
+	for {
+		select {
+		case got := <-ch:
+			if slices.Equal(got, want) {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("list never became %v", want)
+		}
+	}
+}
+
+func mustList(t *testing.T, reg Registry, service string, want []string) {
+	t.Helper()
+	got, err := reg.List(context.Background(), service)
+	if err != nil {
+		t.Fatalf("list %s: %v", service, err)
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("list %s = %v, want %v", service, got, want)
+	}
+}
+
+// 所有Registry实现都需满足的行为
+func testRegistryContract(t *testing.T, reg Registry, service string) {
+	ctx := context.Background()
+	mustList(t, reg, service, nil)
+
+	updates := make(chan []string, 16)
+	wctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reg.Watch(wctx, service, func(list []string) { updates <- list })
+	}()
+	waitList(t, updates, nil)
+
+	for _, addr := range []string{"127.0.0.1:8002", "127.0.0.1:8001"} {
+		if err := reg.Register(ctx, service, addr); err != nil {
+			t.Fatalf("register %s: %v", addr, err)
+		}
+	}
+	mustList(t, reg, service, []string{"127.0.0.1:8001", "127.0.0.1:8002"})
+	waitList(t, updates, []string{"127.0.0.1:8001", "127.0.0.1:8002"})
+
+	if err := reg.Deregister(ctx, service, "127.0.0.1:8001"); err != nil {
+		t.Fatal(err)
+	}
+	mustList(t, reg, service, []string{"127.0.0.1:8002"})
+	waitList(t, updates, []string{"127.0.0.1:8002"})
+
+	// 注销不存在的实例不报错
+	if err := reg.Deregister(ctx, service, "127.0.0.1:8001"); err != nil {
+		t.Fatalf("deregister twice: %v", err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(waitTimeout):
+		t.Fatal("watch did not return after cancel")
+	}
+}
+
+func TestCheckBackend(t *testing.T) {
+	for _, backend := range []string{"", BackendZookeeper, BackendEtcd} {
+		if err := CheckBackend(backend); err != nil {
+			t.Errorf("CheckBackend(%q) = %v", backend, err)
+		}
+	}
+	if err := CheckBackend("consul"); err == nil {
+		t.Error("expected an error for unknown backend")
+	}
+}
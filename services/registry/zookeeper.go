@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"context"
+	"errors"
+
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper"
+	"github.com/samuel/go-zookeeper/zk"
+)
+
+// Zookeeper 基于ZkManager的注册中心，实例为服务路径下的临时节点
+type Zookeeper struct {
+	zk *zookeeper.ZkManager
+}
+
+func NewZookeeper(z *zookeeper.ZkManager) *Zookeeper {
+	return &Zookeeper{zk: z}
+}
+
+// zk客户端的请求不接受ctx，连接断开时会一直等待重连。
+// 在goroutine中执行请求，ctx结束时立即返回ctx.Err()；此后请求仍成功时调用undo撤销
+func withContext(ctx context.Context, call func() error, undo func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- call() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if undo != nil {
+			go func() {
+				if <-done == nil {
+					undo()
+				}
+			}()
+		}
+		return ctx.Err()
+	}
+}
+
+// Register 超时后注册仍然完成时删除节点，不留下调用方认为失败的注册
+func (r *Zookeeper) Register(ctx context.Context, service, addr string) error {
+	return withContext(ctx, func() error {
+		return r.zk.RegistServerPath(service, addr)
+	}, func() {
+		r.zk.Unregister(service + "/" + addr)
+	})
+}
+
+func (r *Zookeeper) Deregister(ctx context.Context, service, addr string) error {
+	return withContext(ctx, func() error {
+		return r.zk.Unregister(service + "/" + addr)
+	}, nil)
+}
+
+// List 服务路径不存在时返回空列表
+func (r *Zookeeper) List(ctx context.Context, service string) ([]string, error) {
+	var list []string
+	err := withContext(ctx, func() error {
+		var err error
+		list, err = r.zk.GetServerListByPath(service)
+		return err
+	}, nil)
+	if err != nil {
+		if errors.Is(err, zk.ErrNoNode) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *Zookeeper) Watch(ctx context.Context, service string, fn func([]string)) {
+	r.zk.FollowChildren(ctx, service, fn)
+}
+
+func (r *Zookeeper) Close() error {
+	r.zk.Close()
+	return nil
+}
@@ -0,0 +1,42 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper"
+	"github.com/21Mile/go_downstreamer_server/services/http_server/zookeeper/zktest"
+)
+
+func newMemoryZookeeper(t *testing.T) *Zookeeper {
+	t.Helper()
+	z := zookeeper.NewZkManager([]string{"zktest"})
+	z.Dial = zktest.NewServer().Dial
+	z.Logger = testLogger(t, "zookeeper")
+	if err := z.GetConnect(); err != nil {
+		t.Fatal(err)
+	}
+	reg := NewZookeeper(z)
+	t.Cleanup(func() { reg.Close() })
+	return reg
+}
+
+func TestZookeeperRegistry(t *testing.T) {
+	testRegistryContract(t, newMemoryZookeeper(t), "/registry_test/zookeeper")
+}
+
+func TestZookeeperRespectsContext(t *testing.T) {
+	reg := newMemoryZookeeper(t)
+	const service = "/registry_test/zookeeper_ctx"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := reg.Register(ctx, service, "127.0.0.1:8001"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("register with canceled ctx = %v", err)
+	}
+	if _, err := reg.List(ctx, service); !errors.Is(err, context.Canceled) {
+		t.Fatalf("list with canceled ctx = %v", err)
+	}
+	mustList(t, reg, service, nil)
+}
@@ -0,0 +1,244 @@
+package tcp_server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+)
+
+const (
+	frameHeaderLen         = 4
+	defaultMaxFrameSize    = 4 << 20
+	defaultPipelineBacklog = 1024 // 每个连接最多积压的未回复请求数，超过时暂停读取
+)
+
+// FrameRule 一条帧匹配规则，Prefix与Match二选一，Match为正则时Reply中可用 $1 引用分组
+type FrameRule struct {
+	Prefix string        `yaml:"prefix"`
+	Match  string        `yaml:"match"`
+	Reply  string        `yaml:"reply"`
+	Delay  time.Duration `yaml:"delay"` // 该规则的回复延迟，0时使用 min_delay/max_delay
+	Drop   bool          `yaml:"drop"`  // 不回复
+}
+
+type frameRule struct {
+	prefix []byte
+	re     *regexp.Regexp
+	reply  string
+	delay  time.Duration
+	drop   bool
+}
+
+// framedHandler 4字节大端长度前缀的帧协议。
+// 每个请求帧可带 id_bytes 字节的请求ID，回复帧以相同的ID开头，客户端据此匹配乱序回复
+type framedHandler struct {
+	rules        []frameRule
+	defaultReply string // 为空时回显请求体
+	idBytes      int
+	maxFrameSize int
+	outOfOrder   bool
+	backlog      int
+	min, max     time.Duration
+	logger       *logger.Logger
+	metrics      *metrics.Instance
+}
+
+func newFramedHandler(opts *ServerOptions, lg *logger.Logger, mt *metrics.Instance) (*framedHandler, error) {
+	if opts.MaxDelay < opts.MinDelay {
+		return nil, fmt.Errorf("max_delay %v is less than min_delay %v", opts.MaxDelay, opts.MinDelay)
+	}
+	if opts.IDBytes < 0 {
+		return nil, fmt.Errorf("id_bytes must not be negative")
+	}
+	h := &framedHandler{
+		defaultReply: opts.DefaultReply,
+		idBytes:      opts.IDBytes,
+		maxFrameSize: opts.MaxFrameSize,
+		outOfOrder:   opts.OutOfOrder,
+		backlog:      defaultPipelineBacklog,
+		min:          opts.MinDelay,
+		max:          opts.MaxDelay,
+		logger:       lg,
+		metrics:      mt,
+	}
+	if h.maxFrameSize <= 0 {
+		h.maxFrameSize = defaultMaxFrameSize
+	}
+	for _, r := range opts.Frames {
+		rule := frameRule{prefix: []byte(r.Prefix), reply: r.Reply, delay: r.Delay, drop: r.Drop}
+		if r.Match != "" {
+			re, err := regexp.Compile(r.Match)
+			if err != nil {
+				return nil, fmt.Errorf("invalid frame match %q: %w", r.Match, err)
+			}
+			rule.re = re
+		}
+		h.rules = append(h.rules, rule)
+	}
+	return h, nil
+}
+
+// 读取一帧，返回不含长度头的内容
+func readFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var header [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if int64(n) > int64(maxSize) {
+		return nil, fmt.Errorf("frame size %d exceeds limit %d", n, maxSize)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// 写出一帧，长度头与内容一次写入
+func writeFrame(w io.Writer, payload []byte) error {
+	buf := make([]byte, frameHeaderLen+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(len(payload)))
+	copy(buf[frameHeaderLen:], payload)
+	_, err := w.Write(buf)
+	return err
+}
+
+// 计算请求的回复内容与延迟，ok为false表示不回复
+func (h *framedHandler) respond(frame []byte) (reply []byte, delay time.Duration, ok bool) {
+	id, body := frame, []byte(nil)
+	if len(frame) >= h.idBytes {
+		id, body = frame[:h.idBytes], frame[h.idBytes:]
+	}
+	delay = h.min
+	if h.max > h.min {
+		delay += time.Duration(rand.Int63n(int64(h.max - h.min)))
+	}
+	out := body
+	if h.defaultReply != "" {
+		out = []byte(h.defaultReply)
+	}
+	for _, r := range h.rules {
+		if r.re != nil {
+			m := r.re.FindSubmatchIndex(body)
+			if m == nil {
+				continue
+			}
+			out = r.re.Expand(nil, []byte(r.reply), body, m)
+		} else if bytes.HasPrefix(body, r.prefix) {
+			out = []byte(r.reply)
+		} else {
+			continue
+		}
+		if r.drop {
+			return nil, 0, false
+		}
+		if r.delay > 0 {
+			delay = r.delay
+		}
+		break
+	}
+	return append(append([]byte(nil), id...), out...), delay, true
+}
+
+func (h *framedHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	var (
+		wmu       sync.Mutex
+		pending   sync.WaitGroup
+		in, out   atomic.Int64
+		writeFail atomic.Bool
+	)
+	send := func(reply []byte) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		if writeFail.Load() {
+			return
+		}
+		if err := writeFrame(src, reply); err != nil {
+			writeFail.Store(true)
+			return
+		}
+		out.Add(1)
+		h.metrics.AddFrames("out", 1)
+	}
+
+	// 按序回复：每个请求占一个位置，写协程按请求顺序等待各自的回复
+	var queue chan chan []byte
+	if !h.outOfOrder {
+		queue = make(chan chan []byte, h.backlog)
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			for slot := range queue {
+				if reply, ok := <-slot; ok {
+					send(reply)
+				}
+			}
+		}()
+	}
+
+	// 等待回复的请求数有上限，达到上限时不再读取新请求，避免慢回复下协程无限增长
+	inflight := make(chan struct{}, h.backlog)
+
+	r := bufio.NewReader(src)
+	var readErr error
+	for {
+		frame, err := readFrame(r, h.maxFrameSize)
+		if err != nil {
+			readErr = err
+			break
+		}
+		in.Add(1)
+		h.metrics.AddFrames("in", 1)
+		reply, delay, ok := h.respond(frame)
+		h.logger.Trace("frame received", "remote", src.RemoteAddr().String(), "bytes", len(frame), "delay", delay, "reply", ok)
+
+		var slot chan []byte
+		if queue != nil {
+			slot = make(chan []byte, 1)
+			queue <- slot
+		}
+		// 请求在各自的协程中等待延迟，读取不被阻塞（流水线）
+		inflight <- struct{}{}
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			defer func() { <-inflight }()
+			if delay > 0 {
+				tracing.InjectedLatency(ctx, delay)
+				time.Sleep(delay)
+			}
+			switch {
+			case slot != nil && ok:
+				slot <- reply
+			case slot != nil:
+				close(slot)
+			case ok:
+				send(reply)
+			}
+		}()
+	}
+	if queue != nil {
+		close(queue)
+	}
+	// 客户端半关闭后仍把未完成的回复发完
+	pending.Wait()
+	if readErr == io.EOF {
+		readErr = nil
+	}
+	h.logger.Debug("framed connection finished", "remote", src.RemoteAddr().String(),
+		"frames_in", in.Load(), "frames_out", out.Load(), "err", readErr)
+}
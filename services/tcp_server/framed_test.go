@@ -0,0 +1,201 @@
+package tcp_server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func frameBytes(payload string) []byte {
+	var b bytes.Buffer
+	writeFrame(&b, []byte(payload))
+	return b.Bytes()
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    string
+		wantErr error // 为nil且fail时只要求返回错误
+		fail    bool
+	}{
+		{name: "frame", input: frameBytes("hello"), want: "hello"},
+		{name: "empty frame", input: frameBytes(""), want: ""},
+		{name: "at limit", input: frameBytes("12345678"), want: "12345678"},
+		{name: "over limit", input: frameBytes("123456789"), fail: true},
+		{name: "no data", wantErr: io.EOF, fail: true},
+		{name: "truncated header", input: []byte{0, 0}, wantErr: io.ErrUnexpectedEOF, fail: true},
+		{name: "truncated payload", input: frameBytes("hello")[:7], wantErr: io.ErrUnexpectedEOF, fail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readFrame(bytes.NewReader(tt.input), 8)
+			if tt.fail {
+				if err == nil || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Fatalf("frame = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func newFramedForTest(t *testing.T, opts ServerOptions) *framedHandler {
+	t.Helper()
+	opts.Mode = ModeFramed
+	h, err := NewHandler(&opts, testLogger(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.(*framedHandler)
+}
+
+func TestFramedRespond(t *testing.T) {
+	rules := []FrameRule{
+		{Prefix: "PING", Reply: "PONG"},
+		{Match: `^GET (\w+)$`, Reply: "VALUE $1"},
+		{Prefix: "SLOW", Reply: "LATE", Delay: time.Second},
+		{Prefix: "BLACKHOLE", Drop: true},
+	}
+	tests := []struct {
+		name   string
+		opts   ServerOptions
+		frame  string
+		reply  string
+		delay  time.Duration
+		noSend bool
+	}{
+		{name: "echo", frame: "hi", reply: "hi"},
+		{name: "echo with id", opts: ServerOptions{IDBytes: 2}, frame: "01hi", reply: "01hi"},
+		{name: "default reply keeps id", opts: ServerOptions{IDBytes: 2, DefaultReply: "OK"}, frame: "07anything", reply: "07OK"},
+		{name: "prefix", opts: ServerOptions{IDBytes: 2, Frames: rules}, frame: "01PING", reply: "01PONG"},
+		{name: "match expands group", opts: ServerOptions{IDBytes: 2, Frames: rules}, frame: "02GET user", reply: "02VALUE user"},
+		{name: "rule delay", opts: ServerOptions{IDBytes: 2, Frames: rules}, frame: "03SLOW", reply: "03LATE", delay: time.Second},
+		{name: "drop", opts: ServerOptions{IDBytes: 2, Frames: rules}, frame: "04BLACKHOLE", noSend: true},
+		{name: "unmatched echoes body", opts: ServerOptions{IDBytes: 2, Frames: rules}, frame: "05other", reply: "05other"},
+		{name: "frame shorter than id", opts: ServerOptions{IDBytes: 4}, frame: "ab", reply: "ab"},
+		{name: "fixed delay", opts: ServerOptions{MinDelay: time.Millisecond, MaxDelay: time.Millisecond}, frame: "x", reply: "x", delay: time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newFramedForTest(t, tt.opts)
+			reply, delay, ok := h.respond([]byte(tt.frame))
+			if ok == tt.noSend {
+				t.Fatalf("reply sent = %v, want %v", ok, !tt.noSend)
+			}
+			if ok && (string(reply) != tt.reply || delay != tt.delay) {
+				t.Fatalf("reply = %q after %s, want %q after %s", reply, delay, tt.reply, tt.delay)
+			}
+		})
+	}
+}
+
+func TestFramedOptionsValidation(t *testing.T) {
+	lg := testLogger(t)
+	for _, opts := range []ServerOptions{
+		{MinDelay: time.Second, MaxDelay: time.Millisecond},
+		{IDBytes: -1},
+		{Frames: []FrameRule{{Match: "("}}},
+	} {
+		if _, err := newFramedHandler(&opts, lg, nil); err == nil {
+			t.Errorf("options %+v accepted", opts)
+		}
+	}
+}
+
+func readFrames(t *testing.T, c net.Conn, n int) []string {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(waitTimeout))
+	var got []string
+	for range n {
+		frame, err := readFrame(c, defaultMaxFrameSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(frame))
+	}
+	return got
+}
+
+func writeFrames(t *testing.T, c net.Conn, payloads ...string) {
+	t.Helper()
+	var b bytes.Buffer
+	for _, p := range payloads {
+		writeFrame(&b, []byte(p))
+	}
+	go c.Write(b.Bytes())
+}
+
+var pipelineRules = []FrameRule{{Prefix: "slow", Reply: "slow", Delay: 100 * time.Millisecond}}
+
+// 按序模式下慢请求的回复阻塞后面的回复
+func TestFramedPipelineInOrder(t *testing.T) {
+	c := servePipe(t, newFramedForTest(t, ServerOptions{IDBytes: 1, Frames: pipelineRules}))
+	writeFrames(t, c, "1slow", "2fast", "3fast")
+	got := readFrames(t, c, 3)
+	if want := []string{"1slow", "2fast", "3fast"}; !slices.Equal(got, want) {
+		t.Fatalf("replies = %v, want %v", got, want)
+	}
+}
+
+// 乱序模式下按延迟到期的顺序回复，客户端按ID匹配
+func TestFramedPipelineOutOfOrder(t *testing.T) {
+	c := servePipe(t, newFramedForTest(t, ServerOptions{IDBytes: 1, Frames: pipelineRules, OutOfOrder: true}))
+	writeFrames(t, c, "1slow", "2fast")
+	got := readFrames(t, c, 2)
+	if want := []string{"2fast", "1slow"}; !slices.Equal(got, want) {
+		t.Fatalf("replies = %v, want %v", got, want)
+	}
+}
+
+// 未回复的请求达到backlog后暂停读取，不再为新请求启动协程
+func TestFramedBacklogBoundsInflight(t *testing.T) {
+	for _, outOfOrder := range []bool{false, true} {
+		h := newFramedForTest(t, ServerOptions{IDBytes: 1, Frames: pipelineRules, OutOfOrder: outOfOrder})
+		h.backlog = 2
+		c := servePipe(t, h)
+		start := time.Now()
+		writeFrames(t, c, "1slow", "2slow", "3slow", "4slow")
+		readFrames(t, c, 4)
+		// 每次最多两个请求在等待延迟，四个请求至少需要两轮
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Fatalf("out_of_order=%v: 4 replies after %s, backlog not enforced", outOfOrder, elapsed)
+		}
+	}
+}
+
+// 客户端半关闭后仍发送未完成的回复
+func TestFramedFlushesAfterClientDone(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	h := newFramedForTest(t, ServerOptions{IDBytes: 1, Frames: pipelineRules})
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		h.ServeTCP(t.Context(), c)
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Write(frameBytes("1slow"))
+	c.(*net.TCPConn).CloseWrite()
+	if got := readFrames(t, c, 1); got[0] != "1slow" {
+		t.Fatalf("reply = %v", got)
+	}
+}
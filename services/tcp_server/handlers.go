@@ -0,0 +1,229 @@
+package tcp_server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+)
+
+// 可选的处理模式
+const (
+	ModeBanner     = "banner"      // 发送一行欢迎语后关闭（默认，与原先的 tcpHandler 一致）
+	ModeEcho       = "echo"        // 原样返回收到的数据
+	ModeDiscard    = "discard"     // 读取并丢弃所有数据
+	ModeChargen    = "chargen"     // 持续发送字符流，直到客户端关闭
+	ModeScript     = "script"      // 按行匹配规则返回响应
+	ModeDelayEcho  = "delay-echo"  // 每次回显前随机延迟
+	ModeCloseAfter = "close-after" // 回显，累计收到N字节后主动关闭
+	ModeFramed     = "framed"      // 4字节长度前缀的帧协议，按规则回复
+)
+
+const defaultBanner = "tcpHandler\n"
+
+// ScriptRule 一条按行匹配的规则，Match为正则，Reply中可用 $1 引用分组
+type ScriptRule struct {
+	Match string `yaml:"match"`
+	Reply string `yaml:"reply"`
+	Close bool   `yaml:"close"` // 回复后关闭连接
+}
+
+// NewHandler 按模式创建处理器
+func NewHandler(opts *ServerOptions, lg *logger.Logger, mt *metrics.Instance) (TCPHandler, error) {
+	if opts == nil {
+		opts = &ServerOptions{}
+	}
+	switch opts.Mode {
+	case "", ModeBanner:
+		banner := opts.Banner
+		if banner == "" {
+			banner = defaultBanner
+		}
+		return &bannerHandler{banner: banner, logger: lg}, nil
+	case ModeEcho:
+		return &echoHandler{logger: lg}, nil
+	case ModeDiscard:
+		return &discardHandler{logger: lg}, nil
+	case ModeChargen:
+		return &chargenHandler{logger: lg}, nil
+	case ModeScript:
+		return newScriptHandler(opts, lg)
+	case ModeDelayEcho:
+		if opts.MaxDelay < opts.MinDelay {
+			return nil, fmt.Errorf("max_delay %v is less than min_delay %v", opts.MaxDelay, opts.MinDelay)
+		}
+		return &delayEchoHandler{min: opts.MinDelay, max: opts.MaxDelay, logger: lg}, nil
+	case ModeCloseAfter:
+		if opts.CloseAfter <= 0 {
+			return nil, fmt.Errorf("close_after must be positive")
+		}
+		return &closeAfterHandler{limit: opts.CloseAfter, logger: lg}, nil
+	case ModeFramed:
+		return newFramedHandler(opts, lg, mt)
+	default:
+		return nil, fmt.Errorf("unknown tcp mode %q, available: banner, echo, discard, chargen, script, delay-echo, close-after, framed", opts.Mode)
+	}
+}
+
+type bannerHandler struct {
+	banner string
+	logger *logger.Logger
+}
+
+func (h *bannerHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	h.logger.Debug("connection accepted", "remote", src.RemoteAddr().String())
+	src.Write([]byte(h.banner))
+}
+
+type echoHandler struct {
+	logger *logger.Logger
+}
+
+func (h *echoHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	n, err := io.Copy(src, src)
+	h.logger.Debug("echo finished", "remote", src.RemoteAddr().String(), "bytes", n, "err", err)
+}
+
+type discardHandler struct {
+	logger *logger.Logger
+}
+
+func (h *discardHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	n, err := io.Copy(io.Discard, src)
+	h.logger.Debug("discard finished", "remote", src.RemoteAddr().String(), "bytes", n, "err", err)
+}
+
+// chargenHandler 按RFC 864的格式循环发送72字符的行
+type chargenHandler struct {
+	logger *logger.Logger
+}
+
+func (h *chargenHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	const (
+		first   = ' ' + 1
+		count   = '~' - first + 1
+		lineLen = 72
+	)
+	// 预先生成所有行，循环写出
+	var pattern []byte
+	for i := 0; i < count; i++ {
+		for j := 0; j < lineLen; j++ {
+			pattern = append(pattern, byte(first+(i+j)%count))
+		}
+		pattern = append(pattern, '\r', '\n')
+	}
+	// 客户端发送的数据直接丢弃，读失败说明连接已关闭
+	go io.Copy(io.Discard, src)
+	var total int64
+	for {
+		n, err := src.Write(pattern)
+		total += int64(n)
+		if err != nil {
+			h.logger.Debug("chargen finished", "remote", src.RemoteAddr().String(), "bytes", total, "err", err)
+			return
+		}
+	}
+}
+
+type scriptRule struct {
+	re    *regexp.Regexp
+	reply string
+	close bool
+}
+
+// scriptHandler 按行读取请求，返回第一条匹配规则的回复
+type scriptHandler struct {
+	greeting     string
+	rules        []scriptRule
+	defaultReply string
+	logger       *logger.Logger
+}
+
+func newScriptHandler(opts *ServerOptions, lg *logger.Logger) (*scriptHandler, error) {
+	h := &scriptHandler{greeting: opts.Banner, defaultReply: opts.DefaultReply, logger: lg}
+	for _, r := range opts.Script {
+		re, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid script match %q: %w", r.Match, err)
+		}
+		h.rules = append(h.rules, scriptRule{re: re, reply: r.Reply, close: r.Close})
+	}
+	return h, nil
+}
+
+func (h *scriptHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	if h.greeting != "" {
+		if _, err := io.WriteString(src, h.greeting); err != nil {
+			return
+		}
+	}
+	scanner := bufio.NewScanner(src)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		reply, close := h.defaultReply, false
+		for _, r := range h.rules {
+			if m := r.re.FindStringSubmatchIndex(line); m != nil {
+				reply = string(r.re.ExpandString(nil, r.reply, line, m))
+				close = r.close
+				break
+			}
+		}
+		h.logger.Trace("script line", "remote", src.RemoteAddr().String(), "line", line, "reply", reply)
+		if reply != "" {
+			if _, err := io.WriteString(src, reply); err != nil {
+				return
+			}
+		}
+		if close {
+			return
+		}
+	}
+}
+
+// delayEchoHandler 每次读到数据后等待 [min, max] 之间的随机时间再回显
+type delayEchoHandler struct {
+	min, max time.Duration
+	logger   *logger.Logger
+}
+
+func (h *delayEchoHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			delay := h.min
+			if h.max > h.min {
+				delay += time.Duration(rand.Int63n(int64(h.max - h.min)))
+			}
+			h.logger.Trace("delay echo", "remote", src.RemoteAddr().String(), "bytes", n, "delay", delay)
+			tracing.InjectedLatency(ctx, delay)
+			time.Sleep(delay)
+			if _, werr := src.Write(buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// closeAfterHandler 回显数据，累计收到limit字节后截断并关闭连接，用于模拟上游中途断开
+type closeAfterHandler struct {
+	limit  int64
+	logger *logger.Logger
+}
+
+func (h *closeAfterHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	n, err := io.Copy(src, io.LimitReader(src, h.limit))
+	h.logger.Debug("close after limit", "remote", src.RemoteAddr().String(), "bytes", n, "err", err)
+}
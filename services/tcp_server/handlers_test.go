@@ -0,0 +1,210 @@
+package tcp_server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHandlerForTest(t *testing.T, opts *ServerOptions) TCPHandler {
+	t.Helper()
+	h, err := NewHandler(opts, testLogger(t), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func writeString(t *testing.T, conn net.Conn, s string) {
+	t.Helper()
+	conn.SetWriteDeadline(time.Now().Add(waitTimeout))
+	if _, err := io.WriteString(conn, s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readLine(t *testing.T, conn net.Conn, r *bufio.Reader) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read %q: %v", line, err)
+	}
+	return line
+}
+
+// 读取到handler关闭连接为止
+func readAll(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// 在timeout内没有收到任何数据
+func expectSilence(t *testing.T, conn net.Conn, timeout time.Duration) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	var buf [1]byte
+	n, err := conn.Read(buf[:])
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("read %q, %v, want timeout", buf[:n], err)
+	}
+}
+
+func TestBannerHandler(t *testing.T) {
+	for _, tc := range []struct {
+		banner, want string
+	}{
+		{"", defaultBanner},
+		{"hello\n", "hello\n"},
+	} {
+		conn := servePipe(t, newHandlerForTest(t, &ServerOptions{Mode: ModeBanner, Banner: tc.banner}))
+		if got := readAll(t, conn); got != tc.want {
+			t.Errorf("banner %q: got %q, want %q", tc.banner, got, tc.want)
+		}
+	}
+	// 零值参数为banner模式
+	if got := readAll(t, servePipe(t, newHandlerForTest(t, nil))); got != defaultBanner {
+		t.Errorf("default handler sent %q", got)
+	}
+}
+
+func TestEchoHandler(t *testing.T) {
+	conn := servePipe(t, newHandlerForTest(t, &ServerOptions{Mode: ModeEcho}))
+	r := bufio.NewReader(conn)
+	for _, line := range []string{"hello\n", "world\n"} {
+		writeString(t, conn, line)
+		if got := readLine(t, conn, r); got != line {
+			t.Fatalf("echo = %q, want %q", got, line)
+		}
+	}
+}
+
+func TestDiscardHandler(t *testing.T) {
+	conn := servePipe(t, newHandlerForTest(t, &ServerOptions{Mode: ModeDiscard}))
+	// net.Pipe的写在对端读完后才返回，说明数据已被读取
+	writeString(t, conn, strings.Repeat("x", 64<<10))
+	expectSilence(t, conn, 100*time.Millisecond)
+}
+
+func TestChargenHandler(t *testing.T) {
+	conn := servePipe(t, newHandlerForTest(t, &ServerOptions{Mode: ModeChargen}))
+	r := bufio.NewReader(conn)
+	first := readLine(t, conn, r)
+	second := readLine(t, conn, r)
+	if len(first) != 74 || !strings.HasSuffix(first, "\r\n") {
+		t.Fatalf("line = %q, want 72 characters and CRLF", first)
+	}
+	if !strings.HasPrefix(first, "!\"#$%") || !strings.HasPrefix(second, "\"#$%") {
+		t.Fatalf("lines = %q, %q", first, second)
+	}
+	// 每行相对上一行左移一个字符
+	if first[1:72] != second[:71] {
+		t.Fatalf("second line not rotated: %q, %q", first, second)
+	}
+	// 客户端发送的数据被丢弃，不影响字符流
+	writeString(t, conn, "ignored\n")
+	if line := readLine(t, conn, r); len(line) != 74 {
+		t.Fatalf("line after client write = %q", line)
+	}
+}
+
+func TestScriptHandler(t *testing.T) {
+	conn := servePipe(t, newHandlerForTest(t, &ServerOptions{
+		Mode:         ModeScript,
+		Banner:       "READY\n",
+		DefaultReply: "ERR unknown\n",
+		Script: []ScriptRule{
+			{Match: `^GET (\w+)$`, Reply: "VALUE $1\n"},
+			{Match: `^SET (\w+) (\w+)$`, Reply: "STORED ${1}=${2}\n"},
+			{Match: `^QUIT$`, Reply: "BYE\n", Close: true},
+		},
+	}))
+	r := bufio.NewReader(conn)
+	if got := readLine(t, conn, r); got != "READY\n" {
+		t.Fatalf("greeting = %q", got)
+	}
+	for _, tc := range []struct {
+		send, want string
+	}{
+		{"GET foo\n", "VALUE foo\n"},
+		{"SET a b\r\n", "STORED a=b\n"}, // 行尾的\r被去掉
+		{"DEL foo\n", "ERR unknown\n"},
+	} {
+		writeString(t, conn, tc.send)
+		if got := readLine(t, conn, r); got != tc.want {
+			t.Fatalf("%q: reply = %q, want %q", tc.send, got, tc.want)
+		}
+	}
+	writeString(t, conn, "QUIT\n")
+	if got := readLine(t, conn, r); got != "BYE\n" {
+		t.Fatalf("QUIT reply = %q", got)
+	}
+	if rest := readAll(t, conn); rest != "" {
+		t.Fatalf("data after close rule: %q", rest)
+	}
+}
+
+func TestScriptHandlerWithoutDefault(t *testing.T) {
+	conn := servePipe(t, newHandlerForTest(t, &ServerOptions{
+		Mode:   ModeScript,
+		Script: []ScriptRule{{Match: `^PING$`, Reply: "PONG\n"}},
+	}))
+	// 没有匹配且没有default_reply时不回复
+	writeString(t, conn, "other\n")
+	expectSilence(t, conn, 100*time.Millisecond)
+	writeString(t, conn, "PING\n")
+	if got := readLine(t, conn, bufio.NewReader(conn)); got != "PONG\n" {
+		t.Fatalf("reply = %q", got)
+	}
+}
+
+func TestDelayEchoHandler(t *testing.T) {
+	conn := servePipe(t, newHandlerForTest(t, &ServerOptions{Mode: ModeDelayEcho, MinDelay: 50 * time.Millisecond, MaxDelay: 60 * time.Millisecond}))
+	r := bufio.NewReader(conn)
+	start := time.Now()
+	writeString(t, conn, "ping\n")
+	if got := readLine(t, conn, r); got != "ping\n" {
+		t.Fatalf("echo = %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("echo after %s, want at least 50ms", elapsed)
+	}
+}
+
+func TestCloseAfterHandler(t *testing.T) {
+	conn := servePipe(t, newHandlerForTest(t, &ServerOptions{Mode: ModeCloseAfter, CloseAfter: 10}))
+	// 分多次发送，累计到10字节时截断并关闭；关闭后剩余的写失败，忽略
+	go func() {
+		for _, s := range []string{"abcd", "efgh", "ijkl", "mnop"} {
+			if _, err := io.WriteString(conn, s); err != nil {
+				return
+			}
+		}
+	}()
+	if got := readAll(t, conn); got != "abcdefghij" {
+		t.Fatalf("echoed %q, want the first 10 bytes", got)
+	}
+}
+
+func TestNewHandlerValidation(t *testing.T) {
+	lg := testLogger(t)
+	for _, opts := range []ServerOptions{
+		{Mode: "unknown"},
+		{Mode: ModeCloseAfter},
+		{Mode: ModeDelayEcho, MinDelay: time.Second, MaxDelay: time.Millisecond},
+		{Mode: ModeScript, Script: []ScriptRule{{Match: "("}}},
+		{Mode: ModeFramed, Frames: []FrameRule{{Match: "("}}},
+	} {
+		if _, err := NewHandler(&opts, lg, nil); err == nil {
+			t.Errorf("options %+v accepted", opts)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package tcp_server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/proxy_protocol"
+)
+
+// 超过最大连接数时的拒绝方式
+const (
+	RejectClose = "close" // 接受后立即正常关闭（FIN）
+	RejectReset = "rst"   // 接受后以RST关闭
+)
+
+// ServerOptions 单个TCP实例的参数，零值表示banner模式、不限制连接数、不设超时
+type ServerOptions struct {
+	Mode         string        `yaml:"mode"`
+	Banner       string        `yaml:"banner"`        // banner模式发送的内容，script模式连接建立后先发送的内容
+	Script       []ScriptRule  `yaml:"script"`        // script模式的规则，按顺序匹配
+	DefaultReply string        `yaml:"default_reply"` // script模式没有规则匹配时的回复，为空时不回复
+	MinDelay     time.Duration `yaml:"min_delay"`     // delay-echo模式的延迟范围
+	MaxDelay     time.Duration `yaml:"max_delay"`
+	CloseAfter   int64         `yaml:"close_after"` // close-after模式收到多少字节后关闭
+	Frames       []FrameRule   `yaml:"frames"`      // framed模式的规则，按顺序匹配，没有匹配时回显或返回default_reply
+	IDBytes      int           `yaml:"id_bytes"`    // framed模式请求帧开头的请求ID长度，回复帧以相同ID开头
+	MaxFrameSize int           `yaml:"max_frame_size"`
+	OutOfOrder   bool          `yaml:"out_of_order"` // framed模式回复按各自延迟到期的顺序发送，否则按请求顺序
+
+	MaxConns     int           `yaml:"max_conns"`     // 最大连接数，0为不限制
+	Reject       string        `yaml:"reject"`        // 超过最大连接数时的拒绝方式：close/rst，默认close
+	ReadTimeout  time.Duration `yaml:"read_timeout"`  // 每次读的超时
+	WriteTimeout time.Duration `yaml:"write_timeout"` // 每次写的超时
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`  // 连接无任何读写超过该时间后关闭
+	KeepAlive    time.Duration `yaml:"keepalive"`     // TCP keepalive探测间隔
+
+	ProxyProtocol string `yaml:"proxy_protocol"` // PROXY协议头：off/optional/required，默认off
+}
+
+// 应用连接相关参数
+func (o *ServerOptions) apply(srv *TcpServer) error {
+	switch o.Reject {
+	case "", RejectClose, RejectReset:
+	default:
+		return fmt.Errorf("unknown reject mode %q, available: close, rst", o.Reject)
+	}
+	if err := proxy_protocol.CheckMode(o.ProxyProtocol); err != nil {
+		return err
+	}
+	srv.ProxyProtocol = o.ProxyProtocol
+	srv.MaxConns = o.MaxConns
+	srv.RejectMode = o.Reject
+	srv.ReadTimeout = o.ReadTimeout
+	srv.WriteTimeout = o.WriteTimeout
+	srv.IdleTimeout = o.IdleTimeout
+	srv.KeepAliveTimeout = o.KeepAlive
+	return nil
+}
@@ -0,0 +1,189 @@
+package tcp_server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/load_balance"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/proxy_protocol"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+)
+
+const defaultDialTimeout = 3 * time.Second
+
+// Upstream 代理的上游，Addr为 host:port 或受管实例名（如 tcp:3003、redis:6380）
+type Upstream struct {
+	Addr   string `yaml:"addr"`
+	Weight int    `yaml:"weight"` // weight-round-robin与consistent-hash使用，默认1
+}
+
+// ProxyOptions 单个TCP代理实例的参数
+type ProxyOptions struct {
+	Balance          string        `yaml:"balance"` // random/round-robin/weight-round-robin/least-conn/consistent-hash，默认round-robin
+	Upstreams        []Upstream    `yaml:"upstreams"`
+	Discovery        string        `yaml:"discovery"`         // 跟随注册中心中该服务路径下的实例（host:port）作为上游，设置后替换upstreams
+	DiscoveryBackend string        `yaml:"discovery_backend"` // 服务发现使用的注册中心：zookeeper/etcd，默认zookeeper
+	DialTimeout      time.Duration `yaml:"dial_timeout"`      // 连接上游的超时，默认3s
+	MaxConns         int           `yaml:"max_conns"`         // 最大连接数，0为不限制
+	IdleTimeout      time.Duration `yaml:"idle_timeout"`      // 连接无任何读写超过该时间后关闭
+	ProxyProtocol    string        `yaml:"proxy_protocol"`    // 解析客户端连接的PROXY协议头：off/optional/required，默认off
+}
+
+// Resolver 把上游名称解析为可连接的地址，名称不是受管实例时原样返回
+type Resolver func(name string) string
+
+// TcpProxy TCP负载均衡反向代理
+type TcpProxy struct {
+	*TcpServer
+	handler *proxyHandler
+}
+
+// SetUpstreams 整体替换上游列表（服务发现回调）
+func (p *TcpProxy) SetUpstreams(addrs []string) error {
+	nodes := make([]load_balance.Node, len(addrs))
+	for i, addr := range addrs {
+		nodes[i] = load_balance.Node{Addr: addr}
+	}
+	if err := p.handler.lb.Update(nodes); err != nil {
+		return err
+	}
+	p.handler.logger.Info("upstreams updated", "upstreams", addrs)
+	return nil
+}
+
+func Run_tcp_proxy(port int, opts *ProxyOptions, resolve Resolver, lg *logger.Logger, mt *metrics.Instance, tr *tracing.Instance) (*TcpProxy, error) {
+	// 记录服务器启动日志
+	lg.Info("开始启动TCP代理", "port", port, "balance", opts.Balance, "upstreams", len(opts.Upstreams))
+	if err := proxy_protocol.CheckMode(opts.ProxyProtocol); err != nil {
+		return nil, err
+	}
+	handler, err := newProxyHandler(opts, resolve, lg)
+	if err != nil {
+		return nil, err
+	}
+	srv := &TcpServer{
+		Addr:          ":" + strconv.Itoa(port),
+		Handler:       handler,
+		Logger:        lg,
+		Metrics:       mt,
+		Tracer:        tr,
+		MaxConns:      opts.MaxConns,
+		IdleTimeout:   opts.IdleTimeout,
+		ProxyProtocol: opts.ProxyProtocol,
+	}
+	ln, err := srv.Listen()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != ErrServerClosed {
+			lg.Error("TCP proxy failed", "err", err)
+		}
+	}()
+	return &TcpProxy{TcpServer: srv, handler: handler}, nil
+}
+
+type proxyHandler struct {
+	lb          *load_balance.Dynamic
+	resolve     Resolver
+	dialTimeout time.Duration
+	logger      *logger.Logger
+}
+
+func newProxyHandler(opts *ProxyOptions, resolve Resolver, lg *logger.Logger) (*proxyHandler, error) {
+	if len(opts.Upstreams) == 0 && opts.Discovery == "" {
+		return nil, fmt.Errorf("tcp proxy needs at least one upstream or a discovery path")
+	}
+	typ, err := load_balance.ParseType(opts.Balance)
+	if err != nil {
+		return nil, err
+	}
+	lb := load_balance.NewDynamic(typ)
+	for _, u := range opts.Upstreams {
+		weight := ""
+		if u.Weight != 0 {
+			weight = strconv.Itoa(u.Weight)
+		}
+		if err := lb.Add(u.Addr, weight); err != nil {
+			return nil, err
+		}
+	}
+	if resolve == nil {
+		resolve = func(name string) string { return name }
+	}
+	h := &proxyHandler{
+		lb:          lb,
+		resolve:     resolve,
+		dialTimeout: opts.DialTimeout,
+		logger:      lg,
+	}
+	if h.dialTimeout <= 0 {
+		h.dialTimeout = defaultDialTimeout
+	}
+	return h, nil
+}
+
+// 选择上游并连接，失败时换下一个，最多尝试上游个数次。
+// 成功时调用方在连接结束后调用 lb.Done，失败的选择在这里释放（least-conn按连接计数）
+func (h *proxyHandler) dial(key string) (string, net.Conn, error) {
+	tried := make(map[string]bool)
+	var lastErr error
+	for i := 0; i < max(h.lb.Len(), 1); i++ {
+		name, err := h.lb.Get(key)
+		if err != nil {
+			return "", nil, err
+		}
+		// 一致性哈希对同一个key总是返回同一节点，重复时不再重试
+		if tried[name] {
+			h.lb.Done(name)
+			break
+		}
+		tried[name] = true
+		addr := h.resolve(name)
+		dst, err := net.DialTimeout("tcp", addr, h.dialTimeout)
+		if err == nil {
+			return name, dst, nil
+		}
+		h.lb.Done(name)
+		h.logger.Warn("dial upstream failed", "upstream", name, "dial_addr", addr, "err", err)
+		lastErr = err
+	}
+	return "", nil, fmt.Errorf("all upstreams failed: %w", lastErr)
+}
+
+func (h *proxyHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	// 一致性哈希按客户端IP，有PROXY协议头时使用其中的原始客户端地址
+	remote := src.RemoteAddr().String()
+	client := remote
+	if header, _ := ctx.Value(ProxyHeaderContextKey).(*proxy_protocol.Header); header != nil && header.Source != nil {
+		client = header.Source.String()
+	}
+	key := client
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		key = host
+	}
+	name, dst, err := h.dial(key)
+	if err != nil {
+		h.logger.Error("no upstream available", "remote", remote, "err", err)
+		return
+	}
+	defer h.lb.Done(name)
+	defer dst.Close()
+	h.logger.Debug("proxy connection", "remote", remote, "upstream", name, "upstream_addr", dst.RemoteAddr().String())
+
+	// 客户端发完后半关闭上游写方向，上游回复结束后整个连接结束
+	go func() {
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+	}()
+	n, err := io.Copy(src, dst)
+	h.logger.Trace("upstream finished", "remote", remote, "upstream", name, "bytes", n, "err", err)
+}
@@ -0,0 +1,200 @@
+package tcp_server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 启动一个上游：连接建立后发送自己的名称，之后丢弃收到的数据直到客户端关闭
+func startBackend(t *testing.T, name string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.WriteString(conn, name+"\n")
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// 没有监听的地址
+func deadAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func startProxyForTest(t *testing.T, opts *ProxyOptions, resolve Resolver) (*TcpProxy, string) {
+	t.Helper()
+	p, err := Run_tcp_proxy(0, opts, resolve, testLogger(t), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p, net.JoinHostPort("127.0.0.1", strconv.Itoa(p.l.Addr().(*net.TCPAddr).Port))
+}
+
+// 通过代理连接，header不为空时先发送PROXY协议头，返回连接与所到的上游名称
+func viaProxy(t *testing.T, addr, header string) (net.Conn, string) {
+	t.Helper()
+	conn := dialTCP(t, addr)
+	if header != "" {
+		writeString(t, conn, header)
+	}
+	return conn, strings.TrimSuffix(readLine(t, conn, bufio.NewReader(conn)), "\n")
+}
+
+func upstreams(addrs ...string) []Upstream {
+	us := make([]Upstream, len(addrs))
+	for i, a := range addrs {
+		us[i] = Upstream{Addr: a}
+	}
+	return us
+}
+
+func TestProxyRoundRobinAndFailover(t *testing.T) {
+	_, addr := startProxyForTest(t, &ProxyOptions{Upstreams: upstreams(startBackend(t, "a"), deadAddr(t), startBackend(t, "b"))}, nil)
+	// 连接失败的上游被跳过，其余上游轮流
+	counts := map[string]int{}
+	for range 6 {
+		conn, name := viaProxy(t, addr, "")
+		counts[name]++
+		conn.Close()
+	}
+	if counts["a"] != 3 || counts["b"] != 3 {
+		t.Fatalf("distribution = %v, want 3/3 with the dead upstream skipped", counts)
+	}
+}
+
+func TestProxyAllUpstreamsDown(t *testing.T) {
+	_, addr := startProxyForTest(t, &ProxyOptions{Upstreams: upstreams(deadAddr(t), deadAddr(t)), DialTimeout: time.Second}, nil)
+	if err := readUntilClosed(t, dialTCP(t, addr)); err != nil {
+		t.Fatalf("connection closed with %v", err)
+	}
+}
+
+func TestProxyLeastConn(t *testing.T) {
+	p, addr := startProxyForTest(t, &ProxyOptions{Balance: "least-conn", Upstreams: upstreams(startBackend(t, "a"), startBackend(t, "b"))}, nil)
+	held, busy := viaProxy(t, addr, "")
+	waitConns(t, p.TcpServer, 1)
+	// 一个上游有保持中的连接，之后的短连接都到另一个上游
+	for range 4 {
+		conn, name := viaProxy(t, addr, "")
+		if name == busy {
+			t.Fatalf("connection sent to %s, which already holds a connection", busy)
+		}
+		conn.Close()
+		// 连接结束后释放计数
+		waitConns(t, p.TcpServer, 1)
+	}
+	held.Close()
+	waitConns(t, p.TcpServer, 0)
+	counts := map[string]int{}
+	for range 4 {
+		conn, name := viaProxy(t, addr, "")
+		counts[name]++
+		conn.Close()
+		waitConns(t, p.TcpServer, 0)
+	}
+	if counts["a"] != 2 || counts["b"] != 2 {
+		t.Fatalf("idle distribution = %v, want 2/2", counts)
+	}
+}
+
+// 一致性哈希按PROXY协议头中的原始客户端IP，同一IP的不同端口到同一上游
+func TestProxyConsistentHashByProxySource(t *testing.T) {
+	_, addr := startProxyForTest(t, &ProxyOptions{
+		Balance:       "consistent-hash",
+		ProxyProtocol: "required",
+		Upstreams:     upstreams(startBackend(t, "a"), startBackend(t, "b"), startBackend(t, "c")),
+	}, nil)
+	counts := map[string]int{}
+	for i := 1; i <= 30; i++ {
+		src := fmt.Sprintf("10.0.%d.%d", i, i*7%256)
+		var first string
+		for port := 1000; port < 1003; port++ {
+			conn, name := viaProxy(t, addr, fmt.Sprintf("PROXY TCP4 %s 192.0.2.1 %d 80\r\n", src, port))
+			conn.Close()
+			if first == "" {
+				first = name
+				counts[name]++
+			} else if name != first {
+				t.Fatalf("client %s sent to %s and %s", src, first, name)
+			}
+		}
+	}
+	if len(counts) != 3 {
+		t.Fatalf("30 clients spread over %v, want all three upstreams", counts)
+	}
+}
+
+func TestProxyResolvesInstanceName(t *testing.T) {
+	backend := startBackend(t, "managed")
+	var asked []string
+	resolve := func(name string) string {
+		asked = append(asked, name)
+		if name == "tcp:3003" {
+			return backend
+		}
+		return name
+	}
+	_, addr := startProxyForTest(t, &ProxyOptions{Upstreams: upstreams("tcp:3003")}, resolve)
+	conn, name := viaProxy(t, addr, "")
+	conn.Close()
+	if name != "managed" || len(asked) != 1 || asked[0] != "tcp:3003" {
+		t.Fatalf("reached %q, resolver asked for %v", name, asked)
+	}
+}
+
+func TestProxySetUpstreams(t *testing.T) {
+	p, addr := startProxyForTest(t, &ProxyOptions{Upstreams: upstreams(startBackend(t, "a"))}, nil)
+	if err := p.SetUpstreams([]string{startBackend(t, "b"), startBackend(t, "c")}); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for range 4 {
+		conn, name := viaProxy(t, addr, "")
+		counts[name]++
+		conn.Close()
+	}
+	if counts["b"] != 2 || counts["c"] != 2 {
+		t.Fatalf("distribution after update = %v", counts)
+	}
+}
+
+func TestProxyOptionsValidation(t *testing.T) {
+	lg := testLogger(t)
+	for _, opts := range []ProxyOptions{
+		{},
+		{Upstreams: upstreams("a:1"), Balance: "fastest"},
+		{Upstreams: []Upstream{{Addr: "a:1", Weight: -1}}},
+		{Upstreams: upstreams("a:1"), ProxyProtocol: "sometimes"},
+	} {
+		if p, err := Run_tcp_proxy(0, &opts, nil, lg, nil, nil); err == nil {
+			p.Close()
+			t.Errorf("options %+v accepted", opts)
+		}
+	}
+}
@@ -0,0 +1,833 @@
+package tcp_server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+)
+
+const (
+	redisVersion      = "7.2.0"
+	defaultRedisError = "ERR injected error"
+	maxRedisBulkLen   = 512 << 20
+	maxRedisArgs      = 1 << 20
+	maxRedisLineLen   = 64 << 10 // 与redis的内联命令上限相同
+)
+
+var errRedisLineTooLong = errors.New("too big inline request")
+
+// RedisOptions 单个redis mock实例的参数，注入的延迟与错误作用于每条命令
+type RedisOptions struct {
+	MinDelay     time.Duration `yaml:"min_delay"` // 每条命令执行前的随机延迟范围
+	MaxDelay     time.Duration `yaml:"max_delay"`
+	ErrorPercent float64       `yaml:"error_percent"` // 命令返回错误的比例(0-100)
+	ErrorReply   string        `yaml:"error_reply"`   // 注入的错误内容，默认 "ERR injected error"
+	ErrorOn      []string      `yaml:"error_on"`      // 只对这些命令注入错误，为空时对所有命令生效
+	MaxConns     int           `yaml:"max_conns"`     // 最大连接数，0为不限制
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`  // 连接无任何读写超过该时间后关闭
+}
+
+func Run_redis_server(port int, name string, opts *RedisOptions, lg *logger.Logger, mt *metrics.Instance, tr *tracing.Instance) (*TcpServer, error) {
+	// 记录服务器启动日志
+	lg.Info("开始启动redis mock服务器", "port", port)
+	handler, err := newRedisHandler(port, name, opts, lg)
+	if err != nil {
+		return nil, err
+	}
+	srv := &TcpServer{
+		Addr:        ":" + strconv.Itoa(port),
+		Handler:     handler,
+		Logger:      lg,
+		Metrics:     mt,
+		Tracer:      tr,
+		MaxConns:    opts.MaxConns,
+		IdleTimeout: opts.IdleTimeout,
+	}
+	ln, err := srv.Listen()
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != ErrServerClosed {
+			lg.Error("redis mock server failed", "err", err)
+		}
+	}()
+	return srv, nil
+}
+
+type redisEntry struct {
+	value    string
+	expireAt time.Time // 零值表示不过期
+}
+
+func (e *redisEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && !now.Before(e.expireAt)
+}
+
+// redisHandler 内存中的键空间与发布订阅，实例内所有连接共享
+type redisHandler struct {
+	name      string
+	port      int
+	runID     string
+	startedAt time.Time
+	opts      RedisOptions
+	errorOn   map[string]bool
+	logger    *logger.Logger
+
+	mu   sync.Mutex
+	keys map[string]*redisEntry
+
+	subMu    sync.Mutex
+	channels map[string]map[*redisClient]struct{}
+
+	clients  atomic.Int64
+	commands atomic.Int64
+	nextID   atomic.Int64
+}
+
+func newRedisHandler(port int, name string, opts *RedisOptions, lg *logger.Logger) (*redisHandler, error) {
+	if opts.ErrorPercent < 0 || opts.ErrorPercent > 100 {
+		return nil, fmt.Errorf("error_percent must be between 0 and 100")
+	}
+	if opts.MaxDelay < opts.MinDelay {
+		return nil, fmt.Errorf("max_delay %v is less than min_delay %v", opts.MaxDelay, opts.MinDelay)
+	}
+	h := &redisHandler{
+		name:      name,
+		port:      port,
+		runID:     fmt.Sprintf("%016x%016x%08x", rand.Uint64(), rand.Uint64(), rand.Uint32()),
+		startedAt: time.Now(),
+		opts:      *opts,
+		logger:    lg,
+		keys:      make(map[string]*redisEntry),
+		channels:  make(map[string]map[*redisClient]struct{}),
+	}
+	if h.opts.ErrorReply == "" {
+		h.opts.ErrorReply = defaultRedisError
+	}
+	if len(opts.ErrorOn) > 0 {
+		h.errorOn = make(map[string]bool)
+		for _, c := range opts.ErrorOn {
+			h.errorOn[strings.ToUpper(c)] = true
+		}
+	}
+	return h, nil
+}
+
+// redisClient 单个连接，订阅消息与命令回复可能并发写出
+type redisClient struct {
+	id    int64 // 连接建立时分配，HELLO与CLIENT ID返回
+	mu    sync.Mutex
+	w     *bufio.Writer
+	proto int // 2或3，只在本连接的协程中修改，修改时持有mu
+	subs  map[string]struct{}
+	name  string
+}
+
+func (c *redisClient) send(f func(w *respWriter)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f(&respWriter{w: c.w, proto: c.proto})
+	return c.w.Flush()
+}
+
+func (h *redisHandler) ServeTCP(ctx context.Context, src net.Conn) {
+	h.clients.Add(1)
+	defer h.clients.Add(-1)
+	c := &redisClient{id: h.nextID.Add(1), w: bufio.NewWriter(src), proto: 2, subs: make(map[string]struct{})}
+	defer h.unsubscribeAll(c)
+
+	r := bufio.NewReader(src)
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				c.send(func(w *respWriter) { w.error("ERR Protocol error: " + err.Error()) })
+				h.logger.Debug("redis protocol error", "remote", src.RemoteAddr().String(), "err", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		h.commands.Add(1)
+		cmd := strings.ToUpper(args[0])
+		h.logger.Trace("redis command", "remote", src.RemoteAddr().String(), "cmd", cmd, "args", len(args)-1)
+		if h.inject(ctx, c, cmd) {
+			continue
+		}
+		if quit := h.exec(c, cmd, args[1:]); quit {
+			return
+		}
+	}
+}
+
+// 注入延迟与错误，返回true表示已回复错误
+func (h *redisHandler) inject(ctx context.Context, c *redisClient, cmd string) bool {
+	delay := h.opts.MinDelay
+	if h.opts.MaxDelay > h.opts.MinDelay {
+		delay += time.Duration(rand.Int63n(int64(h.opts.MaxDelay - h.opts.MinDelay)))
+	}
+	if delay > 0 {
+		tracing.InjectedLatency(ctx, delay)
+		time.Sleep(delay)
+	}
+	if h.opts.ErrorPercent <= 0 || (h.errorOn != nil && !h.errorOn[cmd]) {
+		return false
+	}
+	if rand.Float64()*100 >= h.opts.ErrorPercent {
+		return false
+	}
+	tracing.InjectedFault(ctx, cmd+": "+h.opts.ErrorReply)
+	c.send(func(w *respWriter) { w.error(h.opts.ErrorReply) })
+	return true
+}
+
+func wrongArgs(cmd string) string {
+	return fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd))
+}
+
+// 执行一条命令，返回true表示关闭连接
+func (h *redisHandler) exec(c *redisClient, cmd string, args []string) bool {
+	// 订阅状态下RESP2只允许订阅相关命令
+	if len(c.subs) > 0 && c.proto == 2 {
+		switch cmd {
+		case "SUBSCRIBE", "UNSUBSCRIBE", "PING", "QUIT", "RESET":
+		default:
+			c.send(func(w *respWriter) {
+				w.error(fmt.Sprintf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(cmd)))
+			})
+			return false
+		}
+	}
+
+	switch cmd {
+	case "PING":
+		c.send(func(w *respWriter) {
+			switch {
+			case len(c.subs) > 0 && c.proto == 2:
+				msg := ""
+				if len(args) > 0 {
+					msg = args[0]
+				}
+				w.arrayHeader(2)
+				w.bulk("pong")
+				w.bulk(msg)
+			case len(args) > 0:
+				w.bulk(args[0])
+			default:
+				w.simple("PONG")
+			}
+		})
+	case "ECHO":
+		if len(args) != 1 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		c.send(func(w *respWriter) { w.bulk(args[0]) })
+	case "QUIT":
+		c.send(func(w *respWriter) { w.simple("OK") })
+		return true
+	case "HELLO":
+		h.hello(c, args)
+	case "SELECT", "RESET":
+		c.send(func(w *respWriter) { w.simple("OK") })
+	case "CLIENT":
+		if len(args) >= 2 && strings.EqualFold(args[0], "SETNAME") {
+			c.name = args[1]
+		}
+		if len(args) >= 1 && strings.EqualFold(args[0], "ID") {
+			c.send(func(w *respWriter) { w.integer(c.id) })
+			return false
+		}
+		if len(args) >= 1 && strings.EqualFold(args[0], "GETNAME") {
+			c.send(func(w *respWriter) { w.bulkOrNull(c.name, c.name != "") })
+			return false
+		}
+		c.send(func(w *respWriter) { w.simple("OK") })
+	case "COMMAND":
+		// redis-cli 启动时会查询命令文档，返回空即可
+		c.send(func(w *respWriter) { w.arrayHeader(0) })
+	case "INFO":
+		section := ""
+		if len(args) > 0 {
+			section = strings.ToLower(args[0])
+		}
+		info := h.info(section)
+		c.send(func(w *respWriter) { w.verbatim(info) })
+	case "DBSIZE":
+		n := h.dbsize()
+		c.send(func(w *respWriter) { w.integer(int64(n)) })
+	case "FLUSHDB", "FLUSHALL":
+		h.mu.Lock()
+		h.keys = make(map[string]*redisEntry)
+		h.mu.Unlock()
+		c.send(func(w *respWriter) { w.simple("OK") })
+	case "GET":
+		if len(args) != 1 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		v, ok := h.get(args[0])
+		c.send(func(w *respWriter) { w.bulkOrNull(v, ok) })
+	case "SET":
+		return h.set(c, cmd, args)
+	case "DEL", "UNLINK", "EXISTS":
+		if len(args) == 0 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		n := h.delOrExists(args, cmd != "EXISTS")
+		c.send(func(w *respWriter) { w.integer(int64(n)) })
+	case "EXPIRE", "PEXPIRE":
+		if len(args) != 2 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return h.replyError(c, "ERR value is not an integer or out of range")
+		}
+		unit := time.Second
+		if cmd == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		ok := h.expire(args[0], time.Duration(n)*unit)
+		c.send(func(w *respWriter) { w.boolInt(ok) })
+	case "TTL", "PTTL":
+		if len(args) != 1 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		unit := time.Second
+		if cmd == "PTTL" {
+			unit = time.Millisecond
+		}
+		ttl := h.ttl(args[0], unit)
+		c.send(func(w *respWriter) { w.integer(ttl) })
+	case "PERSIST":
+		if len(args) != 1 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		ok := h.persist(args[0])
+		c.send(func(w *respWriter) { w.boolInt(ok) })
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		return h.incr(c, cmd, args)
+	case "KEYS":
+		if len(args) != 1 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		keys := h.match(args[0])
+		c.send(func(w *respWriter) {
+			w.arrayHeader(len(keys))
+			for _, k := range keys {
+				w.bulk(k)
+			}
+		})
+	case "PUBLISH":
+		if len(args) != 2 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		n := h.publish(args[0], args[1])
+		c.send(func(w *respWriter) { w.integer(int64(n)) })
+	case "SUBSCRIBE":
+		if len(args) == 0 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		for _, ch := range args {
+			n := h.subscribe(c, ch)
+			c.send(func(w *respWriter) { w.pushHeader(3); w.bulk("subscribe"); w.bulk(ch); w.integer(int64(n)) })
+		}
+	case "UNSUBSCRIBE":
+		channels := args
+		if len(channels) == 0 {
+			for ch := range c.subs {
+				channels = append(channels, ch)
+			}
+			sort.Strings(channels)
+		}
+		if len(channels) == 0 {
+			c.send(func(w *respWriter) { w.pushHeader(3); w.bulk("unsubscribe"); w.null(); w.integer(0) })
+		}
+		for _, ch := range channels {
+			n := h.unsubscribe(c, ch)
+			c.send(func(w *respWriter) { w.pushHeader(3); w.bulk("unsubscribe"); w.bulk(ch); w.integer(int64(n)) })
+		}
+	default:
+		return h.replyError(c, fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(cmd)))
+	}
+	return false
+}
+
+func (h *redisHandler) replyError(c *redisClient, msg string) bool {
+	c.send(func(w *respWriter) { w.error(msg) })
+	return false
+}
+
+// HELLO [protover [AUTH user pass] [SETNAME name]]
+func (h *redisHandler) hello(c *redisClient, args []string) {
+	if len(args) > 0 {
+		v, err := strconv.Atoi(args[0])
+		if err != nil || (v != 2 && v != 3) {
+			h.replyError(c, "NOPROTO unsupported protocol version")
+			return
+		}
+		// publish在其它连接的协程中按proto编码推送消息
+		c.mu.Lock()
+		c.proto = v
+		c.mu.Unlock()
+		for i := 1; i+1 < len(args); i++ {
+			if strings.EqualFold(args[i], "SETNAME") {
+				c.name = args[i+1]
+			}
+		}
+	}
+	c.send(func(w *respWriter) {
+		w.mapHeader(7)
+		w.bulk("server")
+		w.bulk("redis")
+		w.bulk("version")
+		w.bulk(redisVersion)
+		w.bulk("proto")
+		w.integer(int64(c.proto))
+		w.bulk("id")
+		w.integer(c.id)
+		w.bulk("mode")
+		w.bulk("standalone")
+		w.bulk("role")
+		w.bulk("master")
+		w.bulk("modules")
+		w.arrayHeader(0)
+	})
+}
+
+// SET key value [EX seconds|PX milliseconds] [NX|XX] [GET]
+func (h *redisHandler) set(c *redisClient, cmd string, args []string) bool {
+	if len(args) < 2 {
+		return h.replyError(c, wrongArgs(cmd))
+	}
+	key, value := args[0], args[1]
+	var ttl time.Duration
+	var nx, xx, get bool
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return h.replyError(c, "ERR syntax error")
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				return h.replyError(c, "ERR invalid expire time in 'set' command")
+			}
+			ttl = time.Duration(n) * time.Second
+			if strings.EqualFold(args[i], "PX") {
+				ttl = time.Duration(n) * time.Millisecond
+			}
+			i++
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GET":
+			get = true
+		default:
+			return h.replyError(c, "ERR syntax error")
+		}
+	}
+
+	now := time.Now()
+	h.mu.Lock()
+	old, exists := h.keys[key]
+	if exists && old.expired(now) {
+		delete(h.keys, key)
+		old, exists = nil, false
+	}
+	var oldValue string
+	if exists {
+		oldValue = old.value
+	}
+	ok := !(nx && exists) && !(xx && !exists)
+	if ok {
+		e := &redisEntry{value: value}
+		if ttl > 0 {
+			e.expireAt = now.Add(ttl)
+		}
+		h.keys[key] = e
+	}
+	h.mu.Unlock()
+
+	c.send(func(w *respWriter) {
+		switch {
+		case get:
+			w.bulkOrNull(oldValue, exists)
+		case ok:
+			w.simple("OK")
+		default:
+			w.null()
+		}
+	})
+	return false
+}
+
+func (h *redisHandler) incr(c *redisClient, cmd string, args []string) bool {
+	delta := int64(1)
+	switch cmd {
+	case "INCR", "DECR":
+		if len(args) != 1 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+	default:
+		if len(args) != 2 {
+			return h.replyError(c, wrongArgs(cmd))
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return h.replyError(c, "ERR value is not an integer or out of range")
+		}
+		delta = n
+	}
+	if strings.HasPrefix(cmd, "DECR") {
+		delta = -delta
+	}
+
+	h.mu.Lock()
+	e, ok := h.keys[args[0]]
+	if ok && e.expired(time.Now()) {
+		ok = false
+	}
+	var cur int64
+	if ok {
+		n, err := strconv.ParseInt(e.value, 10, 64)
+		if err != nil {
+			h.mu.Unlock()
+			return h.replyError(c, "ERR value is not an integer or out of range")
+		}
+		cur = n
+	} else {
+		e = &redisEntry{}
+		h.keys[args[0]] = e
+	}
+	cur += delta
+	e.value = strconv.FormatInt(cur, 10)
+	h.mu.Unlock()
+
+	c.send(func(w *respWriter) { w.integer(cur) })
+	return false
+}
+
+func (h *redisHandler) get(key string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.keys[key]
+	if !ok {
+		return "", false
+	}
+	if e.expired(time.Now()) {
+		delete(h.keys, key)
+		return "", false
+	}
+	return e.value, true
+}
+
+func (h *redisHandler) delOrExists(keys []string, del bool) int {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := 0
+	for _, k := range keys {
+		e, ok := h.keys[k]
+		if !ok {
+			continue
+		}
+		if e.expired(now) {
+			delete(h.keys, k)
+			continue
+		}
+		n++
+		if del {
+			delete(h.keys, k)
+		}
+	}
+	return n
+}
+
+func (h *redisHandler) expire(key string, ttl time.Duration) bool {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.keys[key]
+	if !ok || e.expired(now) {
+		delete(h.keys, key)
+		return false
+	}
+	if ttl <= 0 {
+		delete(h.keys, key)
+		return true
+	}
+	e.expireAt = now.Add(ttl)
+	return true
+}
+
+func (h *redisHandler) persist(key string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.keys[key]
+	if !ok || e.expired(time.Now()) || e.expireAt.IsZero() {
+		return false
+	}
+	e.expireAt = time.Time{}
+	return true
+}
+
+// 与redis一致：不存在返回-2，没有过期时间返回-1
+func (h *redisHandler) ttl(key string, unit time.Duration) int64 {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.keys[key]
+	if !ok || e.expired(now) {
+		return -2
+	}
+	if e.expireAt.IsZero() {
+		return -1
+	}
+	return int64((e.expireAt.Sub(now) + unit - 1) / unit)
+}
+
+func (h *redisHandler) match(pattern string) []string {
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var keys []string
+	for k, e := range h.keys {
+		if e.expired(now) {
+			continue
+		}
+		if ok, _ := path.Match(pattern, k); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (h *redisHandler) dbsize() int {
+	return len(h.match("*"))
+}
+
+func (h *redisHandler) subscribe(c *redisClient, ch string) int {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	subs, ok := h.channels[ch]
+	if !ok {
+		subs = make(map[*redisClient]struct{})
+		h.channels[ch] = subs
+	}
+	subs[c] = struct{}{}
+	c.subs[ch] = struct{}{}
+	return len(c.subs)
+}
+
+func (h *redisHandler) unsubscribe(c *redisClient, ch string) int {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	if subs, ok := h.channels[ch]; ok {
+		delete(subs, c)
+		if len(subs) == 0 {
+			delete(h.channels, ch)
+		}
+	}
+	delete(c.subs, ch)
+	return len(c.subs)
+}
+
+func (h *redisHandler) unsubscribeAll(c *redisClient) {
+	for ch := range c.subs {
+		h.unsubscribe(c, ch)
+	}
+}
+
+// 发布消息，返回收到消息的订阅者数
+func (h *redisHandler) publish(ch, msg string) int {
+	h.subMu.Lock()
+	subs := make([]*redisClient, 0, len(h.channels[ch]))
+	for c := range h.channels[ch] {
+		subs = append(subs, c)
+	}
+	h.subMu.Unlock()
+	for _, c := range subs {
+		c.send(func(w *respWriter) {
+			w.pushHeader(3)
+			w.bulk("message")
+			w.bulk(ch)
+			w.bulk(msg)
+		})
+	}
+	return len(subs)
+}
+
+// INFO 输出，实例名写在server段，便于确认请求被代理到了哪个实例
+func (h *redisHandler) info(section string) string {
+	var b strings.Builder
+	all := section == "" || section == "all" || section == "everything" || section == "default"
+	if all || section == "server" {
+		fmt.Fprintf(&b, "# Server\r\nredis_version:%s\r\nredis_mode:standalone\r\nos:go_downstreamer_server mock\r\n", redisVersion)
+		fmt.Fprintf(&b, "run_id:%s\r\ntcp_port:%d\r\nuptime_in_seconds:%d\r\n", h.runID, h.port, int(time.Since(h.startedAt).Seconds()))
+		fmt.Fprintf(&b, "downstream_instance:%s\r\n\r\n", h.name)
+	}
+	if all || section == "clients" {
+		fmt.Fprintf(&b, "# Clients\r\nconnected_clients:%d\r\n\r\n", h.clients.Load())
+	}
+	if all || section == "stats" {
+		fmt.Fprintf(&b, "# Stats\r\ntotal_commands_processed:%d\r\n\r\n", h.commands.Load())
+	}
+	if all || section == "keyspace" {
+		b.WriteString("# Keyspace\r\n")
+		now := time.Now()
+		h.mu.Lock()
+		keys, expires := 0, 0
+		for _, e := range h.keys {
+			if e.expired(now) {
+				continue
+			}
+			keys++
+			if !e.expireAt.IsZero() {
+				expires++
+			}
+		}
+		h.mu.Unlock()
+		if keys > 0 {
+			fmt.Fprintf(&b, "db0:keys=%d,expires=%d,avg_ttl=0\r\n", keys, expires)
+		}
+	}
+	return b.String()
+}
+
+// 读取一条命令：RESP数组或内联命令（telnet直接输入）
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxRedisArgs {
+		return nil, fmt.Errorf("invalid multibulk length")
+	}
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("expected '$', got '%s'", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxRedisBulkLen {
+			return nil, fmt.Errorf("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// 读取一行，超过maxRedisLineLen时返回错误，避免没有换行的数据占满内存
+func readRESPLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxRedisLineLen {
+			return "", errRedisLineTooLong
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// respWriter 按协议版本输出，RESP3时使用null/map/push/verbatim类型
+type respWriter struct {
+	w     *bufio.Writer
+	proto int
+}
+
+func (w *respWriter) simple(s string) { fmt.Fprintf(w.w, "+%s\r\n", s) }
+func (w *respWriter) error(s string)  { fmt.Fprintf(w.w, "-%s\r\n", s) }
+func (w *respWriter) integer(n int64) { fmt.Fprintf(w.w, ":%d\r\n", n) }
+func (w *respWriter) bulk(s string)   { fmt.Fprintf(w.w, "$%d\r\n%s\r\n", len(s), s) }
+
+func (w *respWriter) boolInt(ok bool) {
+	if ok {
+		w.integer(1)
+	} else {
+		w.integer(0)
+	}
+}
+
+func (w *respWriter) null() {
+	if w.proto == 3 {
+		w.w.WriteString("_\r\n")
+	} else {
+		w.w.WriteString("$-1\r\n")
+	}
+}
+
+func (w *respWriter) bulkOrNull(s string, ok bool) {
+	if ok {
+		w.bulk(s)
+	} else {
+		w.null()
+	}
+}
+
+func (w *respWriter) arrayHeader(n int) { fmt.Fprintf(w.w, "*%d\r\n", n) }
+
+func (w *respWriter) mapHeader(n int) {
+	if w.proto == 3 {
+		fmt.Fprintf(w.w, "%%%d\r\n", n)
+	} else {
+		w.arrayHeader(n * 2)
+	}
+}
+
+// 订阅消息在RESP3中是push类型
+func (w *respWriter) pushHeader(n int) {
+	if w.proto == 3 {
+		fmt.Fprintf(w.w, ">%d\r\n", n)
+	} else {
+		w.arrayHeader(n)
+	}
+}
+
+func (w *respWriter) verbatim(s string) {
+	if w.proto == 3 {
+		fmt.Fprintf(w.w, "=%d\r\ntxt:%s\r\n", len(s)+4, s)
+	} else {
+		w.bulk(s)
+	}
+}
@@ -0,0 +1,298 @@
+package tcp_server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+)
+
+const waitTimeout = 3 * time.Second
+
+func testLogger(t *testing.T) *logger.Logger {
+	t.Helper()
+	lg, err := logger.New(logger.Options{Level: "error"}, "test", "tcp", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lg.Close() })
+	return lg
+}
+
+// redisErr 错误回复
+type redisErr string
+
+// redisConn 通过net.Pipe连接到handler的测试客户端
+type redisConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newRedisHandlerForTest(t *testing.T, opts *RedisOptions) *redisHandler {
+	t.Helper()
+	h, err := newRedisHandler(0, "test", opts, testLogger(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// 通过net.Pipe连接到handler，测试结束时关闭连接并等待handler返回
+func servePipe(t *testing.T, h TCPHandler) net.Conn {
+	t.Helper()
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer server.Close()
+		h.ServeTCP(context.Background(), server)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	return client
+}
+
+func dialRedis(t *testing.T, h *redisHandler) *redisConn {
+	t.Helper()
+	client := servePipe(t, h)
+	return &redisConn{t: t, conn: client, r: bufio.NewReader(client)}
+}
+
+func (c *redisConn) send(args ...string) {
+	c.t.Helper()
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	c.write(b.String())
+}
+
+func (c *redisConn) write(data string) {
+	c.t.Helper()
+	c.conn.SetWriteDeadline(time.Now().Add(waitTimeout))
+	if _, err := c.conn.Write([]byte(data)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *redisConn) reply() any {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	v, err := readReply(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return v
+}
+
+func (c *redisConn) do(args ...string) any {
+	c.t.Helper()
+	c.send(args...)
+	return c.reply()
+}
+
+// 解析一个RESP2/RESP3回复：简单字符串与bulk为string，空值为nil，数组/push为[]any，map为map[string]any
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisErr(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '_':
+		return nil, nil
+	case '$', '=':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		s := string(buf[:n])
+		if line[0] == '=' {
+			s = s[4:]
+		}
+		return s, nil
+	case '*', '>':
+		n, _ := strconv.Atoi(line[1:])
+		items := make([]any, 0, n)
+		for range n {
+			v, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, v)
+		}
+		return items, nil
+	case '%':
+		n, _ := strconv.Atoi(line[1:])
+		m := make(map[string]any, n)
+		for range n {
+			k, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			m[fmt.Sprint(k)] = v
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+func expectReply(t *testing.T, got, want any) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("reply = %#v, want %#v", got, want)
+	}
+}
+
+func TestRedisKeyspace(t *testing.T) {
+	c := dialRedis(t, newRedisHandlerForTest(t, &RedisOptions{}))
+	expectReply(t, c.do("SET", "k", "v"), "OK")
+	expectReply(t, c.do("GET", "k"), "v")
+	expectReply(t, c.do("GET", "missing"), nil)
+	expectReply(t, c.do("SET", "k", "v2", "NX"), nil)
+	expectReply(t, c.do("SET", "k", "v2", "XX", "GET"), "v")
+	expectReply(t, c.do("GET"), redisErr("ERR wrong number of arguments for 'get' command"))
+
+	expectReply(t, c.do("INCR", "n"), int64(1))
+	expectReply(t, c.do("INCRBY", "n", "5"), int64(6))
+	expectReply(t, c.do("DECR", "n"), int64(5))
+	expectReply(t, c.do("INCR", "k"), redisErr("ERR value is not an integer or out of range"))
+
+	expectReply(t, c.do("TTL", "k"), int64(-1))
+	expectReply(t, c.do("EXPIRE", "k", "100"), int64(1))
+	expectReply(t, c.do("TTL", "k"), int64(100))
+	expectReply(t, c.do("PERSIST", "k"), int64(1))
+	expectReply(t, c.do("EXPIRE", "missing", "1"), int64(0))
+	expectReply(t, c.do("PEXPIRE", "k", "20"), int64(1))
+	time.Sleep(50 * time.Millisecond)
+	expectReply(t, c.do("GET", "k"), nil)
+	expectReply(t, c.do("TTL", "k"), int64(-2))
+	expectReply(t, c.do("EXISTS", "n", "k"), int64(1))
+	expectReply(t, c.do("DEL", "n"), int64(1))
+	expectReply(t, c.do("DBSIZE"), int64(0))
+}
+
+func TestRedisHello(t *testing.T) {
+	h := newRedisHandlerForTest(t, &RedisOptions{})
+	first, second := dialRedis(t, h), dialRedis(t, h)
+
+	hello, ok := first.do("HELLO", "3", "SETNAME", "app").(map[string]any)
+	if !ok {
+		t.Fatalf("HELLO 3 did not reply with a map")
+	}
+	if hello["proto"] != int64(3) || hello["server"] != "redis" {
+		t.Fatalf("hello = %v", hello)
+	}
+	// 同一连接的id不随命令数变化，不同连接的id不同
+	first.do("PING")
+	again := first.do("HELLO").(map[string]any)
+	if again["id"] != hello["id"] {
+		t.Fatalf("id changed on the same connection: %v -> %v", hello["id"], again["id"])
+	}
+	expectReply(t, first.do("CLIENT", "ID"), hello["id"])
+	expectReply(t, first.do("CLIENT", "GETNAME"), "app")
+	if other := second.do("CLIENT", "ID"); other == hello["id"] {
+		t.Fatalf("two connections share id %v", other)
+	}
+	// RESP3的空值
+	expectReply(t, first.do("GET", "missing"), nil)
+	expectReply(t, second.do("HELLO", "4"), redisErr("NOPROTO unsupported protocol version"))
+}
+
+func TestRedisSubscribe(t *testing.T) {
+	h := newRedisHandlerForTest(t, &RedisOptions{})
+	sub, pub := dialRedis(t, h), dialRedis(t, h)
+
+	expectReply(t, sub.do("SUBSCRIBE", "news", "sport"), []any{"subscribe", "news", int64(1)})
+	expectReply(t, sub.reply(), []any{"subscribe", "sport", int64(2)})
+	// RESP2订阅状态下只允许订阅相关命令
+	if reply, ok := sub.do("GET", "k").(redisErr); !ok || !strings.Contains(string(reply), "only (P|S)SUBSCRIBE") {
+		t.Fatalf("GET while subscribed = %v", reply)
+	}
+	expectReply(t, sub.do("PING"), []any{"pong", ""})
+
+	// net.Pipe没有缓冲，先读取推送的消息，PUBLISH才能返回
+	pub.send("PUBLISH", "news", "hello")
+	expectReply(t, sub.reply(), []any{"message", "news", "hello"})
+	expectReply(t, pub.reply(), int64(1))
+	expectReply(t, pub.do("PUBLISH", "weather", "rain"), int64(0))
+
+	expectReply(t, sub.do("UNSUBSCRIBE"), []any{"unsubscribe", "news", int64(1)})
+	expectReply(t, sub.reply(), []any{"unsubscribe", "sport", int64(0)})
+	expectReply(t, sub.do("GET", "k"), nil)
+	expectReply(t, pub.do("PUBLISH", "news", "again"), int64(0))
+}
+
+func TestRedisInjectedErrors(t *testing.T) {
+	h := newRedisHandlerForTest(t, &RedisOptions{ErrorPercent: 100, ErrorOn: []string{"get"}, ErrorReply: "LOADING fake"})
+	c := dialRedis(t, h)
+	expectReply(t, c.do("SET", "k", "v"), "OK")
+	expectReply(t, c.do("GET", "k"), redisErr("LOADING fake"))
+
+	c = dialRedis(t, newRedisHandlerForTest(t, &RedisOptions{ErrorPercent: 100}))
+	expectReply(t, c.do("PING"), redisErr(defaultRedisError))
+}
+
+func TestRedisInjectedDelay(t *testing.T) {
+	c := dialRedis(t, newRedisHandlerForTest(t, &RedisOptions{MinDelay: 50 * time.Millisecond, MaxDelay: 60 * time.Millisecond}))
+	start := time.Now()
+	expectReply(t, c.do("PING"), "PONG")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("reply after %s, want at least 50ms", elapsed)
+	}
+}
+
+func TestRedisOptionsValidation(t *testing.T) {
+	lg := testLogger(t)
+	for _, opts := range []RedisOptions{
+		{ErrorPercent: 101},
+		{MinDelay: time.Second, MaxDelay: time.Millisecond},
+	} {
+		if _, err := newRedisHandler(0, "test", &opts, lg); err == nil {
+			t.Errorf("options %+v accepted", opts)
+		}
+	}
+}
+
+func TestRedisProtocol(t *testing.T) {
+	h := newRedisHandlerForTest(t, &RedisOptions{})
+	c := dialRedis(t, h)
+	// telnet方式的内联命令
+	c.write("SET inline 1\r\n")
+	expectReply(t, c.reply(), "OK")
+	c.write("PING\r\n")
+	expectReply(t, c.reply(), "PONG")
+
+	c.write("*1\r\n$" + strconv.Itoa(maxRedisBulkLen+1) + "\r\n")
+	expectReply(t, c.reply(), redisErr("ERR Protocol error: invalid bulk length"))
+
+	// 没有换行的超长行被拒绝并关闭连接
+	c = dialRedis(t, h)
+	go c.conn.Write([]byte(strings.Repeat("a", 2*maxRedisLineLen)))
+	expectReply(t, c.reply(), redisErr("ERR Protocol error: "+errRedisLineTooLong.Error()))
+}
@@ -5,6 +5,12 @@ import (
 	"fmt"
 	"net"
 	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/proxy_protocol"
+	"go.opentelemetry.io/otel/codes"
 )
 
 type tcpKeepAliveListener struct {
@@ -32,7 +38,15 @@ type conn struct {
 	server     *TcpServer
 	cancelCtx  context.CancelFunc
 	rwc        net.Conn
+	raw        net.Conn // 未包装的原始连接，用于断开与RST
 	remoteAddr string
+
+	id         uint64
+	startedAt  time.Time
+	bytesIn    atomic.Int64
+	bytesOut   atomic.Int64
+	lastActive atomic.Int64 // 最近一次读写的时间（UnixNano）
+	header     atomic.Pointer[proxy_protocol.Header]
 }
 
 func (c *conn) close() {
@@ -40,17 +54,46 @@ func (c *conn) close() {
 }
 
 func (c *conn) serve(ctx context.Context) {
+	start := c.startedAt
+	mt := c.server.Metrics
+	mt.ConnOpened()
+	// 先解析PROXY协议头，之后的追踪与日志使用原始客户端地址
+	pc, isProxy := c.rwc.(*proxy_protocol.Conn)
+	var header *proxy_protocol.Header
+	var headerErr error
+	if isProxy {
+		if header, headerErr = pc.Header(); header != nil {
+			c.header.Store(header)
+		}
+	}
+	c.rwc = &trackedConn{Conn: metrics.CountingConn(mt, c.rwc), c: c}
+	ctx, span := c.server.Tracer.StartConn(ctx, c.rwc)
+	code := "ok"
 	defer func() {
 		if err := recover(); err != nil && err != ErrAbortHandler {
 			const size = 64 << 10
 			buf := make([]byte, size)
 			buf = buf[:runtime.Stack(buf, false)]
-			fmt.Printf("tcp: panic serving %v: %v\n%s", c.remoteAddr, err, buf)
+			c.server.logf("tcp: panic serving %v: %v\n%s", c.remoteAddr, err, buf)
+			code = "error"
+			span.SetStatus(codes.Error, fmt.Sprint(err))
 		}
+		span.End()
 		c.close()
+		c.server.untrackConn(c)
+		mt.ConnClosed()
+		mt.ObserveRequest(code, time.Since(start))
 	}()
-	c.remoteAddr = c.rwc.RemoteAddr().String()
+	if headerErr != nil {
+		c.server.logf("tcp: invalid proxy protocol header from %v: %v", c.remoteAddr, headerErr)
+		code = "error"
+		span.SetStatus(codes.Error, headerErr.Error())
+		return
+	}
 	ctx = context.WithValue(ctx, LocalAddrContextKey, c.rwc.LocalAddr())
+	if isProxy {
+		ctx = context.WithValue(ctx, ProxyHeaderContextKey, header)
+	}
 	if c.server.Handler == nil {
 		panic("handler empty")
 	}
@@ -1,80 +1,41 @@
 package tcp_server
 
 import (
-	"context"
-	"net"
 	"strconv"
-)
-
-type tcpHandler struct {
-}
 
-func (t *tcpHandler) ServeTCP(ctx context.Context, src net.Conn) {
-	src.Write([]byte("tcpHandler\n"))
-}
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
+)
 
-func Run_tcp_server(port int, tcpLoggerPath *string) (*TcpServer, error) {
+func Run_tcp_server(port int, opts *ServerOptions, lg *logger.Logger, mt *metrics.Instance, tr *tracing.Instance) (*TcpServer, error) {
 	addr := ":" + strconv.Itoa(port)
-	// 初始化日志
-	err := initTcpLogger(*tcpLoggerPath)
+	// 记录服务器启动日志
+	lg.Info("开始启动TCP服务器", "port", port, "mode", opts.Mode)
+	handler, err := NewHandler(opts, lg, mt)
 	if err != nil {
-		tcpLogger.Fatalf("初始化日志失败: %v", err)
+		return nil, err
 	}
-	defer closeTcpLogger()
-
-	// 记录服务器启动日志
-	tcpLogger.Printf("开始启动TCP服务器，端口: %d, 配置路径: %s, 日志路径: %s\n", port, *tcpLoggerPath, tcpLoggerPath)
 
 	tcpServer := TcpServer{
 		Addr:    addr,
-		Handler: &tcpHandler{},
+		Handler: handler,
+		Logger:  lg,
+		Metrics: mt,
+		Tracer:  tr,
+	}
+	if err := opts.apply(&tcpServer); err != nil {
+		return nil, err
 	}
 	// fmt.Println("Starting tcp_server at " + addr)
+	ln, err := tcpServer.Listen()
+	if err != nil {
+		return nil, err
+	}
 	go func() {
-		if err := tcpServer.ListenAndServe(); err != nil {
-			tcpLogger.Printf("TCP server failed:%v\n", port)
+		if err := tcpServer.Serve(ln); err != nil && err != ErrServerClosed {
+			lg.Error("TCP server failed", "err", err)
 		}
 	}()
 	return &tcpServer, nil
-	//代理测试
-	//rb := load_balance.LoadBanlanceFactory(load_balance.LbWeightRoundRobin)
-	//rb.Add("127.0.0.1:6001", "40")
-	//proxy := proxy.NewTcpLoadBalanceReverseProxy(&tcp_middleware.TcpSliceRouterContext{}, rb)
-	//tcpServ := tcp_proxy.TcpServer{Addr: addr, Handler: proxy,}
-	//fmt.Println("Starting tcp_proxy at " + addr)
-	//tcpServ.ListenAndServe()
-
-	//redis服务器测试
-	//rb := load_balance.LoadBanlanceFactory(load_balance.LbWeightRoundRobin)
-	//rb.Add("127.0.0.1:6379", "40")
-	//proxy := proxy.NewTcpLoadBalanceReverseProxy(&tcp_middleware.TcpSliceRouterContext{}, rb)
-	//tcpServ := tcp_proxy.TcpServer{Addr: addr, Handler: proxy,}
-	//fmt.Println("Starting tcp_proxy at " + addr)
-	//tcpServ.ListenAndServe()
-
-	//http服务器测试:
-	//缺点对请求的管控不足,比如我们用来做baidu代理,因为无法更改请求host,所以很轻易把我们拒绝
-	//rb := load_balance.LoadBanlanceFactory(load_balance.LbWeightRoundRobin)
-	//rb.Add("127.0.0.1:2003", "40")
-	////rb.Add("www.baidu.com:80", "40")
-	//proxy := proxy.NewTcpLoadBalanceReverseProxy(&tcp_tcp_middleware.TcpSliceRouterContext{}, rb)
-	//tcpServ := tcp_proxy.TcpServer{Addr: addr, Handler: proxy,}
-	//fmt.Println("tcp_proxy start at:" + addr)
-	//tcpServ.ListenAndServe()
-
-	//websocket服务器测试:缺点对请求的管控不足
-	//rb := load_balance.LoadBanlanceFactory(load_balance.LbWeightRoundRobin)
-	//rb.Add("127.0.0.1:2003", "40")
-	//proxy := proxy.NewTcpLoadBalanceReverseProxy(&tcp_middleware.TcpSliceRouterContext{}, rb)
-	//tcpServ := tcp_proxy.TcpServer{Addr: addr, Handler: proxy,}
-	//fmt.Println("Starting tcp_proxy at " + addr)
-	//tcpServ.ListenAndServe()
-
-	//http2服务器测试:缺点对请求的管控不足
-	//rb := load_balance.LoadBanlanceFactory(load_balance.LbWeightRoundRobin)
-	//rb.Add("127.0.0.1:3003", "40")
-	//proxy := proxy.NewTcpLoadBalanceReverseProxy(&tcp_middleware.TcpSliceRouterContext{}, rb)
-	//tcpServ := tcp_proxy.TcpServer{Addr: addr, Handler: proxy,}
-	//fmt.Println("Starting tcp_proxy at " + addr)
-	//tcpServ.ListenAndServe()
 }
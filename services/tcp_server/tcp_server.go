@@ -8,6 +8,12 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/listen"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+	"github.com/21Mile/go_downstreamer_server/services/proxy_protocol"
+	"github.com/21Mile/go_downstreamer_server/services/tracing"
 )
 
 var (
@@ -15,6 +21,8 @@ var (
 	ErrAbortHandler     = errors.New("tcp: abort TCPHandler")
 	ServerContextKey    = &contextKey{"tcp-server"}
 	LocalAddrContextKey = &contextKey{"local-addr"}
+	// 开启PROXY协议时，值为 *proxy_protocol.Header（optional模式下没有头部时为nil）
+	ProxyHeaderContextKey = &contextKey{"proxy-header"}
 )
 
 type onceCloseListener struct {
@@ -41,10 +49,21 @@ type TcpServer struct {
 	Handler TCPHandler
 	err     error
 	BaseCtx context.Context
+	Logger  *logger.Logger    // 为空时输出到标准输出
+	Metrics *metrics.Instance // 为空时不统计
+	Tracer  *tracing.Instance // 为空时不追踪
 
-	WriteTimeout     time.Duration
-	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration // 每次写的超时
+	ReadTimeout      time.Duration // 每次读的超时
+	IdleTimeout      time.Duration // 无读写超过该时间关闭连接
 	KeepAliveTimeout time.Duration
+	MaxConns         int    // 最大连接数，0为不限制
+	RejectMode       string // 超过最大连接数时的拒绝方式
+	ProxyProtocol    string // PROXY协议头解析模式
+
+	connMu     sync.Mutex
+	conns      map[uint64]*conn
+	nextConnID uint64
 
 	mu         sync.Mutex
 	inShutdown int32
@@ -63,33 +82,51 @@ func (srv *TcpServer) ListenAndServe() error {
 	if srv.doneChan == nil {
 		srv.doneChan = make(chan struct{})
 	}
-	addr := srv.Addr
-	if addr == "" {
-		return errors.New("need addr")
-	}
-	ln, err := net.Listen("tcp", addr)
+	ln, err := srv.Listen()
 	if err != nil {
 		return err
 	}
-	return srv.Serve(tcpKeepAliveListener{
-		ln.(*net.TCPListener)})
+	return srv.Serve(ln)
+}
+
+// Listen 同步监听Addr，端口被占用等错误直接返回，之后在协程中调用Serve
+func (srv *TcpServer) Listen() (net.Listener, error) {
+	if srv.Addr == "" {
+		return nil, errors.New("need addr")
+	}
+	ln, err := listen.TCP(srv.Addr)
+	if err != nil {
+		return nil, err
+	}
+	srv.getDoneChan()
+	l := proxy_protocol.Wrap(tcpKeepAliveListener{ln.(*net.TCPListener)}, srv.ProxyProtocol, 0)
+	// Serve开始前Close也能关闭监听
+	srv.l = &onceCloseListener{Listener: l}
+	return l, nil
 }
 
 func (srv *TcpServer) Close() error {
 	atomic.StoreInt32(&srv.inShutdown, 1)
 	close(srv.doneChan) //关闭channel
 	srv.l.Close()       //执行listener关闭
+	// 断开所有连接，避免echo/chargen等长连接处理器一直运行
+	srv.closeConns()
 	return nil
 }
 
 func (srv *TcpServer) Serve(l net.Listener) error {
-	srv.l = &onceCloseListener{Listener: l}
+	if srv.l == nil || srv.l.Listener != l {
+		srv.l = &onceCloseListener{Listener: l}
+	}
 	defer srv.l.Close() //执行listener关闭
 	if srv.BaseCtx == nil {
 		srv.BaseCtx = context.Background()
 	}
 	baseCtx := srv.BaseCtx
 	ctx := context.WithValue(baseCtx, ServerContextKey, srv)
+	if srv.IdleTimeout > 0 {
+		go srv.reapIdle(srv.getDoneChan())
+	}
 	for {
 		rw, e := l.Accept()
 		if e != nil {
@@ -98,29 +135,36 @@ func (srv *TcpServer) Serve(l net.Listener) error {
 				return ErrServerClosed
 			default:
 			}
-			fmt.Printf("accept fail, err: %v\n", e)
+			srv.logf("accept fail, err: %v", e)
 			continue
 		}
 		c := srv.newConn(rw)
+		if !srv.trackConn(c) {
+			// 使用未包装PROXY协议的连接：才能设置RST，且不会为读取RemoteAddr等待头部
+			srv.reject(c.raw)
+			continue
+		}
 		go c.serve(ctx)
 	}
-	return nil
 }
 
 func (srv *TcpServer) newConn(rwc net.Conn) *conn {
-	c := &conn{
-		server: srv,
-		rwc:    rwc,
+	// 开启PROXY协议时不能在这里读取RemoteAddr，会阻塞accept循环等待头部
+	raw := rwc
+	if pc, ok := rwc.(*proxy_protocol.Conn); ok {
+		raw = pc.NetConn()
 	}
-	// 设置参数
-	if d := c.server.ReadTimeout; d != 0 {
-		c.rwc.SetReadDeadline(time.Now().Add(d))
-	}
-	if d := c.server.WriteTimeout; d != 0 {
-		c.rwc.SetWriteDeadline(time.Now().Add(d))
+	c := &conn{
+		server:     srv,
+		rwc:        rwc,
+		raw:        raw,
+		remoteAddr: raw.RemoteAddr().String(),
+		startedAt:  time.Now(),
 	}
+	c.touch()
+	// 读写超时在每次读写前设置，见 trackedConn
 	if d := c.server.KeepAliveTimeout; d != 0 {
-		if tcpConn, ok := c.rwc.(*net.TCPConn); ok {
+		if tcpConn, ok := c.raw.(*net.TCPConn); ok {
 			tcpConn.SetKeepAlive(true)
 			tcpConn.SetKeepAlivePeriod(d)
 		}
@@ -128,6 +172,15 @@ func (srv *TcpServer) newConn(rwc net.Conn) *conn {
 	return c
 }
 
+// 输出错误日志
+func (s *TcpServer) logf(format string, args ...any) {
+	if s.Logger != nil {
+		s.Logger.Error(fmt.Sprintf(format, args...))
+		return
+	}
+	fmt.Printf(format+"\n", args...)
+}
+
 func (s *TcpServer) getDoneChan() <-chan struct{} {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,168 @@
+package tcp_server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// ConnInfo 一个打开中的连接
+type ConnInfo struct {
+	ID        uint64        `json:"id"`
+	Remote    string        `json:"remote"`
+	Client    string        `json:"client,omitempty"` // PROXY协议头中的原始客户端地址
+	Local     string        `json:"local"`
+	BytesIn   int64         `json:"bytes_in"`
+	BytesOut  int64         `json:"bytes_out"`
+	StartedAt time.Time     `json:"started_at"`
+	Idle      time.Duration `json:"idle_ns"`
+}
+
+// trackedConn 每次读写前设置超时，并记录字节数与最近活动时间
+type trackedConn struct {
+	net.Conn
+	c *conn
+}
+
+func (t *trackedConn) Read(p []byte) (int, error) {
+	if d := t.c.server.ReadTimeout; d > 0 {
+		t.Conn.SetReadDeadline(time.Now().Add(d))
+	}
+	n, err := t.Conn.Read(p)
+	if n > 0 {
+		t.c.bytesIn.Add(int64(n))
+		t.c.touch()
+	}
+	return n, err
+}
+
+func (t *trackedConn) Write(p []byte) (int, error) {
+	if d := t.c.server.WriteTimeout; d > 0 {
+		t.Conn.SetWriteDeadline(time.Now().Add(d))
+	}
+	n, err := t.Conn.Write(p)
+	if n > 0 {
+		t.c.bytesOut.Add(int64(n))
+		t.c.touch()
+	}
+	return n, err
+}
+
+func (c *conn) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+func (c *conn) info(now time.Time) ConnInfo {
+	var client string
+	if h := c.header.Load(); h != nil && h.Source != nil {
+		client = h.Source.String()
+	}
+	return ConnInfo{
+		ID:        c.id,
+		Client:    client,
+		Remote:    c.raw.RemoteAddr().String(),
+		Local:     c.raw.LocalAddr().String(),
+		BytesIn:   c.bytesIn.Load(),
+		BytesOut:  c.bytesOut.Load(),
+		StartedAt: c.startedAt,
+		Idle:      now.Sub(time.Unix(0, c.lastActive.Load())),
+	}
+}
+
+// 登记新连接，超过最大连接数时返回false
+func (srv *TcpServer) trackConn(c *conn) bool {
+	srv.connMu.Lock()
+	defer srv.connMu.Unlock()
+	if srv.MaxConns > 0 && len(srv.conns) >= srv.MaxConns {
+		return false
+	}
+	if srv.conns == nil {
+		srv.conns = make(map[uint64]*conn)
+	}
+	srv.nextConnID++
+	c.id = srv.nextConnID
+	srv.conns[c.id] = c
+	return true
+}
+
+func (srv *TcpServer) untrackConn(c *conn) {
+	srv.connMu.Lock()
+	delete(srv.conns, c.id)
+	srv.connMu.Unlock()
+}
+
+// 超过最大连接数，按配置的方式拒绝。rw为accept得到的原始连接
+func (srv *TcpServer) reject(rw net.Conn) {
+	if srv.RejectMode == RejectReset {
+		if tc, ok := rw.(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+	}
+	rw.Close()
+	if srv.Logger != nil {
+		srv.Logger.Warn("connection rejected", "remote", rw.RemoteAddr().String(), "max_conns", srv.MaxConns, "reject", srv.RejectMode)
+	}
+	srv.Metrics.ObserveRequest("rejected", 0)
+}
+
+// Conns 当前打开的连接，按ID排序
+func (srv *TcpServer) Conns() []ConnInfo {
+	now := time.Now()
+	srv.connMu.Lock()
+	infos := make([]ConnInfo, 0, len(srv.conns))
+	for _, c := range srv.conns {
+		infos = append(infos, c.info(now))
+	}
+	srv.connMu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// KillConn 断开指定连接
+func (srv *TcpServer) KillConn(id uint64) error {
+	srv.connMu.Lock()
+	c, ok := srv.conns[id]
+	srv.connMu.Unlock()
+	if !ok {
+		return fmt.Errorf("connection %d not found", id)
+	}
+	if srv.Logger != nil {
+		srv.Logger.Info("connection killed", "id", id, "remote", c.remoteAddr)
+	}
+	return c.raw.Close()
+}
+
+// 关闭所有连接
+func (srv *TcpServer) closeConns() {
+	srv.connMu.Lock()
+	defer srv.connMu.Unlock()
+	for _, c := range srv.conns {
+		c.raw.Close()
+	}
+}
+
+// 定期关闭空闲超时的连接，任意方向的读写都会重置空闲时间
+func (srv *TcpServer) reapIdle(done <-chan struct{}) {
+	interval := min(max(srv.IdleTimeout/4, 100*time.Millisecond), time.Second)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			deadline := now.Add(-srv.IdleTimeout).UnixNano()
+			srv.connMu.Lock()
+			for _, c := range srv.conns {
+				if c.lastActive.Load() < deadline {
+					if srv.Logger != nil {
+						srv.Logger.Debug("idle connection closed", "id", c.id, "remote", c.remoteAddr)
+					}
+					c.raw.Close()
+				}
+			}
+			srv.connMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package tcp_server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func startTCPServerForTest(t *testing.T, opts *ServerOptions) (*TcpServer, string) {
+	t.Helper()
+	srv, err := Run_tcp_server(0, opts, testLogger(t), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { srv.Close() })
+	port := srv.l.Addr().(*net.TCPAddr).Port
+	return srv, net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+}
+
+func dialTCP(t *testing.T, addr string) net.Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, waitTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// 等待服务端登记的连接数达到n
+func waitConns(t *testing.T, srv *TcpServer, n int) []ConnInfo {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		conns := srv.Conns()
+		if len(conns) == n {
+			return conns
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d connections, want %d", len(conns), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// 读取到连接被服务端关闭为止，返回最后的错误（正常关闭为nil）
+func readUntilClosed(t *testing.T, conn net.Conn) error {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(waitTimeout))
+	_, err := io.Copy(io.Discard, conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("connection not closed by the server")
+	}
+	return err
+}
+
+func echoOnce(t *testing.T, conn net.Conn, line string) {
+	t.Helper()
+	writeString(t, conn, line)
+	if got := readLine(t, conn, bufio.NewReader(conn)); got != line {
+		t.Fatalf("echo = %q, want %q", got, line)
+	}
+}
+
+func TestMaxConnsReject(t *testing.T) {
+	for _, tc := range []struct {
+		reject string
+		reset  bool
+	}{
+		{"", false},
+		{RejectClose, false},
+		{RejectReset, true},
+	} {
+		t.Run("reject="+tc.reject, func(t *testing.T) {
+			srv, addr := startTCPServerForTest(t, &ServerOptions{Mode: ModeEcho, MaxConns: 2, Reject: tc.reject})
+			first := dialTCP(t, addr)
+			dialTCP(t, addr)
+			waitConns(t, srv, 2)
+
+			err := readUntilClosed(t, dialTCP(t, addr))
+			if reset := errors.Is(err, syscall.ECONNRESET); reset != tc.reset {
+				t.Fatalf("rejected connection read error = %v, want reset %v", err, tc.reset)
+			}
+			waitConns(t, srv, 2)
+
+			// 释放一个连接后可以再次连接
+			first.Close()
+			waitConns(t, srv, 1)
+			conn := dialTCP(t, addr)
+			echoOnce(t, conn, "again\n")
+			waitConns(t, srv, 2)
+		})
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	srv, addr := startTCPServerForTest(t, &ServerOptions{Mode: ModeEcho, ReadTimeout: 200 * time.Millisecond})
+	conn := dialTCP(t, addr)
+	// 每次读都重新计时，持续有数据时不超时
+	for range 4 {
+		time.Sleep(100 * time.Millisecond)
+		echoOnce(t, conn, "ping\n")
+	}
+	start := time.Now()
+	if err := readUntilClosed(t, conn); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("closed after %s, before the read timeout", elapsed)
+	}
+	waitConns(t, srv, 0)
+}
+
+func TestWriteTimeout(t *testing.T) {
+	srv, addr := startTCPServerForTest(t, &ServerOptions{Mode: ModeChargen, WriteTimeout: 100 * time.Millisecond})
+	dialTCP(t, addr)
+	waitConns(t, srv, 1)
+	// 客户端不读取，缓冲区写满后写超时，连接被关闭
+	waitConns(t, srv, 0)
+}
+
+func TestIdleTimeout(t *testing.T) {
+	srv, addr := startTCPServerForTest(t, &ServerOptions{Mode: ModeEcho, IdleTimeout: 300 * time.Millisecond})
+	active, silent := dialTCP(t, addr), dialTCP(t, addr)
+	waitConns(t, srv, 2)
+	for range 6 {
+		echoOnce(t, active, "ping\n")
+		time.Sleep(100 * time.Millisecond)
+	}
+	// 不读写的连接已被关闭，有活动的连接保留
+	if err := readUntilClosed(t, silent); err != nil {
+		t.Fatal(err)
+	}
+	conns := waitConns(t, srv, 1)
+	if conns[0].Remote != active.LocalAddr().String() {
+		t.Fatalf("remaining connection %s, want %s", conns[0].Remote, active.LocalAddr())
+	}
+	echoOnce(t, active, "still open\n")
+}
+
+func TestConnsAndKillConn(t *testing.T) {
+	srv, addr := startTCPServerForTest(t, &ServerOptions{Mode: ModeEcho})
+	first, second := dialTCP(t, addr), dialTCP(t, addr)
+	echoOnce(t, first, "hello\n")
+	waitConns(t, srv, 2)
+	echoOnce(t, second, "x\n")
+
+	conns := srv.Conns()
+	if conns[0].ID >= conns[1].ID {
+		t.Fatalf("connections not sorted by id: %+v", conns)
+	}
+	var target ConnInfo
+	for _, c := range conns {
+		if c.Remote == first.LocalAddr().String() {
+			target = c
+		}
+	}
+	if target.ID == 0 || target.BytesIn != 6 || target.BytesOut != 6 {
+		t.Fatalf("connection info = %+v", target)
+	}
+
+	if err := srv.KillConn(target.ID); err != nil {
+		t.Fatal(err)
+	}
+	readUntilClosed(t, first)
+	waitConns(t, srv, 1)
+	if err := srv.KillConn(target.ID); err == nil {
+		t.Fatal("killing a closed connection succeeded")
+	}
+	echoOnce(t, second, "alive\n")
+}
+
+func TestServerOptionsValidation(t *testing.T) {
+	lg := testLogger(t)
+	for _, opts := range []ServerOptions{
+		{Reject: "drop"},
+		{ProxyProtocol: "sometimes"},
+	} {
+		if srv, err := Run_tcp_server(0, &opts, lg, nil, nil); err == nil {
+			srv.Close()
+			t.Errorf("options %+v accepted", opts)
+		}
+	}
+}
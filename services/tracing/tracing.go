@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/stats"
+)
+
+const (
+	defaultServiceName = "go_downstreamer_server"
+	defaultFilePath    = "./logs/traces.json"
+	instrumentation    = "github.com/21Mile/go_downstreamer_server/services/tracing"
+)
+
+// Options 链路追踪配置
+type Options struct {
+	Exporter    string            `yaml:"exporter"`     // none/otlp-grpc/otlp-http/file，为空或none时不导出
+	Endpoint    string            `yaml:"endpoint"`     // OTLP地址，如 127.0.0.1:4317
+	Insecure    bool              `yaml:"insecure"`     // OTLP不使用TLS
+	Headers     map[string]string `yaml:"headers"`      // OTLP请求附加的头（如鉴权）
+	FilePath    string            `yaml:"file_path"`    // file导出时的文件路径，每个span一行JSON
+	SampleRatio float64           `yaml:"sample_ratio"` // 根span采样比例，0按1处理；上游已采样的请求始终跟随上游
+	ServiceName string            `yaml:"service_name"`
+}
+
+// Setup 初始化全局TracerProvider与W3C传播器，返回的函数在退出时刷新并关闭导出器
+func Setup(opts Options) (func(context.Context) error, error) {
+	// 即使不导出，也要透传上游的traceparent
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch opts.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp-grpc", "otlp":
+		grpcOpts := []otlptracegrpc.Option{otlptracegrpc.WithHeaders(opts.Headers)}
+		if opts.Endpoint != "" {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			grpcOpts = append(grpcOpts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(context.Background(), grpcOpts...)
+	case "otlp-http":
+		httpOpts := []otlptracehttp.Option{otlptracehttp.WithHeaders(opts.Headers)}
+		if opts.Endpoint != "" {
+			httpOpts = append(httpOpts, otlptracehttp.WithEndpoint(opts.Endpoint))
+		}
+		if opts.Insecure {
+			httpOpts = append(httpOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), httpOpts...)
+	case "file":
+		exporter, err = newFileExporter(opts.FilePath)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, available: none, otlp-grpc, otlp-http, file", opts.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s trace exporter: %w", opts.Exporter, err)
+	}
+
+	serviceName := opts.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	ratio := opts.SampleRatio
+	if ratio <= 0 {
+		ratio = 1
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(serviceName))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// 本地文件导出，不需要外部collector即可查看链路
+func newFileExporter(path string) (sdktrace.SpanExporter, error) {
+	if path == "" {
+		path = defaultFilePath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileExporter{SpanExporter: exporter, file: f}, nil
+}
+
+// fileExporter 关闭导出器时同时关闭文件
+type fileExporter struct {
+	sdktrace.SpanExporter
+	file *os.File
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if cerr := e.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Instance 单个服务实例的追踪，所有span带实例名/类型/地址属性；方法对nil安全
+type Instance struct {
+	attrs []attribute.KeyValue
+}
+
+// NewInstance 创建实例追踪
+func NewInstance(name, typ, address string) *Instance {
+	return &Instance{attrs: []attribute.KeyValue{
+		attribute.String("downstream.name", name),
+		attribute.String("downstream.type", typ),
+		attribute.String("downstream.address", address),
+	}}
+}
+
+// HTTPMiddleware 从请求头提取trace上下文并创建server span
+func (i *Instance) HTTPMiddleware(next http.Handler) http.Handler {
+	if i == nil {
+		return next
+	}
+	return otelhttp.NewHandler(next, "http.server",
+		otelhttp.WithSpanOptions(trace.WithAttributes(i.attrs...)),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+// HTTPTransport 为转发到上游的请求创建client span并注入traceparent
+func (i *Instance) HTTPTransport(base http.RoundTripper) http.RoundTripper {
+	if i == nil {
+		return base
+	}
+	return otelhttp.NewTransport(base,
+		otelhttp.WithSpanOptions(trace.WithAttributes(i.attrs...)),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "proxy " + r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+// GRPCStatsHandler 从metadata提取trace上下文并为每个调用创建server span
+func (i *Instance) GRPCStatsHandler() stats.Handler {
+	return otelgrpc.NewServerHandler(otelgrpc.WithSpanAttributes(i.attributes()...))
+}
+
+// StartConn 为一个TCP连接创建server span。
+// TCP没有可以携带traceparent的头部，每个连接作为新的trace根；ctx中已有span时（如经过代理）作为其子span
+func (i *Instance) StartConn(ctx context.Context, conn net.Conn) (context.Context, trace.Span) {
+	if i == nil {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	attrs := append(i.attributes(),
+		attribute.String("network.peer.address", conn.RemoteAddr().String()),
+		attribute.String("network.local.address", conn.LocalAddr().String()),
+	)
+	return otel.Tracer(instrumentation).Start(ctx, "tcp.conn",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func (i *Instance) attributes() []attribute.KeyValue {
+	if i == nil {
+		return nil
+	}
+	return append([]attribute.KeyValue(nil), i.attrs...)
+}
+
+// InjectedLatency 标记人为注入的延迟，便于在链路中区分真实耗时
+func InjectedLatency(ctx context.Context, d time.Duration) {
+	trace.SpanFromContext(ctx).AddEvent("injected.latency", trace.WithAttributes(
+		attribute.String("downstream.delay", d.String()),
+	))
+}
+
+// InjectedFault 标记人为注入的错误，并把span状态置为错误
+func InjectedFault(ctx context.Context, reason string) {
+	span := trace.SpanFromContext(ctx)
+	span.AddEvent("injected.fault", trace.WithAttributes(
+		attribute.String("downstream.fault", reason),
+	))
+	span.SetStatus(codes.Error, reason)
+}
@@ -0,0 +1,192 @@
+package tracing
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// 用内存记录器替换全局TracerProvider，测试结束后恢复
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	if _, err := Setup(Options{}); err != nil {
+		t.Fatal(err)
+	}
+	old := otel.GetTracerProvider()
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+	return rec
+}
+
+func attr(span sdktrace.ReadOnlySpan, key string) string {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func expectInstanceAttrs(t *testing.T, span sdktrace.ReadOnlySpan, name, typ, address string) {
+	t.Helper()
+	if attr(span, "downstream.name") != name || attr(span, "downstream.type") != typ || attr(span, "downstream.address") != address {
+		t.Fatalf("span %s attributes = %v", span.Name(), span.Attributes())
+	}
+}
+
+// 服务端span延续请求头中的traceparent，转发到上游时注入新的traceparent
+func TestHTTPPropagation(t *testing.T) {
+	rec := recordSpans(t)
+	var upstreamParent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		upstreamParent = req.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+
+	inst := NewInstance("proxy-1", "http-proxy", "127.0.0.1:2010")
+	client := &http.Client{Transport: inst.HTTPTransport(http.DefaultTransport)}
+	h := inst.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		out, _ := http.NewRequestWithContext(req.Context(), http.MethodGet, upstream.URL+"/api", nil)
+		resp, err := client.Do(out)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.Header.Set("traceparent", traceparent)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := rec.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans ended", len(spans))
+	}
+	clientSpan, serverSpan := spans[0], spans[1]
+	if serverSpan.Name() != "GET /api" || serverSpan.SpanKind() != trace.SpanKindServer {
+		t.Fatalf("server span %q kind %v", serverSpan.Name(), serverSpan.SpanKind())
+	}
+	if serverSpan.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || serverSpan.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Fatalf("server span not continued from traceparent: parent %v", serverSpan.Parent())
+	}
+	if clientSpan.Name() != "proxy GET /api" || clientSpan.Parent().SpanID() != serverSpan.SpanContext().SpanID() {
+		t.Fatalf("client span %q parent %v", clientSpan.Name(), clientSpan.Parent())
+	}
+	expectInstanceAttrs(t, serverSpan, "proxy-1", "http-proxy", "127.0.0.1:2010")
+	expectInstanceAttrs(t, clientSpan, "proxy-1", "http-proxy", "127.0.0.1:2010")
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + clientSpan.SpanContext().SpanID().String() + "-01"; upstreamParent != want {
+		t.Fatalf("upstream traceparent %q, want %q", upstreamParent, want)
+	}
+}
+
+func TestStartConn(t *testing.T) {
+	rec := recordSpans(t)
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	inst := NewInstance("tcp:3003", "tcp", "3003")
+
+	// 没有上游span时作为新的trace根
+	ctx, span := inst.StartConn(context.Background(), server)
+	InjectedLatency(ctx, 0)
+	InjectedFault(ctx, "close after 10 bytes")
+	span.End()
+	// 经过代理时作为代理span的子span
+	parentCtx, parent := otel.Tracer("test").Start(context.Background(), "proxy")
+	_, child := inst.StartConn(parentCtx, server)
+	child.End()
+	parent.End()
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("%d spans ended", len(spans))
+	}
+	root := spans[0]
+	if root.Name() != "tcp.conn" || root.Parent().IsValid() || root.SpanKind() != trace.SpanKindServer {
+		t.Fatalf("root span %q parent %v", root.Name(), root.Parent())
+	}
+	expectInstanceAttrs(t, root, "tcp:3003", "tcp", "3003")
+	if attr(root, "network.peer.address") != "pipe" {
+		t.Fatalf("attributes = %v", root.Attributes())
+	}
+	if root.Status().Code != codes.Error || root.Status().Description != "close after 10 bytes" {
+		t.Fatalf("status = %+v", root.Status())
+	}
+	events := root.Events()
+	if len(events) != 2 || events[0].Name != "injected.latency" || events[1].Name != "injected.fault" ||
+		events[1].Attributes[0] != attribute.String("downstream.fault", "close after 10 bytes") {
+		t.Fatalf("events = %+v", events)
+	}
+	if spans[1].Parent().SpanID() != spans[2].SpanContext().SpanID() {
+		t.Fatal("connection span not a child of the proxy span")
+	}
+}
+
+// 未开启追踪的服务传nil
+func TestNilInstance(t *testing.T) {
+	var inst *Instance
+	next := http.NotFoundHandler()
+	if h := inst.HTTPMiddleware(next); h == nil {
+		t.Fatal("nil handler")
+	}
+	if rt := inst.HTTPTransport(http.DefaultTransport); rt != http.DefaultTransport {
+		t.Fatal("transport wrapped without an instance")
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctx, span := inst.StartConn(context.Background(), server)
+	if ctx != context.Background() || span.SpanContext().IsValid() {
+		t.Fatal("span started without an instance")
+	}
+	if inst.GRPCStatsHandler() == nil {
+		t.Fatal("nil stats handler")
+	}
+}
+
+func TestSetup(t *testing.T) {
+	old := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(old) })
+	if _, err := Setup(Options{Exporter: "jaeger"}); err == nil {
+		t.Fatal("unknown exporter accepted")
+	}
+
+	// 文件导出：关闭时刷新，每个span一行JSON
+	path := filepath.Join(t.TempDir(), "traces", "spans.json")
+	shutdown, err := Setup(Options{Exporter: "file", FilePath: path, ServiceName: "test-svc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	ctx, span := NewInstance("udp:5003", "udp", "5003").StartConn(context.Background(), server)
+	span.End()
+	if err := shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"Name":"tcp.conn"`, `"Value":"udp:5003"`, `"Value":"test-svc"`} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("exported span missing %s:\n%s", want, data)
+		}
+	}
+}
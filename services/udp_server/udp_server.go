@@ -0,0 +1,232 @@
+package udp_server
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/listen"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/metrics"
+)
+
+// 可选的响应模式
+const (
+	ModeEcho    = "echo"    // 原样返回收到的数据报（默认）
+	ModeReply   = "reply"   // 返回固定内容
+	ModeDiscard = "discard" // 只接收不回复
+)
+
+const (
+	maxPacketSize = 64 * 1024
+	// 最多记录的对端数，超过后淘汰最久未活动的对端
+	maxPeers = 4096
+	// 最多同时等待的延迟回复数，超过后新数据报按丢包处理，避免高速发送时定时器无限增长
+	maxPendingReplies = 4096
+)
+
+// ServerOptions 单个UDP实例的参数，丢包与延迟对所有模式生效
+type ServerOptions struct {
+	Mode        string        `yaml:"mode"`
+	Reply       string        `yaml:"reply"`        // reply模式返回的内容
+	DropPercent float64       `yaml:"drop_percent"` // 丢弃收到的数据报的比例(0-100)，不回复
+	MinDelay    time.Duration `yaml:"min_delay"`    // 回复前的随机延迟范围
+	MaxDelay    time.Duration `yaml:"max_delay"`
+}
+
+// PeerInfo 单个对端的收发统计
+type PeerInfo struct {
+	Peer       string    `json:"peer"`
+	PacketsIn  uint64    `json:"packets_in"`
+	PacketsOut uint64    `json:"packets_out"`
+	Dropped    uint64    `json:"dropped"`
+	BytesIn    uint64    `json:"bytes_in"`
+	BytesOut   uint64    `json:"bytes_out"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+type peerStats struct {
+	packetsIn, packetsOut, dropped atomic.Uint64
+	bytesIn, bytesOut              atomic.Uint64
+	firstSeen                      time.Time
+	lastSeen                       atomic.Int64
+}
+
+// UdpServer UDP服务实例
+type UdpServer struct {
+	Addr    string
+	Options ServerOptions
+	Logger  *logger.Logger
+	Metrics *metrics.Instance
+
+	conn    *net.UDPConn
+	mu      sync.Mutex
+	peers   map[string]*peerStats
+	pending atomic.Int64 // 等待中的延迟回复数
+	closed  atomic.Bool
+}
+
+func Run_udp_server(port int, opts *ServerOptions, lg *logger.Logger, mt *metrics.Instance) (*UdpServer, error) {
+	// 记录服务器启动日志
+	lg.Info("开始启动UDP服务器", "port", port, "mode", opts.Mode)
+	switch opts.Mode {
+	case "", ModeEcho, ModeReply, ModeDiscard:
+	default:
+		return nil, fmt.Errorf("unknown udp mode %q, available: echo, reply, discard", opts.Mode)
+	}
+	if opts.DropPercent < 0 || opts.DropPercent > 100 {
+		return nil, fmt.Errorf("drop_percent must be between 0 and 100")
+	}
+	if opts.MaxDelay < opts.MinDelay {
+		return nil, fmt.Errorf("max_delay %v is less than min_delay %v", opts.MaxDelay, opts.MinDelay)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := listen.UDP(addr)
+	if err != nil {
+		return nil, err
+	}
+	lg.Info("udp server listening", "listen", conn.LocalAddr().String())
+	s := &UdpServer{
+		Addr:    conn.LocalAddr().String(),
+		Options: *opts,
+		Logger:  lg,
+		Metrics: mt,
+		conn:    conn,
+		peers:   make(map[string]*peerStats),
+	}
+	go s.serve()
+	return s, nil
+}
+
+func (s *UdpServer) serve() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, peer, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if s.closed.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.Logger.Error("udp read failed", "err", err)
+			continue
+		}
+		start := time.Now()
+		st := s.peer(peer.String(), start)
+		st.packetsIn.Add(1)
+		st.bytesIn.Add(uint64(n))
+		s.Metrics.AddBytesIn(n)
+
+		if s.Options.DropPercent > 0 && rand.Float64()*100 < s.Options.DropPercent {
+			st.dropped.Add(1)
+			s.Logger.Trace("udp packet dropped", "peer", peer.String(), "bytes", n)
+			s.Metrics.ObserveRequest("dropped", 0)
+			continue
+		}
+		var reply []byte
+		switch s.Options.Mode {
+		case ModeDiscard:
+			s.Metrics.ObserveRequest("ok", time.Since(start))
+			continue
+		case ModeReply:
+			reply = []byte(s.Options.Reply)
+		default:
+			reply = append([]byte(nil), buf[:n]...)
+		}
+		delay := s.Options.MinDelay
+		if s.Options.MaxDelay > s.Options.MinDelay {
+			delay += time.Duration(rand.Int63n(int64(s.Options.MaxDelay - s.Options.MinDelay)))
+		}
+		if delay <= 0 {
+			s.reply(peer, st, reply, start)
+			continue
+		}
+		if s.pending.Add(1) > maxPendingReplies {
+			s.pending.Add(-1)
+			st.dropped.Add(1)
+			s.Logger.Trace("udp packet dropped, too many pending replies", "peer", peer.String(), "bytes", n)
+			s.Metrics.ObserveRequest("dropped", 0)
+			continue
+		}
+		// 延迟回复不阻塞读取
+		time.AfterFunc(delay, func() {
+			defer s.pending.Add(-1)
+			s.reply(peer, st, reply, start)
+		})
+	}
+}
+
+func (s *UdpServer) reply(peer *net.UDPAddr, st *peerStats, reply []byte, start time.Time) {
+	if s.closed.Load() {
+		return
+	}
+	n, err := s.conn.WriteToUDP(reply, peer)
+	if err != nil {
+		s.Logger.Warn("udp write failed", "peer", peer.String(), "err", err)
+		s.Metrics.ObserveRequest("error", time.Since(start))
+		return
+	}
+	st.packetsOut.Add(1)
+	st.bytesOut.Add(uint64(n))
+	s.Metrics.AddBytesOut(n)
+	s.Metrics.ObserveRequest("ok", time.Since(start))
+}
+
+// 获取对端统计，对端过多时淘汰最久未活动的
+func (s *UdpServer) peer(key string, now time.Time) *peerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.peers[key]
+	if !ok {
+		if len(s.peers) >= maxPeers {
+			var oldest string
+			var oldestSeen int64
+			for k, p := range s.peers {
+				if seen := p.lastSeen.Load(); oldest == "" || seen < oldestSeen {
+					oldest, oldestSeen = k, seen
+				}
+			}
+			delete(s.peers, oldest)
+		}
+		st = &peerStats{firstSeen: now}
+		s.peers[key] = st
+	}
+	st.lastSeen.Store(now.UnixNano())
+	return st
+}
+
+// Peers 各对端的收发统计，按最近活动时间倒序
+func (s *UdpServer) Peers() []PeerInfo {
+	s.mu.Lock()
+	infos := make([]PeerInfo, 0, len(s.peers))
+	for key, st := range s.peers {
+		infos = append(infos, PeerInfo{
+			Peer:       key,
+			PacketsIn:  st.packetsIn.Load(),
+			PacketsOut: st.packetsOut.Load(),
+			Dropped:    st.dropped.Load(),
+			BytesIn:    st.bytesIn.Load(),
+			BytesOut:   st.bytesOut.Load(),
+			FirstSeen:  st.firstSeen,
+			LastSeen:   time.Unix(0, st.lastSeen.Load()),
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].LastSeen.After(infos[j].LastSeen) })
+	return infos
+}
+
+// Close 关闭服务，丢弃尚未发送的延迟回复
+func (s *UdpServer) Close() error {
+	s.closed.Store(true)
+	return s.conn.Close()
+}
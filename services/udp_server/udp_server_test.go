@@ -0,0 +1,198 @@
+package udp_server
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+)
+
+const waitTimeout = 3 * time.Second
+
+func startTestServer(t *testing.T, opts ServerOptions) *UdpServer {
+	t.Helper()
+	lg, err := logger.New(logger.Options{Level: "error"}, "test", "udp", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lg.Close() })
+	s, err := Run_udp_server(0, &opts, lg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func dialTestServer(t *testing.T, s *UdpServer) *net.UDPConn {
+	t.Helper()
+	port := s.conn.LocalAddr().(*net.UDPAddr).Port
+	conn, err := net.Dial("udp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn.(*net.UDPConn)
+}
+
+// 发送一个数据报并在timeout内等待回复，没有回复时返回false
+func exchange(t *testing.T, conn *net.UDPConn, data string, timeout time.Duration) (string, bool) {
+	t.Helper()
+	if _, err := conn.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, maxPacketSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return "", false
+		}
+		t.Fatal(err)
+	}
+	return string(buf[:n]), true
+}
+
+// 等待对端统计满足条件，统计在读协程中更新
+func waitPeer(t *testing.T, s *UdpServer, ok func(PeerInfo) bool) PeerInfo {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for {
+		peers := s.Peers()
+		if len(peers) == 1 && ok(peers[0]) {
+			return peers[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("peers = %+v", peers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestModes(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		opts  ServerOptions
+		reply string
+		ok    bool
+	}{
+		{"echo", ServerOptions{}, "ping", true},
+		{"reply", ServerOptions{Mode: ModeReply, Reply: "pong"}, "pong", true},
+		{"discard", ServerOptions{Mode: ModeDiscard}, "", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := startTestServer(t, tc.opts)
+			conn := dialTestServer(t, s)
+			timeout := waitTimeout
+			if !tc.ok {
+				timeout = 200 * time.Millisecond
+			}
+			reply, ok := exchange(t, conn, "ping", timeout)
+			if ok != tc.ok || reply != tc.reply {
+				t.Fatalf("reply = %q, %v, want %q, %v", reply, ok, tc.reply, tc.ok)
+			}
+			p := waitPeer(t, s, func(p PeerInfo) bool { return p.PacketsIn == 1 })
+			if p.BytesIn != 4 || p.Peer != conn.LocalAddr().String() {
+				t.Fatalf("peer = %+v", p)
+			}
+			if tc.ok {
+				waitPeer(t, s, func(p PeerInfo) bool { return p.PacketsOut == 1 && p.BytesOut == uint64(len(tc.reply)) })
+			}
+		})
+	}
+}
+
+func TestDropPercent(t *testing.T) {
+	s := startTestServer(t, ServerOptions{DropPercent: 100})
+	conn := dialTestServer(t, s)
+	for range 3 {
+		if reply, ok := exchange(t, conn, "ping", 100*time.Millisecond); ok {
+			t.Fatalf("dropped packet answered with %q", reply)
+		}
+	}
+	waitPeer(t, s, func(p PeerInfo) bool { return p.PacketsIn == 3 && p.Dropped == 3 && p.PacketsOut == 0 })
+}
+
+func TestDelay(t *testing.T) {
+	s := startTestServer(t, ServerOptions{MinDelay: 100 * time.Millisecond, MaxDelay: 120 * time.Millisecond})
+	conn := dialTestServer(t, s)
+	start := time.Now()
+	if reply, ok := exchange(t, conn, "ping", waitTimeout); !ok || reply != "ping" {
+		t.Fatalf("reply = %q, %v", reply, ok)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("reply after %s, want at least 100ms", elapsed)
+	}
+	// 延迟回复全部发出后计数归零
+	deadline := time.Now().Add(waitTimeout)
+	for s.pending.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pending = %d after the reply", s.pending.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPendingRepliesCapped(t *testing.T) {
+	s := startTestServer(t, ServerOptions{MinDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond})
+	conn := dialTestServer(t, s)
+	s.pending.Store(maxPendingReplies)
+	if reply, ok := exchange(t, conn, "ping", 200*time.Millisecond); ok {
+		t.Fatalf("packet over the pending limit answered with %q", reply)
+	}
+	waitPeer(t, s, func(p PeerInfo) bool { return p.Dropped == 1 && p.PacketsOut == 0 })
+	if n := s.pending.Load(); n != maxPendingReplies {
+		t.Fatalf("pending = %d, want %d", n, maxPendingReplies)
+	}
+
+	s.pending.Store(0)
+	if reply, ok := exchange(t, conn, "ping", waitTimeout); !ok || reply != "ping" {
+		t.Fatalf("reply = %q, %v", reply, ok)
+	}
+}
+
+func TestPeerEviction(t *testing.T) {
+	s := &UdpServer{peers: make(map[string]*peerStats)}
+	base := time.Now()
+	for i := range maxPeers {
+		s.peer("peer"+strconv.Itoa(i), base.Add(time.Duration(i)*time.Millisecond))
+	}
+	// 最早的对端再次活动，此后最久未活动的是peer1
+	s.peer("peer0", base.Add(time.Hour))
+	s.peer("new", base.Add(2*time.Hour))
+	if len(s.peers) != maxPeers {
+		t.Fatalf("%d peers, want %d", len(s.peers), maxPeers)
+	}
+	if _, ok := s.peers["peer1"]; ok {
+		t.Fatal("least recently seen peer not evicted")
+	}
+	for _, key := range []string{"peer0", "peer2", "new"} {
+		if _, ok := s.peers[key]; !ok {
+			t.Fatalf("%s evicted", key)
+		}
+	}
+	if infos := s.Peers(); infos[0].Peer != "new" || infos[1].Peer != "peer0" {
+		t.Fatalf("peers not sorted by last seen: %s, %s", infos[0].Peer, infos[1].Peer)
+	}
+}
+
+func TestOptionsValidation(t *testing.T) {
+	lg, err := logger.New(logger.Options{Level: "error"}, "test", "udp", "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lg.Close()
+	for _, opts := range []ServerOptions{
+		{Mode: "tcp"},
+		{DropPercent: -1},
+		{DropPercent: 101},
+		{MinDelay: time.Second, MaxDelay: time.Millisecond},
+	} {
+		if s, err := Run_udp_server(0, &opts, lg, nil); err == nil {
+			s.Close()
+			t.Errorf("options %+v accepted", opts)
+		}
+	}
+}
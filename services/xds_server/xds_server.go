@@ -0,0 +1,254 @@
+package xds_server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/load_balance"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	upstreamhttpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	xdslog "github.com/envoyproxy/go-control-plane/pkg/log"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	defaultConnectTimeout = time.Second
+	httpProtocolOptions   = "envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+)
+
+// Options xDS控制面参数
+type Options struct {
+	ConnectTimeout time.Duration `yaml:"connect_timeout"` // cluster的连接超时，默认1s
+	LbPolicy       string        `yaml:"lb_policy"`       // round-robin/weight-round-robin/least-conn/random/consistent-hash，默认round-robin
+}
+
+// Endpoint 分组中的一个实例
+type Endpoint struct {
+	Name    string // 实例名 type:address，作为Envoy中的hostname
+	Host    string
+	Port    int
+	Weight  int  // 不小于1
+	Healthy bool // 运行中为HEALTHY，已停止为UNHEALTHY
+	UDP     bool // udp实例，地址的协议为UDP（供udp_proxy使用）
+}
+
+// Group 一个分组对应一个EDS类型的cluster
+type Group struct {
+	Name      string
+	HTTP2     bool // 上游为gRPC时使用HTTP/2
+	Endpoints []Endpoint
+}
+
+// 所有Envoy节点看到同一份配置
+type allNodes struct{}
+
+func (allNodes) ID(*corev3.Node) string { return "" }
+
+// XdsServer ADS（以及单独的CDS/EDS）控制面，每次Update生成新版本的快照推送给所有Envoy
+type XdsServer struct {
+	grpc    *grpc.Server
+	cache   cachev3.SnapshotCache
+	opts    Options
+	lbType  clusterv3.Cluster_LbPolicy
+	logger  *logger.Logger
+	mu      sync.Mutex
+	version uint64
+	cancel  context.CancelFunc
+}
+
+func Run_xds_server(addr string, opts *Options, lg *logger.Logger) (*XdsServer, error) {
+	lbType, err := lbPolicy(opts.LbPolicy)
+	if err != nil {
+		return nil, err
+	}
+	s := &XdsServer{opts: *opts, lbType: lbType, logger: lg}
+	if s.opts.ConnectTimeout <= 0 {
+		s.opts.ConnectTimeout = defaultConnectTimeout
+	}
+	s.cache = cachev3.NewSnapshotCache(true, allNodes{}, xdslog.LoggerFuncs{
+		DebugFunc: func(format string, args ...any) { lg.Trace(fmt.Sprintf(format, args...)) },
+		InfoFunc:  func(format string, args ...any) { lg.Debug(fmt.Sprintf(format, args...)) },
+		WarnFunc:  func(format string, args ...any) { lg.Warn(fmt.Sprintf(format, args...)) },
+		ErrorFunc: func(format string, args ...any) { lg.Error(fmt.Sprintf(format, args...)) },
+	})
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	lg.Info("开始启动xDS控制面", "lb_policy", lbType.String())
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	srv := serverv3.NewServer(ctx, s.cache, serverv3.CallbackFuncs{
+		StreamOpenFunc: func(_ context.Context, id int64, typeURL string) error {
+			lg.Debug("xds stream opened", "stream", id, "type_url", typeURL)
+			return nil
+		},
+		StreamClosedFunc: func(id int64, node *corev3.Node) {
+			lg.Debug("xds stream closed", "stream", id, "node", node.GetId())
+		},
+		StreamRequestFunc: func(id int64, req *discoveryv3.DiscoveryRequest) error {
+			// 带ErrorDetail的请求是Envoy拒绝（NACK）了上一次推送的配置
+			if req.ErrorDetail != nil {
+				lg.Warn("envoy rejected config", "stream", id, "node", req.GetNode().GetId(),
+					"type_url", req.TypeUrl, "version", req.VersionInfo, "err", req.ErrorDetail.Message)
+			} else {
+				lg.Trace("xds request", "stream", id, "node", req.GetNode().GetId(), "type_url", req.TypeUrl, "version", req.VersionInfo)
+			}
+			return nil
+		},
+	})
+	s.grpc = grpc.NewServer()
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(s.grpc, srv)
+	clusterservice.RegisterClusterDiscoveryServiceServer(s.grpc, srv)
+	endpointservice.RegisterEndpointDiscoveryServiceServer(s.grpc, srv)
+	go func() {
+		if err := s.grpc.Serve(lis); err != nil {
+			lg.Error("xDS控制面运行失败", "err", err)
+		}
+	}()
+	return s, nil
+}
+
+// Update 用分组生成新的快照，连接中的Envoy会收到新版本
+func (s *XdsServer) Update(groups []Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var clusters, assignments []types.Resource
+	endpoints := 0
+	for _, g := range groups {
+		cluster, err := s.cluster(g)
+		if err != nil {
+			return err
+		}
+		clusters = append(clusters, cluster)
+		assignments = append(assignments, loadAssignment(g))
+		endpoints += len(g.Endpoints)
+	}
+	s.version++
+	version := strconv.FormatUint(s.version, 10)
+	snapshot, err := cachev3.NewSnapshot(version, map[resource.Type][]types.Resource{
+		resource.ClusterType:  clusters,
+		resource.EndpointType: assignments,
+	})
+	if err != nil {
+		return err
+	}
+	if err := snapshot.Consistent(); err != nil {
+		return err
+	}
+	if err := s.cache.SetSnapshot(context.Background(), "", snapshot); err != nil {
+		return err
+	}
+	s.logger.Debug("xds snapshot updated", "version", version, "clusters", len(clusters), "endpoints", endpoints)
+	return nil
+}
+
+// Close 停止服务，ADS为长连接，不等待流结束
+func (s *XdsServer) Close() error {
+	s.cancel()
+	s.grpc.Stop()
+	return nil
+}
+
+func (s *XdsServer) cluster(g Group) (*clusterv3.Cluster, error) {
+	c := &clusterv3.Cluster{
+		Name:                 g.Name,
+		ConnectTimeout:       durationpb.New(s.opts.ConnectTimeout),
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: clusterv3.Cluster_EDS},
+		LbPolicy:             s.lbType,
+		EdsClusterConfig: &clusterv3.Cluster_EdsClusterConfig{
+			EdsConfig: &corev3.ConfigSource{
+				ResourceApiVersion:    corev3.ApiVersion_V3,
+				ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+			},
+		},
+	}
+	if g.HTTP2 {
+		opts, err := anypb.New(&upstreamhttpv3.HttpProtocolOptions{
+			UpstreamProtocolOptions: &upstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_{
+				ExplicitHttpConfig: &upstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig{
+					ProtocolConfig: &upstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+						Http2ProtocolOptions: &corev3.Http2ProtocolOptions{},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.TypedExtensionProtocolOptions = map[string]*anypb.Any{httpProtocolOptions: opts}
+	}
+	return c, nil
+}
+
+func loadAssignment(g Group) *endpointv3.ClusterLoadAssignment {
+	lbEndpoints := make([]*endpointv3.LbEndpoint, 0, len(g.Endpoints))
+	for _, e := range g.Endpoints {
+		health := corev3.HealthStatus_HEALTHY
+		if !e.Healthy {
+			health = corev3.HealthStatus_UNHEALTHY
+		}
+		protocol := corev3.SocketAddress_TCP
+		if e.UDP {
+			protocol = corev3.SocketAddress_UDP
+		}
+		lbEndpoints = append(lbEndpoints, &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+				Endpoint: &endpointv3.Endpoint{
+					Address: &corev3.Address{
+						Address: &corev3.Address_SocketAddress{
+							SocketAddress: &corev3.SocketAddress{
+								Protocol:      protocol,
+								Address:       e.Host,
+								PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(e.Port)},
+							},
+						},
+					},
+					Hostname: e.Name,
+				},
+			},
+			HealthStatus:        health,
+			LoadBalancingWeight: wrapperspb.UInt32(uint32(e.Weight)),
+		})
+	}
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: g.Name,
+		Endpoints:   []*endpointv3.LocalityLbEndpoints{{LbEndpoints: lbEndpoints}},
+	}
+}
+
+// 负载均衡名称与代理一致，Envoy的轮询本身按权重
+func lbPolicy(name string) (clusterv3.Cluster_LbPolicy, error) {
+	typ, err := load_balance.ParseType(name)
+	if err != nil {
+		return 0, err
+	}
+	switch typ {
+	case load_balance.LbRandom:
+		return clusterv3.Cluster_RANDOM, nil
+	case load_balance.LbLeastConn:
+		return clusterv3.Cluster_LEAST_REQUEST, nil
+	case load_balance.LbConsistentHash:
+		return clusterv3.Cluster_RING_HASH, nil
+	default:
+		return clusterv3.Cluster_ROUND_ROBIN, nil
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// 未配置 snapshot_dir 时命名快照的目录
+const defaultSnapshotDir = "./snapshots"
+
+// ServerState 一个实例的期望状态与运行时设置的标签
+type ServerState struct {
+	Type    string `yaml:"type" json:"type"`
+	Address string `yaml:"address" json:"address"` // 实际监听的地址
+	Labels  `yaml:",inline"`
+	Running bool `yaml:"running" json:"running"`
+}
+
+// State 整个拓扑，保存在状态文件或命名快照中
+type State struct {
+	SavedAt time.Time     `yaml:"saved_at" json:"saved_at"`
+	Servers []ServerState `yaml:"servers" json:"servers"`
+}
+
+// Snapshot 当前所有实例（包括已停止的）的状态。
+// 按zk期望服务列表启动的实例由该列表管理，不写入状态，重启后由列表重新启动
+func (m *ServerManager) Snapshot() *State {
+	st := &State{SavedAt: time.Now()}
+	desired := m.desiredSet()
+	for _, s := range m.GetServers() {
+		if desired[s.Type+":"+s.Address] {
+			continue
+		}
+		st.Servers = append(st.Servers, ServerState{
+			Type:    s.Type,
+			Address: s.Address,
+			Labels:  s.Labels,
+			Running: s.Status == "running",
+		})
+	}
+	return st
+}
+
+// Apply 使实例与状态一致：启动其中运行中的实例，停止其中已停止的实例。
+// exclusive为true时还停止状态中没有的实例（加载完整拓扑），为false时保留（与配置文件合并）。
+// 按zk期望服务列表启动的实例不在状态中，也不会因此被停止
+func (m *ServerManager) Apply(st *State, exclusive bool) []OpResult {
+	desired := m.desiredSet()
+	current := make(map[string]*Server)
+	for _, s := range m.GetServers() {
+		current[s.Type+":"+s.Address] = s
+	}
+	listed := make(map[string]bool)
+	var ops []instanceOp
+	for _, ss := range st.Servers {
+		key := ss.Type + ":" + ss.Address
+		listed[key] = true
+		running := current[key] != nil && current[key].Status == "running"
+		switch {
+		case ss.Running && !running:
+			labels := ss.Labels.clone()
+			ops = append(ops, instanceOp{typ: ss.Type, address: ss.Address, name: ss.Name, action: "start", labels: &labels})
+		case !ss.Running && running:
+			ops = append(ops, instanceOp{typ: ss.Type, address: ss.Address, name: ss.Name, action: "stop"})
+		}
+	}
+	if exclusive {
+		for key, s := range current {
+			if !listed[key] && !desired[key] && s.Status == "running" {
+				ops = append(ops, instanceOp{typ: s.Type, address: s.Address, name: s.Name, action: "stop"})
+			}
+		}
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	return m.runOps(ops)
+}
+
+func readState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	st := &State{}
+	if err := yaml.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("parse state %s: %w", path, err)
+	}
+	return st, nil
+}
+
+// 先写临时文件再rename，进程在写入中途退出也不会留下不完整的状态
+func writeState(path string, st *State) error {
+	data, err := yaml.Marshal(st)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// StateKeeper 启动时按状态文件恢复实例，之后在实例启动/停止时重写状态文件
+type StateKeeper struct {
+	path    string
+	manager *ServerManager
+	mu      sync.Mutex // 串行写入
+	done    chan struct{}
+}
+
+// 在配置文件中的服务启动后调用，File为空时不启用
+func startStateKeeper(cfg StateConfig, manager *ServerManager) *StateKeeper {
+	if cfg.File == "" {
+		return nil
+	}
+	st, err := readState(cfg.File)
+	switch {
+	case err == nil:
+		results := manager.Apply(st, false)
+		log.Printf("restored state from %s (saved at %s)", cfg.File, st.SavedAt.Format(time.RFC3339))
+		for _, r := range results {
+			if r.Error != "" {
+				log.Printf("Failed to %s %s: %s", r.Action, r.Instance, r.Error)
+			}
+		}
+	case os.IsNotExist(err):
+	default:
+		log.Printf("state not restored: %v", err)
+	}
+
+	k := &StateKeeper{path: cfg.File, manager: manager, done: make(chan struct{})}
+	changed := manager.Subscribe()
+	k.write()
+	go func() {
+		for {
+			select {
+			case <-changed:
+				k.write()
+			case <-k.done:
+				return
+			}
+		}
+	}()
+	return k
+}
+
+// Close 停止跟随，需在退出时停止所有服务之前调用，使状态文件保留退出前的拓扑
+func (k *StateKeeper) Close() {
+	close(k.done)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+}
+
+func (k *StateKeeper) write() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	select {
+	case <-k.done:
+		return
+	default:
+	}
+	if err := writeState(k.path, k.manager.Snapshot()); err != nil {
+		log.Printf("save state failed: %v", err)
+	}
+}
+
+// 命名快照的路径，名称不能包含路径
+func snapshotPath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	dir := mConfig.State.SnapshotDir
+	if dir == "" {
+		dir = defaultSnapshotDir
+	}
+	return filepath.Join(dir, name+".yaml"), nil
+}
+
+// save <name>：保存当前拓扑为命名快照
+func saveSnapshot(args []string, manager *ServerManager) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("missing snapshot name")
+	}
+	path, err := snapshotPath(args[0])
+	if err != nil {
+		return "", err
+	}
+	return path, writeState(path, manager.Snapshot())
+}
+
+// load <name>：按命名快照启动/停止实例，快照中没有的实例也会被停止
+func loadSnapshot(args []string, manager *ServerManager) ([]OpResult, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("missing snapshot name, available: %s", strings.Join(listSnapshots(), ", "))
+	}
+	path, err := snapshotPath(args[0])
+	if err != nil {
+		return nil, err
+	}
+	st, err := readState(path)
+	if err != nil {
+		return nil, err
+	}
+	return manager.Apply(st, true), nil
+}
+
+// 已保存的快照名称
+func listSnapshots() []string {
+	dir := mConfig.State.SnapshotDir
+	if dir == "" {
+		dir = defaultSnapshotDir
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		names = append(names, strings.TrimSuffix(filepath.Base(f), ".yaml"))
+	}
+	sort.Strings(names)
+	return names
+}
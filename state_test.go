@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/21Mile/go_downstreamer_server/services/registry"
+)
+
+// 按期望列表启动的实例不写入状态：重启后由列表重新启动，并且仍能在列表中移除时停止
+func TestStateExcludesDesiredServers(t *testing.T) {
+	m := testManager(t)
+	manual, desired := freeTestPort(t), freeTestPort(t)
+	if err := m.StartServer("tcp", manual); err != nil {
+		t.Fatal(err)
+	}
+	m.reconcile([]byte("- tcp:" + desired + "\n"))
+
+	st := m.Snapshot()
+	if len(st.Servers) != 1 || st.Servers[0].Address != manual || !st.Servers[0].Running {
+		t.Fatalf("snapshot = %+v, want only the manual server", st.Servers)
+	}
+
+	// 加载完整拓扑不停止期望列表中的实例
+	m.Apply(st, true)
+	if !m.isRunning("tcp:" + desired) {
+		t.Fatal("exclusive apply stopped a desired server")
+	}
+
+	cfg := StateConfig{File: filepath.Join(t.TempDir(), "state.yaml")}
+	k := startStateKeeper(cfg, m)
+	k.write()
+	k.Close()
+	m.StopAll()
+
+	// 模拟重启：先恢复状态，再跟随期望列表
+	restarted := testManager(t)
+	k = startStateKeeper(cfg, restarted)
+	defer k.Close()
+	if !restarted.isRunning("tcp:" + manual) {
+		t.Fatal("manual server not restored")
+	}
+	if restarted.isRunning("tcp:" + desired) {
+		t.Fatal("desired server restored from state")
+	}
+	restarted.reconcile([]byte("- tcp:" + desired + "\n"))
+	if !restarted.isRunning("tcp:" + desired) {
+		t.Fatal("desired server not started from the list")
+	}
+	restarted.reconcile([]byte("[]"))
+	if restarted.isRunning("tcp:" + desired) {
+		t.Fatal("desired server not stopped after removal from the list")
+	}
+	if !restarted.isRunning("tcp:" + manual) {
+		t.Fatal("manual server stopped by the desired list")
+	}
+}
+
+// 注册与注销时阻塞，直到测试放行；测试结束后不再阻塞
+type blockingRegistry struct {
+	registry.Registry
+	entered chan string
+	release chan struct{}
+	done    atomic.Bool
+}
+
+func (r *blockingRegistry) wait(op string) {
+	if r.done.Load() {
+		return
+	}
+	r.entered <- op
+	<-r.release
+}
+
+func (r *blockingRegistry) Register(ctx context.Context, service, addr string) error {
+	r.wait("register")
+	return nil
+}
+
+func (r *blockingRegistry) Deregister(ctx context.Context, service, addr string) error {
+	r.wait("deregister")
+	return nil
+}
+
+func (r *blockingRegistry) Close() error { return nil }
+
+// 期望实例启动后注册、停止前注销的期间都在运行，状态文件中不能出现它
+func TestStateKeeperSkipsDesiredServerWhileChanging(t *testing.T) {
+	m := testManager(t)
+	key := "tcp:" + freeTestPort(t)
+	reg := &blockingRegistry{entered: make(chan string), release: make(chan struct{})}
+	m.mu.Lock()
+	m.registries[registry.BackendEtcd] = reg
+	m.mu.Unlock()
+	t.Cleanup(func() { reg.done.Store(true) })
+	mConfig.Registrations = []RegistrationConfig{{Instance: key, Backend: registry.BackendEtcd, Path: "/test/state"}}
+
+	cfg := StateConfig{File: filepath.Join(t.TempDir(), "state.yaml")}
+	k := startStateKeeper(cfg, m)
+	defer k.Close()
+	recorded := func() bool {
+		t.Helper()
+		k.write()
+		st, err := readState(cfg.File)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range st.Servers {
+			if s.Type+":"+s.Address == key && s.Running {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, list := range []string{"- " + key + "\n", "[]"} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			m.reconcile([]byte(list))
+		}()
+		var op string
+		select {
+		case op = <-reg.entered:
+		case <-time.After(waitTimeout):
+			t.Fatal("registry not called")
+		}
+		if !m.isRunning(key) {
+			t.Fatalf("%s not running during %s", key, op)
+		}
+		leaked := recorded()
+		reg.release <- struct{}{}
+		<-done
+		if leaked {
+			t.Fatalf("state file records desired server %s as running during %s", key, op)
+		}
+	}
+	if m.isRunning(key) {
+		t.Fatalf("%s still running after removal from the list", key)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"github.com/21Mile/go_downstreamer_server/services/file_sd"
+	"github.com/21Mile/go_downstreamer_server/services/logger"
+	"github.com/21Mile/go_downstreamer_server/services/xds_server"
+)
+
+// XdsPublisher 服务状态变化时把分组推送到xDS控制面
+type XdsPublisher struct {
+	server  *xds_server.XdsServer
+	logger  *logger.Logger
+	manager *ServerManager
+	done    chan struct{}
+}
+
+// 启动xDS控制面，Addr为空时不启用
+func startXdsServer(cfg XdsConfig, manager *ServerManager) *XdsPublisher {
+	if cfg.Addr == "" {
+		return nil
+	}
+	lg, err := logger.New(mConfig.Log.Options(), "xds:"+cfg.Addr, "xds", cfg.Addr)
+	if err != nil {
+		log.Printf("xds disabled: %v", err)
+		return nil
+	}
+	s, err := xds_server.Run_xds_server(cfg.Addr, &cfg.Options, lg)
+	if err != nil {
+		log.Printf("xds disabled: %v", err)
+		lg.Close()
+		return nil
+	}
+	p := &XdsPublisher{server: s, logger: lg, manager: manager, done: make(chan struct{})}
+	changed := manager.Subscribe()
+	p.publish()
+	go func() {
+		for {
+			select {
+			case <-changed:
+				p.publish()
+			case <-p.done:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+func (p *XdsPublisher) Close() {
+	close(p.done)
+	p.server.Close()
+	p.logger.Close()
+}
+
+func (p *XdsPublisher) publish() {
+	if err := p.server.Update(xdsGroups(p.manager.GetServers())); err != nil {
+		p.logger.Error("xds update failed", "err", err)
+	}
+}
+
+// 已停止的实例以UNHEALTHY下发，gRPC类型的分组使用HTTP/2，udp实例的地址协议为UDP
+func xdsGroups(servers []*Server) []xds_server.Group {
+	var groups []xds_server.Group
+	for _, g := range groupInstances(servers) {
+		xg := xds_server.Group{Name: g.Name, HTTP2: true}
+		for _, in := range g.Instances {
+			if !strings.HasPrefix(in.Type, "grpc") {
+				xg.HTTP2 = false
+			}
+			xg.Endpoints = append(xg.Endpoints, xds_server.Endpoint{
+				Name:    in.Name,
+				Host:    in.Host,
+				Port:    in.Port,
+				Weight:  in.Weight,
+				Healthy: in.Running,
+				UDP:     in.Protocol == file_sd.ProtocolUDP,
+			})
+		}
+		groups = append(groups, xg)
+	}
+	return groups
+}
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestXdsGroups(t *testing.T) {
+	testConfig(t)
+	groups := xdsGroups([]*Server{
+		{Type: "grpc", Address: "50055", Labels: Labels{Group: "rpc"}, Status: "running"},
+		{Type: "http", Address: "0.0.0.0:8080", Labels: Labels{Group: "web"}, Status: "stopped"},
+		{Type: "udp", Address: "5003", Labels: Labels{Group: "web"}, Status: "running"},
+	})
+	if len(groups) != 2 {
+		t.Fatalf("groups = %+v", groups)
+	}
+	if !groups[0].HTTP2 || groups[1].HTTP2 {
+		t.Errorf("http2 = %v/%v, want only the grpc group", groups[0].HTTP2, groups[1].HTTP2)
+	}
+	web, udp := groups[1].Endpoints[0], groups[1].Endpoints[1]
+	if web.Host != "127.0.0.1" || web.Healthy || web.UDP {
+		t.Errorf("http endpoint = %+v, want unhealthy tcp on 127.0.0.1", web)
+	}
+	if !udp.UDP || !udp.Healthy {
+		t.Errorf("udp endpoint = %+v, want healthy udp", udp)
+	}
+}